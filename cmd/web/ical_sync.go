@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/icalsync"
+)
+
+// listenForICalFeeds imports the external calendars in the background for
+// the lifetime of the process.
+func listenForICalFeeds() {
+	syncer := icalsync.New(handlers.Repo.DB, &appConfig.ErrorLog)
+	go syncer.Run(context.Background(), appConfig.ICalSyncInterval)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -12,12 +14,19 @@ import (
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/driver"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ledger"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/payments"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/storage"
 )
 
 const portNumber = ":8083"
 
+// uploadsDir holds the files staff upload, such as room photos
+const uploadsDir = "./uploads/"
+
 var appConfig config.AppConfig
 
 func main() {
@@ -30,6 +39,8 @@ func main() {
 	defer close(appConfig.MailChan)
 
 	listenForMail()
+	listenForICalFeeds()
+	listenForPaymentHolds()
 
 	if err != nil {
 		log.Fatalln("Error starting application: ", err)
@@ -50,7 +61,6 @@ func run() (*driver.DB, error) {
 	gob.Register(models.RoomRestriction{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
-	gob.Register(map[string]int{})
 	// Initialize the template cache
 	templateCache, err := render.InitializeTmplCache()
 	if err != nil {
@@ -65,6 +75,43 @@ func run() (*driver.DB, error) {
 	// This is the entry point of the application
 	appConfig.InProduction = false
 	appConfig.UseCache = false
+	appConfig.DBTimeout = 3 * time.Second
+	appConfig.BaseURL = "http://localhost" + portNumber
+	appConfig.SecretKey = []byte(os.Getenv("BOOKINGS_SECRET_KEY"))
+	if len(appConfig.SecretKey) == 0 {
+		if appConfig.InProduction {
+			log.Fatalln("BOOKINGS_SECRET_KEY must be set in production")
+		}
+		appConfig.SecretKey = []byte("development-secret-key")
+	}
+	appConfig.EmailVerificationTTL = 48 * time.Hour
+	appConfig.PasswordResetTTL = time.Hour
+	appConfig.GuestCancellationNotice = 48 * time.Hour
+	appConfig.APITokenTTL = 24 * time.Hour
+	appConfig.ICalSyncInterval = 15 * time.Minute
+	appConfig.Currency = os.Getenv("BOOKINGS_CURRENCY")
+	if appConfig.Currency == "" {
+		appConfig.Currency = money.DefaultCurrency
+	}
+	if !money.ValidCurrency(appConfig.Currency) {
+		log.Fatalln("BOOKINGS_CURRENCY must be an ISO 4217 code such as USD")
+	}
+	provider := os.Getenv("BOOKINGS_PAYMENT_PROVIDER")
+	if provider == "" {
+		provider = "fake"
+	}
+	appConfig.Payments, err = payments.New(provider, appConfig.SecretKey)
+	if err != nil {
+		log.Fatalln("BOOKINGS_PAYMENT_PROVIDER:", err)
+	}
+	// the in-process provider accepts every payment without moving money
+	if appConfig.InProduction && appConfig.Payments.Name() == "fake" {
+		log.Fatalln("BOOKINGS_PAYMENT_PROVIDER must name a real provider in production")
+	}
+	appConfig.PaymentTerms = ledger.Terms{DepositPercent: 30, BalanceDueDays: 14}
+	appConfig.PaymentHold = 30 * time.Minute
+	appConfig.PaymentSweepInterval = time.Minute
+	appConfig.Storage = storage.NewLocal(uploadsDir, "/uploads")
 
 	appConfig.InfoLog = *log.New(log.Writer(), "INFO\t", log.Ldate|log.Ltime)
 	appConfig.ErrorLog = *log.New(log.Writer(), "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
@@ -88,5 +135,18 @@ func run() (*driver.DB, error) {
 	// Initialize a new repository
 	repo := handlers.InitializeRepository(&appConfig, db)
 	handlers.SetRepository(repo)
+
+	// stored amounts carry no conversion, a property changing its currency
+	// would add up prices and payments of different currencies
+	currencies, err := repo.DB.StoredCurrencies(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, currency := range currencies {
+		if currency != appConfig.Currency {
+			return nil, fmt.Errorf("BOOKINGS_CURRENCY is %s but reservations or payments are stored in %s", appConfig.Currency, currency)
+		}
+	}
+
 	return db, nil
 }
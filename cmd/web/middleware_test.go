@@ -1,8 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -27,3 +35,86 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf("Expected type http.Handler, Received %T", v)
 	}
 }
+
+func TestRequirePermission(t *testing.T) {
+	var myH myHandler
+	h := RequirePermission(rbac.PermViewAdmin)(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// Do nothing
+	default:
+		t.Errorf("Expected type http.Handler, Received %T", v)
+	}
+}
+
+func TestAPIRequire(t *testing.T) {
+	var myH myHandler
+	h := APIRequire(rbac.PermReadRooms)(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// Do nothing
+	default:
+		t.Errorf("Expected type http.Handler, Received %T", v)
+	}
+}
+
+// zeros reads as many zero bytes as asked for.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestLimitUploads(t *testing.T) {
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	_ = mw.WriteField("csrf_token", "token")
+	part, _ := mw.CreateFormFile("images", "photo.jpg")
+	_, _ = part.Write([]byte("jpeg"))
+	_ = mw.Close()
+
+	// a body larger than the limit, without its length known up front
+	head, _, _ := strings.Cut(form.String(), "jpeg")
+	huge := io.MultiReader(strings.NewReader(head), io.LimitReader(zeros{}, handlers.MaxUploadBytes))
+
+	tests := []struct {
+		name   string
+		path   string
+		body   io.Reader
+		length int64
+		status int
+		parsed bool
+	}{
+		{"upload", "/admin/rooms/1/images", bytes.NewReader(form.Bytes()), int64(form.Len()), http.StatusOK, true},
+		{"too large", "/admin/rooms/1/images", bytes.NewReader(form.Bytes()), handlers.MaxUploadBytes + 1, http.StatusRequestEntityTooLarge, false},
+		{"larger than said", "/admin/rooms/1/images", huge, -1, http.StatusRequestEntityTooLarge, false},
+		{"other form", "/admin/rooms/1", bytes.NewReader(form.Bytes()), int64(form.Len()), http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		var reached, parsed bool
+		h := LimitUploads(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+			parsed = r.MultipartForm != nil && r.MultipartForm.Value["csrf_token"][0] == "token"
+		}))
+
+		req := httptest.NewRequest("POST", tt.path, tt.body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.ContentLength = tt.length
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.status)
+		}
+		if reached != (tt.status == http.StatusOK) {
+			t.Errorf("%s: next handler reached %v", tt.name, reached)
+		}
+		if parsed != tt.parsed {
+			t.Errorf("%s: upload parsed %v, want %v", tt.name, parsed, tt.parsed)
+		}
+	}
+}
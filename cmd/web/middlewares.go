@@ -1,14 +1,27 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
 func NoSurf(next http.Handler) http.Handler {
 	csrf := nosurf.New(next)
+	// the json api authenticates with bearer tokens, not cookies
+	csrf.ExemptGlob("/api/*")
+	// the payment provider signs its webhooks instead
+	csrf.ExemptPath("/payments/webhook")
 	csrf.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
@@ -19,6 +32,39 @@ func NoSurf(next http.Handler) http.Handler {
 	return csrf
 }
 
+// LimitUploads bounds the room photo uploads and reads them, keeping at most
+// handlers.MaxUploadMemory in memory, before NoSurf parses the form for its
+// token. Larger uploads are refused unread.
+func LimitUploads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upload, _ := path.Match("/admin/rooms/*/images", r.URL.Path); !upload || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tooLarge := fmt.Sprintf("Photos may be up to %d MB in total", handlers.MaxUploadBytes>>20)
+		if r.ContentLength > handlers.MaxUploadBytes {
+			http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxUploadBytes)
+		err := r.ParseMultipartForm(handlers.MaxUploadMemory)
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Cannot read upload", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func SessionLoad(next http.Handler) http.Handler {
 	return appConfig.Session.LoadAndSave(next)
 }
@@ -33,3 +79,107 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// LoadCurrentUser puts the logged in user into the request context so
+// handlers, templates and RequirePermission can read it.
+func LoadCurrentUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := appConfig.Session.GetInt(r.Context(), "user_id")
+		if id == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := handlers.Repo.DB.GetUserById(r.Context(), id)
+		if err != nil {
+			// the account is gone, drop the stale login
+			appConfig.Session.Remove(r.Context(), "user_id")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithCurrentUser(r.Context(), user)))
+	})
+}
+
+// RequirePermission only lets users whose role grants perm through.
+func RequirePermission(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := helpers.CurrentUser(r); !ok {
+				appConfig.Session.Put(r.Context(), "error", "Log in first")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			if !helpers.Can(r, perm) {
+				helpers.ClientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuth authenticates /api requests with an "Authorization: Bearer" token
+// and loads its user into the request context. Both signed session tokens
+// from /api/v1/tokens and api keys issued in the admin area are accepted, the
+// latter limit the request to the scopes they were issued with.
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			helpers.WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Missing bearer token")
+			return
+		}
+
+		ctx := r.Context()
+		var userId int
+		if strings.HasPrefix(token, handlers.APIKeyPrefix) {
+			apiToken, err := handlers.Repo.DB.GetAPITokenByHash(ctx, tokens.Hash(token))
+			if err != nil || (!apiToken.ExpiresAt.IsZero() && time.Now().After(apiToken.ExpiresAt)) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				helpers.WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired token")
+				return
+			}
+
+			if err := handlers.Repo.DB.TouchAPIToken(ctx, apiToken.ID); err != nil {
+				appConfig.ErrorLog.Println("TouchAPIToken", err)
+			}
+			userId = apiToken.UserId
+			ctx = helpers.WithTokenScopes(ctx, rbac.ParsePermissions(apiToken.Scopes))
+		} else {
+			subject, err := tokens.Verify(appConfig.SecretKey, handlers.APITokenPurpose, token)
+			if err == nil {
+				userId, err = strconv.Atoi(subject)
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				helpers.WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired token")
+				return
+			}
+		}
+
+		user, err := handlers.Repo.DB.GetUserById(ctx, userId)
+		if err != nil {
+			helpers.WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Invalid or expired token")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithCurrentUser(ctx, user)))
+	})
+}
+
+// APIRequire rejects api requests whose user or token lacks perm.
+func APIRequire(perm rbac.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !helpers.Can(r, perm) {
+				helpers.WriteAPIError(w, http.StatusForbidden, "forbidden", "Token is not allowed to perform this action")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/holds"
+)
+
+// listenForPaymentHolds cancels the reservations left unpaid in the
+// background for the lifetime of the process.
+func listenForPaymentHolds() {
+	sweeper := holds.New(handlers.Repo.DB, &appConfig.ErrorLog)
+	go sweeper.Run(context.Background(), appConfig.PaymentSweepInterval)
+}
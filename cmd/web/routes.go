@@ -6,17 +6,22 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/handlers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
 )
 
 func routes() http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
+	mux.Use(LimitUploads)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(LoadCurrentUser)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+	uploads := http.FileServer(http.Dir(uploadsDir))
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", uploads))
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
@@ -24,10 +29,14 @@ func routes() http.Handler {
 	// mux.Get("/generals-quarters", handlers.Repo.Generals)
 	// mux.Get("/majors-suite", handlers.Repo.Majors)
 	mux.Get("/rooms/{id}", handlers.Repo.Room)
+	mux.Get("/rooms/{slug}/calendar.ics", handlers.Repo.RoomCalendar)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.CreateReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/reservation-payment", handlers.Repo.ReservationPayment)
+	mux.Post("/reservation-payment", handlers.Repo.PostReservationPayment)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.SearchAvailability)
@@ -42,21 +51,135 @@ func routes() http.Handler {
 	mux.Get("/user/logout", handlers.Repo.Logout)
 	mux.Get("/user/register", handlers.Repo.ShowRegistration)
 	mux.Post("/user/register", handlers.Repo.PostRegistration)
+	mux.Get("/user/verify-email", handlers.Repo.VerifyEmail)
+	mux.Post("/user/resend-verification", handlers.Repo.ResendVerification)
+	mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", handlers.Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", handlers.Repo.PostResetPassword)
+
+	mux.Route("/user/reservations", func(r chi.Router) {
+		r.Use(Auth)
+		r.Get("/", handlers.Repo.UserReservations)
+		r.Get("/{id}", handlers.Repo.UserShowReservation)
+		r.Post("/{id}/cancel", handlers.Repo.UserCancelReservation)
+		r.Post("/{id}/change-request", handlers.Repo.UserRequestDateChange)
+	})
+
+	// JSON api for mobile and machine clients
+	mux.Route("/api/v1", func(r chi.Router) {
+		r.Post("/tokens", handlers.Repo.APICreateToken)
+
+		r.Group(func(r chi.Router) {
+			r.Use(APIAuth)
+
+			r.Group(func(r chi.Router) {
+				r.Use(APIRequire(rbac.PermReadRooms))
+				r.Get("/rooms", handlers.Repo.APIListRooms)
+				r.Get("/rooms/{id}", handlers.Repo.APIGetRoom)
+				r.Get("/amenities", handlers.Repo.APIListAmenities)
+				r.Get("/availability", handlers.Repo.APISearchAvailability)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(APIRequire(rbac.PermOwnReservations))
+				r.Get("/reservations", handlers.Repo.APIListReservations)
+				r.Post("/reservations", handlers.Repo.APICreateReservation)
+				r.Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+				r.Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+			})
+		})
+	})
 
 	// Admin
 	mux.Route("/admin", func(r chi.Router) {
-		if appConfig.InProduction {
-			r.Use(Auth)
-		}
+		r.Use(Auth)
+		r.Use(RequirePermission(rbac.PermViewAdmin))
 		r.Get("/dashboard", handlers.Repo.AdminDashboard)
-		r.Get("/reservations/{id}", handlers.Repo.AdminShowReservation)
-		r.Post("/reservations/{id}", handlers.Repo.AdminEditReservation)
-		r.Get("/reservations/{id}/delete", handlers.Repo.AdminDeleteReservation)
-		r.Get("/reservations/{id}/processed", handlers.Repo.AdminProcessedReservation)
-		r.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		r.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		r.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		r.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermViewReservations))
+			r.Get("/reservations/{id}", handlers.Repo.AdminShowReservation)
+			r.Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			r.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			r.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermEditReservations))
+			r.Post("/reservations/{id}", handlers.Repo.AdminEditReservation)
+			r.Post("/reservations/{id}/status", handlers.Repo.AdminReservationStatus)
+			r.Post("/reservations/{id}/move", handlers.Repo.AdminMoveReservation)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermManageRooms))
+			r.Get("/rooms", handlers.Repo.AdminRooms)
+			r.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+			r.Post("/rooms", handlers.Repo.AdminPostRoom)
+			r.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+			r.Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+			r.Post("/rooms/{id}/archive", handlers.Repo.AdminArchiveRoom)
+			r.Post("/rooms/{id}/restore", handlers.Repo.AdminRestoreRoom)
+			r.Post("/rooms/{id}/move", handlers.Repo.AdminMoveRoom)
+			r.Get("/rooms/{id}/images", handlers.Repo.AdminRoomImages)
+			r.Post("/rooms/{id}/images", handlers.Repo.AdminUploadRoomImages)
+			r.Post("/rooms/{id}/images/{imageId}/delete", handlers.Repo.AdminDeleteRoomImage)
+			r.Post("/rooms/{id}/images/{imageId}/cover", handlers.Repo.AdminSetRoomCoverImage)
+			r.Post("/rooms/{id}/images/{imageId}/move", handlers.Repo.AdminMoveRoomImage)
+			r.Post("/rooms/{id}/units", handlers.Repo.AdminPostRoomUnit)
+			r.Post("/rooms/{id}/units/{unitId}/delete", handlers.Repo.AdminDeleteRoomUnit)
+			r.Post("/rooms/{id}/stay-rules", handlers.Repo.AdminPostStayRule)
+			r.Post("/rooms/{id}/stay-rules/{ruleId}/delete", handlers.Repo.AdminDeleteStayRule)
+			r.Get("/amenities", handlers.Repo.AdminAmenities)
+			r.Post("/amenities", handlers.Repo.AdminPostAmenity)
+			r.Post("/amenities/{id}/delete", handlers.Repo.AdminDeleteAmenity)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermFrontDesk))
+			r.Get("/front-desk", handlers.Repo.AdminFrontDesk)
+			r.Post("/front-desk/{id}/check-in", handlers.Repo.AdminCheckIn)
+			r.Post("/front-desk/{id}/check-out", handlers.Repo.AdminCheckOut)
+		})
+
+		r.With(RequirePermission(rbac.PermDeleteReservations)).Post("/reservations/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		r.With(RequirePermission(rbac.PermRecordPayments)).Post("/reservations/{id}/payments", handlers.Repo.AdminPostReservationPayment)
+		r.With(RequirePermission(rbac.PermRefundPayments)).Post("/reservations/{id}/refunds", handlers.Repo.AdminPostReservationRefund)
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermManageCalendar))
+			r.Get("/blocks/new", handlers.Repo.AdminNewBlock)
+			r.Post("/blocks", handlers.Repo.AdminPostBlock)
+			r.Get("/blocks/{id}", handlers.Repo.AdminShowBlock)
+			r.Post("/blocks/{id}", handlers.Repo.AdminPostBlock)
+			r.Post("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
+			r.Get("/ical-feeds", handlers.Repo.AdminICalFeeds)
+			r.Post("/ical-feeds", handlers.Repo.AdminPostICalFeed)
+			r.Post("/ical-feeds/{id}/delete", handlers.Repo.AdminDeleteICalFeed)
+			r.Post("/ical-feeds/{id}/sync", handlers.Repo.AdminSyncICalFeed)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermManagePricing))
+			r.Get("/pricing", handlers.Repo.AdminPricing)
+			r.Get("/pricing/{id}", handlers.Repo.AdminRoomPricing)
+			r.Post("/pricing/{id}", handlers.Repo.AdminPostRoomRateSettings)
+			r.Post("/pricing/{id}/seasons", handlers.Repo.AdminPostSeasonalRate)
+			r.Post("/pricing/{id}/seasons/{seasonId}/delete", handlers.Repo.AdminDeleteSeasonalRate)
+			r.Post("/pricing/{id}/discounts", handlers.Repo.AdminPostStayDiscount)
+			r.Post("/pricing/{id}/discounts/{discountId}/delete", handlers.Repo.AdminDeleteStayDiscount)
+			r.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+			r.Post("/cancellation-policies", handlers.Repo.AdminPostCancellationPolicy)
+			r.Post("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
+			r.Post("/cancellation-policies/rooms/{roomId}", handlers.Repo.AdminPostRoomCancellationPolicy)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequirePermission(rbac.PermManageAPITokens))
+			r.Get("/api-tokens", handlers.Repo.AdminAPITokens)
+			r.Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+			r.Post("/api-tokens/{id}/revoke", handlers.Repo.AdminRevokeAPIToken)
+		})
 	})
 	return mux
 }
@@ -0,0 +1,53 @@
+// Package cancellation works out what a guest pays when they cancel, from
+// the cancellation policy of the room they booked.
+package cancellation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+// Policy lets guests cancel for free until FreeUntilDays before arrival,
+// cancelling on that day or later costs PenaltyPercent of the total. The zero Policy,
+// used for rooms without one, is always free.
+type Policy struct {
+	ID             int
+	Name           string
+	FreeUntilDays  int
+	PenaltyPercent int
+}
+
+// Free reports whether cancelling at now costs nothing.
+func (p Policy) Free(arrival, now time.Time) bool {
+	if p.PenaltyPercent <= 0 {
+		return true
+	}
+	return dateOf(now).Before(dateOf(arrival).AddDate(0, 0, -p.FreeUntilDays))
+}
+
+// Penalty is what the property keeps of total when the stay is cancelled at
+// now.
+func (p Policy) Penalty(total money.Money, arrival, now time.Time) money.Money {
+	if p.Free(arrival, now) {
+		return money.New(0, total.Currency)
+	}
+	return total.Percent(p.PenaltyPercent)
+}
+
+// String describes the policy for guests.
+func (p Policy) String() string {
+	switch {
+	case p.PenaltyPercent <= 0:
+		return "Free cancellation"
+	case p.FreeUntilDays <= 0:
+		return fmt.Sprintf("Free cancellation until the day before arrival, %d%% of the total on arrival day", p.PenaltyPercent)
+	}
+	return fmt.Sprintf("Free cancellation until %d days before arrival, %d%% of the total from then on", p.FreeUntilDays, p.PenaltyPercent)
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
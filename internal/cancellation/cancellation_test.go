@@ -0,0 +1,32 @@
+package cancellation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+func TestPolicy_Penalty(t *testing.T) {
+	arrival := time.Date(2050, 3, 10, 0, 0, 0, 0, time.UTC)
+	total := money.New(25000, "USD")
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		now     time.Time
+		penalty int64
+	}{
+		{"no policy", Policy{}, arrival, 0},
+		{"last free day", Policy{FreeUntilDays: 7, PenaltyPercent: 50}, time.Date(2050, 3, 2, 23, 0, 0, 0, time.UTC), 0},
+		{"first day with a penalty", Policy{FreeUntilDays: 7, PenaltyPercent: 50}, time.Date(2050, 3, 3, 8, 0, 0, 0, time.UTC), 12500},
+		{"day before arrival", Policy{PenaltyPercent: 100}, time.Date(2050, 3, 9, 0, 0, 0, 0, time.UTC), 0},
+		{"on arrival", Policy{PenaltyPercent: 100}, time.Date(2050, 3, 10, 9, 0, 0, 0, time.UTC), 25000},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Penalty(total, arrival, tt.now); got != money.New(tt.penalty, "USD") {
+			t.Errorf("%s: got penalty %s, want %d", tt.name, got, tt.penalty)
+		}
+	}
+}
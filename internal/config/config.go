@@ -3,9 +3,13 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ledger"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/payments"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/storage"
 )
 
 type AppConfig struct {
@@ -16,4 +20,30 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	// DBTimeout bounds every database call on top of the request context
+	DBTimeout time.Duration
+	// BaseURL is the public address used to build links sent by email
+	BaseURL string
+	// SecretKey signs tokens such as email verification links
+	SecretKey            []byte
+	EmailVerificationTTL time.Duration
+	PasswordResetTTL     time.Duration
+	APITokenTTL          time.Duration
+	// GuestCancellationNotice is how long before arrival guests can still
+	// cancel a reservation themselves
+	GuestCancellationNotice time.Duration
+	// ICalSyncInterval is how often external calendar feeds are imported
+	ICalSyncInterval time.Duration
+	// Currency is the ISO 4217 code every price of the property is in
+	Currency string
+	// Payments charges guests before their reservation is confirmed
+	Payments payments.Provider
+	// PaymentTerms set the deposit taken at booking and when the balance is due
+	PaymentTerms ledger.Terms
+	// PaymentHold is how long a new reservation keeps its room while the
+	// guest pays, unpaid ones are cancelled every PaymentSweepInterval
+	PaymentHold          time.Duration
+	PaymentSweepInterval time.Duration
+	// Storage keeps uploaded files such as room photos
+	Storage storage.Storage
 }
@@ -1,19 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"html"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/icalsync"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
@@ -21,7 +27,7 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservations from database")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
@@ -35,8 +41,17 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 	})
 }
 
+// AdminAllReservations lists the reservations, only those in the status of
+// the query when there is one.
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	status := r.URL.Query().Get("status")
+	if status != "" && !lifecycle.Valid(status) {
+		m.App.Session.Put(r.Context(), "error", "Invalid status")
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	reservations, err := m.DB.AllReservations(r.Context(), status)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservations from database")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
@@ -44,8 +59,12 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 	}
 	dataMap := make(map[string]interface{})
 	dataMap["reservations"] = reservations
+	dataMap["states"] = lifecycle.States
+	strMap := make(map[string]string)
+	strMap["status"] = status
 	render.Template(w, r, "adminAllReservations.page.tmpl", &models.TemplateData{
-		Data: dataMap,
+		Data:      dataMap,
+		StringMap: strMap,
 	})
 }
 
@@ -79,7 +98,8 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	dataMap := make(map[string]interface{})
 	dataMap["now"] = now
 
-	rooms, err := m.DB.GetRooms()
+	// archived rooms keep their reservations and blocks
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -87,15 +107,22 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	type roomRestrictionResult struct {
 		roomID           int
+		units            []models.RoomUnit
 		roomRestrictions []models.RoomRestriction
 		err              error
 	}
 
 	results := make(chan roomRestrictionResult)
 	for _, room := range rooms {
+		dataMap[fmt.Sprintf("calendar_feed_%d", room.ID)] = m.roomCalendarURL(room)
 		go func(room models.Room) {
-			roomRestrictions, err := m.DB.GetRoomRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
-			results <- roomRestrictionResult{roomID: room.ID, roomRestrictions: roomRestrictions, err: err}
+			units, err := m.DB.GetRoomUnits(r.Context(), room.ID)
+			if err != nil {
+				results <- roomRestrictionResult{roomID: room.ID, err: err}
+				return
+			}
+			roomRestrictions, err := m.DB.GetRoomRestrictionsForRoomByDate(r.Context(), room.ID, firstOfMonth, lastOfMonth)
+			results <- roomRestrictionResult{roomID: room.ID, units: units, roomRestrictions: roomRestrictions, err: err}
 		}(room)
 	}
 
@@ -106,27 +133,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 			return
 		}
 
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-02")] = 0
-			blockMap[d.Format("2006-01-02")] = 0
-		}
-
-		for _, y := range result.roomRestrictions {
-			if y.ReservationId > 0 {
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationId
-				}
-			} else {
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
-		}
-
-		dataMap[fmt.Sprintf("block_map_%d", result.roomID)] = blockMap
-		dataMap[fmt.Sprintf("reservation_map_%d", result.roomID)] = reservationMap
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", result.roomID), blockMap)
+		dataMap[fmt.Sprintf("units_%d", result.roomID)] = unitCalendars(result.units, result.roomRestrictions, firstOfMonth, lastOfMonth.Day())
 	}
 
 	dataMap["rooms"] = rooms
@@ -138,66 +145,268 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 	})
 }
 
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+// unitCalendars lays out a row for each unit of a room, holding the
+// restrictions of the unit and those closing the whole room.
+func unitCalendars(units []models.RoomUnit, restrictions []models.RoomRestriction, firstOfMonth time.Time, daysInMonth int) []models.UnitCalendar {
+	calendars := make([]models.UnitCalendar, 0, len(units))
+	for _, unit := range units {
+		var own []models.RoomRestriction
+		for _, y := range restrictions {
+			if y.RoomUnitId == 0 || y.RoomUnitId == unit.ID {
+				own = append(own, y)
+			}
+		}
+		calendars = append(calendars, models.UnitCalendar{Unit: unit, Spans: calendarSpans(own, firstOfMonth, daysInMonth)})
+	}
+	return calendars
+}
+
+// calendarSpans lays the restrictions of a room over the nights of a month
+// and merges consecutive nights with the same occupant into one span. Where
+// restrictions overlap the one starting first wins.
+func calendarSpans(restrictions []models.RoomRestriction, firstOfMonth time.Time, daysInMonth int) []models.CalendarSpan {
+	nights := make([]models.CalendarSpan, daysInMonth)
+	for i := range nights {
+		nights[i] = models.CalendarSpan{Kind: "free", Date: firstOfMonth.AddDate(0, 0, i).Format(layout), Days: 1}
+	}
+
+	for _, y := range restrictions {
+		occupant := models.CalendarSpan{ID: y.ID, Kind: "block", Label: models.BlockReasonLabel(y.Reason)}
+		switch {
+		case y.ReservationId > 0:
+			occupant = models.CalendarSpan{ID: y.ReservationId, Kind: "reservation", Label: "Reservation"}
+		case y.RestrictionId == models.RestrictionExternal:
+			occupant = models.CalendarSpan{ID: y.ID, Kind: "external", Label: "External"}
+		}
+
+		from, to := nightIndex(firstOfMonth, y.StartDate), nightIndex(firstOfMonth, y.EndDate)
+		if to == from {
+			to = from + 1
+		}
+		for i := max(from, 0); i < min(to, daysInMonth); i++ {
+			if nights[i].Kind == "free" {
+				occupant.Date = nights[i].Date
+				nights[i] = occupant
+			}
+		}
+	}
+
+	spans := []models.CalendarSpan{}
+	for _, night := range nights {
+		last := len(spans) - 1
+		if night.Kind != "free" && last >= 0 && spans[last].Kind == night.Kind && spans[last].ID == night.ID {
+			spans[last].Days++
+			continue
+		}
+		night.Days = 1
+		spans = append(spans, night)
+	}
+
+	return spans
+}
+
+// nightIndex is the number of calendar days from first to d.
+func nightIndex(first, d time.Time) int {
+	a := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	b := time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	return int(b.Sub(a).Hours() / 24)
+}
+
+const maxBlockNoteLength = 500
+
+// AdminNewBlock shows an empty block form, room_id, unit_id and start
+// prefill it from the calendar.
+func (m *Repository) AdminNewBlock(w http.ResponseWriter, r *http.Request) {
+	block := models.RoomRestriction{RestrictionId: models.RestrictionOwnerBlock, Reason: models.BlockReasons[0].Value}
+	block.RoomId, _ = strconv.Atoi(r.URL.Query().Get("room_id"))
+	block.RoomUnitId, _ = strconv.Atoi(r.URL.Query().Get("unit_id"))
+	if start, err := time.Parse(layout, r.URL.Query().Get("start")); err == nil {
+		block.StartDate, block.EndDate = start, start.AddDate(0, 0, 1)
+	}
+
+	m.renderBlockForm(w, r, block, forms.New(nil))
+}
+
+func (m *Repository) AdminShowBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderBlockForm(w, r, block, forms.New(nil))
+}
+
+// AdminPostBlock creates a block, or updates the one in the url.
+func (m *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	block := models.RoomRestriction{RestrictionId: models.RestrictionOwnerBlock}
+	if chi.URLParam(r, "id") != "" {
+		var ok bool
+		if block, ok = m.blockFromURL(w, r); !ok {
+			return
+		}
+	}
+
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
-	m.App.InfoLog.Println(year, month)
-	form := forms.New(r.PostForm)
+	f := forms.New(r.PostForm)
+	f.Required("room_id", "start_date", "last_night", "reason")
 
-	rooms, err := m.DB.GetRooms()
+	block.RoomId, _ = strconv.Atoi(r.Form.Get("room_id"))
+	block.RoomUnitId, _ = strconv.Atoi(r.Form.Get("room_unit_id"))
+	block.Reason = r.Form.Get("reason")
+	block.Note = strings.TrimSpace(r.Form.Get("note"))
+
+	if models.BlockReasonLabel(block.Reason) == "Blocked" {
+		f.Errors.Add("reason", "Choose a reason")
+	}
+	if len(block.Note) > maxBlockNoteLength {
+		f.Errors.Add("note", fmt.Sprintf("The note cannot be longer than %d characters", maxBlockNoteLength))
+	}
+	if block.RoomUnitId != 0 {
+		units, err := m.DB.GetRoomUnits(r.Context(), block.RoomId)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !hasUnit(units, block.RoomUnitId) {
+			f.Errors.Add("room_unit_id", "Choose a unit of the room, or the whole room")
+		}
+	}
+
+	start, startErr := time.Parse(layout, r.Form.Get("start_date"))
+	lastNight, lastErr := time.Parse(layout, r.Form.Get("last_night"))
+	if startErr != nil || lastErr != nil {
+		f.Errors.Add("start_date", "Dates must look like 2006-01-02")
+	} else if lastNight.Before(start) {
+		f.Errors.Add("last_night", "The last night cannot be before the first")
+	} else {
+		// blocks end the morning after their last night, like a departure
+		block.StartDate, block.EndDate = start, lastNight.AddDate(0, 0, 1)
+	}
 
+	if !f.Valid() {
+		m.renderBlockForm(w, r, block, f)
+		return
+	}
+
+	if block.ID == 0 {
+		_, err = m.DB.InsertBlock(r.Context(), block)
+	} else {
+		err = m.DB.UpdateBlock(r.Context(), block)
+	}
+
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		f.Errors.Add("start_date", "These nights overlap a reservation or another block of the unit")
+		m.renderBlockForm(w, r, block, f)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save block")
+		http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block saved")
+	http.Redirect(w, r, calendarMonthURL(block.StartDate), http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.RemoveBlockById(r.Context(), block.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot remove block")
+		http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block removed")
+	http.Redirect(w, r, calendarMonthURL(block.StartDate), http.StatusSeeOther)
+}
+
+// blockFromURL loads the owner block named by the id url param, reservations
+// and imported blocks cannot be edited here.
+func (m *Repository) blockFromURL(w http.ResponseWriter, r *http.Request) (models.RoomRestriction, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse block id")
+		http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
+		return models.RoomRestriction{}, false
+	}
+
+	block, err := m.DB.GetBlockById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find block")
+		http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
+		return models.RoomRestriction{}, false
+	}
+
+	return block, true
+}
+
+func (m *Repository) renderBlockForm(w http.ResponseWriter, r *http.Request, block models.RoomRestriction, f *forms.Form) {
+	allRooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	var wg sync.WaitGroup
+	// archived rooms take no new blocks, but a block already on one is shown
+	rooms := make([]models.Room, 0, len(allRooms))
+	for _, room := range allRooms {
+		if room.ArchivedAt.IsZero() || room.ID == block.RoomId {
+			rooms = append(rooms, room)
+		}
+	}
 
+	units := make(map[int][]models.RoomUnit)
 	for _, room := range rooms {
-		blocks := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-		for k, v := range blocks {
-			if v > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, k)) {
-				wg.Add(1)
-				go func() {
-					err := m.DB.RemoveBlockById(v)
-					defer wg.Done()
-					if err != nil {
-						helpers.ServerError(w, err)
-						return
-					}
-				}()
-			}
+		units[room.ID], err = m.DB.GetRoomUnits(r.Context(), room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
 		}
 	}
 
-	wg.Wait()
-
-	for k := range r.PostForm {
-		if strings.HasPrefix(k, "add_block") {
-			wg.Add(1)
-			go func(k string) {
-				defer wg.Done()
-				exploded := strings.Split(k, "_")
-				roomId, _ := strconv.Atoi(exploded[2])
-				startDate, _ := time.Parse("2006-01-2", exploded[3])
-				err := m.DB.InsertBlockForRoom(roomId, startDate)
-				if err != nil {
-					log.Println(err)
-				}
-			}(k)
-		}
+	strMap := make(map[string]string)
+	if !block.StartDate.IsZero() {
+		strMap["start_date"] = block.StartDate.Format(layout)
+		strMap["last_night"] = block.EndDate.AddDate(0, 0, -1).Format(layout)
 	}
 
-	wg.Wait()
+	dataMap := make(map[string]interface{})
+	dataMap["block"] = block
+	dataMap["rooms"] = rooms
+	dataMap["units"] = units
+	dataMap["reasons"] = models.BlockReasons
 
-	m.App.Session.Put(r.Context(), "flash", "Save calendar successfully!")
-	http.Redirect(w, r, "/admin/reservations-calendar", http.StatusSeeOther)
+	render.Template(w, r, "adminBlock.page.tmpl", &models.TemplateData{
+		Form:      f,
+		Data:      dataMap,
+		StringMap: strMap,
+	})
+}
+
+// hasUnit reports whether the unit is one of units.
+func hasUnit(units []models.RoomUnit, id int) bool {
+	for _, u := range units {
+		if u.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func calendarMonthURL(d time.Time) string {
+	return fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", d.Year(), d.Month())
 }
 
 func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
@@ -209,7 +418,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	reservation, err := m.DB.GetReservationById(id)
+	reservation, err := m.DB.GetReservationById(r.Context(), id)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from database")
@@ -217,10 +426,55 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	l, err := m.reservationLedger(r.Context(), reservation)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", ledgerError(err))
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	policy, err := m.DB.GetRoomCancellationPolicy(r.Context(), reservation.RoomId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get cancellation policy from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	events, err := m.DB.GetReservationEvents(r.Context(), reservation.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation history from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	rooms, err := m.DB.GetRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rooms from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// cancelling has its own form
+	var transitions []string
+	for _, s := range lifecycle.Next(reservation.Status) {
+		if s != models.ReservationCancelled {
+			transitions = append(transitions, s)
+		}
+	}
+
 	strMap := make(map[string]string)
 	strMap["from"] = r.URL.Query().Get("from")
+	strMap["cancellation_policy"] = policy.String()
 	dataMap := make(map[string]interface{})
 	dataMap["reservation"] = reservation
+	dataMap["cancellation_fee"] = policy.Penalty(reservation.Quote.Total, reservation.StartDate, time.Now())
+	dataMap["events"] = events
+	dataMap["transitions"] = transitions
+	dataMap["can_cancel"] = lifecycle.Can(reservation.Status, models.ReservationCancelled)
+	dataMap["can_move"] = len(lifecycle.Next(reservation.Status)) > 0 && reservation.Status != models.ReservationPendingPayment
+	dataMap["rooms"] = rooms
+	dataMap["ledger"] = l
+	dataMap["overdue"] = l.Overdue(time.Now())
 
 	render.Template(w, r, "adminShowReservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -245,7 +499,7 @@ func (m *Repository) AdminEditReservation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	reservation, err := m.DB.GetReservationById(id)
+	reservation, err := m.DB.GetReservationById(r.Context(), id)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from database")
@@ -279,7 +533,7 @@ func (m *Repository) AdminEditReservation(w http.ResponseWriter, r *http.Request
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
 	// Update the reservation
-	err = m.DB.UpdateReservation(reservation)
+	err = m.DB.UpdateReservation(r.Context(), reservation)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot update reservation")
 		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
@@ -301,60 +555,456 @@ func (m *Repository) AdminEditReservation(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (m *Repository) AdminProcessedReservation(w http.ResponseWriter, r *http.Request) {
+// AdminReservationStatus moves a reservation to the status posted, as far as
+// its lifecycle allows. Cancelling has its own handler as it refunds the
+// guest.
+func (m *Repository) AdminReservationStatus(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/reservations/%d?from=%s", reservation.ID, url.QueryEscape(r.URL.Query().Get("from")))
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	status := r.Form.Get("status")
+	if !lifecycle.Valid(status) || status == models.ReservationCancelled {
+		m.App.Session.Put(r.Context(), "error", "Invalid status")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.TransitionReservation(r.Context(), reservation.ID, status, userId, strings.TrimSpace(r.Form.Get("note")))
+	if errors.Is(err, lifecycle.ErrTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A reservation %s cannot be marked %s", strings.ToLower(lifecycle.Label(reservation.Status)), strings.ToLower(lifecycle.Label(status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot update reservation")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked "+strings.ToLower(lifecycle.Label(status)))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminMoveReservation moves a reservation to other dates or another room.
+// The stay is priced again with the room's current rates and the guest gets
+// an amended confirmation.
+func (m *Repository) AdminMoveReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/reservations/%d", reservation.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("room_id", "start_date", "end_date")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Room, arrival and departure are required")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	roomId, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil || roomId <= 0 {
+		m.App.Session.Put(r.Context(), "error", "Invalid room")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse start date")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "The departure must be after the arrival")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	// the guest is being charged the deposit of the booked stay, a new price
+	// would leave the payment for the wrong amount
+	if reservation.Status == models.ReservationPendingPayment {
+		m.App.Session.Put(r.Context(), "error", "The deposit is still being paid, the reservation can be moved once it is confirmed")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	sameStart := startDate.Format(layout) == reservation.StartDate.Format(layout)
+	if reservation.Status == models.ReservationCheckedIn && !sameStart {
+		m.App.Session.Put(r.Context(), "error", "The guest has already arrived, only the departure can change")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if roomId == reservation.RoomId && sameStart && endDate.Format(layout) == reservation.EndDate.Format(layout) {
+		m.App.Session.Put(r.Context(), "error", "The reservation already has this room and dates")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	moved := reservation
+	moved.RoomId = roomId
+	moved.Room = models.Room{ID: roomId}
+	moved.StartDate = startDate
+	moved.EndDate = endDate
+	moved.Quote, err = m.quoteStay(r.Context(), roomId, startDate, endDate)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Cannot price the new stay")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	// the deposit follows the new total, as if the guest had booked the new
+	// stay when they made the reservation
+	moved.Deposit, moved.BalanceDue = m.App.PaymentTerms.Schedule(moved.Quote.Total, startDate, reservation.CreatedAt)
+
+	note := fmt.Sprintf("Moved from %s, %s to %s", defaultString(reservation.Room.Name, fmt.Sprintf("room %d", reservation.RoomId)),
+		reservation.StartDate.Format(layout), reservation.EndDate.Format(layout))
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.MoveReservation(r.Context(), moved, userId, note)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "The room is not available for those dates")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrRoomTooSmall) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The room does not sleep %d adults and %d children", reservation.Adults, reservation.Children))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, lifecycle.ErrTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A reservation %s cannot be moved", strings.ToLower(lifecycle.Label(reservation.Status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot move reservation")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if room, err := m.DB.GetRoomById(r.Context(), roomId); err == nil {
+		moved.Room = room
+	}
+	m.sendReservationAmendment(reservation, moved)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation moved, the new total is %s", moved.Quote.Total))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// sendReservationAmendment tells the guest about the new stay and price of
+// their reservation.
+func (m *Repository) sendReservationAmendment(before, after models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Amended</strong><br>
+		Dear %s,<br>
+		Your reservation from %s to %s has been changed, you are now staying from %s to %s.<br>
+	`, html.EscapeString(after.FirstName), before.StartDate.Format(layout), before.EndDate.Format(layout), after.StartDate.Format(layout), after.EndDate.Format(layout))
+
+	if after.Room.Name != "" {
+		htmlMessage += fmt.Sprintf(`Room: %s<br>
+		`, html.EscapeString(after.Room.Name))
+	}
+	htmlMessage += fmt.Sprintf(`Total: %s
+	`, after.Quote.Total)
+	if after.Quote.Total != before.Quote.Total {
+		htmlMessage += fmt.Sprintf(`(%s before the change)
+		`, before.Quote.Total)
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:       after.Email,
+		From:     "universal@booking.com",
+		Subject:  "Reservation Amended",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// AdminAPITokens lists the api keys and the form to issue a new one. A freshly
+// issued key is popped from the session so its secret is only shown once.
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	apiTokens, err := m.DB.AllAPITokens(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get api tokens from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get users from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	strMap := make(map[string]string)
+	strMap["new_token"] = m.App.Session.PopString(r.Context(), "new_api_token")
+
+	dataMap := make(map[string]interface{})
+	dataMap["tokens"] = apiTokens
+	dataMap["users"] = users
+	dataMap["scopes"] = rbac.RoleOwner.Permissions()
+
+	render.Template(w, r, "adminAPITokens.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      dataMap,
+		StringMap: strMap,
+	})
+}
+
+// AdminPostAPIToken issues an api key for a user. The scopes are narrowed to
+// what the user's role grants, a key can never do more than its owner.
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("name", "user_id")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Name and user are required")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.Form.Get("user_id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid user")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	owner, err := m.DB.GetUserById(r.Context(), userId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find user")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	role := rbac.RoleFromAccessLevel(owner.AccessLevel)
+	scopes := []string{}
+	for _, p := range rbac.ParsePermissions(strings.Join(r.Form["scopes"], ",")) {
+		if role.Can(p) {
+			scopes = append(scopes, string(p))
+		}
+	}
+	if len(scopes) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Select at least one scope the user's role allows")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	var expiresAt time.Time
+	if days := r.Form.Get("expires_in_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			m.App.Session.Put(r.Context(), "error", "Expiry must be a positive number of days")
+			http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+			return
+		}
+		expiresAt = time.Now().AddDate(0, 0, n)
+	}
+
+	secret, err := tokens.Random(32)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	plain := APIKeyPrefix + secret
+
+	admin, _ := helpers.CurrentUser(r)
+	_, err = m.DB.InsertAPIToken(r.Context(), models.APIToken{
+		UserId:    owner.ID,
+		Name:      r.Form.Get("name"),
+		TokenHash: tokens.Hash(plain),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+		CreatedBy: admin.ID,
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot create api token")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "new_api_token", plain)
+	m.App.Session.Put(r.Context(), "flash", "API token created, copy it now as it will not be shown again")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	from := r.URL.Query().Get("from")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse api token id")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
 
-	redirectPath := "/admin/dashboard"
-	if from == "all" {
-		redirectPath = "/admin/reservations-all"
-	} else if from == "new" {
-		redirectPath = "/admin/reservations-new"
+	err = m.DB.RevokeAPIToken(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot revoke api token")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+// AdminICalFeeds lists the external calendars imported as room blocks.
+func (m *Repository) AdminICalFeeds(w http.ResponseWriter, r *http.Request) {
+	feeds, err := m.DB.AllRoomICalFeeds(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get calendar feeds from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
 	}
 
+	rooms, err := m.DB.GetRooms(r.Context())
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot parse reservation id")
-		http.Redirect(w, r, redirectPath, http.StatusTemporaryRedirect)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["feeds"] = feeds
+	dataMap["rooms"] = rooms
+
+	render.Template(w, r, "adminICalFeeds.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+func (m *Repository) AdminPostICalFeed(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 		return
 	}
 
-	processedStr := r.URL.Query().Get("processed")
+	f := forms.New(r.PostForm)
+	f.Required("room_id", "name", "url")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Room, name and url are required")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
 
-	if processedStr == "" {
-		m.App.Session.Put(r.Context(), "error", "Cannot parse processed value")
-		http.Redirect(w, r, redirectPath, http.StatusTemporaryRedirect)
+	roomId, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid room")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 		return
 	}
 
-	processed := processedStr == "true"
-	err = m.DB.ProcessReservation(id, processed)
+	feedURL, ok := normalizeFeedURL(r.Form.Get("url"))
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "The feed url must be an http, https or webcal address")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
 
+	_, err = m.DB.InsertRoomICalFeed(r.Context(), models.RoomICalFeed{
+		RoomId: roomId,
+		Name:   r.Form.Get("name"),
+		URL:    feedURL,
+	})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot update reservation")
-		http.Redirect(w, r, redirectPath, http.StatusTemporaryRedirect)
+		m.App.Session.Put(r.Context(), "error", "Cannot save calendar feed")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation processed")
-	http.Redirect(w, r, redirectPath, http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Calendar feed added, it will be imported shortly")
+	http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+func (m *Repository) AdminDeleteICalFeed(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot parse reservation id")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		m.App.Session.Put(r.Context(), "error", "Cannot parse calendar feed id")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 		return
 	}
 
-	err = m.DB.DeleteReservation(id)
+	err = m.DB.DeleteRoomICalFeed(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete calendar feed")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar feed and its blocks deleted")
+	http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+}
 
+// AdminSyncICalFeed imports a feed right away instead of waiting for the
+// background worker.
+func (m *Repository) AdminSyncICalFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot delete reservation")
-		http.Redirect(w, r, "/admin/dashboard", http.StatusTemporaryRedirect)
+		m.App.Session.Put(r.Context(), "error", "Cannot parse calendar feed id")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
+
+	feed, err := m.DB.GetRoomICalFeedById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find calendar feed")
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+		return
+	}
+
+	result, err := icalsync.New(m.DB, &m.App.ErrorLog).SyncFeed(r.Context(), feed)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Import failed: "+err.Error())
+		http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
-	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported: %d added, %d updated, %d removed", result.Added, result.Updated, result.Removed))
+	http.Redirect(w, r, "/admin/ical-feeds", http.StatusSeeOther)
+}
+
+// normalizeFeedURL accepts http(s) urls and rewrites the webcal scheme most
+// channels hand out to https.
+func normalizeFeedURL(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "webcal":
+		u.Scheme = "https"
+	default:
+		return "", false
+	}
+	return u.String(), true
 }
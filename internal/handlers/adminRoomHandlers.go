@@ -0,0 +1,382 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+)
+
+// slugPattern is what room slugs may look like in urls, lowercase words
+// separated by single dashes.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// AdminRooms lists every room in the order guests see them, archived rooms
+// last.
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rooms from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	var active, archived []models.Room
+	for _, room := range rooms {
+		if room.ArchivedAt.IsZero() {
+			active = append(active, room)
+		} else {
+			archived = append(archived, room)
+		}
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["rooms"] = active
+	dataMap["archived"] = archived
+
+	render.Template(w, r, "adminRooms.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	m.renderRoomForm(w, r, newRoom(), forms.New(nil))
+}
+
+// newRoom is a room before staff fill it in, capacity left out of the form
+// keeps these values.
+func newRoom() models.Room {
+	return models.Room{MaxAdults: 2, Beds: 1}
+}
+
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	var err error
+	room.Amenities, err = m.DB.GetRoomAmenities(r.Context(), room.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get amenities from database")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	m.renderRoomForm(w, r, room, forms.New(nil))
+}
+
+// AdminPostRoom creates a room, or updates the one in the url along with
+// its amenities. The slug is made from the name when left empty.
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	room := newRoom()
+	if chi.URLParam(r, "id") != "" {
+		var ok bool
+		if room, ok = m.adminRoomFromURL(w, r); !ok {
+			return
+		}
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("name", "price")
+
+	room.Name = strings.TrimSpace(r.Form.Get("name"))
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = slugify(room.Name)
+	}
+	if room.Name != "" && !slugPattern.MatchString(room.Slug) {
+		f.Errors.Add("slug", "Use lowercase letters, digits and dashes only")
+	}
+
+	if v := r.Form.Get("price"); v != "" {
+		price, ok := m.parseAmount(v)
+		if !ok || price.IsZero() {
+			f.Errors.Add("price", "The price must be an amount like 120.00")
+		} else {
+			room.Price = price
+		}
+	}
+
+	capacity := []struct {
+		field string
+		min   int
+		dst   *int
+	}{
+		{"max_adults", 1, &room.MaxAdults},
+		{"max_children", 0, &room.MaxChildren},
+		{"beds", 1, &room.Beds},
+	}
+	for _, c := range capacity {
+		v := r.Form.Get(c.field)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < c.min || n > models.MaxGuests {
+			f.Errors.Add(c.field, fmt.Sprintf("Enter a number from %d to %d", c.min, models.MaxGuests))
+			continue
+		}
+		*c.dst = n
+	}
+
+	catalogue, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get amenities from database")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	room.Amenities = nil
+	for _, v := range r.Form["amenity"] {
+		id, _ := strconv.Atoi(v)
+		for _, a := range catalogue {
+			if a.ID == id {
+				room.Amenities = append(room.Amenities, a)
+			}
+		}
+	}
+
+	if !f.Valid() {
+		m.renderRoomForm(w, r, room, f)
+		return
+	}
+
+	if room.ID == 0 {
+		room.ID, err = m.DB.InsertRoom(r.Context(), room)
+	} else {
+		err = m.DB.UpdateRoom(r.Context(), room)
+	}
+
+	if errors.Is(err, repository.ErrSlugTaken) {
+		f.Errors.Add("slug", "Another room already uses this slug")
+		m.renderRoomForm(w, r, room, f)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.SetRoomAmenities(r.Context(), room.ID, amenityIds(room.Amenities))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Room saved, but not its amenities")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminArchiveRoom hides a room from guests, its reservations stay as they
+// are.
+func (m *Repository) AdminArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, true)
+}
+
+func (m *Repository) AdminRestoreRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, false)
+}
+
+func (m *Repository) setRoomArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.SetRoomArchived(r.Context(), room.ID, archived)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot update room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	if archived {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s archived", room.Name))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s restored", room.Name))
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom swaps a room with the one before or after it in the sort
+// order, as the posted direction says.
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse room id")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := directionStep(r.Form.Get("direction"))
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Invalid direction")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rooms from database")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+	if !swapWithNeighbour(ids, id, step) {
+		m.App.Session.Put(r.Context(), "error", "Cannot find room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ReorderRooms(r.Context(), ids)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot reorder rooms")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// directionStep turns the direction posted by the up and down buttons into a
+// step through a sorted list.
+func directionStep(direction string) (int, bool) {
+	switch direction {
+	case "up":
+		return -1, true
+	case "down":
+		return 1, true
+	}
+	return 0, false
+}
+
+// swapWithNeighbour swaps id with the id step places away, leaving ids as
+// they are when id is already first or last. It reports whether id was found.
+func swapWithNeighbour(ids []int, id, step int) bool {
+	for at := range ids {
+		if ids[at] != id {
+			continue
+		}
+		if to := at + step; to >= 0 && to < len(ids) {
+			ids[at], ids[to] = ids[to], ids[at]
+		}
+		return true
+	}
+	return false
+}
+
+func (m *Repository) adminRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse room id")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find room")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return models.Room{}, false
+	}
+
+	return room, true
+}
+
+func (m *Repository) renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, f *forms.Form) {
+	strMap := make(map[string]string)
+	if !room.Price.IsZero() {
+		strMap["price"] = room.Price.Decimal()
+	}
+
+	catalogue, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get amenities from database")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	checked := make(map[int]bool)
+	for _, a := range room.Amenities {
+		checked[a.ID] = true
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["room"] = room
+	dataMap["amenities"] = catalogue
+	dataMap["checked_amenities"] = checked
+	if room.ID != 0 {
+		units, err := m.DB.GetRoomUnits(r.Context(), room.ID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot get units from database")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		dataMap["units"] = units
+
+		rules, err := m.DB.GetRoomStayRules(r.Context(), room.ID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Cannot get stay rules from database")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		dataMap["stay_rules"] = rules
+		dataMap["weekdays"] = weekdays
+	}
+
+	render.Template(w, r, "adminRoom.page.tmpl", &models.TemplateData{
+		Form:      f,
+		Data:      dataMap,
+		StringMap: strMap,
+	})
+}
+
+// slugify turns a room name such as "General's Quarters" into
+// "generals-quarters".
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'':
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
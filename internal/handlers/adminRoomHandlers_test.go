@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRepository_AdminPostRoom(t *testing.T) {
+	// generals-quarters belongs to room 1 and majors-suite to room 2
+	tests := []struct {
+		name       string
+		roomId     string
+		postedData url.Values
+		location   string
+		message    string
+	}{
+		{"new room", "", url.Values{"name": {"Colonel's Cabin"}, "price": {"150.00"}, "description": {"By the lake"}}, "/admin/rooms", "flash"},
+		{"edit room", "1", url.Values{"name": {"General's Quarters"}, "slug": {"generals-quarters"}, "price": {"110"}}, "/admin/rooms", "flash"},
+		{"slug of another room", "1", url.Values{"name": {"General's Quarters"}, "slug": {"majors-suite"}, "price": {"110"}}, "", ""},
+		{"slug made from a taken name", "", url.Values{"name": {"General's Quarters"}, "price": {"110"}}, "", ""},
+		{"invalid slug", "", url.Values{"name": {"Cabin"}, "slug": {"The Cabin"}, "price": {"110"}}, "", ""},
+		{"missing price", "", url.Values{"name": {"Cabin"}}, "", ""},
+		{"zero price", "", url.Values{"name": {"Cabin"}, "price": {"0"}}, "", ""},
+		{"room for a family", "", url.Values{"name": {"Cabin"}, "price": {"110"}, "max_adults": {"2"}, "max_children": {"2"}, "beds": {"2"}}, "/admin/rooms", "flash"},
+		{"no adults", "", url.Values{"name": {"Cabin"}, "price": {"110"}, "max_adults": {"0"}}, "", ""},
+		{"negative children", "", url.Values{"name": {"Cabin"}, "price": {"110"}, "max_children": {"-1"}}, "", ""},
+		{"room with amenities", "1", url.Values{"name": {"General's Quarters"}, "price": {"110"}, "amenity": {"1", "3", "99"}}, "/admin/rooms", "flash"},
+		{"unknown room", "7", url.Values{"name": {"Cabin"}, "price": {"110"}}, "/admin/rooms", "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		if tt.roomId != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", tt.roomId)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		}
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostRoom).ServeHTTP(rr, req)
+
+		if tt.location == "" {
+			// invalid rooms are shown again with their errors
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: expected the form again, got code %d", tt.name, rr.Code)
+			}
+			continue
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminMoveRoom(t *testing.T) {
+	tests := []struct {
+		name      string
+		roomId    string
+		direction string
+		hasError  bool
+	}{
+		{"down", "1", "down", false},
+		{"up from the top", "1", "up", false},
+		{"unknown direction", "1", "left", true},
+		{"unknown room", "7", "up", true},
+	}
+
+	for _, tt := range tests {
+		postedData := url.Values{"direction": {tt.direction}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/move", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminMoveRoom).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/rooms" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "error") != tt.hasError {
+			t.Errorf("%s: expected error %t", tt.name, tt.hasError)
+		}
+	}
+}
+
+func TestRepository_AdminArchiveRoom(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/rooms/1/archive", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminArchiveRoom).ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/admin/rooms" || !appConfig.Session.Exists(ctx, "flash") {
+		t.Error("AdminArchiveRoom should go back to the rooms with a flash message")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"General's Quarters": "generals-quarters",
+		"  Suite 2 -- Sea ":  "suite-2-sea",
+	}
+
+	for name, want := range tests {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+)
+
+const maxAmenityNameLength = 50
+
+// AdminAmenities lists the amenity catalogue rooms pick their features from.
+func (m *Repository) AdminAmenities(w http.ResponseWriter, r *http.Request) {
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get amenities from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["amenities"] = amenities
+
+	render.Template(w, r, "adminAmenities.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+// AdminPostAmenity adds an amenity to the catalogue, its slug is made from
+// the name.
+func (m *Repository) AdminPostAmenity(w http.ResponseWriter, r *http.Request) {
+	back := "/admin/amenities"
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	amenity := models.Amenity{Name: strings.TrimSpace(r.Form.Get("name"))}
+	amenity.Slug = slugify(amenity.Name)
+	if amenity.Slug == "" || len(amenity.Name) > maxAmenityNameLength {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Amenity names need a letter or digit and are up to %d characters long", maxAmenityNameLength))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertAmenity(r.Context(), amenity)
+	if errors.Is(err, repository.ErrAmenityTaken) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is already in the catalogue", amenity.Name))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save amenity")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Amenity added")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteAmenity removes an amenity from the catalogue and every room.
+func (m *Repository) AdminDeleteAmenity(w http.ResponseWriter, r *http.Request) {
+	back := "/admin/amenities"
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse amenity id")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteAmenity(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete amenity")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Amenity deleted")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+)
+
+// amenityBySlug looks the amenity up in the catalogue.
+func amenityBySlug(slug string) (models.Amenity, bool) {
+	amenities, _ := Repo.DB.AllAmenities(context.Background())
+	for _, a := range amenities {
+		if a.Slug == slug {
+			return a, true
+		}
+	}
+	return models.Amenity{}, false
+}
+
+func TestRepository_AdminPostAmenity(t *testing.T) {
+	tests := []struct {
+		name    string
+		amenity string
+		added   string
+		message string
+	}{
+		{"new amenity", " Pets allowed ", "pets-allowed", ""},
+		{"same name", "sea view", "", "sea view is already in the catalogue"},
+		{"same slug", "WiFi", "", "WiFi is already in the catalogue"},
+		{"no letters", "  --  ", "", "Amenity names need a letter or digit"},
+		{"too long", strings.Repeat("x", maxAmenityNameLength+1), "", "Amenity names need a letter or digit"},
+	}
+
+	for _, tt := range tests {
+		before, _ := Repo.DB.AllAmenities(context.Background())
+
+		postedData := url.Values{"name": {tt.amenity}}
+		req, _ := http.NewRequest("POST", "/admin/amenities", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostAmenity).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/amenities" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+
+		after, _ := Repo.DB.AllAmenities(context.Background())
+		if tt.added == "" {
+			if len(after) != len(before) {
+				t.Errorf("%s: added an amenity", tt.name)
+			}
+			if msg := appConfig.Session.GetString(ctx, "error"); !strings.HasPrefix(msg, tt.message) {
+				t.Errorf("%s: got error %q, want %q", tt.name, msg, tt.message)
+			}
+			continue
+		}
+		a, ok := amenityBySlug(tt.added)
+		if !ok {
+			t.Errorf("%s: %s not in the catalogue", tt.name, tt.added)
+		} else if a.Name != strings.TrimSpace(tt.amenity) {
+			t.Errorf("%s: added as %q, want %q", tt.name, a.Name, strings.TrimSpace(tt.amenity))
+		}
+	}
+}
+
+func TestRepository_AdminDeleteAmenity(t *testing.T) {
+	id, err := Repo.DB.InsertAmenity(context.Background(), models.Amenity{Name: "Minibar", Slug: "minibar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/amenities/%d/delete", id), nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(id))
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminDeleteAmenity).ServeHTTP(rr, req)
+
+	if !appConfig.Session.Exists(ctx, "flash") {
+		t.Error("expected flash message after deleting an amenity")
+	}
+	if _, ok := amenityBySlug("minibar"); ok {
+		t.Error("the amenity is still in the catalogue")
+	}
+}
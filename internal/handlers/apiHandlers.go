@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
+)
+
+const (
+	// APITokenPurpose signs the bearer tokens handed out by APICreateToken
+	APITokenPurpose = "api-access"
+	// APIKeyPrefix marks the long lived api keys issued from the admin area
+	APIKeyPrefix = "bk_"
+
+	defaultPerPage  = 20
+	maxPerPage      = 100
+	maxAPIBodyBytes = 1 << 20
+)
+
+func (m *Repository) APICreateToken(w http.ResponseWriter, r *http.Request) {
+	var input apiTokenRequest
+	if !m.readJSON(w, r, &input) {
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), strings.ToLower(strings.TrimSpace(input.Email)), input.Password)
+	if err != nil {
+		helpers.WriteAPIError(w, http.StatusUnauthorized, "invalid_credentials", "Invalid email or password")
+		return
+	}
+
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	if !user.EmailVerified {
+		helpers.WriteAPIError(w, http.StatusForbidden, "email_not_verified", "Verify your email address first")
+		return
+	}
+
+	expiresAt := time.Now().Add(m.App.APITokenTTL)
+	token := tokens.Sign(m.App.SecretKey, APITokenPurpose, strconv.Itoa(user.ID), m.App.APITokenTTL)
+
+	helpers.WriteJSON(w, http.StatusCreated, apiEnvelope{Data: apiToken{Token: token, ExpiresAt: expiresAt}})
+}
+
+func (m *Repository) APIListRooms(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	rooms, total, err := m.DB.GetRoomsPage(r.Context(), perPage, (page-1)*perPage)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	data := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		data = append(data, newAPIRoom(room))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: data, Meta: newAPIMeta(page, perPage, total)})
+}
+
+func (m *Repository) APIGetRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_id", "Room id must be a number")
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !room.ArchivedAt.IsZero() {
+		helpers.WriteAPIError(w, http.StatusNotFound, "not_found", "Room not found")
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	room.Amenities, err = m.DB.GetRoomAmenities(r.Context(), room.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: newAPIRoom(room)})
+}
+
+// APIListAmenities lists the amenities searches can be filtered on.
+func (m *Repository) APIListAmenities(w http.ResponseWriter, r *http.Request) {
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: newAPIAmenities(amenities)})
+}
+
+// APISearchAvailability lists every room that is free between start and end,
+// sleeps the party and has all of the amenities asked for.
+func (m *Repository) APISearchAvailability(w http.ResponseWriter, r *http.Request) {
+	startDate, errStart := time.Parse(layout, r.URL.Query().Get("start"))
+	endDate, errEnd := time.Parse(layout, r.URL.Query().Get("end"))
+	if errStart != nil || errEnd != nil {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_dates", "start and end must be dates in YYYY-MM-DD format")
+		return
+	}
+
+	if !endDate.After(startDate) {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_dates", "end must be after start")
+		return
+	}
+
+	guests, ok := parseGuests(r.URL.Query().Get("adults"), r.URL.Query().Get("children"))
+	if !ok {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_guests", fmt.Sprintf("adults must be between 1 and %d and children between 0 and %d", models.MaxGuests, models.MaxGuests))
+		return
+	}
+
+	var wanted []models.Amenity
+	if v := r.URL.Query().Get("amenities"); v != "" {
+		catalogue, err := m.DB.AllAmenities(r.Context())
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		if wanted, ok = pickAmenities(catalogue, strings.Split(v, ",")); !ok {
+			helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_amenities", "amenities must be comma separated slugs from /api/v1/amenities")
+			return
+		}
+	}
+
+	rooms, err := m.DB.SearchAvailabilityInRange(r.Context(), startDate, endDate, guests, amenityIds(wanted))
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	data := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		data = append(data, newAPIRoom(room))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: data})
+}
+
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	user, _ := helpers.CurrentUser(r)
+
+	var input apiReservationRequest
+	if !m.readJSON(w, r, &input) {
+		return
+	}
+
+	values := url.Values{}
+	values.Set("first_name", input.FirstName)
+	values.Set("last_name", input.LastName)
+	values.Set("email", input.Email)
+	values.Set("phone", input.Phone)
+	values.Set("start_date", input.StartDate)
+	values.Set("end_date", input.EndDate)
+
+	f := forms.New(values)
+	f.Required("first_name", "last_name", "email", "phone", "start_date", "end_date")
+	f.IsEmail("email")
+
+	if input.RoomId <= 0 {
+		f.Errors.Add("room_id", "This field is required")
+	}
+
+	startDate, errStart := time.Parse(layout, input.StartDate)
+	endDate, errEnd := time.Parse(layout, input.EndDate)
+	if input.StartDate != "" && errStart != nil {
+		f.Errors.Add("start_date", "Invalid date format")
+	}
+	if input.EndDate != "" && errEnd != nil {
+		f.Errors.Add("end_date", "Invalid date format")
+	}
+	if errStart == nil && errEnd == nil && !endDate.After(startDate) {
+		f.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	// a reservation without a party is for one adult
+	if input.Adults == 0 {
+		input.Adults = 1
+	}
+	if input.Adults < 1 || input.Adults > models.MaxGuests {
+		f.Errors.Add("adults", fmt.Sprintf("Must be between 1 and %d", models.MaxGuests))
+	}
+	if input.Children < 0 || input.Children > models.MaxGuests {
+		f.Errors.Add("children", fmt.Sprintf("Must be between 0 and %d", models.MaxGuests))
+	}
+
+	if !f.Valid() {
+		helpers.WriteJSON(w, http.StatusUnprocessableEntity, helpers.APIError{Error: helpers.APIErrorBody{
+			Code:    "validation_failed",
+			Message: "Some fields are invalid",
+			Fields:  f.Errors,
+		}})
+		return
+	}
+
+	reservation := models.Reservation{
+		UserId:    user.ID,
+		RoomId:    input.RoomId,
+		FirstName: input.FirstName,
+		LastName:  input.LastName,
+		Email:     input.Email,
+		Phone:     input.Phone,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Adults:    input.Adults,
+		Children:  input.Children,
+		Status:    models.ReservationPendingPayment,
+	}
+
+	quote, err := m.quoteStay(r.Context(), reservation.RoomId, startDate, endDate)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	reservation.Quote = quote
+	reservation.Deposit, reservation.BalanceDue = m.App.PaymentTerms.Schedule(quote.Total, startDate, time.Now())
+	reservation.PaymentDueAt = time.Now().Add(m.App.PaymentHold)
+
+	newId, err := m.DB.CreateReservation(r.Context(), &reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		helpers.WriteAPIError(w, http.StatusConflict, "room_unavailable", "The room is not available for these dates")
+		return
+	}
+	if errors.Is(err, repository.ErrRoomTooSmall) {
+		helpers.WriteAPIError(w, http.StatusUnprocessableEntity, "room_too_small", "The room does not sleep that many guests")
+		return
+	}
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		helpers.WriteAPIError(w, http.StatusUnprocessableEntity, violation.Code, violation.Message)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	reservation.ID = newId
+	reservation.CreatedAt = time.Now()
+
+	// the client confirms the intent with the provider, whose webhook then
+	// confirms the reservation
+	intent, err := m.startPayment(r.Context(), reservation)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	data := newAPIReservation(reservation)
+	data.Payment = &apiPayment{IntentId: intent.ID, ClientSecret: intent.ClientSecret, Amount: intent.Amount, DueAt: reservation.PaymentDueAt}
+
+	helpers.WriteJSON(w, http.StatusCreated, apiEnvelope{Data: data})
+}
+
+func (m *Repository) APIListReservations(w http.ResponseWriter, r *http.Request) {
+	user, _ := helpers.CurrentUser(r)
+
+	page, perPage, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	reservations, total, err := m.DB.GetReservationsByUserIdPage(r.Context(), user.ID, perPage, (page-1)*perPage)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	data := make([]apiReservation, 0, len(reservations))
+	for _, res := range reservations {
+		data = append(data, newAPIReservation(res))
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: data, Meta: newAPIMeta(page, perPage, total)})
+}
+
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	helpers.WriteJSON(w, http.StatusOK, apiEnvelope{Data: newAPIReservation(reservation)})
+}
+
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	user, _ := helpers.CurrentUser(r)
+
+	reservation, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if !lifecycle.Can(reservation.Status, models.ReservationCancelled) {
+		helpers.WriteAPIError(w, http.StatusConflict, "not_cancellable", "This reservation can no longer be cancelled")
+		return
+	}
+
+	reason := "Cancelled by the guest"
+	if helpers.Can(r, rbac.PermDeleteReservations) {
+		reason = "Cancelled by staff"
+	} else if !m.guestCanCancel(reservation, time.Now()) {
+		helpers.WriteAPIError(w, http.StatusConflict, "cancellation_closed", "This reservation can no longer be cancelled online")
+		return
+	}
+
+	_, err := m.cancelReservation(r.Context(), reservation, user.ID, reason, false)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiReservationFromURL lets staff read any reservation while other users
+// only see their own, everything else is reported as not found.
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	user, _ := helpers.CurrentUser(r)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_id", "Reservation id must be a number")
+		return models.Reservation{}, false
+	}
+
+	var reservation models.Reservation
+	if helpers.Can(r, rbac.PermViewReservations) {
+		reservation, err = m.DB.GetReservationById(r.Context(), id)
+	} else {
+		reservation, err = m.DB.GetUserReservationById(r.Context(), id, user.ID)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.WriteAPIError(w, http.StatusNotFound, "not_found", "Reservation not found")
+		return models.Reservation{}, false
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return models.Reservation{}, false
+	}
+
+	return reservation, true
+}
+
+// readJSON decodes a single json object from the body into dst, it writes the
+// error response itself and returns false when the body is not acceptable.
+func (m *Repository) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must only contain a single json object")
+	}
+
+	if err != nil {
+		helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Cannot parse request body: %s", err))
+		return false
+	}
+
+	return true
+}
+
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	helpers.WriteAPIError(w, http.StatusInternalServerError, "server_error", "Something went wrong")
+}
+
+func parsePagination(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	page, perPage := 1, defaultPerPage
+	var err error
+
+	if v := r.URL.Query().Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_pagination", "page must be a positive number")
+			return 0, 0, false
+		}
+	}
+
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		perPage, err = strconv.Atoi(v)
+		if err != nil || perPage < 1 || perPage > maxPerPage {
+			helpers.WriteAPIError(w, http.StatusBadRequest, "invalid_pagination", fmt.Sprintf("per_page must be between 1 and %d", maxPerPage))
+			return 0, 0, false
+		}
+	}
+
+	return page, perPage, true
+}
+
+func newAPIMeta(page, perPage, total int) *apiMeta {
+	return &apiMeta{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
+)
+
+type apiTestEnvelope struct {
+	Data  json.RawMessage      `json:"data"`
+	Meta  *apiMeta             `json:"meta"`
+	Error helpers.APIErrorBody `json:"error"`
+}
+
+func apiRequest(method, target, body string, user models.User, params map[string]string) *http.Request {
+	req, _ := http.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	ctx = helpers.WithCurrentUser(ctx, user)
+	return req.WithContext(ctx)
+}
+
+func decodeAPIResponse(t *testing.T, rr *httptest.ResponseRecorder) apiTestEnvelope {
+	var env apiTestEnvelope
+	if err := json.Unmarshal(rr.Body.Bytes(), &env); err != nil {
+		t.Fatalf("failed to parse json: %s", rr.Body.String())
+	}
+	return env
+}
+
+func TestRepository_APICreateToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectStatus int
+	}{
+		{"verified user", `{"email":"existing@example.com","password":"secret"}`, http.StatusCreated},
+		{"unverified user", `{"email":"unverified@example.com","password":"secret"}`, http.StatusForbidden},
+		{"unknown field", `{"email":"existing@example.com","password":"secret","admin":true}`, http.StatusBadRequest},
+		{"not json", `email=existing@example.com`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.APICreateToken).ServeHTTP(rr, apiRequest("POST", "/api/v1/tokens", tt.body, models.User{}, nil))
+
+		if rr.Code != tt.expectStatus {
+			t.Errorf("%s: APICreateToken returned wrong response code: got %d, want %d", tt.name, rr.Code, tt.expectStatus)
+		}
+	}
+}
+
+func TestRepository_APIListRooms(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIListRooms).ServeHTTP(rr, apiRequest("GET", "/api/v1/rooms?page=2&per_page=1", "", models.User{ID: 1}, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("APIListRooms returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	env := decodeAPIResponse(t, rr)
+	if env.Meta == nil || env.Meta.Page != 2 || env.Meta.Total != 2 || env.Meta.TotalPages != 2 {
+		t.Errorf("APIListRooms returned wrong pagination meta: %+v", env.Meta)
+	}
+
+	var rooms []apiRoom
+	_ = json.Unmarshal(env.Data, &rooms)
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("APIListRooms returned the wrong page: %+v", rooms)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIListRooms).ServeHTTP(rr, apiRequest("GET", "/api/v1/rooms?per_page=1000", "", models.User{ID: 1}, nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("APIListRooms returned wrong response code: got %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRepository_APICreateReservation(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		expectStatus int
+		expectCode   string
+	}{
+		{"valid", `{"room_id":1,"first_name":"John","last_name":"Smith","email":"john@example.com","phone":"5551234567","start_date":"2050-01-01","end_date":"2050-01-03"}`, http.StatusCreated, ""},
+		{"invalid fields", `{"room_id":1,"email":"invalid","start_date":"2050-01-03","end_date":"2050-01-01"}`, http.StatusUnprocessableEntity, "validation_failed"},
+		{"room taken", `{"room_id":3,"first_name":"John","last_name":"Smith","email":"john@example.com","phone":"5551234567","start_date":"2050-01-01","end_date":"2050-01-03"}`, http.StatusConflict, "room_unavailable"},
+		{"too short", `{"room_id":1,"first_name":"John","last_name":"Smith","email":"john@example.com","phone":"5551234567","start_date":"2060-12-21","end_date":"2060-12-22"}`, http.StatusUnprocessableEntity, "min_nights"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.APICreateReservation).ServeHTTP(rr, apiRequest("POST", "/api/v1/reservations", tt.body, models.User{ID: 1}, nil))
+
+		if rr.Code != tt.expectStatus {
+			t.Errorf("%s: APICreateReservation returned wrong response code: got %d, want %d", tt.name, rr.Code, tt.expectStatus)
+		}
+
+		if env := decodeAPIResponse(t, rr); env.Error.Code != tt.expectCode {
+			t.Errorf("%s: APICreateReservation returned error code %q, want %q", tt.name, env.Error.Code, tt.expectCode)
+		}
+	}
+}
+
+func TestRepository_APIGetReservation(t *testing.T) {
+	// a guest only sees their own reservations
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIGetReservation).ServeHTTP(rr, apiRequest("GET", "/api/v1/reservations/1", "", models.User{ID: 5, AccessLevel: 1}, map[string]string{"id": "1"}))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("APIGetReservation returned wrong response code: got %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIGetReservation).ServeHTTP(rr, apiRequest("GET", "/api/v1/reservations/1", "", models.User{ID: 1, AccessLevel: 1}, map[string]string{"id": "1"}))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("APIGetReservation returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_APIScopedToken(t *testing.T) {
+	req := apiRequest("GET", "/api/v1/reservations/1", "", models.User{ID: 1, AccessLevel: 3}, map[string]string{"id": "1"})
+	req = req.WithContext(helpers.WithTokenScopes(req.Context(), []rbac.Permission{rbac.PermOwnReservations}))
+
+	if helpers.Can(req, rbac.PermViewReservations) {
+		t.Error("token without reservations.view scope must not see every reservation")
+	}
+	if !helpers.Can(req, rbac.PermOwnReservations) {
+		t.Error("token with reservations.own scope should be allowed")
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIGetReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("scoped token got status %d reading its own reservation", rr.Code)
+	}
+}
+
+func TestRepository_APISearchAvailability_Amenities(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.APISearchAvailability).ServeHTTP(rr, apiRequest("GET", "/api/v1/availability?start=2050-01-01&end=2050-01-03&amenities=sea-view,wifi", "", models.User{ID: 1}, nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("APISearchAvailability returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+	var rooms []apiRoom
+	_ = json.Unmarshal(decodeAPIResponse(t, rr).Data, &rooms)
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("only room 2 has a sea view, got %+v", rooms)
+	}
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.APISearchAvailability).ServeHTTP(rr, apiRequest("GET", "/api/v1/availability?start=2050-01-01&end=2050-01-03&amenities=sauna", "", models.User{ID: 1}, nil))
+
+	if env := decodeAPIResponse(t, rr); rr.Code != http.StatusBadRequest || env.Error.Code != "invalid_amenities" {
+		t.Errorf("unknown amenity: got %d %q, want %d invalid_amenities", rr.Code, env.Error.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRepository_APIGetRoom_Amenities(t *testing.T) {
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.APIGetRoom).ServeHTTP(rr, apiRequest("GET", "/api/v1/rooms/1", "", models.User{ID: 1}, map[string]string{"id": "1"}))
+
+	var room apiRoom
+	_ = json.Unmarshal(decodeAPIResponse(t, rr).Data, &room)
+	if len(room.Amenities) != 1 || room.Amenities[0].Slug != "wifi" {
+		t.Errorf("room 1 should list wifi, got %+v", room.Amenities)
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+)
+
+// AdminCancelReservation cancels a reservation for the guest. Staff can
+// waive the penalty of the room's cancellation policy.
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/reservations/%d", reservation.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if !lifecycle.Can(reservation.Status, models.ReservationCancelled) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A reservation %s cannot be cancelled", strings.ToLower(lifecycle.Label(reservation.Status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	staffId := m.App.Session.GetInt(r.Context(), "user_id")
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+	reservation, err = m.cancelReservation(r.Context(), reservation, staffId, reason, r.Form.Get("waive_penalty") == "on")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot cancel reservation")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", cancellationMessage(reservation))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminCancellationPolicies lists the cancellation policies and the rooms
+// they are attached to.
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := m.DB.AllCancellationPolicies(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get cancellation policies from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	// reservations of archived rooms are still cancelled under their policy
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rooms from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["policies"] = policies
+	dataMap["rooms"] = rooms
+
+	render.Template(w, r, "adminCancellationPolicies.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+func (m *Repository) AdminPostCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	back := "/admin/cancellation-policies"
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("name", "free_until_days", "penalty_percent")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Name, free days and penalty are required")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	policy := cancellation.Policy{Name: strings.TrimSpace(r.Form.Get("name"))}
+
+	policy.FreeUntilDays, err = strconv.Atoi(r.Form.Get("free_until_days"))
+	if err != nil || policy.FreeUntilDays < 0 {
+		m.App.Session.Put(r.Context(), "error", "The free days must be a positive number")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	policy.PenaltyPercent, err = strconv.Atoi(r.Form.Get("penalty_percent"))
+	if err != nil || policy.PenaltyPercent < 0 || policy.PenaltyPercent > 100 {
+		m.App.Session.Put(r.Context(), "error", "The penalty must be a percentage between 0 and 100")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertCancellationPolicy(r.Context(), policy)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save cancellation policy")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy added")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationPolicy removes a policy, the rooms using it become
+// free to cancel.
+func (m *Repository) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	back := "/admin/cancellation-policies"
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse cancellation policy id")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteCancellationPolicy(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete cancellation policy")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminPostRoomCancellationPolicy attaches a policy to a room, an empty
+// policy id detaches it.
+func (m *Repository) AdminPostRoomCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	back := "/admin/cancellation-policies"
+
+	roomId, err := strconv.Atoi(chi.URLParam(r, "roomId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse room id")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	policyId, err := strconv.Atoi(defaultString(r.Form.Get("policy_id"), "0"))
+	if err != nil || policyId < 0 {
+		m.App.Session.Put(r.Context(), "error", "Invalid cancellation policy")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.SetRoomCancellationPolicy(r.Context(), roomId, policyId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot update room")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room updated")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// cancelReservation cancels the reservation with the penalty of its room's
+// cancellation policy, unless waived, refunds the rest of what was paid and
+// tells the guest. A failed refund does not undo the cancellation, staff are
+// asked to refund by hand instead.
+func (m *Repository) cancelReservation(ctx context.Context, reservation models.Reservation, cancelledBy int, reason string, waivePenalty bool) (models.Reservation, error) {
+	policy, err := m.DB.GetRoomCancellationPolicy(ctx, reservation.RoomId)
+	if err != nil {
+		return reservation, err
+	}
+
+	now := time.Now()
+	penalty := money.New(0, reservation.Quote.Total.Currency)
+	if !waivePenalty {
+		penalty = policy.Penalty(reservation.Quote.Total, reservation.StartDate, now)
+	}
+
+	reservation.Status = models.ReservationCancelled
+	reservation.CancelledAt = now
+	reservation.CancelledBy = cancelledBy
+	reservation.CancellationReason = reason
+	reservation.CancellationPenalty = penalty
+
+	err = m.DB.CancelReservation(ctx, reservation)
+	if err != nil {
+		return reservation, err
+	}
+
+	refunded, err := m.refundCancellation(ctx, reservation)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.MailChan <- models.MailData{
+			To:       "universal@booking.com",
+			From:     "universal@booking.com",
+			Subject:  fmt.Sprintf("Refund failed for cancelled reservation #%d", reservation.ID),
+			Content:  fmt.Sprintf("The refund of %s for reservation #%d could not be made: %s", refunded, reservation.ID, html.EscapeString(err.Error())),
+			Template: "basic.html",
+		}
+	}
+
+	m.sendCancellationNotice(reservation, refunded)
+	return reservation, nil
+}
+
+func (m *Repository) sendCancellationNotice(reservation models.Reservation, refunded money.Money) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		Dear %s,<br>
+		Your reservation from %s to %s has been cancelled.
+	`, html.EscapeString(reservation.FirstName), reservation.StartDate.Format(layout), reservation.EndDate.Format(layout))
+
+	if reservation.CancellationPenalty.Amount > 0 {
+		htmlMessage += fmt.Sprintf(`<br>
+		A cancellation fee of %s applies under the room's cancellation policy.
+		`, reservation.CancellationPenalty)
+	}
+	if refunded.Amount > 0 {
+		htmlMessage += fmt.Sprintf(`<br>
+		We are refunding %s of what you paid.
+		`, refunded)
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:       reservation.Email,
+		From:     "universal@booking.com",
+		Subject:  "Reservation Cancelled",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+func cancellationMessage(reservation models.Reservation) string {
+	if reservation.CancellationPenalty.Amount > 0 {
+		return fmt.Sprintf("Reservation cancelled with a fee of %s", reservation.CancellationPenalty)
+	}
+	return "Reservation cancelled"
+}
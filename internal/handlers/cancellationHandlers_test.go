@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+)
+
+func TestRepository_UserCancelReservation_Fee(t *testing.T) {
+	// reservation 3 starts in five days, inside the 7 days of room 1's policy
+	req, _ := http.NewRequest("POST", "/user/reservations/3/cancel", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "3")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.UserCancelReservation).ServeHTTP(rr, req)
+
+	if flash := appConfig.Session.GetString(ctx, "flash"); !strings.Contains(flash, "$125.00") {
+		t.Errorf("got flash %q, want it to mention the $125.00 fee", flash)
+	}
+}
+
+// paidReservation makes a $250.00 reservation of room 1 arriving the given
+// number of days from today, with paid captured by card.
+func paidReservation(t *testing.T, arrives int, paid money.Money) models.Reservation {
+	res := models.Reservation{
+		RoomId:     1,
+		FirstName:  "Jane",
+		LastName:   "Doe",
+		Email:      "jane@example.com",
+		Status:     models.ReservationConfirmed,
+		StartDate:  today().AddDate(0, 0, arrives),
+		EndDate:    today().AddDate(0, 0, arrives+2),
+		Quote:      pricing.Quote{Total: money.New(25000, "USD")},
+		Deposit:    money.New(7500, "USD"),
+		BalanceDue: today(),
+	}
+	id, err := Repo.DB.CreateReservation(context.Background(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ID = id
+
+	intent, _ := appConfig.Payments.CreateIntent(context.Background(), paid, fmt.Sprintf("reservation-%d", id))
+	_, err = appConfig.Payments.Capture(context.Background(), intent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Repo.DB.InsertPayment(context.Background(), models.Payment{
+		ReservationId: id,
+		Kind:          models.PaymentKindCharge,
+		Method:        models.PaymentMethodCard,
+		Provider:      appConfig.Payments.Name(),
+		ProviderRef:   intent.ID,
+		Amount:        paid,
+		Status:        models.PaymentSucceeded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestRepository_AdminCancelReservation(t *testing.T) {
+	// the reservations start in five days, inside the 7 days of room 1's
+	// policy, which keeps half of the $250.00
+	tests := []struct {
+		name       string
+		arrives    int
+		postedData url.Values
+		penalty    int64
+		refund     int64
+	}{
+		{"with a reason", 5, url.Values{"reason": {"Guest called"}}, 12500, 7500},
+		{"waiving the fee", 5, url.Values{"reason": {"Flight cancelled"}, "waive_penalty": {"on"}}, 0, 20000},
+		{"without a reason", 5, url.Values{}, 12500, 7500},
+		{"before the policy applies", 30, url.Values{"reason": {"Guest called"}}, 0, 20000},
+	}
+
+	for _, tt := range tests {
+		res := paidReservation(t, tt.arrives, money.New(20000, "USD"))
+		path := fmt.Sprintf("/admin/reservations/%d", res.ID)
+		rr, ctx := postReservationForm(Repo.AdminCancelReservation, path+"/cancel", tt.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != path {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, "flash") {
+			t.Errorf("%s: expected flash message in session", tt.name)
+		}
+
+		got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+		if got.Status != models.ReservationCancelled {
+			t.Errorf("%s: expected cancelled, got %s", tt.name, got.Status)
+		}
+		if got.CancellationReason != tt.postedData.Get("reason") {
+			t.Errorf("%s: expected reason %q, got %q", tt.name, tt.postedData.Get("reason"), got.CancellationReason)
+		}
+		if got.CancellationPenalty != money.New(tt.penalty, "USD") {
+			t.Errorf("%s: expected a penalty of %s, got %s", tt.name, money.New(tt.penalty, "USD"), got.CancellationPenalty)
+		}
+
+		payments, _ := Repo.DB.GetPaymentsByReservation(context.Background(), res.ID)
+		refunded := money.New(0, "USD")
+		for _, p := range payments {
+			if p.Kind == models.PaymentKindRefund && p.Status == models.PaymentSucceeded {
+				refunded = refunded.Add(p.Amount)
+			}
+		}
+		if refunded != money.New(tt.refund, "USD") {
+			t.Errorf("%s: expected a refund of %s, got %s", tt.name, money.New(tt.refund, "USD"), refunded)
+		}
+
+		available, _ := Repo.DB.CheckIfRoomAvailableByDate(context.Background(), res.RoomId, res.StartDate, res.EndDate)
+		if !available {
+			t.Errorf("%s: expected the dates released", tt.name)
+		}
+	}
+}
+
+func TestRepository_AdminCancelReservation_Cancelled(t *testing.T) {
+	res := paidReservation(t, 5, money.New(20000, "USD"))
+	path := fmt.Sprintf("/admin/reservations/%d", res.ID)
+	postReservationForm(Repo.AdminCancelReservation, path+"/cancel", url.Values{"waive_penalty": {"on"}})
+	_, ctx := postReservationForm(Repo.AdminCancelReservation, path+"/cancel", url.Values{})
+
+	if !appConfig.Session.Exists(ctx, "error") {
+		t.Error("expected error message in session")
+	}
+	got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+	if got.CancellationPenalty.Amount != 0 {
+		t.Errorf("the second cancellation charged a penalty of %s", got.CancellationPenalty)
+	}
+	payments, _ := Repo.DB.GetPaymentsByReservation(context.Background(), res.ID)
+	if len(payments) != 2 {
+		t.Errorf("expected the charge and one refund, got %d payments", len(payments))
+	}
+}
+
+func TestRepository_AdminPostCancellationPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		postedData url.Values
+		added      *cancellation.Policy
+	}{
+		{"valid policy", url.Values{"name": {" Strict "}, "free_until_days": {"14"}, "penalty_percent": {"100"}}, &cancellation.Policy{Name: "Strict", FreeUntilDays: 14, PenaltyPercent: 100}},
+		{"missing name", url.Values{"free_until_days": {"14"}, "penalty_percent": {"100"}}, nil},
+		{"negative days", url.Values{"name": {"Strict"}, "free_until_days": {"-1"}, "penalty_percent": {"100"}}, nil},
+		{"over a hundred percent", url.Values{"name": {"Strict"}, "free_until_days": {"14"}, "penalty_percent": {"120"}}, nil},
+	}
+
+	for _, tt := range tests {
+		before, _ := Repo.DB.AllCancellationPolicies(context.Background())
+
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostCancellationPolicy).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/cancellation-policies" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+
+		after, _ := Repo.DB.AllCancellationPolicies(context.Background())
+		if tt.added == nil {
+			if len(after) != len(before) {
+				t.Errorf("%s: added a policy", tt.name)
+			}
+			if !appConfig.Session.Exists(ctx, "error") {
+				t.Errorf("%s: expected error message in session", tt.name)
+			}
+			continue
+		}
+		if len(after) != len(before)+1 {
+			t.Errorf("%s: expected one policy added, got %d", tt.name, len(after)-len(before))
+			continue
+		}
+		got := after[len(after)-1]
+		got.ID = 0
+		if got != *tt.added {
+			t.Errorf("%s: added %+v, want %+v", tt.name, got, *tt.added)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomCancellationPolicy(t *testing.T) {
+	// room 2 starts without a policy
+	tests := []struct {
+		name     string
+		roomId   string
+		policyId string
+		message  string
+		policy   int
+	}{
+		{"attach a policy", "2", "1", "flash", 1},
+		{"invalid policy", "2", "abc", "error", 1},
+		{"back to free cancellation", "2", "", "flash", 0},
+		{"unknown room", "9", "1", "error", 0},
+	}
+
+	for _, tt := range tests {
+		postedData := url.Values{"policy_id": {tt.policyId}}
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies/rooms/"+tt.roomId, strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("roomId", tt.roomId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostRoomCancellationPolicy).ServeHTTP(rr, req)
+
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+		if policy, _ := Repo.DB.GetRoomCancellationPolicy(context.Background(), 2); policy.ID != tt.policy {
+			t.Errorf("%s: room 2 has policy %d, want %d", tt.name, policy.ID, tt.policy)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+)
+
+// AdminFrontDesk lists the arrivals and departures of a day, today unless
+// the query asks for another date. Guests still in their room after their
+// departure date are flagged, more so when the next booking of the room has
+// already started.
+func (m *Repository) AdminFrontDesk(w http.ResponseWriter, r *http.Request) {
+	day := today()
+	if v := r.URL.Query().Get("date"); v != "" {
+		d, err := time.Parse(layout, v)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "Dates must look like 2006-01-02")
+			http.Redirect(w, r, "/admin/front-desk", http.StatusSeeOther)
+			return
+		}
+		day = d
+	}
+
+	arrivals, err := m.DB.GetArrivals(r.Context(), day)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get arrivals from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	departures, err := m.DB.GetDepartures(r.Context(), day)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get departures from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	flagDepartures(departures, day)
+
+	dataMap := make(map[string]interface{})
+	dataMap["arrivals"] = arrivals
+	dataMap["departures"] = departures
+
+	strMap := make(map[string]string)
+	strMap["date"] = day.Format(layout)
+	strMap["prev"] = day.AddDate(0, 0, -1).Format(layout)
+	strMap["next"] = day.AddDate(0, 0, 1).Format(layout)
+
+	render.Template(w, r, "adminFrontDesk.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      dataMap,
+		StringMap: strMap,
+	})
+}
+
+// flagDepartures marks the guests still in their room after their departure
+// date as late, and those still in on the day the next booking of their room
+// starts, their own departure day included, as colliding with it.
+func flagDepartures(departures []models.Departure, day time.Time) {
+	for i, d := range departures {
+		stillIn := d.Reservation.Status == models.ReservationCheckedIn
+		departures[i].Late = stillIn && d.Reservation.EndDate.Before(day)
+		departures[i].Collides = stillIn && !d.Reservation.EndDate.After(day) &&
+			!d.NextArrival.IsZero() && !d.NextArrival.After(day)
+	}
+}
+
+// today is the hotel's calendar date, at midnight UTC like the reservation
+// dates it is compared with.
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// AdminCheckIn records the guest's arrival: the time they actually arrived,
+// now unless given, and the identity document they showed.
+func (m *Repository) AdminCheckIn(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := "/admin/front-desk"
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("id_document")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "The guest's identity document is required")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	now := time.Now()
+	if today().Before(reservation.StartDate) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s %s only arrives on %s", reservation.FirstName, reservation.LastName, reservation.StartDate.Format(layout)))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	// no night of the stay is left from the departure day on
+	if !today().Before(reservation.EndDate) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The stay of %s %s ended on %s", reservation.FirstName, reservation.LastName, reservation.EndDate.Format(layout)))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	arrivedAt := now
+	if v := r.Form.Get("arrived_at"); v != "" {
+		t, err := time.ParseInLocation("15:04", v, now.Location())
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "The arrival time must look like 15:04")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		arrivedAt = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	}
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.CheckInReservation(r.Context(), reservation.ID, userId, arrivedAt,
+		strings.TrimSpace(r.Form.Get("id_document")), strings.TrimSpace(r.Form.Get("notes")))
+	if errors.Is(err, lifecycle.ErrTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A reservation %s cannot be checked in", strings.ToLower(lifecycle.Label(reservation.Status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot check the guest in")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s checked in", reservation.FirstName, reservation.LastName))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminCheckOut records the guest's departure and warns the desk about any
+// balance left to pay.
+func (m *Repository) AdminCheckOut(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := "/admin/front-desk"
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	err = m.DB.CheckOutReservation(r.Context(), reservation.ID, userId, time.Now(), strings.TrimSpace(r.Form.Get("notes")))
+	if errors.Is(err, lifecycle.ErrTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A reservation %s cannot be checked out", strings.ToLower(lifecycle.Label(reservation.Status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot check the guest out")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	message := fmt.Sprintf("%s %s checked out", reservation.FirstName, reservation.LastName)
+	if l, err := m.reservationLedger(r.Context(), reservation); err == nil && l.Balance().Amount > 0 {
+		message += fmt.Sprintf(", %s is still due", l.Balance())
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
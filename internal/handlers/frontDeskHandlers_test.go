@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+)
+
+func postFrontDeskForm(handler http.HandlerFunc, id string, data url.Values) (*httptest.ResponseRecorder, context.Context) {
+	req, _ := http.NewRequest("POST", "/admin/front-desk/"+id, strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+// frontDeskReservation makes a reservation in the status arriving the given
+// number of days from today.
+func frontDeskReservation(t *testing.T, status string, arrives int) models.Reservation {
+	res := models.Reservation{
+		RoomId:    1,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Status:    status,
+		StartDate: today().AddDate(0, 0, arrives),
+		EndDate:   today().AddDate(0, 0, arrives+2),
+	}
+	id, err := Repo.DB.CreateReservation(context.Background(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ID = id
+	return res
+}
+
+func TestRepository_AdminCheckIn(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		arrives    int
+		postedData url.Values
+		checkedIn  bool
+		arrivedAt  string
+	}{
+		{"arriving today", models.ReservationConfirmed, 0, url.Values{"id_document": {"P1234567"}}, true, ""},
+		{"with an arrival time", models.ReservationConfirmed, 0, url.Values{"id_document": {"P1234567"}, "arrived_at": {"14:30"}, "notes": {"Late flight"}}, true, "14:30"},
+		{"invalid arrival time", models.ReservationConfirmed, 0, url.Values{"id_document": {"P1234567"}, "arrived_at": {"2pm"}}, false, ""},
+		{"missing id document", models.ReservationConfirmed, 0, url.Values{}, false, ""},
+		{"arrives later", models.ReservationConfirmed, 30, url.Values{"id_document": {"P1234567"}}, false, ""},
+		{"departure day", models.ReservationConfirmed, -2, url.Values{"id_document": {"P1234567"}}, false, ""},
+		{"stay over", models.ReservationConfirmed, -10, url.Values{"id_document": {"P1234567"}}, false, ""},
+		{"already checked in", models.ReservationCheckedIn, -1, url.Values{"id_document": {"P1234567"}}, true, ""},
+	}
+
+	for _, tt := range tests {
+		res := frontDeskReservation(t, tt.status, tt.arrives)
+		before := time.Now()
+		rr, ctx := postFrontDeskForm(Repo.AdminCheckIn, strconv.Itoa(res.ID), tt.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/front-desk" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		changed := tt.checkedIn && tt.status != models.ReservationCheckedIn
+		if changed && !appConfig.Session.Exists(ctx, "flash") {
+			t.Errorf("%s: expected flash message in session", tt.name)
+		}
+		if !changed && !appConfig.Session.Exists(ctx, "error") {
+			t.Errorf("%s: expected error message in session", tt.name)
+		}
+
+		got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+		if (got.Status == models.ReservationCheckedIn) != tt.checkedIn {
+			t.Errorf("%s: unexpected status %s", tt.name, got.Status)
+		}
+		if !changed {
+			if !got.CheckedInAt.IsZero() || got.GuestIdDocument != "" {
+				t.Errorf("%s: recorded an arrival at %v with %q", tt.name, got.CheckedInAt, got.GuestIdDocument)
+			}
+			continue
+		}
+		if got.GuestIdDocument != tt.postedData.Get("id_document") {
+			t.Errorf("%s: expected id document %q, got %q", tt.name, tt.postedData.Get("id_document"), got.GuestIdDocument)
+		}
+		if got.FrontDeskNotes != tt.postedData.Get("notes") {
+			t.Errorf("%s: expected notes %q, got %q", tt.name, tt.postedData.Get("notes"), got.FrontDeskNotes)
+		}
+		if tt.arrivedAt != "" && got.CheckedInAt.Format("15:04") != tt.arrivedAt {
+			t.Errorf("%s: expected arrival at %s, got %v", tt.name, tt.arrivedAt, got.CheckedInAt)
+		}
+		if tt.arrivedAt == "" && (got.CheckedInAt.Before(before) || got.CheckedInAt.After(time.Now())) {
+			t.Errorf("%s: expected arrival now, got %v", tt.name, got.CheckedInAt)
+		}
+	}
+}
+
+func TestRepository_AdminCheckOut(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     string
+		checkedOut bool
+	}{
+		{"checked in", models.ReservationCheckedIn, true},
+		{"not arrived yet", models.ReservationConfirmed, false},
+	}
+
+	for _, tt := range tests {
+		res := frontDeskReservation(t, tt.status, -2)
+		rr, ctx := postFrontDeskForm(Repo.AdminCheckOut, strconv.Itoa(res.ID), url.Values{"notes": {"Minibar"}})
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/front-desk" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+
+		got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+		if !tt.checkedOut {
+			if got.Status != tt.status || !got.CheckedOutAt.IsZero() {
+				t.Errorf("%s: expected the reservation untouched, got %s left at %v", tt.name, got.Status, got.CheckedOutAt)
+			}
+			if !appConfig.Session.Exists(ctx, "error") {
+				t.Errorf("%s: expected error message in session", tt.name)
+			}
+			continue
+		}
+		if got.Status != models.ReservationCheckedOut || got.CheckedOutAt.IsZero() {
+			t.Errorf("%s: expected checked out, got %s left at %v", tt.name, got.Status, got.CheckedOutAt)
+		}
+		if got.FrontDeskNotes != "Minibar" {
+			t.Errorf("%s: expected notes %q, got %q", tt.name, "Minibar", got.FrontDeskNotes)
+		}
+		if flash := appConfig.Session.GetString(ctx, "flash"); flash != "Jane Doe checked out" {
+			t.Errorf("%s: unexpected flash %q", tt.name, flash)
+		}
+	}
+}
+
+func TestFlagDepartures(t *testing.T) {
+	day := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	departure := func(status string, end, next time.Time) models.Departure {
+		return models.Departure{
+			Reservation: models.Reservation{Status: status, StartDate: end.AddDate(0, 0, -2), EndDate: end},
+			NextArrival: next,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		d        models.Departure
+		late     bool
+		collides bool
+	}{
+		{"leaving today", departure(models.ReservationCheckedIn, day, time.Time{}), false, false},
+		{"leaving today, next guest today", departure(models.ReservationCheckedIn, day, day), false, true},
+		{"leaving today, next guest tomorrow", departure(models.ReservationCheckedIn, day, day.AddDate(0, 0, 1)), false, false},
+		{"overstaying", departure(models.ReservationCheckedIn, day.AddDate(0, 0, -1), time.Time{}), true, false},
+		{"overstaying into the next guest", departure(models.ReservationCheckedIn, day.AddDate(0, 0, -1), day), true, true},
+		{"left today, next guest today", departure(models.ReservationCheckedOut, day, day), false, false},
+	}
+
+	for _, tt := range tests {
+		departures := []models.Departure{tt.d}
+		flagDepartures(departures, day)
+		if departures[0].Late != tt.late {
+			t.Errorf("%s: expected late %v, got %v", tt.name, tt.late, departures[0].Late)
+		}
+		if departures[0].Collides != tt.collides {
+			t.Errorf("%s: expected collides %v, got %v", tt.name, tt.collides, departures[0].Collides)
+		}
+	}
+}
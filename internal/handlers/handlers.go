@@ -1,22 +1,37 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/config"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/driver"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository/dbRepo"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
-const layout = "2006-01-02"
+const (
+	layout             = "2006-01-02"
+	verifyEmailPurpose = "verify-email"
+)
 
 type Repository struct {
 	App *config.AppConfig
@@ -44,7 +59,7 @@ func InitializeTestingRepository(a *config.AppConfig) *Repository {
 }
 
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	m.DB.AllUsers()
+	m.DB.AllUsers(r.Context())
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
@@ -57,8 +72,18 @@ func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	amenities, err := m.DB.AllAmenities(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["amenities"] = amenities
+
 	render.Template(w, r, "searchAvailability.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
+		Data: data,
 	})
 }
 
@@ -81,22 +106,68 @@ func (m *Repository) SearchAvailability(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityInRange(startDate, endDate)
+	guests, ok := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Enter between 1 and %d adults and up to %d children", models.MaxGuests, models.MaxGuests))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	var wanted []models.Amenity
+	if slugs := r.Form["amenity"]; len(slugs) > 0 {
+		catalogue, err := m.DB.AllAmenities(r.Context())
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if wanted, ok = pickAmenities(catalogue, slugs); !ok {
+			m.App.Session.Put(r.Context(), "error", "Unknown amenity")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+	}
+
+	rooms, err := m.DB.SearchAvailabilityInRange(r.Context(), startDate, endDate, guests, amenityIds(wanted))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(), "error", "No available rooms")
+		message := "No available rooms"
+		reasons, err := m.stayRuleReasons(r.Context(), startDate, endDate)
+		if err != nil {
+			m.App.ErrorLog.Println("cannot explain stay rules", err)
+		}
+		if len(reasons) > 0 {
+			message += ". " + strings.Join(reasons, ". ")
+		}
+		m.App.Session.Put(r.Context(), "error", message)
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	// rooms that cannot be priced are still listed, just without a total
+	quotes := make(map[int]pricing.Quote, len(rooms))
+	for _, room := range rooms {
+		quote, err := m.quoteStay(r.Context(), room.ID, startDate, endDate)
+		if err != nil {
+			m.App.ErrorLog.Println("cannot price room", room.ID, err)
+			continue
+		}
+		quotes[room.ID] = quote
 	}
 
 	data := make(map[string]interface{})
 
 	data["rooms"] = rooms
+	data["quotes"] = quotes
+	data["guests"] = guests
+	data["amenities"] = wanted
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    guests.Adults,
+		Children:  guests.Children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -118,6 +189,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		startDate, errParseSD := time.Parse(layout, r.Form.Get("start"))
 		endDate, errParsedED := time.Parse(layout, r.Form.Get("end"))
 		roomId, errParsedRoomId := strconv.Atoi(r.Form.Get("room_id"))
+		guests, guestsOK := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
 		if errParsedED != nil || errParseSD != nil {
 			resp.OK = false
 			statusCode = http.StatusBadRequest
@@ -126,8 +198,20 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 			resp.OK = false
 			statusCode = http.StatusBadRequest
 			resp.Message = "Cannot parse room id"
+		} else if !guestsOK {
+			resp.OK = false
+			statusCode = http.StatusBadRequest
+			resp.Message = "Cannot parse guests"
 		} else {
-			available, err := m.DB.CheckIfRoomAvailableByDate(roomId, startDate, endDate)
+			available, err := m.DB.CheckIfRoomAvailableByDate(r.Context(), roomId, startDate, endDate)
+			var room models.Room
+			var rules stayrules.Rules
+			if err == nil && available {
+				room, err = m.DB.GetRoomById(r.Context(), roomId)
+			}
+			if err == nil && available {
+				rules, err = m.DB.GetRoomStayRules(r.Context(), roomId)
+			}
 			if err != nil {
 				resp.OK = false
 				statusCode = http.StatusInternalServerError
@@ -136,6 +220,18 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 				resp.OK = available
 				resp.StartDate = startDate.Format(layout)
 				resp.EndDate = endDate.Format(layout)
+				resp.Adults = guests.Adults
+				resp.Children = guests.Children
+				var violation *stayrules.Violation
+				if available && !room.ArchivedAt.IsZero() {
+					resp.OK = false
+				} else if available && !room.Sleeps(guests) {
+					resp.OK = false
+					resp.Message = fmt.Sprintf("This room sleeps at most %d adults and %d children", room.MaxAdults, room.MaxChildren)
+				} else if available && errors.As(rules.Check(startDate, endDate, time.Now()), &violation) {
+					resp.OK = false
+					resp.Message = violation.Message
+				}
 			}
 		}
 	}
@@ -146,6 +242,82 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// stayRuleReasons explains the stay rules of the rooms that refuse the stay,
+// each reason once.
+func (m *Repository) stayRuleReasons(ctx context.Context, start, end time.Time) ([]string, error) {
+	rules, err := m.DB.AllStayRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roomIds := make([]int, 0, len(rules))
+	for id := range rules {
+		roomIds = append(roomIds, id)
+	}
+	sort.Ints(roomIds)
+
+	var reasons []string
+	seen := make(map[string]bool)
+	now := time.Now()
+	for _, id := range roomIds {
+		var violation *stayrules.Violation
+		if errors.As(rules[id].Check(start, end, now), &violation) && !seen[violation.Message] {
+			seen[violation.Message] = true
+			reasons = append(reasons, violation.Message)
+		}
+	}
+	return reasons, nil
+}
+
+// parseGuests reads the party size of a search, one adult and no children
+// when left empty.
+func parseGuests(adults, children string) (models.Guests, bool) {
+	guests := models.Guests{Adults: 1}
+	var err error
+	if adults != "" {
+		if guests.Adults, err = strconv.Atoi(adults); err != nil {
+			return guests, false
+		}
+	}
+	if children != "" {
+		if guests.Children, err = strconv.Atoi(children); err != nil {
+			return guests, false
+		}
+	}
+
+	ok := guests.Adults >= 1 && guests.Adults <= models.MaxGuests &&
+		guests.Children >= 0 && guests.Children <= models.MaxGuests
+	return guests, ok
+}
+
+// pickAmenities looks up the amenities of the catalogue with the slugs, it
+// reports false when a slug is not in the catalogue.
+func pickAmenities(catalogue []models.Amenity, slugs []string) ([]models.Amenity, bool) {
+	var picked []models.Amenity
+	for _, slug := range slugs {
+		found := false
+		for _, a := range catalogue {
+			if a.Slug == slug {
+				picked = append(picked, a)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return picked, true
+}
+
+func amenityIds(amenities []models.Amenity) []int {
+	ids := make([]int, 0, len(amenities))
+	for _, a := range amenities {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
 func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
@@ -161,8 +333,10 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
-	email := r.Form.Get("email")
+	// emails are stored lowercased at registration
+	email := strings.ToLower(strings.TrimSpace(r.Form.Get("email")))
 	password := r.Form.Get("password")
+	r.PostForm.Set("email", email)
 
 	f := forms.New(r.PostForm)
 	f.Required("email", "password")
@@ -178,7 +352,7 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Invalid login")
@@ -186,6 +360,24 @@ func (m *Repository) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !user.EmailVerified {
+		strMap := make(map[string]string)
+		strMap["email"] = email
+		strMap["unverified"] = "true"
+		m.App.Session.Put(r.Context(), "error", "Please verify your email address before logging in")
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form:      forms.New(nil),
+			StringMap: strMap,
+		})
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "user_id", id)
 	m.App.Session.Put(r.Context(), "flash", "Logged in!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -204,5 +396,284 @@ func (m *Repository) ShowRegistration(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) PostRegistration(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/user/register", http.StatusSeeOther)
+		return
+	}
+
+	user := models.User{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     strings.ToLower(strings.TrimSpace(r.Form.Get("email"))),
+		Phone:     r.Form.Get("phone"),
+		Password:  r.Form.Get("password"),
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("first_name", "last_name", "email", "password", "confirm_password")
+	f.MinLength("first_name", 2, r)
+	f.MinLength("last_name", 2, r)
+	f.MinLength("password", 8, r)
+	f.IsEmail("email")
+
+	if user.Password != r.Form.Get("confirm_password") {
+		f.Errors.Add("confirm_password", "Passwords do not match")
+	}
+
+	if f.Valid() {
+		if _, err := m.DB.GetUserByEmail(r.Context(), user.Email); err == nil {
+			f.Errors.Add("email", "This email is already registered")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
 
+	if !f.Valid() {
+		strMap := make(map[string]string)
+		strMap["first_name"] = user.FirstName
+		strMap["last_name"] = user.LastName
+		strMap["email"] = user.Email
+		strMap["phone"] = user.Phone
+		render.Template(w, r, "registration.page.tmpl", &models.TemplateData{
+			Form:      f,
+			StringMap: strMap,
+		})
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot create account")
+		http.Redirect(w, r, "/user/register", http.StatusSeeOther)
+		return
+	}
+
+	m.sendVerificationEmail(user)
+
+	m.App.Session.Put(r.Context(), "flash", "Account created! Check your email to verify your address")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	subject, err := tokens.Verify(m.App.SecretKey, verifyEmailPurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Verification link is invalid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	idStr, email, _ := strings.Cut(subject, "|")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Verification link is invalid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.DB.GetUserById(r.Context(), id)
+	if err != nil || user.Email != email {
+		m.App.Session.Put(r.Context(), "error", "Verification link is invalid or has expired")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.VerifyUserEmail(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot verify email address")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Email verified, you can now log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.Form.Get("email")))
+
+	// Always answer the same way so the form cannot be used to probe for accounts
+	user, err := m.DB.GetUserByEmail(r.Context(), email)
+	if err == nil && !user.EmailVerified {
+		m.sendVerificationEmail(user)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If the account exists and is not verified, a new link has been sent")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgotPassword.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("email")
+	f.IsEmail("email")
+
+	if !f.Valid() {
+		strMap := make(map[string]string)
+		strMap["email"] = r.Form.Get("email")
+		render.Template(w, r, "forgotPassword.page.tmpl", &models.TemplateData{
+			Form:      f,
+			StringMap: strMap,
+		})
+		return
+	}
+
+	// Same answer whether or not the account exists
+	user, err := m.DB.GetUserByEmail(r.Context(), strings.ToLower(strings.TrimSpace(r.Form.Get("email"))))
+	if err == nil {
+		token, err := tokens.Random(32)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.InsertPasswordReset(r.Context(), user.ID, tokens.Hash(token), time.Now().Add(m.App.PasswordResetTTL))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		link := fmt.Sprintf("%s/user/reset-password/%s", m.App.BaseURL, token)
+		htmlMessage := fmt.Sprintf(`
+			<strong>Password reset</strong><br>
+			Dear %s,<br>
+			Someone asked to reset the password of your account. If it was you, click <a href="%s">this link</a>.<br>
+			The link can be used once and expires in %s. If you did not ask for it, you can ignore this email.
+		`, html.EscapeString(user.FirstName), link, m.App.PasswordResetTTL)
+
+		m.App.MailChan <- models.MailData{
+			To:       user.Email,
+			From:     "universal@booking.com",
+			Subject:  "Reset your password",
+			Content:  htmlMessage,
+			Template: "basic.html",
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If the account exists, a reset link has been sent to your email")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	if _, ok := m.validPasswordReset(r.Context(), token); !ok {
+		m.App.Session.Put(r.Context(), "error", "Reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	strMap := make(map[string]string)
+	strMap["token"] = token
+	render.Template(w, r, "resetPassword.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: strMap,
+	})
+}
+
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	reset, ok := m.validPasswordReset(r.Context(), token)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Reset link is invalid or has expired")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, "/user/reset-password/"+token, http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("password", "confirm_password")
+	f.MinLength("password", 8, r)
+	if r.Form.Get("password") != r.Form.Get("confirm_password") {
+		f.Errors.Add("confirm_password", "Passwords do not match")
+	}
+
+	if !f.Valid() {
+		strMap := make(map[string]string)
+		strMap["token"] = token
+		render.Template(w, r, "resetPassword.page.tmpl", &models.TemplateData{
+			Form:      f,
+			StringMap: strMap,
+		})
+		return
+	}
+
+	err = m.DB.ResetUserPassword(r.Context(), reset.ID, reset.UserId, r.Form.Get("password"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot reset password")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	err = helpers.RevokeUserSessions(r.Context(), reset.UserId)
+	if err != nil {
+		m.App.ErrorLog.Println("cannot revoke sessions after password reset", err)
+	}
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "user_id")
+	m.App.Session.Put(r.Context(), "flash", "Password changed, please log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (m *Repository) validPasswordReset(ctx context.Context, token string) (models.PasswordReset, bool) {
+	if token == "" {
+		return models.PasswordReset{}, false
+	}
+
+	reset, err := m.DB.GetPasswordResetByTokenHash(ctx, tokens.Hash(token))
+	if err != nil || time.Now().After(reset.ExpiresAt) {
+		return models.PasswordReset{}, false
+	}
+
+	return reset, true
+}
+
+func (m *Repository) sendVerificationEmail(user models.User) {
+	token := tokens.Sign(m.App.SecretKey, verifyEmailPurpose, fmt.Sprintf("%d|%s", user.ID, user.Email), m.App.EmailVerificationTTL)
+	link := fmt.Sprintf("%s/user/verify-email?token=%s", m.App.BaseURL, url.QueryEscape(token))
+
+	htmlMessage := fmt.Sprintf(`
+		<strong>Verify your email</strong><br>
+		Dear %s,<br>
+		Please confirm your email address by clicking <a href="%s">this link</a>.<br>
+		The link expires in %s.
+	`, html.EscapeString(user.FirstName), link, m.App.EmailVerificationTTL)
+
+	m.App.MailChan <- models.MailData{
+		To:       user.Email,
+		From:     "universal@booking.com",
+		Subject:  "Verify your email address",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
 }
@@ -3,14 +3,19 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"html"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
 type postData struct {
@@ -31,6 +36,20 @@ var testCases = []struct {
 	{"search-availability", "/search-availability", "GET", []postData{}, 200},
 	{"show-login", "/login", "GET", []postData{}, 200},
 	{"show-registration", "/register", "GET", []postData{}, 200},
+	{"admin-pricing", "/admin/pricing", "GET", []postData{}, 200},
+	{"admin-room-pricing", "/admin/pricing/1", "GET", []postData{}, 200},
+	{"admin-cancellation-policies", "/admin/cancellation-policies", "GET", []postData{}, 200},
+	{"admin-reservations-by-status", "/admin/reservations-all?status=checked_in", "GET", []postData{}, 200},
+	{"admin-show-reservation", "/admin/reservations/1", "GET", []postData{}, 200},
+	{"admin-front-desk", "/admin/front-desk", "GET", []postData{}, 200},
+	{"admin-front-desk-by-date", "/admin/front-desk?date=2050-01-01", "GET", []postData{}, 200},
+	{"admin-rooms", "/admin/rooms", "GET", []postData{}, 200},
+	{"admin-new-room", "/admin/rooms/new", "GET", []postData{}, 200},
+	{"admin-show-room", "/admin/rooms/1", "GET", []postData{}, 200},
+	{"admin-room-images", "/admin/rooms/1/images", "GET", []postData{}, 200},
+	{"admin-reservations-calendar", "/admin/reservations-calendar?y=2050&m=1", "GET", []postData{}, 200},
+	{"admin-new-block-for-unit", "/admin/blocks/new?room_id=2&unit_id=3", "GET", []postData{}, 200},
+	{"admin-amenities", "/admin/amenities", "GET", []postData{}, 200},
 }
 
 func TestHandlers(t *testing.T) {
@@ -74,12 +93,14 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		UserId: 1,
-		RoomId: 1,
+		UserId:    1,
+		RoomId:    1,
+		StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			Name:        "General's Quarters",
 			ID:          2,
-			Price:       123,
+			Price:       money.New(12300, "USD"),
 			Description: "description",
 			Slug:        "generals-quarters",
 		},
@@ -249,6 +270,27 @@ func TestRepository_CreateReservation(t *testing.T) {
 	if rr.Code != http.StatusTemporaryRedirect {
 		t.Errorf("CreateReservation handler returned wrong response code: got %d, want %d", rr.Code, http.StatusTemporaryRedirect)
 	}
+
+	// room booked by someone else in the meantime
+	reservation.RoomId = 3
+
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "reservation", reservation)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("CreateReservation handler returned wrong response code: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if loc := rr.Header().Get("Location"); loc != "/search-availability" {
+		t.Errorf("CreateReservation redirected to %s, want /search-availability", loc)
+	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
@@ -412,7 +454,7 @@ func TestRepository_ReservationSummary(t *testing.T) {
 		Room: models.Room{
 			Name:        "General's Quarters",
 			ID:          2,
-			Price:       123,
+			Price:       money.New(12300, "USD"),
 			Description: "description",
 			Slug:        "generals-quarters",
 		},
@@ -462,7 +504,7 @@ func TestRepository_ChooseRoom(t *testing.T) {
 		Room: models.Room{
 			Name:        "General's Quarters",
 			ID:          2,
-			Price:       123,
+			Price:       money.New(12300, "USD"),
 			Description: "description",
 			Slug:        "generals-quarters",
 		},
@@ -512,6 +554,327 @@ func TestRepository_ChooseRoom(t *testing.T) {
 	}
 }
 
+func TestRepository_PostRegistration(t *testing.T) {
+	handler := http.HandlerFunc(Repo.PostRegistration)
+	postData := url.Values{}
+	postData.Add("first_name", "Thanh Phuoc")
+	postData.Add("last_name", "Nguyen")
+	postData.Add("email", "testing@example.com")
+	postData.Add("password", "super-secret")
+	postData.Add("confirm_password", "super-secret")
+
+	req, _ := http.NewRequest("POST", "/user/register", strings.NewReader(postData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostRegistration handler returned wrong response code: got %d, want %d", rr.Code, http.StatusSeeOther)
+	}
+
+	if loc := rr.Header().Get("Location"); loc != "/user/login" {
+		t.Errorf("PostRegistration redirected to %s, want /user/login", loc)
+	}
+
+	// email already registered
+	postData.Set("email", "existing@example.com")
+	req, _ = http.NewRequest("POST", "/user/register", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostRegistration handler returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	// passwords do not match
+	postData.Set("email", "testing@example.com")
+	postData.Set("confirm_password", "something-else")
+	req, _ = http.NewRequest("POST", "/user/register", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostRegistration handler returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	// cannot insert user
+	postData.Set("email", "fail@example.com")
+	postData.Set("confirm_password", "super-secret")
+	req, _ = http.NewRequest("POST", "/user/register", strings.NewReader(postData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/user/register" {
+		t.Errorf("PostRegistration redirected to %s, want /user/register", loc)
+	}
+}
+
+func TestRepository_VerifyEmail(t *testing.T) {
+	handler := http.HandlerFunc(Repo.VerifyEmail)
+
+	tests := []struct {
+		name  string
+		token string
+		flash string
+	}{
+		{"valid", tokens.Sign(appConfig.SecretKey, verifyEmailPurpose, "1|", time.Hour), "flash"},
+		{"expired", tokens.Sign(appConfig.SecretKey, verifyEmailPurpose, "1|", -time.Hour), "error"},
+		{"wrong purpose", tokens.Sign(appConfig.SecretKey, "other", "1|", time.Hour), "error"},
+		{"email changed", tokens.Sign(appConfig.SecretKey, verifyEmailPurpose, "1|old@example.com", time.Hour), "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/user/verify-email?token="+url.QueryEscape(tt.token), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: VerifyEmail handler returned wrong response code: got %d, want %d", tt.name, rr.Code, http.StatusSeeOther)
+		}
+
+		if !appConfig.Session.Exists(ctx, tt.flash) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.flash)
+		}
+	}
+}
+
+func TestRepository_PostLogin_Unverified(t *testing.T) {
+	// the email is matched however it is typed
+	for _, email := range []string{"unverified@example.com", " Unverified@Example.com "} {
+		postData := url.Values{}
+		postData.Add("email", email)
+		postData.Add("password", "super-secret")
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostLogin).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%q: PostLogin handler returned wrong response code: got %d, want %d", email, rr.Code, http.StatusOK)
+		}
+
+		if appConfig.Session.Exists(ctx, "user_id") {
+			t.Errorf("%q: unverified user was logged in", email)
+		}
+
+		if !strings.Contains(rr.Body.String(), "/user/resend-verification") {
+			t.Errorf("%q: login page does not offer to resend the verification email", email)
+		}
+	}
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+	// an existing login of the user that must not survive the reset
+	otherReq, _ := http.NewRequest("GET", "/", nil)
+	otherCtx := getCtx(otherReq)
+	appConfig.Session.Put(otherCtx, "user_id", 1)
+	otherToken, _, err := appConfig.Session.Commit(otherCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := http.HandlerFunc(Repo.PostResetPassword)
+	postData := url.Values{}
+	postData.Add("password", "new-password")
+	postData.Add("confirm_password", "new-password")
+
+	tests := []struct {
+		name     string
+		token    string
+		location string
+	}{
+		{"unknown token", "unknown-token", "/user/forgot-password"},
+		{"expired token", "expired-token", "/user/forgot-password"},
+		{"valid token", "valid-token", "/user/login"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/user/reset-password/"+tt.token, strings.NewReader(postData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", tt.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: PostResetPassword redirected to %s, want %s", tt.name, loc, tt.location)
+		}
+	}
+
+	otherCtx, _ = appConfig.Session.Load(context.Background(), otherToken)
+	if appConfig.Session.Exists(otherCtx, "user_id") {
+		t.Error("existing session was not revoked after password reset")
+	}
+}
+
+func TestRepository_UserCancelReservation(t *testing.T) {
+	handler := http.HandlerFunc(Repo.UserCancelReservation)
+
+	tests := []struct {
+		name     string
+		userId   int
+		id       string
+		location string
+		message  string
+	}{
+		{"own reservation", 1, "1", "/user/reservations", "flash"},
+		{"too close to arrival", 1, "2", "/user/reservations/2", "error"},
+		{"with a cancellation fee", 1, "3", "/user/reservations", "flash"},
+		{"already cancelled", 1, "4", "/user/reservations/4", "error"},
+		{"someone else's reservation", 5, "1", "/user/reservations", "error"},
+		{"invalid id", 1, "abc", "/user/reservations", "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/user/reservations/"+tt.id+"/cancel", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		appConfig.Session.Put(ctx, "user_id", tt.userId)
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: UserCancelReservation redirected to %s, want %s", tt.name, loc, tt.location)
+		}
+
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_UserShowReservation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/reservations/1", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.UserShowReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("UserShowReservation handler returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "/user/reservations/1/cancel") {
+		t.Error("cancellable reservation does not offer the cancel action")
+	}
+}
+
+func TestRepository_CreateReservation_Anonymous(t *testing.T) {
+	bookings := []struct {
+		userId int
+		start  time.Time
+	}{
+		{0, time.Date(2051, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{1, time.Date(2051, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	postData := url.Values{
+		"first_name": {"Thanh Phuoc"},
+		"last_name":  {"Nguyen"},
+		"email":      {"testing@example.com"},
+		"phone":      {"123456789123"},
+	}
+	for _, b := range bookings {
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if b.userId > 0 {
+			appConfig.Session.Put(ctx, "user_id", b.userId)
+		}
+		appConfig.Session.Put(ctx, "reservation", models.Reservation{RoomId: 1, StartDate: b.start, EndDate: b.start.AddDate(0, 0, 2), Adults: 1})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.CreateReservation).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/reservation-payment" {
+			t.Fatalf("booking for user %d redirected to %s", b.userId, loc)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/user/reservations", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "user_id", 1)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.UserReservations).ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "2051-04-01") {
+		t.Error("user 1 does not see their own booking")
+	}
+	if strings.Contains(body, "2051-03-01") {
+		t.Error("user 1 sees a booking made without logging in")
+	}
+}
+
+func TestRepository_CancelledRequest(t *testing.T) {
+	postData := url.Values{}
+	postData.Add("start", "2021-01-01")
+	postData.Add("end", "2021-01-02")
+	postData.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+	ctx, cancel := context.WithCancel(getCtx(req))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// the client went away before the query ran
+	cancel()
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("AvailabilityJSON handler returned wrong response code: got %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal("failed to parse json")
+	}
+
+	if j.OK {
+		t.Error("AvailabilityJSON returned OK for a cancelled request")
+	}
+}
+
 func getCtx(req *http.Request) context.Context {
 	ctx, err := appConfig.Session.Load(req.Context(), req.Header.Get("X-Session"))
 
@@ -521,3 +884,756 @@ func getCtx(req *http.Request) context.Context {
 
 	return ctx
 }
+
+func TestRepository_AdminPostAPIToken(t *testing.T) {
+	tests := []struct {
+		name        string
+		postedData  url.Values
+		expectToken bool
+	}{
+		{"allowed scope", url.Values{"name": {"channel manager"}, "user_id": {"1"}, "scopes": {"rooms.read", "reservations.view"}, "expires_in_days": {"30"}}, true},
+		{"only scopes beyond the role", url.Values{"name": {"script"}, "user_id": {"1"}, "scopes": {"reservations.view"}}, false},
+		{"missing name", url.Values{"user_id": {"1"}, "scopes": {"rooms.read"}}, false},
+		{"invalid expiry", url.Values{"name": {"script"}, "user_id": {"1"}, "scopes": {"rooms.read"}, "expires_in_days": {"-1"}}, false},
+		{"insert fails", url.Values{"name": {"fail"}, "user_id": {"1"}, "scopes": {"rooms.read"}}, false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-tokens", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostAPIToken).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, http.StatusSeeOther)
+		}
+
+		token := appConfig.Session.GetString(ctx, "new_api_token")
+		if tt.expectToken && !strings.HasPrefix(token, APIKeyPrefix) {
+			t.Errorf("%s: expected a new api token in session, got %q", tt.name, token)
+		}
+		if !tt.expectToken && token != "" {
+			t.Errorf("%s: did not expect a token to be issued", tt.name)
+		}
+	}
+}
+
+func TestRepository_AdminRevokeAPIToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/api-tokens/1/revoke", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminRevokeAPIToken).ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/admin/api-tokens" || !appConfig.Session.Exists(ctx, "flash") {
+		t.Errorf("AdminRevokeAPIToken did not revoke, got location %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_RoomCalendar(t *testing.T) {
+	room := models.Room{ID: 1, Slug: "generals-quarters"}
+	feedURL := Repo.roomCalendarURL(room)
+	token := feedURL[strings.Index(feedURL, "token=")+len("token="):]
+
+	if !strings.Contains(feedURL, "/rooms/generals-quarters/calendar.ics?") {
+		t.Errorf("feed url is not addressed by slug: %s", feedURL)
+	}
+
+	// the token stays the same when the slug changes
+	room.Slug = "generals-quarters-renamed"
+	if renamed := Repo.roomCalendarURL(room); !strings.HasSuffix(renamed, "token="+token) {
+		t.Errorf("renaming the room changed its feed token: %s", renamed)
+	}
+
+	tests := []struct {
+		name         string
+		room         string
+		token        string
+		expectStatus int
+	}{
+		{"valid token", "generals-quarters", token, http.StatusOK},
+		{"by room id", "1", token, http.StatusOK},
+		{"wrong token", "generals-quarters", "nope", http.StatusNotFound},
+		{"token of another room", "2", token, http.StatusNotFound},
+		{"unknown slug", "majors-suite", token, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", "/rooms/"+tt.room+"/calendar.ics?token="+tt.token, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("slug", tt.room)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.RoomCalendar).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.expectStatus)
+			continue
+		}
+		if tt.expectStatus != http.StatusOK {
+			continue
+		}
+
+		body := rr.Body.String()
+		for _, want := range []string{"UID:reservation-3@go-bookings-app", "UID:restriction-8@go-bookings-app", "SUMMARY:Blocked"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: feed is missing %q", tt.name, want)
+			}
+		}
+		if strings.Contains(body, "restriction-10@") {
+			t.Errorf("%s: block imported from a channel is exported again", tt.name)
+		}
+	}
+}
+
+func TestSoldOutEvents(t *testing.T) {
+	first := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	units := []models.RoomUnit{{ID: 2, Name: "201"}, {ID: 3, Name: "202"}}
+	restrictions := []models.RoomRestriction{
+		{ID: 1, RoomUnitId: 2, ReservationId: 5, StartDate: first, EndDate: first.AddDate(0, 0, 4)},
+		{ID: 2, RoomUnitId: 3, ReservationId: 6, StartDate: first.AddDate(0, 0, 2), EndDate: first.AddDate(0, 0, 3)},
+		// closes both units
+		{ID: 3, RestrictionId: models.RestrictionOwnerBlock, StartDate: first.AddDate(0, 0, 3), EndDate: first.AddDate(0, 0, 5)},
+		// only one unit, the room can still be sold
+		{ID: 4, RoomUnitId: 3, ReservationId: 7, StartDate: first.AddDate(0, 0, 10), EndDate: first.AddDate(0, 0, 12)},
+		// imported from a channel, which already knows about it
+		{ID: 5, RestrictionId: models.RestrictionExternal, FeedId: 1, StartDate: first.AddDate(0, 0, 20), EndDate: first.AddDate(0, 0, 22)},
+	}
+
+	events := soldOutEvents(ownRestrictions(restrictions), units, first)
+
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1: %+v", len(events), events)
+	}
+	if !events[0].Start.Equal(first.AddDate(0, 0, 2)) || !events[0].End.Equal(first.AddDate(0, 0, 5)) {
+		t.Errorf("sold out from %s to %s, want 2050-01-12 to 2050-01-15", events[0].Start, events[0].End)
+	}
+	if events[0].UID != "soldout-20500112@go-bookings-app" {
+		t.Errorf("unexpected uid %s", events[0].UID)
+	}
+}
+
+func TestRepository_AdminPostICalFeed(t *testing.T) {
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"https feed", url.Values{"room_id": {"1"}, "name": {"Airbnb"}, "url": {"https://www.airbnb.com/calendar/ical/1.ics"}}, "flash"},
+		{"webcal feed", url.Values{"room_id": {"1"}, "name": {"Booking"}, "url": {"webcal://admin.booking.com/ical/1.ics"}}, "flash"},
+		{"unsupported scheme", url.Values{"room_id": {"1"}, "name": {"Local"}, "url": {"file:///etc/passwd"}}, "error"},
+		{"missing url", url.Values{"room_id": {"1"}, "name": {"Airbnb"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/ical-feeds", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostICalFeed).ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != "/admin/ical-feeds" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, rr.Header().Get("Location"))
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestNormalizeFeedURL(t *testing.T) {
+	if u, ok := normalizeFeedURL(" webcal://example.com/a.ics "); !ok || u != "https://example.com/a.ics" {
+		t.Errorf("webcal url not rewritten, got %q", u)
+	}
+	if _, ok := normalizeFeedURL("example.com/a.ics"); ok {
+		t.Error("url without scheme accepted")
+	}
+}
+
+func TestCalendarSpans(t *testing.T) {
+	first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	restrictions := []models.RoomRestriction{
+		// arrives in december, leaves on the 3rd
+		{ID: 1, ReservationId: 4, StartDate: first.AddDate(0, 0, -2), EndDate: first.AddDate(0, 0, 2)},
+		{ID: 2, RestrictionId: models.RestrictionOwnerBlock, Reason: "deep_clean", StartDate: first.AddDate(0, 0, 4), EndDate: first.AddDate(0, 0, 7)},
+		{ID: 3, RestrictionId: models.RestrictionExternal, StartDate: first.AddDate(0, 0, 29), EndDate: first.AddDate(0, 0, 35)},
+	}
+
+	spans := calendarSpans(restrictions, first, 31)
+
+	total := 0
+	for _, s := range spans {
+		total += s.Days
+	}
+	if total != 31 {
+		t.Fatalf("spans cover %d nights, want 31", total)
+	}
+
+	want := []models.CalendarSpan{
+		{Kind: "reservation", ID: 4, Date: "2050-01-01", Days: 2, Label: "Reservation"},
+		{Kind: "free", Date: "2050-01-03", Days: 1},
+		{Kind: "free", Date: "2050-01-04", Days: 1},
+		{Kind: "block", ID: 2, Date: "2050-01-05", Days: 3, Label: "Deep clean"},
+	}
+	for i, w := range want {
+		if spans[i] != w {
+			t.Errorf("span %d: got %+v, want %+v", i, spans[i], w)
+		}
+	}
+
+	if last := spans[len(spans)-1]; last.Kind != "external" || last.Days != 2 {
+		t.Errorf("external block should be clipped to the month, got %+v", last)
+	}
+}
+
+func TestUnitCalendars(t *testing.T) {
+	first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	units := []models.RoomUnit{{ID: 2, Name: "201"}, {ID: 3, Name: "202"}}
+	restrictions := []models.RoomRestriction{
+		{ID: 1, RoomUnitId: 3, ReservationId: 4, StartDate: first, EndDate: first.AddDate(0, 0, 2)},
+		{ID: 2, RestrictionId: models.RestrictionOwnerBlock, Reason: "maintenance", StartDate: first.AddDate(0, 0, 5), EndDate: first.AddDate(0, 0, 6)},
+	}
+
+	calendars := unitCalendars(units, restrictions, first, 31)
+
+	if len(calendars) != 2 || calendars[0].Unit.ID != 2 || calendars[1].Unit.ID != 3 {
+		t.Fatalf("expected a row for each unit in order, got %+v", calendars)
+	}
+	if s := calendars[0].Spans[0]; s.Kind != "free" {
+		t.Errorf("unit 201 should be free on the 1st, got %+v", s)
+	}
+	if s := calendars[1].Spans[0]; s.Kind != "reservation" || s.ID != 4 || s.Days != 2 {
+		t.Errorf("unit 202 should start with the reservation, got %+v", s)
+	}
+	for _, c := range calendars {
+		found := false
+		for _, s := range c.Spans {
+			found = found || s.Kind == "block" && s.ID == 2
+		}
+		if !found {
+			t.Errorf("the room block is missing from unit %s", c.Unit.Name)
+		}
+	}
+}
+
+func TestRepository_AdminPostBlock(t *testing.T) {
+	tests := []struct {
+		name         string
+		id           string
+		postedData   url.Values
+		expectStatus int
+	}{
+		{"new block", "", url.Values{"room_id": {"1"}, "start_date": {"2050-02-01"}, "last_night": {"2050-02-05"}, "reason": {"owner_stay"}}, http.StatusSeeOther},
+		{"overlaps reservation", "", url.Values{"room_id": {"1"}, "start_date": {"2050-01-09"}, "last_night": {"2050-01-10"}, "reason": {"maintenance"}}, http.StatusOK},
+		{"last night before first", "", url.Values{"room_id": {"1"}, "start_date": {"2050-02-05"}, "last_night": {"2050-02-01"}, "reason": {"maintenance"}}, http.StatusOK},
+		{"unknown reason", "", url.Values{"room_id": {"1"}, "start_date": {"2050-02-01"}, "last_night": {"2050-02-01"}, "reason": {"party"}}, http.StatusOK},
+		{"edit block", "8", url.Values{"room_id": {"1"}, "start_date": {"2050-01-15"}, "last_night": {"2050-01-18"}, "reason": {"deep_clean"}, "note": {"carpets"}}, http.StatusSeeOther},
+		{"edit unknown block", "99", url.Values{"room_id": {"1"}, "start_date": {"2050-01-15"}, "last_night": {"2050-01-18"}, "reason": {"deep_clean"}}, http.StatusSeeOther},
+		{"block one unit", "", url.Values{"room_id": {"2"}, "room_unit_id": {"3"}, "start_date": {"2050-02-01"}, "last_night": {"2050-02-05"}, "reason": {"maintenance"}}, http.StatusSeeOther},
+		{"unit of another room", "", url.Values{"room_id": {"1"}, "room_unit_id": {"3"}, "start_date": {"2050-02-01"}, "last_night": {"2050-02-05"}, "reason": {"maintenance"}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		if tt.id != "" {
+			rctx.URLParams.Add("id", tt.id)
+		}
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostBlock).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.expectStatus)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteBlock(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/blocks/8/delete", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "8")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminDeleteBlock).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/admin/reservations-calendar?y=2050&m=1" {
+		t.Errorf("AdminDeleteBlock redirected to %s", loc)
+	}
+	if !appConfig.Session.Exists(ctx, "flash") {
+		t.Error("expected flash message after removing a block")
+	}
+}
+
+func TestRepository_AdminReservationsCalendar_Archived(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-calendar?y=2050&m=1", nil)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReservationsCalendar).ServeHTTP(rr, req)
+
+	// the reservations of an archived room must stay on the calendar
+	if !strings.Contains(rr.Body.String(), "Colonel&#39;s Cabin") {
+		t.Error("archived room is missing from the reservation calendar")
+	}
+}
+
+func TestRepository_CreateReservation_Price(t *testing.T) {
+	reservation := models.Reservation{
+		RoomId: 1,
+		// thursday and friday nights
+		StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
+	}
+	postData := url.Values{
+		"first_name": {"Thanh Phuoc"},
+		"last_name":  {"Nguyen"},
+		"email":      {"testing@example.com"},
+		"phone":      {"123456789123"},
+	}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.CreateReservation).ServeHTTP(rr, req)
+
+	saved, ok := appConfig.Session.Get(ctx, "reservation").(models.Reservation)
+	if !ok {
+		t.Fatal("reservation missing from session")
+	}
+	if len(saved.Quote.Nights) != 2 || saved.Quote.Total != money.New(25000, "USD") {
+		t.Errorf("got %d nights for %s, want 2 nights for $250.00", len(saved.Quote.Nights), saved.Quote.Total)
+	}
+}
+
+func TestRepository_CreateReservation_Guests(t *testing.T) {
+	// room 1 sleeps 2 adults and 1 child
+	tests := []struct {
+		name     string
+		guests   models.Guests
+		location string
+	}{
+		{"party fits", models.Guests{Adults: 2, Children: 1}, "/reservation-payment"},
+		{"child in an adult's place", models.Guests{Adults: 1, Children: 2}, "/reservation-payment"},
+		{"too many adults", models.Guests{Adults: 3}, "/search-availability"},
+		{"too many children", models.Guests{Adults: 1, Children: 3}, "/search-availability"},
+	}
+
+	postData := url.Values{
+		"first_name": {"Thanh Phuoc"},
+		"last_name":  {"Nguyen"},
+		"email":      {"testing@example.com"},
+		"phone":      {"123456789123"},
+	}
+	for _, tt := range tests {
+		reservation := models.Reservation{
+			RoomId:    1,
+			StartDate: time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
+			Adults:    tt.guests.Adults,
+			Children:  tt.guests.Children,
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		appConfig.Session.Put(ctx, "reservation", reservation)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.CreateReservation).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: redirected to %s, want %s", tt.name, loc, tt.location)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON_Guests(t *testing.T) {
+	tests := []struct {
+		name     string
+		adults   string
+		children string
+		code     int
+		ok       bool
+	}{
+		{"default party", "", "", http.StatusOK, true},
+		{"party fits", "2", "1", http.StatusOK, true},
+		{"too many adults", "3", "0", http.StatusOK, false},
+		{"no adults", "0", "0", http.StatusBadRequest, false},
+		{"not a number", "two", "0", http.StatusBadRequest, false},
+	}
+
+	for _, tt := range tests {
+		postData := url.Values{
+			"start":    {"2070-01-01"},
+			"end":      {"2070-01-02"},
+			"room_id":  {"1"},
+			"adults":   {tt.adults},
+			"children": {tt.children},
+		}
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+		var j jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatalf("%s: cannot parse json: %v", tt.name, err)
+		}
+		if rr.Code != tt.code || j.OK != tt.ok {
+			t.Errorf("%s: got code %d and ok %v, want %d and %v", tt.name, rr.Code, j.OK, tt.code, tt.ok)
+		}
+	}
+}
+
+func TestParseGuests(t *testing.T) {
+	tests := []struct {
+		adults, children string
+		want             models.Guests
+		ok               bool
+	}{
+		{"", "", models.Guests{Adults: 1}, true},
+		{"2", "3", models.Guests{Adults: 2, Children: 3}, true},
+		{"0", "", models.Guests{}, false},
+		{"1", "-1", models.Guests{}, false},
+		{"21", "", models.Guests{}, false},
+		{"x", "", models.Guests{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseGuests(tt.adults, tt.children)
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("parseGuests(%q, %q) = %v, %v, want %v, %v", tt.adults, tt.children, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRepository_SearchAvailability_Amenities(t *testing.T) {
+	tests := []struct {
+		name      string
+		amenities []string
+		status    int
+		offered   []string
+		left      []string
+	}{
+		{"no filter", nil, http.StatusOK, []string{"General's Quarters", "Major's Suite"}, nil},
+		{"sea view", []string{"sea-view"}, http.StatusOK, []string{"Major's Suite"}, []string{"General's Quarters"}},
+		{"unknown amenity", []string{"sauna"}, http.StatusSeeOther, nil, nil},
+	}
+
+	for _, tt := range tests {
+		postedData := url.Values{"start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}, "adults": {"1"}, "amenity": tt.amenities}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.SearchAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.status)
+			continue
+		}
+		body := html.UnescapeString(rr.Body.String())
+		for _, name := range tt.offered {
+			if !strings.Contains(body, name) {
+				t.Errorf("%s: %s is not offered", tt.name, name)
+			}
+		}
+		for _, name := range tt.left {
+			if strings.Contains(body, name) {
+				t.Errorf("%s: %s should be left out", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestRepository_SearchAvailability_StayRules(t *testing.T) {
+	// both rooms need longer stays over Christmas 2060, room 1 at least 3
+	// nights and room 2 at least 5
+	tests := []struct {
+		name    string
+		start   string
+		end     string
+		status  int
+		offered []string
+		reasons []string
+	}{
+		{"too short for both", "2060-12-21", "2060-12-23", http.StatusSeeOther, nil, []string{"at least 3 nights", "at least 5 nights"}},
+		{"long enough for room 1", "2060-12-21", "2060-12-24", http.StatusOK, []string{"General's Quarters"}, nil},
+		{"before the rules", "2060-12-17", "2060-12-19", http.StatusOK, []string{"General's Quarters", "Major's Suite"}, nil},
+	}
+
+	for _, tt := range tests {
+		postedData := url.Values{"start_date": {tt.start}, "end_date": {tt.end}, "adults": {"1"}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.SearchAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, rr.Code, tt.status)
+			continue
+		}
+		body := html.UnescapeString(rr.Body.String())
+		for _, name := range tt.offered {
+			if !strings.Contains(body, name) {
+				t.Errorf("%s: %s is not offered", tt.name, name)
+			}
+		}
+		message := appConfig.Session.GetString(ctx, "error")
+		for _, reason := range tt.reasons {
+			if !strings.Contains(message, reason) {
+				t.Errorf("%s: %q does not explain %q", tt.name, message, reason)
+			}
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON_StayRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   string
+		end     string
+		ok      bool
+		message string
+	}{
+		{"allowed", "2060-12-21", "2060-12-24", true, ""},
+		{"too short", "2060-12-21", "2060-12-22", false, "at least 3 nights"},
+		{"sunday arrival", "2060-12-26", "2060-12-29", false, "Arrivals are not possible on Sundays"},
+	}
+
+	for _, tt := range tests {
+		postData := url.Values{"start": {tt.start}, "end": {tt.end}, "room_id": {"1"}}
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+		var j jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatalf("%s: cannot parse json: %v", tt.name, err)
+		}
+		if j.OK != tt.ok || !strings.Contains(j.Message, tt.message) {
+			t.Errorf("%s: got ok %v and %q, want %v and %q", tt.name, j.OK, j.Message, tt.ok, tt.message)
+		}
+	}
+}
+
+func TestRepository_CreateReservation_StayRules(t *testing.T) {
+	reservation := models.Reservation{
+		RoomId:    1,
+		StartDate: time.Date(2060, 12, 21, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2060, 12, 22, 0, 0, 0, 0, time.UTC),
+		Adults:    1,
+	}
+	postData := url.Values{
+		"first_name": {"Thanh Phuoc"},
+		"last_name":  {"Nguyen"},
+		"email":      {"testing@example.com"},
+		"phone":      {"123456789123"},
+	}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "reservation", reservation)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.CreateReservation).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/search-availability" {
+		t.Errorf("redirected to %s, want /search-availability", loc)
+	}
+	if message := appConfig.Session.GetString(ctx, "error"); !strings.Contains(message, "at least 3 nights") {
+		t.Errorf("got error %q, want the stay rule explained", message)
+	}
+}
+
+func TestPickAmenities(t *testing.T) {
+	catalogue := []models.Amenity{{ID: 1, Slug: "wifi"}, {ID: 2, Slug: "sea-view"}}
+
+	picked, ok := pickAmenities(catalogue, []string{"sea-view", "wifi"})
+	if ids := amenityIds(picked); !ok || len(ids) != 2 || ids[0] != 2 || ids[1] != 1 {
+		t.Errorf("got %v, %v, want [2 1], true", ids, ok)
+	}
+	if _, ok := pickAmenities(catalogue, []string{"wifi", "sauna"}); ok {
+		t.Error("unknown slug accepted")
+	}
+}
+
+func TestRepository_AdminPostSeasonalRate(t *testing.T) {
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"valid season", url.Values{"name": {"Summer"}, "start_date": {"2050-07-01"}, "end_date": {"2050-08-31"}, "nightly_rate": {"180"}, "weekend_rate": {"210.50"}}, "flash"},
+		{"ends before it starts", url.Values{"name": {"Summer"}, "start_date": {"2050-08-31"}, "end_date": {"2050-07-01"}, "nightly_rate": {"180"}}, "error"},
+		{"invalid rate", url.Values{"name": {"Summer"}, "start_date": {"2050-07-01"}, "end_date": {"2050-08-31"}, "nightly_rate": {"18.999"}}, "error"},
+		{"missing name", url.Values{"start_date": {"2050-07-01"}, "end_date": {"2050-08-31"}, "nightly_rate": {"180"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/pricing/1/seasons", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostSeasonalRate).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/pricing/1" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminPostStayDiscount(t *testing.T) {
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"weekly discount", url.Values{"min_nights": {"7"}, "percent": {"10"}}, "flash"},
+		{"single night", url.Values{"min_nights": {"1"}, "percent": {"10"}}, "error"},
+		{"over a hundred percent", url.Values{"min_nights": {"7"}, "percent": {"120"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/pricing/1/discounts", strings.NewReader(tt.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostStayDiscount).ServeHTTP(rr, req)
+
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminReservationStatus(t *testing.T) {
+	// reservation 1 is confirmed
+	tests := []struct {
+		name    string
+		status  string
+		message string
+	}{
+		{"check in", "checked_in", "flash"},
+		{"no show", "no_show", "flash"},
+		{"skip check in", "checked_out", "error"},
+		{"back to pending", "pending_payment", "error"},
+		{"cancel without refund", "cancelled", "error"},
+		{"unknown status", "lost", "error"},
+	}
+
+	for _, tt := range tests {
+		rr, ctx := postReservationForm(Repo.AdminReservationStatus, "/admin/reservations/1/status", url.Values{"status": {tt.status}})
+
+		if loc := rr.Header().Get("Location"); !strings.HasPrefix(loc, "/admin/reservations/1") {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminMoveReservation(t *testing.T) {
+	// room 2 cannot be priced and room 3 is taken
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"new dates", url.Values{"room_id": {"1"}, "start_date": {"2050-01-20"}, "end_date": {"2050-01-23"}}, "flash"},
+		{"room taken", url.Values{"room_id": {"3"}, "start_date": {"2050-01-20"}, "end_date": {"2050-01-23"}}, "error"},
+		{"room without rates", url.Values{"room_id": {"2"}, "start_date": {"2050-01-20"}, "end_date": {"2050-01-23"}}, "error"},
+		{"departure before arrival", url.Values{"room_id": {"1"}, "start_date": {"2050-01-20"}, "end_date": {"2050-01-18"}}, "error"},
+		{"invalid room", url.Values{"room_id": {"abc"}, "start_date": {"2050-01-20"}, "end_date": {"2050-01-23"}}, "error"},
+		{"missing dates", url.Values{"room_id": {"1"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		rr, ctx := postReservationForm(Repo.AdminMoveReservation, "/admin/reservations/1/move", tt.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/reservations/1" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminMoveReservation_PendingPayment(t *testing.T) {
+	res := models.Reservation{
+		RoomId:    1,
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Status:    models.ReservationPendingPayment,
+		StartDate: time.Date(2072, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2072, 3, 3, 0, 0, 0, 0, time.UTC),
+	}
+	id, err := Repo.DB.CreateReservation(context.Background(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/admin/reservations/" + strconv.Itoa(id) + "/move"
+	rr, ctx := postReservationForm(Repo.AdminMoveReservation, path, url.Values{"room_id": {"1"}, "start_date": {"2072-03-10"}, "end_date": {"2072-03-12"}})
+
+	if loc := rr.Header().Get("Location"); loc != "/admin/reservations/"+strconv.Itoa(id) {
+		t.Errorf("unexpected redirect to %s", loc)
+	}
+	if !appConfig.Session.Exists(ctx, "error") {
+		t.Error("moving a reservation pending payment was not refused")
+	}
+	if got, _ := Repo.DB.GetReservationById(context.Background(), id); !got.StartDate.Equal(res.StartDate) {
+		t.Errorf("reservation moved to %v", got.StartDate)
+	}
+}
@@ -1,14 +1,156 @@
 package handlers
 
-import "github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+import (
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+)
 
 type jsonResponse struct {
 	OK        bool   `json:"ok"`
 	Message   string `json:"message"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
 }
 type roomJsonResp struct {
 	Rooms   []models.Room `json:"rooms"`
 	Message string        `json:"message"`
 }
+
+// apiEnvelope wraps every successful /api response.
+type apiEnvelope struct {
+	Data interface{} `json:"data"`
+	Meta *apiMeta    `json:"meta,omitempty"`
+}
+
+type apiMeta struct {
+	Page       int `json:"page"`
+	PerPage    int `json:"per_page"`
+	Total      int `json:"total"`
+	TotalPages int `json:"total_pages"`
+}
+
+// apiRoom.FreeUnits is only set in availability searches and Amenities in
+// room details.
+type apiRoom struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Slug        string       `json:"slug"`
+	Description string       `json:"description,omitempty"`
+	Price       money.Money  `json:"price"`
+	MaxAdults   int          `json:"max_adults"`
+	MaxChildren int          `json:"max_children"`
+	Beds        int          `json:"beds"`
+	FreeUnits   int          `json:"free_units,omitempty"`
+	Amenities   []apiAmenity `json:"amenities,omitempty"`
+}
+
+type apiAmenity struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+// apiReservation.Price is missing for reservations made before stays were
+// priced, Payment is only set in the response creating the reservation.
+type apiReservation struct {
+	ID        int            `json:"id"`
+	RoomId    int            `json:"room_id"`
+	RoomName  string         `json:"room_name,omitempty"`
+	UnitName  string         `json:"unit_name,omitempty"`
+	FirstName string         `json:"first_name"`
+	LastName  string         `json:"last_name"`
+	Email     string         `json:"email"`
+	Phone     string         `json:"phone"`
+	StartDate string         `json:"start_date"`
+	EndDate   string         `json:"end_date"`
+	Adults    int            `json:"adults"`
+	Children  int            `json:"children"`
+	Status    string         `json:"status"`
+	Price     *pricing.Quote `json:"price,omitempty"`
+	Payment   *apiPayment    `json:"payment,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type apiPayment struct {
+	IntentId     string      `json:"intent_id"`
+	ClientSecret string      `json:"client_secret"`
+	Amount       money.Money `json:"amount"`
+	// DueAt is when the reservation is cancelled if the payment has not
+	// been captured
+	DueAt time.Time `json:"due_at"`
+}
+
+type apiReservationRequest struct {
+	RoomId    int    `json:"room_id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// Adults defaults to one when left out
+	Adults   int `json:"adults"`
+	Children int `json:"children"`
+}
+
+type apiTokenRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type apiToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:          room.ID,
+		Name:        room.Name,
+		Slug:        room.Slug,
+		Description: room.Description,
+		Price:       room.Price,
+		MaxAdults:   room.MaxAdults,
+		MaxChildren: room.MaxChildren,
+		Beds:        room.Beds,
+		FreeUnits:   room.FreeUnits,
+		Amenities:   newAPIAmenities(room.Amenities),
+	}
+}
+
+func newAPIAmenities(amenities []models.Amenity) []apiAmenity {
+	data := make([]apiAmenity, 0, len(amenities))
+	for _, a := range amenities {
+		data = append(data, apiAmenity{Slug: a.Slug, Name: a.Name})
+	}
+	return data
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	var price *pricing.Quote
+	if len(res.Quote.Nights) > 0 {
+		price = &res.Quote
+	}
+
+	return apiReservation{
+		ID:        res.ID,
+		RoomId:    res.RoomId,
+		RoomName:  res.Room.Name,
+		UnitName:  res.Unit.Name,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		StartDate: res.StartDate.Format(layout),
+		EndDate:   res.EndDate.Format(layout),
+		Adults:    res.Adults,
+		Children:  res.Children,
+		Status:    res.Status,
+		Price:     price,
+		CreatedAt: res.CreatedAt,
+	}
+}
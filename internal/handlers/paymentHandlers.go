@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ledger"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/payments"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+)
+
+// maxWebhookBytes bounds the size of a webhook body.
+const maxWebhookBytes = 64 << 10
+
+// ReservationPayment asks the guest to pay for the reservation they just
+// made. A new intent is started when the session has none, as after a
+// declined payment.
+func (m *Repository) ReservationPayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || reservation.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	if reservation.Status != models.ReservationPendingPayment {
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+		return
+	}
+
+	reservation, ok = m.reservationAwaitingPayment(w, r, reservation)
+	if !ok {
+		return
+	}
+
+	intentId := m.App.Session.GetString(r.Context(), "payment_intent")
+	if intentId == "" {
+		intent, err := m.startPayment(r.Context(), reservation)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			m.App.Session.Put(r.Context(), "error", "Cannot start the payment, please try again later")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		intentId = intent.ID
+		m.App.Session.Put(r.Context(), "payment_intent", intentId)
+	}
+
+	strMap := make(map[string]string)
+	strMap["start_date"] = reservation.StartDate.Format(layout)
+	strMap["end_date"] = reservation.EndDate.Format(layout)
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	render.Template(w, r, "reservationPayment.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: strMap,
+	})
+}
+
+// PostReservationPayment captures the payment of the reservation in the
+// session and confirms it.
+func (m *Repository) PostReservationPayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	intentId := m.App.Session.GetString(r.Context(), "payment_intent")
+	if !ok || reservation.ID == 0 || intentId == "" {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from session")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// the room may have been released while the guest was on the payment
+	// page, its payment must not be taken then
+	reservation, ok = m.reservationAwaitingPayment(w, r, reservation)
+	if !ok {
+		return
+	}
+
+	payment, err := m.DB.GetPaymentByRef(r.Context(), m.App.Payments.Name(), intentId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find your payment")
+		http.Redirect(w, r, "/reservation-payment", http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.App.Payments.Capture(r.Context(), intentId)
+	if errors.Is(err, payments.ErrDeclined) {
+		if err := m.DB.UpdatePaymentStatus(r.Context(), payment.ID, models.PaymentFailed); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		// the next attempt gets a fresh intent
+		m.App.Session.Remove(r.Context(), "payment_intent")
+		m.App.Session.Put(r.Context(), "error", "Your payment was declined, please try again")
+		http.Redirect(w, r, "/reservation-payment", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Cannot take the payment, please try again")
+		http.Redirect(w, r, "/reservation-payment", http.StatusSeeOther)
+		return
+	}
+
+	// the provider has the money at this point, the webhook catches up if
+	// recording it fails
+	reservation, err = m.capturePayment(r.Context(), payment)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.App.Session.Remove(r.Context(), "payment_intent")
+	switch reservation.Status {
+	case models.ReservationConfirmed:
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	case models.ReservationPendingPayment:
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "flash", "Your payment was received, your confirmation will follow by email")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Your reservation was released before the payment went through, the payment will be refunded")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// reservationAwaitingPayment reads the reservation in the session again, it
+// writes the redirect itself when the reservation is not waiting for its
+// payment any more: to the summary once confirmed and home once cancelled,
+// as when it was not paid in time.
+func (m *Repository) reservationAwaitingPayment(w http.ResponseWriter, r *http.Request, reservation models.Reservation) (models.Reservation, bool) {
+	current, err := m.DB.GetReservationById(r.Context(), reservation.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return current, false
+	}
+
+	switch current.Status {
+	case models.ReservationPendingPayment:
+		return current, true
+	case models.ReservationConfirmed:
+		m.App.Session.Remove(r.Context(), "payment_intent")
+		m.App.Session.Put(r.Context(), "reservation", current)
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	default:
+		m.App.Session.Remove(r.Context(), "payment_intent")
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Your reservation was not paid in time and the room was released, please book again")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+	return current, false
+}
+
+// capturePayment records a charge the provider captured and returns its
+// reservation as it is afterwards. Only a reservation still waiting for the
+// payment is confirmed and emailed, staff are asked to refund one cancelled
+// meanwhile.
+func (m *Repository) capturePayment(ctx context.Context, charge models.Payment) (models.Reservation, error) {
+	before, err := m.DB.GetReservationById(ctx, charge.ReservationId)
+	if err != nil {
+		return before, err
+	}
+
+	err = m.DB.CapturePayment(ctx, charge.ID)
+	if err != nil {
+		return before, err
+	}
+
+	after, err := m.DB.GetReservationById(ctx, charge.ReservationId)
+	if err != nil {
+		return before, err
+	}
+
+	switch {
+	case before.Status == models.ReservationPendingPayment && after.Status == models.ReservationConfirmed:
+		m.sendReservationConfirmation(after)
+	case after.Status == models.ReservationCancelled:
+		m.App.MailChan <- models.MailData{
+			To:      "universal@booking.com",
+			From:    "universal@booking.com",
+			Subject: fmt.Sprintf("Payment received for cancelled reservation #%d", after.ID),
+			Content: fmt.Sprintf(`
+				A payment of %s was captured for reservation #%d (%s %s), which was already cancelled.<br>
+				The payment has to be refunded.
+			`, charge.Amount, after.ID, html.EscapeString(after.FirstName), html.EscapeString(after.LastName)),
+			Template: "basic.html",
+		}
+	}
+	return after, nil
+}
+
+// PaymentWebhook records what the provider reports about payments. Events
+// can be delivered more than once and in any order with the guest's own
+// redirect, so handling one twice changes nothing.
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBytes))
+	if err != nil {
+		http.Error(w, "Cannot read body", http.StatusBadRequest)
+		return
+	}
+
+	event, err := m.App.Payments.VerifyWebhook(payload, r.Header.Get("X-Payment-Signature"))
+	if err != nil {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	provider := m.App.Payments.Name()
+	charge, err := m.DB.GetPaymentByRef(r.Context(), provider, event.IntentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// not one of ours, acknowledge it so it is not sent again
+		m.App.InfoLog.Printf("payment webhook: unknown intent %s", event.IntentID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		// a failed response makes the provider retry later
+		m.App.ErrorLog.Println(err)
+		http.Error(w, "Cannot find payment", http.StatusInternalServerError)
+		return
+	}
+
+	switch event.Type {
+	case payments.EventPaymentCaptured:
+		if charge.Status == models.PaymentSucceeded {
+			break
+		}
+		_, err = m.capturePayment(r.Context(), charge)
+
+	case payments.EventPaymentFailed:
+		if charge.Status == models.PaymentPending {
+			err = m.DB.UpdatePaymentStatus(r.Context(), charge.ID, models.PaymentFailed)
+		}
+
+	case payments.EventRefundSucceeded:
+		_, err = m.DB.GetPaymentByRef(r.Context(), provider, event.RefundID)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = m.DB.InsertPayment(r.Context(), models.Payment{
+				ReservationId: charge.ReservationId,
+				ParentId:      charge.ID,
+				Kind:          models.PaymentKindRefund,
+				Method:        charge.Method,
+				Provider:      provider,
+				ProviderRef:   event.RefundID,
+				Amount:        event.Amount,
+				Status:        models.PaymentSucceeded,
+			})
+		}
+
+	default:
+		m.App.InfoLog.Printf("payment webhook: ignoring %s event", event.Type)
+	}
+
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		http.Error(w, "Cannot record event", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// startPayment asks the provider to collect the deposit of a reservation
+// and records the pending charge.
+func (m *Repository) startPayment(ctx context.Context, reservation models.Reservation) (payments.Intent, error) {
+	amount := reservation.Deposit
+	if amount.IsZero() {
+		amount = reservation.Quote.Total
+	}
+
+	intent, err := m.App.Payments.CreateIntent(ctx, amount, fmt.Sprintf("reservation-%d", reservation.ID))
+	if err != nil {
+		return intent, err
+	}
+
+	_, err = m.DB.InsertPayment(ctx, models.Payment{
+		ReservationId: reservation.ID,
+		Kind:          models.PaymentKindCharge,
+		Method:        models.PaymentMethodCard,
+		Provider:      m.App.Payments.Name(),
+		ProviderRef:   intent.ID,
+		Amount:        intent.Amount,
+		Status:        models.PaymentPending,
+	})
+	return intent, err
+}
+
+func (m *Repository) sendReservationConfirmation(reservation models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Confirmation</strong><br>
+		Dear %s,<br>
+		This is to confirm your reservation from %s to %s.<br>
+		Total: %s
+	`, html.EscapeString(reservation.FirstName), reservation.StartDate.Format(layout), reservation.EndDate.Format(layout), reservation.Quote.Total)
+
+	if !reservation.Deposit.IsZero() && reservation.Deposit != reservation.Quote.Total {
+		htmlMessage += fmt.Sprintf(`<br>
+		We received your deposit of %s, the balance of %s is due by %s.
+		`, reservation.Deposit, reservation.Quote.Total.Sub(reservation.Deposit), reservation.BalanceDue.Format(layout))
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:       reservation.Email,
+		From:     "universal@booking.com",
+		Subject:  "Reservation Confirmation",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// AdminPostReservationPayment records money the guest paid at the desk or
+// by bank transfer.
+func (m *Repository) AdminPostReservationPayment(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/reservations/%d", reservation.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	amount, ok := m.parseAmount(r.Form.Get("amount"))
+	if !ok || amount.Amount == 0 {
+		m.App.Session.Put(r.Context(), "error", "The amount must be an amount like 120.00")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	method := r.Form.Get("method")
+	if method != models.PaymentMethodCash && method != models.PaymentMethodBankTransfer {
+		m.App.Session.Put(r.Context(), "error", "Payments are recorded as cash or bank transfer")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if currency := reservation.Quote.Total.Currency; currency != "" && currency != amount.Currency {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This reservation is paid in %s", currency))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	id, err := m.DB.InsertPayment(r.Context(), models.Payment{
+		ReservationId: reservation.ID,
+		Kind:          models.PaymentKindCharge,
+		Method:        method,
+		Provider:      models.PaymentProviderManual,
+		Amount:        amount,
+		Status:        models.PaymentPending,
+		Note:          strings.TrimSpace(r.Form.Get("note")),
+	})
+	if err == nil {
+		// capturing also confirms a reservation still waiting for its deposit
+		err = m.DB.CapturePayment(r.Context(), id)
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot record payment")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Payment of %s recorded", amount))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminPostReservationRefund gives money back to the guest, through the
+// payment provider for card payments.
+func (m *Repository) AdminPostReservationRefund(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.adminReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/reservations/%d", reservation.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	amount, ok := m.parseAmount(r.Form.Get("amount"))
+	if !ok || amount.Amount == 0 {
+		m.App.Session.Put(r.Context(), "error", "The amount must be an amount like 120.00")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	method := r.Form.Get("method")
+	switch method {
+	case models.PaymentMethodCard, models.PaymentMethodCash, models.PaymentMethodBankTransfer:
+	default:
+		m.App.Session.Put(r.Context(), "error", "Unknown refund method")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	l, err := m.reservationLedger(r.Context(), reservation)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", ledgerError(err))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if amount.Currency != l.Currency() {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("This reservation is refunded in %s", l.Currency()))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if amount.Amount > l.Net().Amount {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("At most %s can be refunded", l.Net()))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.refund(r.Context(), l, reservation, amount, method, strings.TrimSpace(r.Form.Get("note")))
+	if errors.Is(err, errRefundNotCovered) {
+		m.App.Session.Put(r.Context(), "error", "The card payments do not cover this refund, refund the rest in cash or by bank transfer")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Cannot issue refund")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Refund of %s issued", amount))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// errRefundNotCovered means the card payments of a reservation hold less
+// than the refund asked for.
+var errRefundNotCovered = errors.New("refund not covered by card payments")
+
+// refund gives amount back and records it. Card refunds go through the
+// provider against the latest card payments, the other methods are only
+// recorded as staff hand the money over themselves.
+func (m *Repository) refund(ctx context.Context, l ledger.Ledger, reservation models.Reservation, amount money.Money, method, note string) error {
+	if method != models.PaymentMethodCard {
+		_, err := m.DB.InsertPayment(ctx, models.Payment{
+			ReservationId: reservation.ID,
+			Kind:          models.PaymentKindRefund,
+			Method:        method,
+			Provider:      models.PaymentProviderManual,
+			Amount:        amount,
+			Status:        models.PaymentSucceeded,
+			Note:          note,
+		})
+		return err
+	}
+
+	allocations := l.AllocateRefund(amount, models.PaymentMethodCard)
+	covered := money.New(0, amount.Currency)
+	for _, a := range allocations {
+		covered = covered.Add(a.Amount)
+	}
+	if covered != amount {
+		return errRefundNotCovered
+	}
+
+	for _, a := range allocations {
+		refund, err := m.App.Payments.Refund(ctx, a.Charge.ProviderRef, a.Amount)
+		if err != nil {
+			return err
+		}
+		_, err = m.DB.InsertPayment(ctx, models.Payment{
+			ReservationId: reservation.ID,
+			ParentId:      a.Charge.ID,
+			Kind:          models.PaymentKindRefund,
+			Method:        models.PaymentMethodCard,
+			Provider:      a.Charge.Provider,
+			ProviderRef:   refund.ID,
+			Amount:        a.Amount,
+			Status:        models.PaymentSucceeded,
+			Note:          note,
+		})
+		if err != nil {
+			// the provider's refund webhook records it later
+			return err
+		}
+	}
+	return nil
+}
+
+// refundCancellation gives back what the guest paid for a cancelled
+// reservation, less its cancellation penalty, and returns the amount. Card
+// payments go back through the provider, staff are asked to hand back the
+// rest.
+func (m *Repository) refundCancellation(ctx context.Context, reservation models.Reservation) (money.Money, error) {
+	l, err := m.reservationLedger(ctx, reservation)
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	amount := l.CancellationRefund(money.New(reservation.CancellationPenalty.Amount, l.Total.Currency))
+	if amount.Amount <= 0 {
+		return amount, nil
+	}
+
+	card := money.New(0, amount.Currency)
+	for _, a := range l.AllocateRefund(amount, models.PaymentMethodCard) {
+		card = card.Add(a.Amount)
+	}
+	if card.Amount > 0 {
+		err = m.refund(ctx, l, reservation, card, models.PaymentMethodCard, "Reservation cancelled")
+		if err != nil {
+			return amount, err
+		}
+	}
+
+	if rest := amount.Sub(card); rest.Amount > 0 {
+		m.App.MailChan <- models.MailData{
+			To:      "universal@booking.com",
+			From:    "universal@booking.com",
+			Subject: fmt.Sprintf("Refund due for cancelled reservation #%d", reservation.ID),
+			Content: fmt.Sprintf(`
+				Reservation #%d (%s %s) was cancelled.<br>
+				%s paid in cash or by bank transfer has to be refunded by hand.
+			`, reservation.ID, html.EscapeString(reservation.FirstName), html.EscapeString(reservation.LastName), rest),
+			Template: "basic.html",
+		}
+	}
+	return amount, nil
+}
+
+// reservationLedger loads the payments of a reservation into its ledger.
+func (m *Repository) reservationLedger(ctx context.Context, reservation models.Reservation) (ledger.Ledger, error) {
+	entries, err := m.DB.GetPaymentsByReservation(ctx, reservation.ID)
+	if err != nil {
+		return ledger.Ledger{}, err
+	}
+	return ledger.New(reservation, entries)
+}
+
+// ledgerError is the message shown to staff when the ledger of a reservation
+// cannot be loaded.
+func ledgerError(err error) string {
+	if errors.Is(err, money.ErrCurrencyMismatch) {
+		return "The payments of this reservation are in another currency than its price"
+	}
+	return "Cannot get payments from database"
+}
+
+// adminReservationFromURL loads the reservation in the url, it writes the
+// redirect itself when the reservation cannot be found.
+func (m *Repository) adminReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse reservation id")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	reservation, err := m.DB.GetReservationById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get reservation from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	return reservation, true
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/payments"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+)
+
+// pendingReservation makes a reservation waiting for its payment, due at
+// dueAt.
+func pendingReservation(t *testing.T, dueAt time.Time) models.Reservation {
+	res := models.Reservation{
+		RoomId:       1,
+		StartDate:    time.Date(2050, 5, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:      time.Date(2050, 5, 3, 0, 0, 0, 0, time.UTC),
+		Status:       models.ReservationPendingPayment,
+		Quote:        pricing.Quote{Total: money.New(25000, "USD")},
+		Deposit:      money.New(7500, "USD"),
+		BalanceDue:   time.Now().AddDate(0, 0, 16),
+		PaymentDueAt: dueAt,
+	}
+	id, err := Repo.DB.CreateReservation(context.Background(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.ID = id
+	return res
+}
+
+// startTestPayment opens the payment page of a pending reservation and
+// returns the session context holding its intent.
+func startTestPayment(t *testing.T) (context.Context, models.Reservation) {
+	res := pendingReservation(t, time.Now().Add(appConfig.PaymentHold))
+
+	req, _ := http.NewRequest("GET", "/reservation-payment", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	appConfig.Session.Put(ctx, "reservation", res)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationPayment).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("ReservationPayment returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+	if appConfig.Session.GetString(ctx, "payment_intent") == "" {
+		t.Fatal("ReservationPayment did not start a payment")
+	}
+	return ctx, res
+}
+
+func TestRepository_PostReservationPayment(t *testing.T) {
+	ctx, _ := startTestPayment(t)
+
+	req, _ := http.NewRequest("POST", "/reservation-payment", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservationPayment).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/reservation-summary" {
+		t.Errorf("PostReservationPayment redirected to %q, want /reservation-summary", loc)
+	}
+	res, _ := appConfig.Session.Get(ctx, "reservation").(models.Reservation)
+	if res.Status != models.ReservationConfirmed {
+		t.Errorf("got reservation status %q, want %q", res.Status, models.ReservationConfirmed)
+	}
+}
+
+func TestRepository_PostReservationPayment_Expired(t *testing.T) {
+	ctx, res := startTestPayment(t)
+
+	// the hold ran out while the guest was on the payment page
+	err := Repo.DB.TransitionReservation(context.Background(), res.ID, models.ReservationCancelled, 0, "Payment not received in time")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intentId := appConfig.Session.GetString(ctx, "payment_intent")
+
+	req, _ := http.NewRequest("POST", "/reservation-payment", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservationPayment).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/" {
+		t.Errorf("PostReservationPayment redirected to %q, want /", loc)
+	}
+	if !appConfig.Session.Exists(ctx, "error") {
+		t.Error("a released reservation should tell the guest")
+	}
+	if appConfig.Session.Exists(ctx, "reservation") {
+		t.Error("a released reservation should leave the session")
+	}
+	payment, _ := Repo.DB.GetPaymentByRef(context.Background(), appConfig.Payments.Name(), intentId)
+	if payment.Status != models.PaymentPending {
+		t.Errorf("got payment status %q, the payment of a released reservation should not be captured", payment.Status)
+	}
+	got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+	if got.Status != models.ReservationCancelled {
+		t.Errorf("got reservation status %q, want %q", got.Status, models.ReservationCancelled)
+	}
+}
+
+func TestRepository_PaymentWebhook_Expired(t *testing.T) {
+	ctx, res := startTestPayment(t)
+	intentId := appConfig.Session.GetString(ctx, "payment_intent")
+
+	err := Repo.DB.TransitionReservation(context.Background(), res.ID, models.ReservationCancelled, 0, "Payment not received in time")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := appConfig.Payments.(*payments.Fake)
+	payload, _ := json.Marshal(payments.Event{Type: payments.EventPaymentCaptured, IntentID: intentId, Amount: res.Deposit})
+	req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(payload)))
+	req.Header.Set("X-Payment-Signature", fake.Sign(payload))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("PaymentWebhook returned wrong response code: got %d, want %d", rr.Code, http.StatusOK)
+	}
+	// a late capture is recorded for the refund but does not bring the
+	// reservation back
+	got, _ := Repo.DB.GetReservationById(context.Background(), res.ID)
+	if got.Status != models.ReservationCancelled {
+		t.Errorf("got reservation status %q, want %q", got.Status, models.ReservationCancelled)
+	}
+}
+
+func TestRepository_PostReservationPayment_Declined(t *testing.T) {
+	ctx, _ := startTestPayment(t)
+	appConfig.Payments.(*payments.Fake).Decline(appConfig.Session.GetString(ctx, "payment_intent"))
+
+	req, _ := http.NewRequest("POST", "/reservation-payment", nil)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservationPayment).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/reservation-payment" {
+		t.Errorf("PostReservationPayment redirected to %q, want /reservation-payment", loc)
+	}
+	if !appConfig.Session.Exists(ctx, "error") {
+		t.Error("a declined payment should tell the guest")
+	}
+	if appConfig.Session.Exists(ctx, "payment_intent") {
+		t.Error("a declined intent should not be captured again")
+	}
+	res, _ := appConfig.Session.Get(ctx, "reservation").(models.Reservation)
+	if res.Status != models.ReservationPendingPayment {
+		t.Errorf("got reservation status %q, want %q", res.Status, models.ReservationPendingPayment)
+	}
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	fake := appConfig.Payments.(*payments.Fake)
+	intent, _ := fake.CreateIntent(context.Background(), money.New(25000, "USD"), "reservation-1")
+
+	tests := []struct {
+		name         string
+		event        payments.Event
+		signature    string
+		expectStatus int
+	}{
+		{"captured", payments.Event{Type: payments.EventPaymentCaptured, IntentID: intent.ID, Amount: intent.Amount}, "", http.StatusOK},
+		{"failed", payments.Event{Type: payments.EventPaymentFailed, IntentID: intent.ID, Amount: intent.Amount}, "", http.StatusOK},
+		{"refund", payments.Event{Type: payments.EventRefundSucceeded, IntentID: intent.ID, RefundID: "re_fake_9", Amount: money.New(5000, "USD")}, "", http.StatusOK},
+		{"unknown intent", payments.Event{Type: payments.EventPaymentCaptured, IntentID: "pi_other_1"}, "", http.StatusOK},
+		{"bad signature", payments.Event{Type: payments.EventPaymentCaptured, IntentID: intent.ID}, "forged", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		payload, _ := json.Marshal(tt.event)
+		signature := tt.signature
+		if signature == "" {
+			signature = fake.Sign(payload)
+		}
+
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(string(payload)))
+		req.Header.Set("X-Payment-Signature", signature)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PaymentWebhook).ServeHTTP(rr, req)
+
+		if rr.Code != tt.expectStatus {
+			t.Errorf("%s: PaymentWebhook returned wrong response code: got %d, want %d", tt.name, rr.Code, tt.expectStatus)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"cash", url.Values{"amount": {"175.00"}, "method": {"cash"}, "note": {"paid at check-in"}}, "flash"},
+		{"bank transfer", url.Values{"amount": {"20"}, "method": {"bank_transfer"}}, "flash"},
+		{"card", url.Values{"amount": {"20"}, "method": {"card"}}, "error"},
+		{"invalid amount", url.Values{"amount": {"abc"}, "method": {"cash"}}, "error"},
+		{"zero amount", url.Values{"amount": {"0"}, "method": {"cash"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		rr, ctx := postReservationForm(Repo.AdminPostReservationPayment, "/admin/reservations/1/payments", tt.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/reservations/1" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationRefund(t *testing.T) {
+	// reservation 1 has paid its 75.00 deposit in cash
+	tests := []struct {
+		name       string
+		postedData url.Values
+		message    string
+	}{
+		{"partial cash refund", url.Values{"amount": {"50.00"}, "method": {"cash"}}, "flash"},
+		{"more than was paid", url.Values{"amount": {"100.00"}, "method": {"bank_transfer"}}, "error"},
+		{"no card payment to refund", url.Values{"amount": {"10.00"}, "method": {"card"}}, "error"},
+		{"unknown method", url.Values{"amount": {"10.00"}, "method": {"cheque"}}, "error"},
+	}
+
+	for _, tt := range tests {
+		rr, ctx := postReservationForm(Repo.AdminPostReservationRefund, "/admin/reservations/1/refunds", tt.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/reservations/1" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if !appConfig.Session.Exists(ctx, tt.message) {
+			t.Errorf("%s: expected %s message in session", tt.name, tt.message)
+		}
+	}
+}
+
+func TestRepository_AdminReservationPayments_OtherCurrency(t *testing.T) {
+	// reservation 1 was priced and paid in USD
+	appConfig.Currency = "EUR"
+	defer func() { appConfig.Currency = "USD" }()
+
+	handlers := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+	}{
+		{"payment", Repo.AdminPostReservationPayment, "/admin/reservations/1/payments"},
+		{"refund", Repo.AdminPostReservationRefund, "/admin/reservations/1/refunds"},
+	}
+
+	for _, h := range handlers {
+		rr, ctx := postReservationForm(h.handler, h.path, url.Values{"amount": {"10.00"}, "method": {"cash"}})
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/reservations/1" {
+			t.Errorf("%s: unexpected redirect to %s", h.name, loc)
+		}
+		if msg := appConfig.Session.GetString(ctx, "error"); !strings.Contains(msg, "USD") {
+			t.Errorf("%s: got error %q, want the reservation's currency named", h.name, msg)
+		}
+	}
+}
+
+func postReservationForm(handler http.HandlerFunc, path string, data url.Values) (*httptest.ResponseRecorder, context.Context) {
+	req, _ := http.NewRequest("POST", path, strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	// paths look like /admin/reservations/{id}/...
+	rctx.URLParams.Add("id", strings.Split(path, "/")[3])
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
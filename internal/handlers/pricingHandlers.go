@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+)
+
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// AdminPricing lists the rooms whose rates can be edited.
+func (m *Repository) AdminPricing(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.GetRooms(r.Context())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rooms from database")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["rooms"] = rooms
+
+	render.Template(w, r, "adminPricing.page.tmpl", &models.TemplateData{
+		Data: dataMap,
+	})
+}
+
+// AdminRoomPricing shows the rates of a room along with a sample quote of
+// the next week.
+func (m *Repository) AdminRoomPricing(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	rates, err := m.DB.GetRoomRates(r.Context(), room.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get rates from database")
+		http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+		return
+	}
+
+	weekendDays := rates.WeekendDays
+	if weekendDays == nil {
+		weekendDays = pricing.DefaultWeekendDays
+	}
+	weekend := make(map[time.Weekday]bool, len(weekendDays))
+	for _, d := range weekendDays {
+		weekend[d] = true
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	sample, _ := rates.Quote(today, today.AddDate(0, 0, 7))
+
+	dataMap := make(map[string]interface{})
+	dataMap["room"] = room
+	dataMap["rates"] = rates
+	dataMap["weekdays"] = weekdays
+	dataMap["weekend"] = weekend
+	dataMap["sample"] = sample
+
+	render.Template(w, r, "adminRoomPricing.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+// AdminPostRoomRateSettings saves the weekend rate and the short stay
+// surcharge of a room.
+func (m *Repository) AdminPostRoomRateSettings(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := roomPricingURL(room.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	rates := pricing.Rates{WeekendDays: []time.Weekday{}}
+
+	if v := r.Form.Get("weekend_rate"); v != "" {
+		if rates.WeekendRate, ok = m.parseAmount(v); !ok {
+			m.App.Session.Put(r.Context(), "error", "The weekend rate must be an amount like 120.00")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+	}
+
+	if rates.WeekendDays, ok = parseWeekdays(r.Form["weekend_days"]); !ok {
+		m.App.Session.Put(r.Context(), "error", "Invalid weekend day")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	rates.ShortStayNights, err = strconv.Atoi(defaultString(r.Form.Get("short_stay_nights"), "0"))
+	if err != nil || rates.ShortStayNights < 0 {
+		m.App.Session.Put(r.Context(), "error", "The short stay nights must be a positive number")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	rates.ShortStaySurcharge, err = strconv.Atoi(defaultString(r.Form.Get("short_stay_surcharge"), "0"))
+	if err != nil || rates.ShortStaySurcharge < 0 || rates.ShortStaySurcharge > 100 {
+		m.App.Session.Put(r.Context(), "error", "The surcharge must be a percentage between 0 and 100")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomRateSettings(r.Context(), room.ID, rates)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save rates")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rates saved")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := roomPricingURL(room.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	f := forms.New(r.PostForm)
+	f.Required("name", "start_date", "end_date", "nightly_rate")
+	if !f.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Name, dates and nightly rate are required")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	season := pricing.Season{Name: strings.TrimSpace(r.Form.Get("name"))}
+
+	start, startErr := time.Parse(layout, r.Form.Get("start_date"))
+	end, endErr := time.Parse(layout, r.Form.Get("end_date"))
+	if startErr != nil || endErr != nil {
+		m.App.Session.Put(r.Context(), "error", "Dates must look like 2006-01-02")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if end.Before(start) {
+		m.App.Session.Put(r.Context(), "error", "The season cannot end before it starts")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	season.Start, season.End = start, end
+
+	if season.NightlyRate, ok = m.parseAmount(r.Form.Get("nightly_rate")); !ok || season.NightlyRate.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "The nightly rate must be an amount like 120.00")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if v := r.Form.Get("weekend_rate"); v != "" {
+		if season.WeekendRate, ok = m.parseAmount(v); !ok {
+			m.App.Session.Put(r.Context(), "error", "The weekend rate must be an amount like 120.00")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+	}
+
+	_, err = m.DB.InsertSeasonalRate(r.Context(), room.ID, season)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save season")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Season added")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := roomPricingURL(room.ID)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "seasonId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse season id")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteSeasonalRate(r.Context(), room.ID, id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete season")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Season deleted")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminPostStayDiscount adds a length of stay discount, or changes the
+// percentage of the one for the same number of nights.
+func (m *Repository) AdminPostStayDiscount(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := roomPricingURL(room.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	minNights, err := strconv.Atoi(r.Form.Get("min_nights"))
+	if err != nil || minNights < 2 {
+		m.App.Session.Put(r.Context(), "error", "Discounts apply from 2 nights or more")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	percent, err := strconv.Atoi(r.Form.Get("percent"))
+	if err != nil || percent < 1 || percent > 100 {
+		m.App.Session.Put(r.Context(), "error", "The discount must be a percentage between 1 and 100")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.SaveStayDiscount(r.Context(), room.ID, pricing.Discount{MinNights: minNights, Percent: percent})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save discount")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Discount saved")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteStayDiscount(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.pricingRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	back := roomPricingURL(room.ID)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "discountId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse discount id")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteStayDiscount(r.Context(), room.ID, id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete discount")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Discount deleted")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+func (m *Repository) pricingRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse room id")
+		http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+		return models.Room{}, false
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot find room")
+		http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+		return models.Room{}, false
+	}
+
+	return room, true
+}
+
+func roomPricingURL(roomId int) string {
+	return fmt.Sprintf("/admin/pricing/%d", roomId)
+}
+
+// parseAmount reads a positive amount in the property currency.
+func (m *Repository) parseAmount(s string) (money.Money, bool) {
+	amount, err := money.Parse(s, m.App.Currency)
+	if err != nil || amount.Amount < 0 {
+		return money.Money{}, false
+	}
+	return amount, true
+}
+
+// parseWeekdays reads the weekday numbers of checkboxes, sunday is 0.
+func parseWeekdays(values []string) ([]time.Weekday, bool) {
+	days := []time.Weekday{}
+	for _, v := range values {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 || d > 6 {
+			return nil, false
+		}
+		days = append(days, time.Weekday(d))
+	}
+	return days, true
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -1,12 +1,18 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
 )
 
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
@@ -18,7 +24,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomById(reservation.RoomId)
+	room, err := m.DB.GetRoomById(r.Context(), reservation.RoomId)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get room from database")
@@ -26,6 +32,25 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// reservations kept in older sessions have no party yet
+	if reservation.Adults == 0 {
+		reservation.Adults = 1
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+	}
+	if !room.Sleeps(reservation.Guests()) {
+		m.App.Session.Put(r.Context(), "error", roomTooSmallMessage(room))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	quote, err := m.quoteStay(r.Context(), reservation.RoomId, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Cannot price your stay, please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusTemporaryRedirect)
+		return
+	}
+
 	reservation.Room = room
 	emptyReservation.RoomId = reservation.RoomId
 	startDate := reservation.StartDate.Format(layout)
@@ -37,6 +62,8 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	strMap["room_name"] = reservation.Room.Name
 	data := make(map[string]interface{})
 	data["reservation"] = emptyReservation
+	data["quote"] = quote
+	data["guests"] = reservation.Guests()
 
 	render.Template(w, r, "makeReservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -60,11 +87,15 @@ func (m *Repository) CreateReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reservation.UserId = 1
+	// reservations made without logging in belong to no user
+	reservation.UserId = m.App.Session.GetInt(r.Context(), "user_id")
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
+	if reservation.Adults == 0 {
+		reservation.Adults = 1
+	}
 
 	f := forms.New(r.PostForm)
 
@@ -85,49 +116,58 @@ func (m *Repository) CreateReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newResId, err := m.DB.InsertReservation(&reservation)
-
+	// the price is worked out again so the guest pays the rates in force
+	// when the reservation is made
+	reservation.Quote, err = m.quoteStay(r.Context(), reservation.RoomId, reservation.StartDate, reservation.EndDate)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot insert reservation into database")
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Cannot price your stay")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	roomRestriction := models.RoomRestriction{
-		RoomId:        reservation.RoomId,
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RestrictionId: 1,
-		ReservationId: newResId,
-	}
+	reservation.Deposit, reservation.BalanceDue = m.App.PaymentTerms.Schedule(reservation.Quote.Total, reservation.StartDate, time.Now())
 
-	htmlMEssage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br>
-		Dear %s,<br>
-		This is to confirm your reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format(layout), reservation.EndDate.Format(layout))
+	// the dates are held while the guest pays, the reservation is confirmed
+	// once the deposit is captured and cancelled if it is not in time
+	reservation.Status = models.ReservationPendingPayment
+	reservation.PaymentDueAt = time.Now().Add(m.App.PaymentHold)
+	newResId, err := m.DB.CreateReservation(r.Context(), &reservation)
 
-	msg := models.MailData{
-		To:       "john@doe.ca",
-		From:     "universal@booking.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMEssage,
-		Template: "basic.html",
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has just been booked for your dates. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
 	}
 
-	m.App.MailChan <- msg
+	if errors.Is(err, repository.ErrRoomTooSmall) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room does not sleep your party. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 
-	err = m.DB.InsertRoomRestriction(&roomRestriction)
+	var violation *stayrules.Violation
+	if errors.As(err, &violation) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, these dates cannot be booked. %s", violation.Message))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Cannot insert room restriction into database")
+		m.App.Session.Put(r.Context(), "error", "Cannot insert reservation into database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
+	reservation.ID = newResId
+
+	m.App.Session.Remove(r.Context(), "payment_intent")
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	http.Redirect(w, r, "/reservation-payment", http.StatusSeeOther)
 }
 
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
@@ -150,3 +190,17 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 		StringMap: strMap,
 	})
 }
+
+// roomTooSmallMessage tells guests how many people the room sleeps.
+func roomTooSmallMessage(room models.Room) string {
+	return fmt.Sprintf("%s sleeps at most %d adults and %d children, please search again", room.Name, room.MaxAdults, room.MaxChildren)
+}
+
+// quoteStay prices the nights of a stay with the current rates of the room.
+func (m *Repository) quoteStay(ctx context.Context, roomId int, start, end time.Time) (pricing.Quote, error) {
+	rates, err := m.DB.GetRoomRates(ctx, roomId)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	return rates.Quote(start, end)
+}
@@ -2,20 +2,25 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ical"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
 func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	m.App.InfoLog.Println(id)
-	room, err := m.DB.GetRoomBySlug(id)
+	room, err := m.DB.GetRoomBySlug(r.Context(), id)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get room from database")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -29,8 +34,25 @@ func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the page falls back to the stock photo when the gallery cannot be read
+	images, err := m.DB.GetRoomImages(r.Context(), room.ID)
+	if err != nil {
+		m.App.ErrorLog.Println("cannot get photos of room", room.ID, err)
+	}
+	images = m.withImageURLs(images)
+
+	// as it leaves the amenities out when they cannot be read
+	room.Amenities, err = m.DB.GetRoomAmenities(r.Context(), room.ID)
+	if err != nil {
+		m.App.ErrorLog.Println("cannot get amenities of room", room.ID, err)
+	}
+
 	data := make(map[string]interface{})
 	data["room"] = room
+	data["images"] = images
+	if cover, ok := coverImage(images); ok {
+		data["cover"] = cover
+	}
 
 	render.Template(w, r, "roomDetails.page.tmpl", &models.TemplateData{
 		Data: data,
@@ -38,7 +60,7 @@ func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) GetRoomList(w http.ResponseWriter, r *http.Request) {
-	rooms, err := m.DB.GetRooms()
+	rooms, err := m.DB.GetRooms(r.Context())
 	roomsResp := roomJsonResp{}
 	status := http.StatusOK
 	if err != nil {
@@ -75,7 +97,6 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	reservation.UserId = 1
 	reservation.RoomId = roomId
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -105,7 +126,14 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomById(roomId)
+	guests, ok := parseGuests(r.URL.Query().Get("adults"), r.URL.Query().Get("children"))
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse guests")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	room, err := m.DB.GetRoomById(r.Context(), roomId)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Cannot get room from database")
@@ -117,9 +145,172 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	reservation.RoomId = roomId
 	reservation.StartDate = startDate
 	reservation.EndDate = endDate
+	reservation.Adults = guests.Adults
+	reservation.Children = guests.Children
 	reservation.Room = room
 
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
+
+const (
+	// roomCalendarPurpose signs the secret token of the per room ical feeds,
+	// tokens are bound to the room id so renaming the slug keeps the token
+	roomCalendarPurpose = "room-calendar-id"
+	// icalUIDDomain keeps event UIDs stable when the site moves to another host
+	icalUIDDomain = "go-bookings-app"
+)
+
+// RoomCalendar serves the occupancy of a room as an iCalendar feed for
+// external calendars to subscribe to. Guests are never named, reservations
+// and owner blocks only show as busy.
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	// the feed is addressed by slug like the room page, feed urls published
+	// with the room id before keep working
+	slug := chi.URLParam(r, "slug")
+	room, err := m.DB.GetRoomBySlug(r.Context(), slug)
+	if err == nil && room.ID == 0 {
+		if roomId, convErr := strconv.Atoi(slug); convErr == nil {
+			room, err = m.DB.GetRoomById(r.Context(), roomId)
+		}
+	}
+	if err != nil || room.ID == 0 || !room.ArchivedAt.IsZero() ||
+		!tokens.ValidMAC(m.App.SecretKey, roomCalendarPurpose, strconv.Itoa(room.ID), r.URL.Query().Get("token")) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -30)
+	end := start.AddDate(2, 0, 0)
+
+	restrictions, err := m.DB.GetRoomRestrictionsForRoomByDate(r.Context(), room.ID, start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	restrictions = ownRestrictions(restrictions)
+
+	units, err := m.DB.GetRoomUnits(r.Context(), room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: "-//go-bookings-app//Room Calendar//EN",
+		Name:   room.Name,
+	}
+	if len(units) > 1 {
+		// other sites sell the room type, it is only closed there once every
+		// unit is taken
+		cal.Events = soldOutEvents(restrictions, units, now)
+	} else {
+		for _, restriction := range restrictions {
+			cal.Events = append(cal.Events, restrictionEvent(restriction, now))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	if err := ical.Write(w, cal); err != nil {
+		m.App.ErrorLog.Println("RoomCalendar", err)
+	}
+}
+
+// roomCalendarURL is the subscription url of a room's ical feed.
+func (m *Repository) roomCalendarURL(room models.Room) string {
+	token := tokens.MAC(m.App.SecretKey, roomCalendarPurpose, strconv.Itoa(room.ID))
+	return fmt.Sprintf("%s/rooms/%s/calendar.ics?token=%s", m.App.BaseURL, url.PathEscape(room.Slug), token)
+}
+
+// ownRestrictions leaves out the blocks imported from external feeds, sending
+// a channel its own bookings back would block them on every other channel
+// twice.
+func ownRestrictions(restrictions []models.RoomRestriction) []models.RoomRestriction {
+	own := make([]models.RoomRestriction, 0, len(restrictions))
+	for _, y := range restrictions {
+		if y.RestrictionId == models.RestrictionExternal || y.FeedId != 0 {
+			continue
+		}
+		own = append(own, y)
+	}
+	return own
+}
+
+// restrictionEvent maps a restriction to an all-day event. Reservations are
+// keyed by reservation so their UID survives the restriction being rewritten.
+func restrictionEvent(restriction models.RoomRestriction, now time.Time) ical.Event {
+	event := ical.Event{
+		UID:     fmt.Sprintf("restriction-%d@%s", restriction.ID, icalUIDDomain),
+		Summary: "Blocked",
+		Start:   restriction.StartDate,
+		End:     restriction.EndDate,
+		Stamp:   restriction.UpdatedAt,
+	}
+
+	if restriction.ReservationId > 0 {
+		event.UID = fmt.Sprintf("reservation-%d@%s", restriction.ReservationId, icalUIDDomain)
+		event.Summary = "Reserved"
+	}
+	if !event.End.After(event.Start) {
+		event.End = event.Start.AddDate(0, 0, 1)
+	}
+	if event.Stamp.IsZero() {
+		event.Stamp = now
+	}
+
+	return event
+}
+
+// soldOutEvents maps the runs of nights on which every unit is restricted to
+// all-day events, keyed by their first night.
+func soldOutEvents(restrictions []models.RoomRestriction, units []models.RoomUnit, now time.Time) []ical.Event {
+	taken := make(map[time.Time]map[int]bool)
+	for _, y := range restrictions {
+		start, end := dateOnly(y.StartDate), dateOnly(y.EndDate)
+		if !end.After(start) {
+			end = start.AddDate(0, 0, 1)
+		}
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			if taken[d] == nil {
+				taken[d] = make(map[int]bool)
+			}
+			for _, u := range units {
+				if y.RoomUnitId == 0 || y.RoomUnitId == u.ID {
+					taken[d][u.ID] = true
+				}
+			}
+		}
+	}
+
+	var nights []time.Time
+	for d, byUnit := range taken {
+		if len(byUnit) == len(units) {
+			nights = append(nights, d)
+		}
+	}
+	sort.Slice(nights, func(i, j int) bool { return nights[i].Before(nights[j]) })
+
+	var events []ical.Event
+	for _, d := range nights {
+		if last := len(events) - 1; last >= 0 && events[last].End.Equal(d) {
+			events[last].End = d.AddDate(0, 0, 1)
+			continue
+		}
+		events = append(events, ical.Event{
+			UID:     fmt.Sprintf("soldout-%s@%s", d.Format("20060102"), icalUIDDomain),
+			Summary: "Sold out",
+			Start:   d,
+			End:     d.AddDate(0, 0, 1),
+			Stamp:   now,
+		})
+	}
+	return events
+}
+
+// dateOnly drops the time of day of t.
+func dateOnly(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
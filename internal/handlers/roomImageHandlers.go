@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/imaging"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
+)
+
+const (
+	// MaxUploadBytes bounds a whole upload, several photos at once. It is
+	// applied by a middleware before the csrf check reads the upload.
+	MaxUploadBytes = 50 << 20
+	// MaxUploadMemory is how much of an upload is kept in memory, the rest
+	// goes to temporary files
+	MaxUploadMemory = 8 << 20
+	// maxPhotoBytes bounds each photo
+	maxPhotoBytes = 20 << 20
+)
+
+// AdminRoomImages shows the photos of a room in gallery order.
+func (m *Repository) AdminRoomImages(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	images, err := m.DB.GetRoomImages(r.Context(), room.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get photos from database")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["room"] = room
+	dataMap["images"] = m.withImageURLs(images)
+
+	render.Template(w, r, "adminRoomImages.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: dataMap,
+	})
+}
+
+// AdminUploadRoomImages adds the photos posted in the "images" field to the
+// end of the room's gallery, each in a web size and a thumbnail. Files that
+// are not photos are reported and skipped.
+func (m *Repository) AdminUploadRoomImages(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	galleryURL := fmt.Sprintf("/admin/rooms/%d/images", room.ID)
+
+	err := r.ParseMultipartForm(MaxUploadMemory)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot read upload, photos may be up to %d MB in total", MaxUploadBytes>>20))
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Choose one or more photos to upload")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	alt := defaultString(strings.TrimSpace(r.Form.Get("alt")), room.Name)
+
+	var failed []string
+	uploaded := 0
+	for _, fh := range files {
+		err := m.saveRoomImage(r.Context(), room.ID, fh, alt)
+		if err != nil {
+			m.App.ErrorLog.Println("AdminUploadRoomImages", fh.Filename, err)
+			failed = append(failed, fh.Filename)
+			continue
+		}
+		uploaded++
+	}
+
+	if len(failed) > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot upload %s, photos must be JPEG, PNG or GIF images of up to %d MB", strings.Join(failed, ", "), maxPhotoBytes>>20))
+	}
+	if uploaded > 0 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d photo(s) uploaded", uploaded))
+	}
+	http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+}
+
+// saveRoomImage stores the resized copies of an uploaded photo and records
+// them, the copies are removed again if the photo cannot be recorded.
+func (m *Repository) saveRoomImage(ctx context.Context, roomId int, fh *multipart.FileHeader, alt string) error {
+	if fh.Size > maxPhotoBytes {
+		return imaging.ErrTooLarge
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	img, err := imaging.Decode(file)
+	if err != nil {
+		return err
+	}
+
+	name, err := tokens.Random(8)
+	if err != nil {
+		return err
+	}
+
+	roomImage := models.RoomImage{RoomId: roomId, Alt: alt}
+	var saved []string
+	for _, v := range []imaging.Variant{imaging.Web, imaging.Thumbnail} {
+		resized := imaging.Resize(img, v)
+		var buf bytes.Buffer
+		if err = imaging.Encode(&buf, resized); err != nil {
+			break
+		}
+
+		key := fmt.Sprintf("rooms/%d/%s-%s.jpg", roomId, name, v.Name)
+		if err = m.App.Storage.Save(ctx, key, &buf); err != nil {
+			break
+		}
+		saved = append(saved, key)
+
+		if v == imaging.Web {
+			roomImage.WebKey = key
+			roomImage.Width = resized.Bounds().Dx()
+			roomImage.Height = resized.Bounds().Dy()
+		} else {
+			roomImage.ThumbKey = key
+		}
+	}
+
+	if err == nil {
+		_, err = m.DB.InsertRoomImage(ctx, roomImage)
+	}
+	if err != nil {
+		m.deleteStoredFiles(ctx, saved...)
+		return err
+	}
+
+	return nil
+}
+
+// AdminDeleteRoomImage removes a photo from the gallery and its files from
+// the storage.
+func (m *Repository) AdminDeleteRoomImage(w http.ResponseWriter, r *http.Request) {
+	img, galleryURL, ok := m.adminRoomImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoomImage(r.Context(), img.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete photo")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+	m.deleteStoredFiles(r.Context(), img.WebKey, img.ThumbKey)
+
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+}
+
+// AdminSetRoomCoverImage makes a photo the one shown first for the room.
+func (m *Repository) AdminSetRoomCoverImage(w http.ResponseWriter, r *http.Request) {
+	img, galleryURL, ok := m.adminRoomImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.SetRoomCoverImage(r.Context(), img.RoomId, img.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot set cover photo")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cover photo changed")
+	http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+}
+
+// AdminMoveRoomImage swaps a photo with the one before or after it in the
+// gallery, as the posted direction says.
+func (m *Repository) AdminMoveRoomImage(w http.ResponseWriter, r *http.Request) {
+	img, galleryURL, ok := m.adminRoomImageFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	step, ok := directionStep(r.Form.Get("direction"))
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Invalid direction")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	images, err := m.DB.GetRoomImages(r.Context(), img.RoomId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot get photos from database")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	ids := make([]int, len(images))
+	for i, image := range images {
+		ids[i] = image.ID
+	}
+	swapWithNeighbour(ids, img.ID, step)
+
+	err = m.DB.ReorderRoomImages(r.Context(), img.RoomId, ids)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot reorder photos")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+}
+
+// adminRoomImageFromURL loads the photo in the url, making sure it belongs
+// to the room in the url as well. It also returns the address of the room's
+// gallery to redirect to.
+func (m *Repository) adminRoomImageFromURL(w http.ResponseWriter, r *http.Request) (models.RoomImage, string, bool) {
+	roomId, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse room id")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return models.RoomImage{}, "", false
+	}
+	galleryURL := fmt.Sprintf("/admin/rooms/%d/images", roomId)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "imageId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse photo id")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return models.RoomImage{}, "", false
+	}
+
+	img, err := m.DB.GetRoomImageById(r.Context(), id)
+	if err != nil || img.RoomId != roomId {
+		m.App.Session.Put(r.Context(), "error", "Cannot find photo")
+		http.Redirect(w, r, galleryURL, http.StatusSeeOther)
+		return models.RoomImage{}, "", false
+	}
+
+	return img, galleryURL, true
+}
+
+// withImageURLs fills the addresses templates show the photos from.
+func (m *Repository) withImageURLs(images []models.RoomImage) []models.RoomImage {
+	for i := range images {
+		images[i].URL = m.App.Storage.URL(images[i].WebKey)
+		images[i].ThumbURL = m.App.Storage.URL(images[i].ThumbKey)
+	}
+	return images
+}
+
+// deleteStoredFiles removes files from the storage, failures only leave
+// unused files behind so they are logged rather than reported.
+func (m *Repository) deleteStoredFiles(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := m.App.Storage.Delete(ctx, key); err != nil {
+			m.App.ErrorLog.Println("cannot delete", key, err)
+		}
+	}
+}
+
+// coverImage returns the cover photo of a gallery.
+func coverImage(images []models.RoomImage) (models.RoomImage, bool) {
+	for _, img := range images {
+		if img.IsCover {
+			return img, true
+		}
+	}
+	return models.RoomImage{}, false
+}
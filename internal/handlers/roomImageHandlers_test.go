@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+type upload struct {
+	filename string
+	content  []byte
+}
+
+func pngPhoto(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRepository_AdminUploadRoomImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		roomId   string
+		files    []upload
+		location string
+		flash    bool
+		hasError bool
+	}{
+		{"one photo", "1", []upload{{"room.png", pngPhoto(t, 2000, 1000)}}, "/admin/rooms/1/images", true, false},
+		{"photo and text file", "1", []upload{{"room.png", pngPhoto(t, 300, 200)}, {"notes.txt", []byte("not a photo")}}, "/admin/rooms/1/images", true, true},
+		{"not a photo", "1", []upload{{"notes.txt", []byte("not a photo")}}, "/admin/rooms/1/images", false, true},
+		{"no files", "1", nil, "/admin/rooms/1/images", false, true},
+		{"unknown room", "7", []upload{{"room.png", pngPhoto(t, 300, 200)}}, "/admin/rooms", false, true},
+	}
+
+	for _, tt := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for _, f := range tt.files {
+			part, _ := mw.CreateFormFile("images", f.filename)
+			part.Write(f.content)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/images", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminUploadRoomImages).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: got code %d, want %d", tt.name, rr.Code, http.StatusSeeOther)
+		}
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "flash") != tt.flash {
+			t.Errorf("%s: flash message in session should be %v", tt.name, tt.flash)
+		}
+		if appConfig.Session.Exists(ctx, "error") != tt.hasError {
+			t.Errorf("%s: error message in session should be %v", tt.name, tt.hasError)
+		}
+	}
+}
+
+func TestRepository_AdminRoomImageActions(t *testing.T) {
+	// room 1 has photos 1 to 3, photo 1 is the cover
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		roomId   string
+		imageId  string
+		posted   url.Values
+		hasError bool
+	}{
+		{"delete", Repo.AdminDeleteRoomImage, "1", "2", nil, false},
+		{"delete photo of another room", Repo.AdminDeleteRoomImage, "2", "2", nil, true},
+		{"set cover", Repo.AdminSetRoomCoverImage, "1", "3", nil, false},
+		{"unknown photo", Repo.AdminSetRoomCoverImage, "1", "9", nil, true},
+		{"move down", Repo.AdminMoveRoomImage, "1", "1", url.Values{"direction": {"down"}}, false},
+		{"move without direction", Repo.AdminMoveRoomImage, "1", "1", nil, true},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/images/"+tt.imageId, strings.NewReader(tt.posted.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		rctx.URLParams.Add("imageId", tt.imageId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/rooms/"+tt.roomId+"/images" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "error") != tt.hasError {
+			t.Errorf("%s: error message in session should be %v", tt.name, tt.hasError)
+		}
+	}
+}
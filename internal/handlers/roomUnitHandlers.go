@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+)
+
+const maxUnitNameLength = 20
+
+// AdminPostRoomUnit adds a unit with the posted name to the room.
+func (m *Repository) AdminPostRoomUnit(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	name := strings.TrimSpace(r.Form.Get("name"))
+	if name == "" || len(name) > maxUnitNameLength {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Unit names are 1 to %d characters long", maxUnitNameLength))
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertRoomUnit(r.Context(), models.RoomUnit{RoomId: room.ID, Name: name})
+	if errors.Is(err, repository.ErrUnitNameTaken) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The room already has a unit named %s", name))
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot add unit")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Unit added")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomUnit removes a unit no reservation or block refers to.
+func (m *Repository) AdminDeleteRoomUnit(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "unitId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse unit id")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRoomUnit(r.Context(), room.ID, id)
+	if errors.Is(err, repository.ErrUnitInUse) {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete a unit with reservations or blocks, or the last unit of a room")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete unit")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Unit deleted")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRepository_AdminPostRoomUnit(t *testing.T) {
+	tests := []struct {
+		name     string
+		roomId   string
+		unitName string
+		location string
+		flash    bool
+	}{
+		{"new unit", "1", "2", "/admin/rooms/1", true},
+		{"name taken", "1", "1", "/admin/rooms/1", false},
+		{"empty name", "1", "  ", "/admin/rooms/1", false},
+		{"name too long", "1", strings.Repeat("x", maxUnitNameLength+1), "/admin/rooms/1", false},
+		{"unknown room", "7", "1", "/admin/rooms", false},
+	}
+
+	for _, tt := range tests {
+		postedData := url.Values{"name": {tt.unitName}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/units", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostRoomUnit).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "flash") != tt.flash {
+			t.Errorf("%s: flash message in session should be %v", tt.name, tt.flash)
+		}
+		if appConfig.Session.Exists(ctx, "error") == tt.flash {
+			t.Errorf("%s: error message in session should be %v", tt.name, !tt.flash)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomUnit(t *testing.T) {
+	tests := []struct {
+		name   string
+		roomId string
+		unitId string
+		flash  bool
+	}{
+		{"unused unit", "1", "4", true},
+		{"last unit of the room", "1", "1", false},
+		{"invalid id", "1", "x", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/units/"+tt.unitId+"/delete", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		rctx.URLParams.Add("unitId", tt.unitId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminDeleteRoomUnit).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/rooms/"+tt.roomId {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "flash") != tt.flash {
+			t.Errorf("%s: flash message in session should be %v", tt.name, tt.flash)
+		}
+	}
+}
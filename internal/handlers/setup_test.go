@@ -16,8 +16,12 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/config"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ledger"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/payments"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/storage"
 )
 
 var appConfig config.AppConfig
@@ -44,6 +48,17 @@ func TestMain(m *testing.M) {
 	// This is the entry point of the application
 	appConfig.InProduction = false
 	appConfig.UseCache = true
+	appConfig.BaseURL = "http://localhost:8083"
+	appConfig.SecretKey = []byte("testing-secret")
+	appConfig.EmailVerificationTTL = time.Hour
+	appConfig.PasswordResetTTL = time.Hour
+	appConfig.GuestCancellationNotice = 48 * time.Hour
+	appConfig.APITokenTTL = time.Hour
+	appConfig.Currency = "USD"
+	appConfig.Payments = payments.NewFake(appConfig.SecretKey)
+	appConfig.PaymentTerms = ledger.Terms{DepositPercent: 30, BalanceDueDays: 14}
+	appConfig.PaymentHold = 30 * time.Minute
+	appConfig.Storage = storage.NewLocal(filepath.Join(os.TempDir(), "bookings-test-uploads"), "/uploads")
 
 	mailChan := make(chan models.MailData)
 	appConfig.MailChan = mailChan
@@ -61,6 +76,7 @@ func TestMain(m *testing.M) {
 	appConfig.InfoLog = *log.New(log.Writer(), "INFO\t", log.Ldate|log.Ltime)
 	appConfig.ErrorLog = *log.New(log.Writer(), "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	helpers.InitHelper(&appConfig)
 	render.InitializeRenderer(&appConfig)
 
 	// Initialize a new repository
@@ -111,10 +127,14 @@ func getRoutes() http.Handler {
 	mux.Get("/contact", Repo.Contact)
 	// mux.Get("/generals-quarters", Repo.Generals)
 	// mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms/{slug}/calendar.ics", Repo.RoomCalendar)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.CreateReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/reservation-payment", Repo.ReservationPayment)
+	mux.Post("/reservation-payment", Repo.PostReservationPayment)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.SearchAvailability)
@@ -125,25 +145,86 @@ func getRoutes() http.Handler {
 	mux.Get("/user/logout", Repo.Logout)
 	mux.Get("/user/register", Repo.ShowRegistration)
 	mux.Post("/user/register", Repo.PostRegistration)
+	mux.Get("/user/verify-email", Repo.VerifyEmail)
+	mux.Post("/user/resend-verification", Repo.ResendVerification)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password/{token}", Repo.ShowResetPassword)
+	mux.Post("/user/reset-password/{token}", Repo.PostResetPassword)
+	mux.Get("/user/reservations", Repo.UserReservations)
+	mux.Get("/user/reservations/{id}", Repo.UserShowReservation)
+	mux.Post("/user/reservations/{id}/cancel", Repo.UserCancelReservation)
+	mux.Post("/user/reservations/{id}/change-request", Repo.UserRequestDateChange)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 	mux.Get("/admin/reservations/{id}", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{id}", Repo.AdminEditReservation)
-	mux.Get("/admin/reservations/{id}/delete", Repo.AdminDeleteReservation)
-	mux.Get("/admin/reservations/{id}/processed", Repo.AdminProcessedReservation)
+	mux.Post("/admin/reservations/{id}/cancel", Repo.AdminCancelReservation)
+	mux.Get("/admin/front-desk", Repo.AdminFrontDesk)
+	mux.Post("/admin/front-desk/{id}/check-in", Repo.AdminCheckIn)
+	mux.Post("/admin/front-desk/{id}/check-out", Repo.AdminCheckOut)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms", Repo.AdminPostRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostRoom)
+	mux.Post("/admin/rooms/{id}/archive", Repo.AdminArchiveRoom)
+	mux.Post("/admin/rooms/{id}/restore", Repo.AdminRestoreRoom)
+	mux.Post("/admin/rooms/{id}/move", Repo.AdminMoveRoom)
+	mux.Get("/admin/rooms/{id}/images", Repo.AdminRoomImages)
+	mux.Post("/admin/rooms/{id}/images", Repo.AdminUploadRoomImages)
+	mux.Post("/admin/rooms/{id}/images/{imageId}/delete", Repo.AdminDeleteRoomImage)
+	mux.Post("/admin/rooms/{id}/images/{imageId}/cover", Repo.AdminSetRoomCoverImage)
+	mux.Post("/admin/rooms/{id}/images/{imageId}/move", Repo.AdminMoveRoomImage)
+	mux.Post("/admin/rooms/{id}/units", Repo.AdminPostRoomUnit)
+	mux.Post("/admin/rooms/{id}/units/{unitId}/delete", Repo.AdminDeleteRoomUnit)
+	mux.Post("/admin/rooms/{id}/stay-rules", Repo.AdminPostStayRule)
+	mux.Post("/admin/rooms/{id}/stay-rules/{ruleId}/delete", Repo.AdminDeleteStayRule)
+	mux.Get("/admin/amenities", Repo.AdminAmenities)
+	mux.Post("/admin/amenities", Repo.AdminPostAmenity)
+	mux.Post("/admin/amenities/{id}/delete", Repo.AdminDeleteAmenity)
+	mux.Post("/admin/reservations/{id}/payments", Repo.AdminPostReservationPayment)
+	mux.Post("/admin/reservations/{id}/refunds", Repo.AdminPostReservationRefund)
+	mux.Post("/admin/reservations/{id}/status", Repo.AdminReservationStatus)
+	mux.Post("/admin/reservations/{id}/move", Repo.AdminMoveReservation)
 	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
-	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/blocks/new", Repo.AdminNewBlock)
+	mux.Post("/admin/blocks", Repo.AdminPostBlock)
+	mux.Get("/admin/blocks/{id}", Repo.AdminShowBlock)
+	mux.Post("/admin/blocks/{id}", Repo.AdminPostBlock)
+	mux.Post("/admin/blocks/{id}/delete", Repo.AdminDeleteBlock)
+	mux.Get("/admin/ical-feeds", Repo.AdminICalFeeds)
+	mux.Post("/admin/ical-feeds", Repo.AdminPostICalFeed)
+	mux.Post("/admin/ical-feeds/{id}/delete", Repo.AdminDeleteICalFeed)
+	mux.Post("/admin/ical-feeds/{id}/sync", Repo.AdminSyncICalFeed)
+	mux.Get("/admin/pricing", Repo.AdminPricing)
+	mux.Get("/admin/pricing/{id}", Repo.AdminRoomPricing)
+	mux.Post("/admin/pricing/{id}", Repo.AdminPostRoomRateSettings)
+	mux.Post("/admin/pricing/{id}/seasons", Repo.AdminPostSeasonalRate)
+	mux.Post("/admin/pricing/{id}/seasons/{seasonId}/delete", Repo.AdminDeleteSeasonalRate)
+	mux.Post("/admin/pricing/{id}/discounts", Repo.AdminPostStayDiscount)
+	mux.Post("/admin/pricing/{id}/discounts/{discountId}/delete", Repo.AdminDeleteStayDiscount)
+	mux.Get("/admin/cancellation-policies", Repo.AdminCancellationPolicies)
+	mux.Post("/admin/cancellation-policies", Repo.AdminPostCancellationPolicy)
+	mux.Post("/admin/cancellation-policies/{id}/delete", Repo.AdminDeleteCancellationPolicy)
+	mux.Post("/admin/cancellation-policies/rooms/{roomId}", Repo.AdminPostRoomCancellationPolicy)
+	mux.Get("/admin/api-tokens", Repo.AdminAPITokens)
+	mux.Post("/admin/api-tokens", Repo.AdminPostAPIToken)
+	mux.Post("/admin/api-tokens/{id}/revoke", Repo.AdminRevokeAPIToken)
 	return mux
 }
 
 // Function is a map of functions that can be used in the template
 var function = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"can":         render.Can,
+	"formatMoney": render.FormatMoney,
+	"statusLabel": render.StatusLabel,
 }
 
 func InitTemplateCache() (map[string]*template.Template, error) {
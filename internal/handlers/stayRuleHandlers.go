@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
+)
+
+// AdminPostStayRule adds a stay rule to the room, the dates are optional and
+// limits left empty are not enforced.
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	var rule stayrules.Rule
+	if v := r.Form.Get("start_date"); v != "" {
+		rule.Start, err = time.Parse(layout, v)
+	}
+	if v := r.Form.Get("end_date"); v != "" && err == nil {
+		rule.End, err = time.Parse(layout, v)
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Dates must look like 2006-01-02")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+	if !rule.Start.IsZero() && !rule.End.IsZero() && rule.End.Before(rule.Start) {
+		m.App.Session.Put(r.Context(), "error", "The rule cannot end before it starts")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	limits := []struct {
+		field string
+		dst   *int
+	}{
+		{"min_nights", &rule.MinNights},
+		{"max_nights", &rule.MaxNights},
+		{"min_lead_days", &rule.MinLeadDays},
+		{"max_lead_days", &rule.MaxLeadDays},
+	}
+	for _, l := range limits {
+		*l.dst, err = strconv.Atoi(defaultString(r.Form.Get(l.field), "0"))
+		if err != nil || *l.dst < 0 {
+			m.App.Session.Put(r.Context(), "error", "Nights and days must be positive numbers")
+			http.Redirect(w, r, roomURL, http.StatusSeeOther)
+			return
+		}
+	}
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		m.App.Session.Put(r.Context(), "error", "The maximum nights cannot be below the minimum")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	arrival, arrivalOK := parseWeekdays(r.Form["closed_to_arrival"])
+	departure, departureOK := parseWeekdays(r.Form["closed_to_departure"])
+	if !arrivalOK || !departureOK {
+		m.App.Session.Put(r.Context(), "error", "Invalid weekday")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+	rule.ClosedToArrival, rule.ClosedToDeparture = arrival, departure
+
+	if rule.MinNights == 0 && rule.MaxNights == 0 && rule.MinLeadDays == 0 && rule.MaxLeadDays == 0 &&
+		len(rule.ClosedToArrival) == 0 && len(rule.ClosedToDeparture) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Set at least one limit or closed day")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(r.Context(), room.ID, rule)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save stay rule")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	roomURL := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	id, err := strconv.Atoi(chi.URLParam(r, "ruleId"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse stay rule id")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(r.Context(), room.ID, id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot delete stay rule")
+		http.Redirect(w, r, roomURL, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, roomURL, http.StatusSeeOther)
+}
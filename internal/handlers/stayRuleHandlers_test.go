@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
+)
+
+// ruleDates gives the dates of the rule, open ends left empty.
+func ruleDates(rule stayrules.Rule) string {
+	if rule.Start.IsZero() && rule.End.IsZero() {
+		return "all year"
+	}
+	dates := make([]string, 2)
+	for i, d := range []time.Time{rule.Start, rule.End} {
+		if !d.IsZero() {
+			dates[i] = d.Format(layout)
+		}
+	}
+	return strings.Join(dates, " to ")
+}
+
+// addedStayRules lists the rules of room 1 other than the fixed Christmas
+// 2060 rule.
+func addedStayRules() stayrules.Rules {
+	rules, _ := Repo.DB.GetRoomStayRules(context.Background(), 1)
+	return rules[1:]
+}
+
+func TestRepository_AdminPostStayRule(t *testing.T) {
+	// rule describes the rule added, empty when none is
+	tests := []struct {
+		name     string
+		roomId   string
+		posted   url.Values
+		location string
+		rule     string
+		dates    string
+	}{
+		{"minimum stay", "1", url.Values{"start_date": {"2050-07-01"}, "end_date": {"2050-08-31"}, "min_nights": {"3"}}, "/admin/rooms/1", "At least 3 nights", "2050-07-01 to 2050-08-31"},
+		{"closed days all year", "1", url.Values{"closed_to_arrival": {"0"}, "closed_to_departure": {"1", "2"}}, "/admin/rooms/1", "No arrivals on Sunday, no departures on Monday, Tuesday", "all year"},
+		{"lead times", "1", url.Values{"min_lead_days": {"2"}, "max_lead_days": {"365"}}, "/admin/rooms/1", "Booked 2 days ahead, booked at most 365 days ahead", "all year"},
+		{"no limit", "1", url.Values{"start_date": {"2050-07-01"}}, "/admin/rooms/1", "", ""},
+		{"ends before it starts", "1", url.Values{"start_date": {"2050-08-31"}, "end_date": {"2050-07-01"}, "min_nights": {"3"}}, "/admin/rooms/1", "", ""},
+		{"bad date", "1", url.Values{"start_date": {"01/07/2050"}, "min_nights": {"3"}}, "/admin/rooms/1", "", ""},
+		{"negative nights", "1", url.Values{"min_nights": {"-1"}}, "/admin/rooms/1", "", ""},
+		{"max below min", "1", url.Values{"min_nights": {"5"}, "max_nights": {"3"}}, "/admin/rooms/1", "", ""},
+		{"bad weekday", "1", url.Values{"closed_to_arrival": {"7"}}, "/admin/rooms/1", "", ""},
+		{"unknown room", "7", url.Values{"min_nights": {"3"}}, "/admin/rooms", "", ""},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+tt.roomId+"/stay-rules", strings.NewReader(tt.posted.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", tt.roomId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostStayRule).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != tt.location {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		added := addedStayRules()
+		if tt.rule == "" {
+			if len(added) != 0 {
+				t.Errorf("%s: added %s", tt.name, added[0])
+			}
+			if !appConfig.Session.Exists(ctx, "error") {
+				t.Errorf("%s: expected error message in session", tt.name)
+			}
+			continue
+		}
+
+		if len(added) != 1 {
+			t.Errorf("%s: expected one rule added, got %d", tt.name, len(added))
+			continue
+		}
+		if added[0].String() != tt.rule {
+			t.Errorf("%s: added %q, want %q", tt.name, added[0], tt.rule)
+		}
+		if dates := ruleDates(added[0]); dates != tt.dates {
+			t.Errorf("%s: added from %q, want %q", tt.name, dates, tt.dates)
+		}
+		_ = Repo.DB.DeleteStayRule(context.Background(), 1, added[0].ID)
+	}
+}
+
+func TestRepository_AdminDeleteStayRule(t *testing.T) {
+	id, err := Repo.DB.InsertStayRule(context.Background(), 1, stayrules.Rule{MinNights: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		ruleId string
+		flash  bool
+		left   int
+	}{
+		{"bad id", "x", false, 1},
+		{"deleted", strconv.Itoa(id), true, 0},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/stay-rules/"+tt.ruleId+"/delete", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		rctx.URLParams.Add("ruleId", tt.ruleId)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminDeleteStayRule).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != "/admin/rooms/1" {
+			t.Errorf("%s: unexpected redirect to %s", tt.name, loc)
+		}
+		if appConfig.Session.Exists(ctx, "flash") != tt.flash {
+			t.Errorf("%s: flash message in session should be %v", tt.name, tt.flash)
+		}
+		if left := len(addedStayRules()); left != tt.left {
+			t.Errorf("%s: %d rules left, want %d", tt.name, left, tt.left)
+		}
+	}
+}
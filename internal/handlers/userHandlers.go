@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/forms"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/render"
+)
+
+func (m *Repository) UserReservations(w http.ResponseWriter, r *http.Request) {
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+
+	reservations, err := m.DB.GetReservationsByUserId(r.Context(), userId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	upcoming := []models.Reservation{}
+	past := []models.Reservation{}
+	for _, res := range reservations {
+		if res.EndDate.Before(today) {
+			past = append(past, res)
+		} else {
+			upcoming = append(upcoming, res)
+		}
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["upcoming"] = upcoming
+	dataMap["past"] = past
+
+	render.Template(w, r, "userReservations.page.tmpl", &models.TemplateData{
+		Data: dataMap,
+	})
+}
+
+func (m *Repository) UserShowReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.userReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	changeRequests, err := m.DB.GetReservationChangeRequests(r.Context(), reservation.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	dataMap := make(map[string]interface{})
+	dataMap["reservation"] = reservation
+	dataMap["change_requests"] = changeRequests
+
+	policy, err := m.DB.GetRoomCancellationPolicy(r.Context(), reservation.RoomId)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	strMap := make(map[string]string)
+	if m.guestCanCancel(reservation, time.Now()) {
+		strMap["can_cancel"] = "true"
+		if penalty := policy.Penalty(reservation.Quote.Total, reservation.StartDate, time.Now()); penalty.Amount > 0 {
+			strMap["cancellation_fee"] = penalty.String()
+		}
+	}
+	strMap["cancellation_policy"] = policy.String()
+	strMap["cancellation_notice"] = fmt.Sprintf("%.0f hours", m.App.GuestCancellationNotice.Hours())
+
+	render.Template(w, r, "userShowReservation.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      dataMap,
+		StringMap: strMap,
+	})
+}
+
+func (m *Repository) UserCancelReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.userReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	detailPath := fmt.Sprintf("/user/reservations/%d", reservation.ID)
+	if !m.guestCanCancel(reservation, time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled online, please contact us")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	reservation, err := m.cancelReservation(r.Context(), reservation, reservation.UserId, "Cancelled by the guest", false)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot cancel reservation")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", cancellationMessage(reservation))
+	http.Redirect(w, r, "/user/reservations", http.StatusSeeOther)
+}
+
+func (m *Repository) UserRequestDateChange(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.userReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	detailPath := fmt.Sprintf("/user/reservations/%d", reservation.ID)
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse form")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	startDate, errStart := time.Parse(layout, r.Form.Get("start_date"))
+	endDate, errEnd := time.Parse(layout, r.Form.Get("end_date"))
+	if errStart != nil || errEnd != nil || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Please choose a valid date range")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	if startDate.Before(time.Now().Truncate(24 * time.Hour)) {
+		m.App.Session.Put(r.Context(), "error", "New dates cannot be in the past")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	changeRequest := models.ReservationChangeRequest{
+		ReservationId: reservation.ID,
+		UserId:        reservation.UserId,
+		StartDate:     startDate,
+		EndDate:       endDate,
+		Note:          r.Form.Get("note"),
+	}
+
+	err = m.DB.InsertReservationChangeRequest(r.Context(), changeRequest)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot save your request")
+		http.Redirect(w, r, detailPath, http.StatusSeeOther)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong>Date change request</strong><br>
+		Reservation #%d (%s %s) asks to move from %s - %s to %s - %s.<br>
+		Note: %s
+	`, reservation.ID, html.EscapeString(reservation.FirstName), html.EscapeString(reservation.LastName),
+		reservation.StartDate.Format(layout), reservation.EndDate.Format(layout),
+		startDate.Format(layout), endDate.Format(layout), html.EscapeString(changeRequest.Note))
+
+	m.App.MailChan <- models.MailData{
+		To:       "universal@booking.com",
+		From:     "universal@booking.com",
+		Subject:  fmt.Sprintf("Date change request for reservation #%d", reservation.ID),
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your request has been sent, we will get back to you shortly")
+	http.Redirect(w, r, detailPath, http.StatusSeeOther)
+}
+
+// userReservationFromURL loads the reservation in the url for the session user,
+// it writes the redirect itself when the reservation cannot be shown.
+func (m *Repository) userReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Cannot parse reservation id")
+		http.Redirect(w, r, "/user/reservations", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	userId := m.App.Session.GetInt(r.Context(), "user_id")
+	reservation, err := m.DB.GetUserReservationById(r.Context(), id, userId)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Reservation not found")
+		http.Redirect(w, r, "/user/reservations", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	return reservation, true
+}
+
+// guestCanCancel applies the cancellation policy: guests can cancel on their
+// own until GuestCancellationNotice before arrival.
+func (m *Repository) guestCanCancel(res models.Reservation, now time.Time) bool {
+	return lifecycle.Can(res.Status, models.ReservationCancelled) && now.Add(m.App.GuestCancellationNotice).Before(res.StartDate)
+}
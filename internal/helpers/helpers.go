@@ -1,11 +1,15 @@
 package helpers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/config"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
 )
 
 var appConfig *config.AppConfig
@@ -25,6 +29,91 @@ func ServerError(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// APIError is the body of every failed /api response.
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Code    string              `json:"code"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// WriteJSON writes v as the json response body with the given status.
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// WriteAPIError writes the error envelope used by the json api.
+func WriteAPIError(w http.ResponseWriter, status int, code, message string) {
+	WriteJSON(w, status, APIError{Error: APIErrorBody{Code: code, Message: message}})
+}
+
 func IsAuthenticated(w http.ResponseWriter, r *http.Request) bool {
 	return appConfig.Session.Exists(r.Context(), "user_id")
 }
+
+type contextKey string
+
+const (
+	currentUserKey contextKey = "current_user"
+	tokenScopesKey contextKey = "token_scopes"
+)
+
+// WithCurrentUser stores the logged in user in the request context.
+func WithCurrentUser(ctx context.Context, user models.User) context.Context {
+	return context.WithValue(ctx, currentUserKey, user)
+}
+
+// CurrentUser returns the user loaded by the LoadCurrentUser middleware.
+func CurrentUser(r *http.Request) (models.User, bool) {
+	user, ok := r.Context().Value(currentUserKey).(models.User)
+	return user, ok
+}
+
+// RevokeUserSessions destroys every active session that belongs to userId.
+// The session store must support iteration.
+func RevokeUserSessions(ctx context.Context, userId int) error {
+	return appConfig.Session.Iterate(ctx, func(ctx context.Context) error {
+		if appConfig.Session.GetInt(ctx, "user_id") != userId {
+			return nil
+		}
+		return appConfig.Session.Destroy(ctx)
+	})
+}
+
+// WithTokenScopes limits the request to the scopes of the api token it was
+// authenticated with.
+func WithTokenScopes(ctx context.Context, scopes []rbac.Permission) context.Context {
+	return context.WithValue(ctx, tokenScopesKey, scopes)
+}
+
+// Can reports whether the current user holds perm and, for requests made with
+// a scoped api token, whether the token was granted it.
+func Can(r *http.Request, perm rbac.Permission) bool {
+	user, ok := CurrentUser(r)
+	if !ok || !rbac.Can(user.AccessLevel, perm) {
+		return false
+	}
+
+	scopes, scoped := r.Context().Value(tokenScopesKey).([]rbac.Permission)
+	if !scoped {
+		return true
+	}
+
+	for _, s := range scopes {
+		if s == perm {
+			return true
+		}
+	}
+	return false
+}
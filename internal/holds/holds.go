@@ -0,0 +1,70 @@
+// Package holds gives back the rooms of reservations whose payment did not
+// arrive in time, so abandoned bookings do not keep them out of sale.
+package holds
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+)
+
+// Store is the part of the repository the sweeper needs.
+type Store interface {
+	GetOverdueReservations(ctx context.Context, now time.Time) ([]int, error)
+	ExpireReservation(ctx context.Context, id int, now time.Time) error
+}
+
+type Sweeper struct {
+	Store    Store
+	ErrorLog *log.Logger
+}
+
+func New(store Store, errorLog *log.Logger) *Sweeper {
+	return &Sweeper{
+		Store:    store,
+		ErrorLog: errorLog,
+	}
+}
+
+// Run sweeps right away and then every interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.Sweep(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep cancels the reservations whose payment was due by now and returns
+// how many it cancelled. Reservations paid meanwhile are left alone.
+func (s *Sweeper) Sweep(ctx context.Context, now time.Time) int {
+	ids, err := s.Store.GetOverdueReservations(ctx, now)
+	if err != nil {
+		s.ErrorLog.Println("holds: cannot list overdue reservations:", err)
+		return 0
+	}
+
+	expired := 0
+	for _, id := range ids {
+		err := s.Store.ExpireReservation(ctx, id, now)
+		if errors.Is(err, lifecycle.ErrTransition) {
+			continue
+		}
+		if err != nil {
+			s.ErrorLog.Printf("holds: reservation %d: %v", id, err)
+			continue
+		}
+		expired++
+	}
+	return expired
+}
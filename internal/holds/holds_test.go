@@ -0,0 +1,77 @@
+package holds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+)
+
+// memStore keeps the status and payment deadline of reservations in memory.
+type memStore struct {
+	status  map[int]string
+	due     map[int]time.Time
+	failing int
+}
+
+func (s *memStore) GetOverdueReservations(ctx context.Context, now time.Time) ([]int, error) {
+	var ids []int
+	for id, status := range s.status {
+		if status == models.ReservationPendingPayment && !s.due[id].After(now) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memStore) ExpireReservation(ctx context.Context, id int, now time.Time) error {
+	if id == s.failing {
+		return errors.New("connection reset")
+	}
+	if s.status[id] != models.ReservationPendingPayment {
+		return lifecycle.ErrTransition
+	}
+	s.status[id] = models.ReservationCancelled
+	return nil
+}
+
+func TestSweeper_Sweep(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := &memStore{
+		status: map[int]string{
+			1: models.ReservationPendingPayment,
+			2: models.ReservationPendingPayment,
+			3: models.ReservationConfirmed,
+			4: models.ReservationPendingPayment,
+		},
+		due: map[int]time.Time{
+			1: now.Add(-time.Minute),
+			2: now.Add(time.Minute),
+			3: now.Add(-time.Hour),
+			4: now.Add(-time.Hour),
+		},
+		failing: 4,
+	}
+	sweeper := New(store, log.New(io.Discard, "", 0))
+
+	if got := sweeper.Sweep(context.Background(), now); got != 1 {
+		t.Errorf("got %d reservations expired, want 1", got)
+	}
+
+	want := map[int]string{
+		1: models.ReservationCancelled,
+		2: models.ReservationPendingPayment,
+		3: models.ReservationConfirmed,
+		4: models.ReservationPendingPayment,
+	}
+	for id, status := range want {
+		if store.status[id] != status {
+			t.Errorf("reservation %d: got status %q, want %q", id, store.status[id], status)
+		}
+	}
+}
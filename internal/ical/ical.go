@@ -0,0 +1,24 @@
+// Package ical reads and writes the small subset of RFC 5545 needed to share
+// room occupancy with external calendars: all-day VEVENTs in a VCALENDAR.
+package ical
+
+import "time"
+
+const dateLayout = "20060102"
+
+// Event is an all-day event, End is exclusive like the departure day of a
+// reservation.
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+	Stamp   time.Time
+}
+
+// Calendar is a named list of events.
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
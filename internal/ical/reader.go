@@ -0,0 +1,184 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned when the input has no VCALENDAR.
+var ErrNotCalendar = errors.New("ical: input is not an iCalendar stream")
+
+// ErrIncomplete is returned when the input stops before END:VCALENDAR or in
+// the middle of an event, as a feed cut off while downloading does.
+var ErrIncomplete = errors.New("ical: calendar is incomplete")
+
+// maxLineBytes bounds a single unfolded content line.
+const maxLineBytes = 1 << 20
+
+var (
+	textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	dayDuration   = regexp.MustCompile(`^P(?:(\d+)W)?(?:(\d+)D)?`)
+)
+
+// Parse reads the VEVENTs of an iCalendar stream as all-day events. Times are
+// truncated to their date, events without an end last one day and cancelled
+// events are dropped. Events without a UID get one derived from their dates
+// so they can still be reconciled. A stream that is not closed by
+// END:VCALENDAR returns ErrIncomplete rather than the events read so far.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		events     []Event
+		current    *Event
+		cancelled  bool
+		duration   string
+		isCalendar bool
+		isEnded    bool
+	)
+
+	for n, line := range lines {
+		name, value := splitLine(line)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			isCalendar = true
+		case name == "END" && strings.EqualFold(value, "VCALENDAR"):
+			if current != nil {
+				return nil, fmt.Errorf("%w: event starting before line %d is not closed", ErrIncomplete, n+1)
+			}
+			isEnded = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			if current != nil {
+				return nil, fmt.Errorf("%w: event starting before line %d is not closed", ErrIncomplete, n+1)
+			}
+			current, cancelled, duration = &Event{}, false, ""
+		case name == "END" && strings.EqualFold(value, "VEVENT") && current != nil:
+			if current.Start.IsZero() {
+				return nil, fmt.Errorf("ical: event ending on line %d has no DTSTART", n+1)
+			}
+			finishEvent(current, duration)
+			if !cancelled {
+				events = append(events, *current)
+			}
+			current = nil
+		case current == nil:
+			// properties of the calendar or of other components
+		case name == "UID":
+			current.UID = value
+		case name == "SUMMARY":
+			current.Summary = textUnescaper.Replace(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DURATION":
+			duration = value
+		case name == "DTSTART" || name == "DTEND":
+			date, err := parseDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("ical: line %d: %w", n+1, err)
+			}
+			if name == "DTSTART" {
+				current.Start = date
+			} else {
+				current.End = date
+			}
+		}
+	}
+
+	if !isCalendar {
+		return nil, ErrNotCalendar
+	}
+	if current != nil {
+		return nil, fmt.Errorf("%w: last event is not closed", ErrIncomplete)
+	}
+	if !isEnded {
+		return nil, fmt.Errorf("%w: END:VCALENDAR is missing", ErrIncomplete)
+	}
+
+	return events, nil
+}
+
+func finishEvent(e *Event, duration string) {
+	if e.End.IsZero() {
+		e.End = e.Start.AddDate(0, 0, parseDays(duration))
+	}
+	if !e.End.After(e.Start) {
+		e.End = e.Start.AddDate(0, 0, 1)
+	}
+	if e.UID == "" {
+		e.UID = fmt.Sprintf("%s-%s", e.Start.Format(dateLayout), e.End.Format(dateLayout))
+	}
+}
+
+// parseDays reads the day part of a DURATION, anything shorter is a day.
+func parseDays(duration string) int {
+	m := dayDuration.FindStringSubmatch(duration)
+	days := 0
+	if m != nil {
+		weeks, _ := strconv.Atoi(m[1])
+		d, _ := strconv.Atoi(m[2])
+		days = weeks*7 + d
+	}
+	if days < 1 {
+		days = 1
+	}
+	return days
+}
+
+// parseDate accepts DATE and DATE-TIME values and keeps the calendar date as
+// written, which is the night the guest arrives or leaves whatever the TZID.
+func parseDate(value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return time.Parse(dateLayout, value[:len(dateLayout)])
+}
+
+// unfold joins continuation lines, which start with a space or a tab.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// splitLine splits "NAME;PARAM=x:value" into its upper cased name and the
+// value, the parameters are not needed. Colons inside quoted parameters are
+// skipped.
+func splitLine(line string) (string, string) {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			name, _, _ := strings.Cut(line[:i], ";")
+			return strings.ToUpper(name), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
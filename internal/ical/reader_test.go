@@ -0,0 +1,107 @@
+package ical
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+const airbnbFeed = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Airbnb Inc//Hosting Calendar 0.8.8//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTEND;VALUE=DATE:20500115\r\n" +
+	"DTSTART;VALUE=DATE:20500110\r\n" +
+	"UID:1418fb94e984-a6a8fa1a2e4b@airbnb.com\r\n" +
+	"SUMMARY:Reserved\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;TZID=\"Europe/Paris\":20500120T150000\r\n" +
+	"DURATION:P2D\r\n" +
+	"UID:long-uid-that-is-\r\n" +
+	" folded@example.com\r\n" +
+	"SUMMARY:Blocked\\, maintenance\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500201\r\n" +
+	"UID:cancelled@example.com\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20500301\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(airbnbFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %+v", len(events), events)
+	}
+
+	day := func(m time.Month, d int) time.Time { return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		uid, summary string
+		start, end   time.Time
+	}{
+		{"1418fb94e984-a6a8fa1a2e4b@airbnb.com", "Reserved", day(1, 10), day(1, 15)},
+		{"long-uid-that-is-folded@example.com", "Blocked, maintenance", day(1, 20), day(1, 22)},
+		{"20500301-20500302", "", day(3, 1), day(3, 2)},
+	}
+
+	for i, tt := range tests {
+		e := events[i]
+		if e.UID != tt.uid || e.Summary != tt.summary || !e.Start.Equal(tt.start) || !e.End.Equal(tt.end) {
+			t.Errorf("event %d: got %+v, want %+v", i, e, tt)
+		}
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	start := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	var sb strings.Builder
+	err := Write(&sb, Calendar{ProdID: "-//test//EN", Events: []Event{
+		{UID: "reservation-1@test", Summary: "Reserved", Start: start, End: start.AddDate(0, 0, 2), Stamp: start},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := Parse(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].UID != "reservation-1@test" || !events[0].End.Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("round trip failed: %+v", events)
+	}
+}
+
+func TestParse_NotCalendar(t *testing.T) {
+	if _, err := Parse(strings.NewReader("<html>login required</html>")); !errors.Is(err, ErrNotCalendar) {
+		t.Errorf("expected ErrNotCalendar, got %v", err)
+	}
+}
+
+func TestParse_Incomplete(t *testing.T) {
+	// a feed cut off anywhere must not read as a calendar with fewer events
+	cut := strings.Index(airbnbFeed, "BEGIN:VEVENT\r\nDTSTART;TZID")
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"no END:VCALENDAR", airbnbFeed[:strings.Index(airbnbFeed, "END:VCALENDAR")]},
+		{"event left open", airbnbFeed[:cut+len("BEGIN:VEVENT\r\nDTSTART;TZID=\"Europe/Paris\":20500120T150000\r\n")]},
+		{"event not closed before the next", strings.Replace(airbnbFeed, "SUMMARY:Reserved\r\nEND:VEVENT\r\n", "SUMMARY:Reserved\r\n", 1)},
+	}
+
+	for _, tt := range tests {
+		events, err := Parse(strings.NewReader(tt.input))
+		if !errors.Is(err, ErrIncomplete) {
+			t.Errorf("%s: expected ErrIncomplete, got %v with %d events", tt.name, err, len(events))
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// maxLineOctets is the line length after which content lines must be folded.
+const maxLineOctets = 75
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Write encodes cal as an iCalendar stream with CRLF line endings.
+func Write(w io.Writer, cal Calendar) error {
+	bw := bufio.NewWriter(w)
+
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:"+escapeText(cal.ProdID))
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	writeLine(bw, "METHOD:PUBLISH")
+	if cal.Name != "" {
+		writeLine(bw, "X-WR-CALNAME:"+escapeText(cal.Name))
+	}
+
+	for _, e := range cal.Events {
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+escapeText(e.UID))
+		writeLine(bw, "DTSTAMP:"+e.Stamp.UTC().Format("20060102T150405Z"))
+		writeLine(bw, "DTSTART;VALUE=DATE:"+e.Start.Format(dateLayout))
+		writeLine(bw, "DTEND;VALUE=DATE:"+e.End.Format(dateLayout))
+		writeLine(bw, "SUMMARY:"+escapeText(e.Summary))
+		writeLine(bw, "TRANSP:OPAQUE")
+		writeLine(bw, "END:VEVENT")
+	}
+
+	writeLine(bw, "END:VCALENDAR")
+	return bw.Flush()
+}
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// writeLine folds lines longer than 75 octets without splitting a utf-8
+// sequence, continuation lines start with a single space.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space counts towards the next line
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
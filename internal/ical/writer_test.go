@@ -0,0 +1,56 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	start := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	cal := Calendar{
+		ProdID: "-//test//EN",
+		Name:   "General's Quarters, east wing",
+		Events: []Event{
+			{UID: "reservation-1@test", Summary: "Reserved", Start: start, End: start.AddDate(0, 0, 2), Stamp: start},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, cal); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:General's Quarters\\, east wing\r\n",
+		"UID:reservation-1@test\r\n",
+		"DTSTART;VALUE=DATE:20500110\r\n",
+		"DTEND;VALUE=DATE:20500112\r\n",
+		"DTSTAMP:20500110T000000Z\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestWrite_FoldsLongLines(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, Calendar{ProdID: "-//test//EN", Name: strings.Repeat("é", 60)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		if len(line) > maxLineOctets {
+			t.Errorf("line longer than %d octets: %q", maxLineOctets, line)
+		}
+	}
+	if !strings.Contains(buf.String(), "\r\n é") {
+		t.Error("expected a folded continuation line")
+	}
+}
@@ -0,0 +1,222 @@
+// Package icalsync imports the events of external ical feeds as room blocks
+// so bookings made on other channels make the room unavailable here.
+package icalsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/ical"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+)
+
+// maxFeedBytes bounds the size of a downloaded feed.
+const maxFeedBytes = 5 << 20
+
+// Store is the part of the repository the syncer needs. InsertExternalBlock
+// and UpdateExternalBlock save the block but return
+// repository.ErrRoomUnavailable when it overlaps bookings on every unit.
+type Store interface {
+	AllRoomICalFeeds(ctx context.Context) ([]models.RoomICalFeed, error)
+	UpdateRoomICalFeedStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error
+	GetExternalBlocksByFeed(ctx context.Context, feedId int) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, block models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, block models.RoomRestriction) error
+	RemoveExternalBlock(ctx context.Context, id int) error
+}
+
+// Result counts the changes a sync made to the blocks of a feed.
+type Result struct {
+	Added   int
+	Updated int
+	Removed int
+	// Conflicts counts the added or updated blocks that found no free unit.
+	Conflicts int
+}
+
+type Syncer struct {
+	Store    Store
+	Client   *http.Client
+	ErrorLog *log.Logger
+}
+
+func New(store Store, errorLog *log.Logger) *Syncer {
+	return &Syncer{
+		Store:    store,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		ErrorLog: errorLog,
+	}
+}
+
+// Run syncs every feed right away and then every interval until ctx is done.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.SyncAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll syncs the feeds one after another, a failing feed does not stop
+// the others.
+func (s *Syncer) SyncAll(ctx context.Context) {
+	feeds, err := s.Store.AllRoomICalFeeds(ctx)
+	if err != nil {
+		s.ErrorLog.Println("ical sync: cannot list feeds:", err)
+		return
+	}
+
+	for _, feed := range feeds {
+		if _, err := s.SyncFeed(ctx, feed); err != nil {
+			s.ErrorLog.Printf("ical sync: feed %d (%s): %v", feed.ID, feed.URL, err)
+		}
+	}
+}
+
+// SyncFeed downloads a feed and reconciles its blocks by event UID. When the
+// feed cannot be fetched or parsed the existing blocks are left untouched, so
+// an outage of the other channel never frees up its bookings.
+func (s *Syncer) SyncFeed(ctx context.Context, feed models.RoomICalFeed) (Result, error) {
+	events, err := s.fetch(ctx, feed.URL)
+	if err != nil {
+		s.recordStatus(ctx, feed, err.Error())
+		return Result{}, err
+	}
+
+	result, err := s.reconcile(ctx, feed, events)
+	if err != nil {
+		s.recordStatus(ctx, feed, err.Error())
+		return result, err
+	}
+
+	s.recordStatus(ctx, feed, "")
+	return result, nil
+}
+
+func (s *Syncer) fetch(ctx context.Context, url string) ([]ical.Event, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// one byte more than allowed tells a feed at the limit from a longer one,
+	// which must not be parsed as the events it starts with
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFeedBytes {
+		return nil, fmt.Errorf("feed is larger than %d bytes", maxFeedBytes)
+	}
+
+	return ical.Parse(bytes.NewReader(body))
+}
+
+func (s *Syncer) reconcile(ctx context.Context, feed models.RoomICalFeed, events []ical.Event) (Result, error) {
+	var result Result
+
+	existing, err := s.Store.GetExternalBlocksByFeed(ctx, feed.ID)
+	if err != nil {
+		return result, err
+	}
+
+	byUID := make(map[string]models.RoomRestriction, len(existing))
+	for _, block := range existing {
+		byUID[block.ExternalUID] = block
+	}
+
+	// past stays are of no use for availability, they are neither imported
+	// nor removed once they drop out of the feed
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	seen := make(map[string]bool, len(events))
+	for _, event := range events {
+		if seen[event.UID] {
+			continue
+		}
+		seen[event.UID] = true
+		if !event.End.After(today) {
+			continue
+		}
+
+		block, ok := byUID[event.UID]
+		switch {
+		case !ok:
+			err = s.Store.InsertExternalBlock(ctx, models.RoomRestriction{
+				RoomId:        feed.RoomId,
+				RestrictionId: models.RestrictionExternal,
+				FeedId:        feed.ID,
+				ExternalUID:   event.UID,
+				StartDate:     event.Start,
+				EndDate:       event.End,
+			})
+			result.Added++
+		case !sameDay(block.StartDate, event.Start) || !sameDay(block.EndDate, event.End):
+			block.StartDate, block.EndDate = event.Start, event.End
+			err = s.Store.UpdateExternalBlock(ctx, block)
+			result.Updated++
+		}
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			result.Conflicts++
+			err = nil
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	for uid, block := range byUID {
+		if seen[uid] || !block.EndDate.After(today) {
+			continue
+		}
+		if err := s.Store.RemoveExternalBlock(ctx, block.ID); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	// the blocks are kept so the room stays closed, but someone has to move
+	// or cancel one of the bookings
+	if result.Conflicts > 0 {
+		return result, fmt.Errorf("%d events overlap bookings on every unit of the room", result.Conflicts)
+	}
+
+	return result, nil
+}
+
+func (s *Syncer) recordStatus(ctx context.Context, feed models.RoomICalFeed, lastError string) {
+	if err := s.Store.UpdateRoomICalFeedStatus(ctx, feed.ID, time.Now(), lastError); err != nil {
+		s.ErrorLog.Println("ical sync: cannot record status:", err)
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
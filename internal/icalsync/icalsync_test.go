@@ -0,0 +1,204 @@
+package icalsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+)
+
+// memStore keeps the blocks of one feed in memory. Blocks overlapping the
+// nights in full are saved but reported as finding no free unit.
+type memStore struct {
+	blocks    map[int]models.RoomRestriction
+	full      []time.Time
+	nextId    int
+	lastError string
+}
+
+func newMemStore(blocks ...models.RoomRestriction) *memStore {
+	s := &memStore{blocks: make(map[int]models.RoomRestriction), nextId: 100}
+	for _, b := range blocks {
+		s.blocks[b.ID] = b
+	}
+	return s
+}
+
+func (s *memStore) AllRoomICalFeeds(ctx context.Context) ([]models.RoomICalFeed, error) {
+	return nil, nil
+}
+
+func (s *memStore) UpdateRoomICalFeedStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	s.lastError = lastError
+	return nil
+}
+
+func (s *memStore) GetExternalBlocksByFeed(ctx context.Context, feedId int) ([]models.RoomRestriction, error) {
+	var blocks []models.RoomRestriction
+	for _, b := range s.blocks {
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+func (s *memStore) InsertExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	s.nextId++
+	block.ID = s.nextId
+	s.blocks[block.ID] = block
+	return s.checkFree(block)
+}
+
+func (s *memStore) UpdateExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	if _, ok := s.blocks[block.ID]; !ok {
+		return errors.New("no such block")
+	}
+	s.blocks[block.ID] = block
+	return s.checkFree(block)
+}
+
+func (s *memStore) checkFree(block models.RoomRestriction) error {
+	for _, night := range s.full {
+		if !night.Before(block.StartDate) && night.Before(block.EndDate) {
+			return repository.ErrRoomUnavailable
+		}
+	}
+	return nil
+}
+
+func (s *memStore) RemoveExternalBlock(ctx context.Context, id int) error {
+	delete(s.blocks, id)
+	return nil
+}
+
+func (s *memStore) byUID(uid string) (models.RoomRestriction, bool) {
+	for _, b := range s.blocks {
+		if b.ExternalUID == uid {
+			return b, true
+		}
+	}
+	return models.RoomRestriction{}, false
+}
+
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+func feedServer(t *testing.T, status int, body string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+const channelFeed = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\nUID:new@channel\r\nDTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500104\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:moved@channel\r\nDTSTART;VALUE=DATE:20500110\r\nDTEND;VALUE=DATE:20500112\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:same@channel\r\nDTSTART;VALUE=DATE:20500120\r\nDTEND;VALUE=DATE:20500121\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nUID:past@channel\r\nDTSTART;VALUE=DATE:20000101\r\nDTEND;VALUE=DATE:20000102\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestSyncFeed_Reconciles(t *testing.T) {
+	store := newMemStore(
+		models.RoomRestriction{ID: 1, RoomId: 1, FeedId: 1, ExternalUID: "moved@channel", StartDate: day(8), EndDate: day(9)},
+		models.RoomRestriction{ID: 2, RoomId: 1, FeedId: 1, ExternalUID: "same@channel", StartDate: day(20), EndDate: day(21)},
+		models.RoomRestriction{ID: 3, RoomId: 1, FeedId: 1, ExternalUID: "cancelled@channel", StartDate: day(25), EndDate: day(27)},
+	)
+	srv := feedServer(t, http.StatusOK, channelFeed)
+	syncer := New(store, log.New(io.Discard, "", 0))
+
+	result, err := syncer.SyncFeed(context.Background(), models.RoomICalFeed{ID: 1, RoomId: 1, URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result != (Result{Added: 1, Updated: 1, Removed: 1}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if b, ok := store.byUID("new@channel"); !ok || !b.StartDate.Equal(day(1)) || !b.EndDate.Equal(day(4)) || b.RestrictionId != models.RestrictionExternal {
+		t.Errorf("new event not imported correctly: %+v", b)
+	}
+	if b, _ := store.byUID("moved@channel"); !b.StartDate.Equal(day(10)) || !b.EndDate.Equal(day(12)) {
+		t.Errorf("moved event not updated: %+v", b)
+	}
+	if _, ok := store.byUID("cancelled@channel"); ok {
+		t.Error("event missing from the feed was not removed")
+	}
+	if _, ok := store.byUID("past@channel"); ok {
+		t.Error("past event should not be imported")
+	}
+
+	// a second run must be a no-op
+	result, err = syncer.SyncFeed(context.Background(), models.RoomICalFeed{ID: 1, RoomId: 1, URL: srv.URL})
+	if err != nil || result != (Result{}) {
+		t.Errorf("second sync changed blocks: %+v, %v", result, err)
+	}
+}
+
+func TestSyncFeed_KeepsBlocksOnFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{"server error", http.StatusInternalServerError, "oops"},
+		{"not a calendar", http.StatusOK, "<html>please log in</html>"},
+		{"cut off", http.StatusOK, channelFeed[:strings.Index(channelFeed, "BEGIN:VEVENT\r\nUID:moved")]},
+		{"too large", http.StatusOK, strings.Replace(channelFeed, "VERSION:2.0\r\n", "VERSION:2.0\r\n"+strings.Repeat("X-PADDING:"+strings.Repeat("x", 1000)+"\r\n", maxFeedBytes/1000), 1)},
+	}
+
+	for _, tt := range tests {
+		store := newMemStore(models.RoomRestriction{ID: 1, RoomId: 1, FeedId: 1, ExternalUID: "kept@channel", StartDate: day(1), EndDate: day(2)})
+		srv := feedServer(t, tt.status, tt.body)
+
+		_, err := New(store, log.New(io.Discard, "", 0)).SyncFeed(context.Background(), models.RoomICalFeed{ID: 1, RoomId: 1, URL: srv.URL})
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+		if _, ok := store.byUID("kept@channel"); !ok {
+			t.Errorf("%s: existing block was removed", tt.name)
+		}
+		if store.lastError == "" {
+			t.Errorf("%s: error was not recorded on the feed", tt.name)
+		}
+	}
+}
+
+func TestSyncFeed_ReportsConflicts(t *testing.T) {
+	store := newMemStore(
+		models.RoomRestriction{ID: 1, RoomId: 1, FeedId: 1, ExternalUID: "moved@channel", StartDate: day(8), EndDate: day(9)},
+		models.RoomRestriction{ID: 2, RoomId: 1, FeedId: 1, ExternalUID: "same@channel", StartDate: day(20), EndDate: day(21)},
+	)
+	// every unit is booked for the nights the moved event now covers
+	store.full = []time.Time{day(11)}
+	srv := feedServer(t, http.StatusOK, channelFeed)
+
+	result, err := New(store, log.New(io.Discard, "", 0)).SyncFeed(context.Background(), models.RoomICalFeed{ID: 1, RoomId: 1, URL: srv.URL})
+	if err == nil {
+		t.Error("expected the conflict to be reported")
+	}
+	if result != (Result{Added: 1, Updated: 1, Conflicts: 1}) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if b, _ := store.byUID("moved@channel"); !b.StartDate.Equal(day(10)) || !b.EndDate.Equal(day(12)) {
+		t.Errorf("conflicting event should still block its new dates: %+v", b)
+	}
+	if _, ok := store.byUID("new@channel"); !ok {
+		t.Error("events after the conflict were not imported")
+	}
+	if store.lastError == "" {
+		t.Error("conflict was not recorded on the feed")
+	}
+}
@@ -0,0 +1,143 @@
+// Package imaging makes the resized copies of uploaded photos. It only uses
+// the standard library so no image tools have to be installed on the server.
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+var (
+	// ErrUnsupported is returned for files that are not JPEG, PNG or GIF
+	// images.
+	ErrUnsupported = errors.New("imaging: unsupported image format")
+	// ErrTooLarge is returned for images with more pixels than MaxPixels,
+	// before they are decoded.
+	ErrTooLarge = errors.New("imaging: image is too large")
+)
+
+// MaxPixels bounds the images Decode accepts, a small file can hold a huge
+// image that would not fit in memory once decoded. It lets in the photos of
+// 24 megapixel cameras, which already take 100 MB decoded as RGBA.
+const MaxPixels = 25_000_000
+
+// Variant is a size photos are published in.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	// Crop fills the whole size, cutting the edges of the photo, instead of
+	// fitting the photo inside it.
+	Crop bool
+}
+
+var (
+	Thumbnail = Variant{Name: "thumb", Width: 400, Height: 300, Crop: true}
+	Web       = Variant{Name: "web", Width: 1600, Height: 1200}
+)
+
+// Decode reads a JPEG, PNG or GIF image of at most MaxPixels.
+func Decode(r io.ReadSeeker) (image.Image, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Resize scales img to the variant, photos are never enlarged. Transparent
+// areas are flattened on white as JPEG has no transparency.
+func Resize(img image.Image, v Variant) *image.RGBA {
+	src := img.Bounds()
+	w, h := src.Dx(), src.Dy()
+
+	if v.Crop {
+		// the largest part of the photo, centered, with the variant's
+		// proportions
+		cw, ch := w, w*v.Height/v.Width
+		if ch > h {
+			cw, ch = h*v.Width/v.Height, h
+		}
+		x0, y0 := src.Min.X+(w-cw)/2, src.Min.Y+(h-ch)/2
+		src = image.Rect(x0, y0, x0+cw, y0+ch)
+		w, h = cw, ch
+	}
+
+	dw, dh := w, h
+	if v.Crop && (w > v.Width || h > v.Height) {
+		// the crop already has the right proportions, rounding could leave
+		// it a pixel short
+		dw, dh = v.Width, v.Height
+	} else if dw > v.Width || dh > v.Height {
+		if dw*v.Height > dh*v.Width {
+			dw, dh = v.Width, max(1, h*v.Width/w)
+		} else {
+			dw, dh = max(1, w*v.Height/h), v.Height
+		}
+	}
+
+	return scaleDown(img, src, dw, dh)
+}
+
+// scaleDown averages the block of source pixels each destination pixel
+// covers, which keeps detail better than sampling single pixels. The rows
+// of src are flattened a strip at a time, only the rows of one destination
+// row are copied and never the whole photo.
+func scaleDown(img image.Image, src image.Rectangle, dw, dh int) *image.RGBA {
+	sw, sh := src.Dx(), src.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	strip := image.NewRGBA(image.Rect(0, 0, sw, sh/dh+1))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		rows := image.Rect(0, 0, sw, y1-y0)
+		draw.Draw(strip, rows, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(strip, rows, img, image.Pt(src.Min.X, src.Min.Y+y0), draw.Over)
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+
+			var r, g, b, a, n int
+			for sy := 0; sy < y1-y0; sy++ {
+				i := strip.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(strip.Pix[i])
+					g += int(strip.Pix[i+1])
+					b += int(strip.Pix[i+2])
+					a += int(strip.Pix[i+3])
+					i += 4
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+// Encode writes img as a JPEG of web quality.
+func Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+}
@@ -0,0 +1,100 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func solid(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		variant       Variant
+		wantW, wantH  int
+	}{
+		{"landscape fits the web size", 4000, 2000, Web, 1600, 800},
+		{"portrait fits the web size", 1200, 2400, Web, 600, 1200},
+		{"small photos are not enlarged", 800, 600, Web, 800, 600},
+		{"thumbnail of a landscape", 4000, 2000, Thumbnail, 400, 300},
+		{"thumbnail of a portrait", 1000, 3000, Thumbnail, 400, 300},
+		{"thumbnail of a small photo", 200, 100, Thumbnail, 133, 100},
+	}
+
+	for _, tt := range tests {
+		got := Resize(solid(tt.width, tt.height, color.Black), tt.variant).Bounds()
+		if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestResize_Colors(t *testing.T) {
+	red := color.RGBA{R: 200, A: 255}
+	if got := Resize(solid(900, 900, red), Thumbnail).RGBAAt(10, 10); got != red {
+		t.Errorf("a solid photo should keep its color, got %v", got)
+	}
+
+	// transparent pixels end up white
+	if got := Resize(solid(10, 10, color.Transparent), Web).RGBAAt(5, 5); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("transparency should be flattened on white, got %v", got)
+	}
+}
+
+func TestResize_Region(t *testing.T) {
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	// red on the left, blue on the right
+	img := solid(3000, 1000, red)
+	for y := 0; y < 1000; y++ {
+		for x := 1500; x < 3000; x++ {
+			img.Set(x, y, blue)
+		}
+	}
+
+	thumb := Resize(img, Thumbnail)
+	if got := thumb.RGBAAt(10, 150); got != red {
+		t.Errorf("left of the thumbnail: got %v, want red", got)
+	}
+	if got := thumb.RGBAAt(390, 150); got != blue {
+		t.Errorf("right of the thumbnail: got %v, want blue", got)
+	}
+
+	// the blue half on its own, its bounds do not start at 0
+	half := Resize(img.SubImage(image.Rect(1500, 0, 3000, 1000)), Web)
+	if b := half.Bounds(); b.Dx() != 1500 || b.Dy() != 1000 {
+		t.Errorf("got %dx%d, want 1500x1000", b.Dx(), b.Dy())
+	}
+	if got := half.RGBAAt(0, 0); got != blue {
+		t.Errorf("got %v, want blue", got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, solid(30, 20, color.White)); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil || img.Bounds().Dx() != 30 {
+		t.Fatalf("got %v, %v, want the 30x20 image", img, err)
+	}
+
+	if _, err := Decode(strings.NewReader("not an image")); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package ledger keeps the accounts of a reservation: what it costs, what
+// the guest has paid and been refunded so far, and what is still due.
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+// Terms say how much of a stay is paid at booking. The rest of the price is
+// due BalanceDueDays before arrival.
+type Terms struct {
+	DepositPercent int
+	BalanceDueDays int
+}
+
+// Schedule splits total into the deposit charged at booking and the date the
+// balance is due. Stays starting within BalanceDueDays are paid in full at
+// booking.
+func (t Terms) Schedule(total money.Money, arrival, bookedAt time.Time) (money.Money, time.Time) {
+	dueDate := dateOf(arrival).AddDate(0, 0, -t.BalanceDueDays)
+	if t.DepositPercent <= 0 || t.DepositPercent >= 100 || !dateOf(bookedAt).Before(dueDate) {
+		return total, dateOf(bookedAt)
+	}
+	return total.Percent(t.DepositPercent), dueDate
+}
+
+// Ledger is the account of one reservation. Entries are its payments, only
+// the succeeded ones count.
+type Ledger struct {
+	Total      money.Money
+	Deposit    money.Money
+	BalanceDue time.Time
+	Entries    []models.Payment
+}
+
+// New builds the ledger of a reservation from its payments. A reservation
+// without a price takes the currency of its payments. It returns
+// money.ErrCurrencyMismatch when a payment is in another currency than the
+// reservation, such amounts cannot be added up.
+func New(res models.Reservation, entries []models.Payment) (Ledger, error) {
+	total := res.Quote.Total
+	if total.Currency == "" && len(entries) > 0 {
+		total = money.New(total.Amount, entries[0].Amount.Currency)
+	}
+	deposit := res.Deposit
+	if deposit.IsZero() {
+		deposit = total
+	}
+	if deposit.Currency != total.Currency {
+		return Ledger{}, fmt.Errorf("%w: reservation %d costs %s with a deposit in %s", money.ErrCurrencyMismatch, res.ID, total.Currency, deposit.Currency)
+	}
+	for _, p := range entries {
+		if p.Amount.Currency != total.Currency {
+			return Ledger{}, fmt.Errorf("%w: reservation %d is in %s, payment %d in %s", money.ErrCurrencyMismatch, res.ID, total.Currency, p.ID, p.Amount.Currency)
+		}
+	}
+	return Ledger{
+		Total:      total,
+		Deposit:    deposit,
+		BalanceDue: res.BalanceDue,
+		Entries:    entries,
+	}, nil
+}
+
+// Currency is the currency of every amount of the ledger.
+func (l Ledger) Currency() string {
+	return l.Total.Currency
+}
+
+// Paid is the sum of the successful charges.
+func (l Ledger) Paid() money.Money {
+	return l.sum(models.PaymentKindCharge)
+}
+
+// Refunded is the sum of the successful refunds.
+func (l Ledger) Refunded() money.Money {
+	return l.sum(models.PaymentKindRefund)
+}
+
+// Net is what the property holds for the reservation.
+func (l Ledger) Net() money.Money {
+	return l.Paid().Sub(l.Refunded())
+}
+
+// Balance is what the guest still owes, negative when they paid too much.
+func (l Ledger) Balance() money.Money {
+	return l.Total.Sub(l.Net())
+}
+
+// DepositOutstanding is what is missing from the deposit.
+func (l Ledger) DepositOutstanding() money.Money {
+	return atLeastZero(l.Deposit.Sub(l.Net()))
+}
+
+// Overdue reports a balance left unpaid after its due date.
+func (l Ledger) Overdue(now time.Time) bool {
+	return l.Balance().Amount > 0 && !l.BalanceDue.IsZero() && dateOf(now).After(dateOf(l.BalanceDue))
+}
+
+// CancellationRefund is what goes back to the guest when the reservation is
+// cancelled with penalty kept by the property.
+func (l Ledger) CancellationRefund(penalty money.Money) money.Money {
+	return atLeastZero(l.Net().Sub(penalty))
+}
+
+// Allocation is the part of a refund given back from one charge.
+type Allocation struct {
+	Charge models.Payment
+	Amount money.Money
+}
+
+// AllocateRefund spreads amount over the charges made with method, latest
+// first, never refunding more than is left of a charge. The allocations
+// cover less than amount when those charges do not hold enough, and nothing
+// when amount is in another currency.
+func (l Ledger) AllocateRefund(amount money.Money, method string) []Allocation {
+	if amount.Currency != l.Currency() {
+		return nil
+	}
+
+	refunded := make(map[int]money.Money)
+	for _, p := range l.Entries {
+		if p.Kind != models.PaymentKindRefund || p.Status != models.PaymentSucceeded {
+			continue
+		}
+		if r, ok := refunded[p.ParentId]; ok {
+			refunded[p.ParentId] = r.Add(p.Amount)
+		} else {
+			refunded[p.ParentId] = p.Amount
+		}
+	}
+
+	var charges []models.Payment
+	for _, p := range l.Entries {
+		if p.Kind == models.PaymentKindCharge && p.Status == models.PaymentSucceeded && p.Method == method {
+			charges = append(charges, p)
+		}
+	}
+	sort.SliceStable(charges, func(i, j int) bool {
+		return charges[i].CreatedAt.After(charges[j].CreatedAt)
+	})
+
+	var allocations []Allocation
+	for _, c := range charges {
+		if amount.Amount <= 0 {
+			break
+		}
+		left := c.Amount
+		if r, ok := refunded[c.ID]; ok {
+			left = left.Sub(r)
+		}
+		if left.Amount <= 0 {
+			continue
+		}
+		if left.Amount > amount.Amount {
+			left = amount
+		}
+		allocations = append(allocations, Allocation{Charge: c, Amount: left})
+		amount = amount.Sub(left)
+	}
+	return allocations
+}
+
+func (l Ledger) sum(kind string) money.Money {
+	total := money.New(0, l.Total.Currency)
+	for _, p := range l.Entries {
+		if p.Kind == kind && p.Status == models.PaymentSucceeded {
+			total = total.Add(p.Amount)
+		}
+	}
+	return total
+}
+
+func atLeastZero(m money.Money) money.Money {
+	if m.Amount < 0 {
+		return money.New(0, m.Currency)
+	}
+	return m
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,127 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+)
+
+func usd(cents int64) money.Money {
+	return money.New(cents, "USD")
+}
+
+func day(m time.Month, d int) time.Time {
+	return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestTerms_Schedule(t *testing.T) {
+	terms := Terms{DepositPercent: 30, BalanceDueDays: 14}
+
+	tests := []struct {
+		name     string
+		bookedAt time.Time
+		deposit  money.Money
+		dueDate  time.Time
+	}{
+		{"booked early", day(1, 1), usd(7500), day(2, 15)},
+		{"booked on the due date", day(2, 15), usd(25000), day(2, 15)},
+		{"booked late", day(2, 20), usd(25000), day(2, 20)},
+	}
+
+	for _, tt := range tests {
+		deposit, dueDate := terms.Schedule(usd(25000), day(3, 1), tt.bookedAt)
+		if deposit != tt.deposit || !dueDate.Equal(tt.dueDate) {
+			t.Errorf("%s: got deposit %s due %s, want %s due %s", tt.name, deposit, dueDate.Format("2006-01-02"), tt.deposit, tt.dueDate.Format("2006-01-02"))
+		}
+	}
+
+	if deposit, _ := (Terms{}).Schedule(usd(25000), day(3, 1), day(1, 1)); deposit != usd(25000) {
+		t.Errorf("without a deposit percentage the stay is paid in full, got %s", deposit)
+	}
+}
+
+func testLedger() Ledger {
+	res := models.Reservation{
+		Quote:      pricing.Quote{Total: usd(25000)},
+		Deposit:    usd(7500),
+		BalanceDue: day(2, 15),
+	}
+	l, _ := New(res, []models.Payment{
+		{ID: 1, Kind: models.PaymentKindCharge, Method: models.PaymentMethodCard, Amount: usd(7500), Status: models.PaymentSucceeded, CreatedAt: day(1, 1)},
+		{ID: 2, Kind: models.PaymentKindCharge, Method: models.PaymentMethodCard, Amount: usd(10000), Status: models.PaymentFailed, CreatedAt: day(1, 2)},
+		{ID: 3, Kind: models.PaymentKindCharge, Method: models.PaymentMethodCash, Amount: usd(5000), Status: models.PaymentSucceeded, CreatedAt: day(1, 3)},
+		{ID: 4, Kind: models.PaymentKindCharge, Method: models.PaymentMethodCard, Amount: usd(5000), Status: models.PaymentSucceeded, CreatedAt: day(1, 4)},
+		{ID: 5, ParentId: 4, Kind: models.PaymentKindRefund, Method: models.PaymentMethodCard, Amount: usd(2000), Status: models.PaymentSucceeded, CreatedAt: day(1, 5)},
+	})
+	return l
+}
+
+func TestNew_CurrencyMismatch(t *testing.T) {
+	res := models.Reservation{ID: 1, Quote: pricing.Quote{Total: usd(25000)}}
+	entries := []models.Payment{
+		{ID: 1, Kind: models.PaymentKindCharge, Amount: usd(7500), Status: models.PaymentSucceeded},
+		{ID: 2, Kind: models.PaymentKindCharge, Amount: money.New(5000, "EUR"), Status: models.PaymentSucceeded},
+	}
+
+	if _, err := New(res, entries); !errors.Is(err, money.ErrCurrencyMismatch) {
+		t.Errorf("expected ErrCurrencyMismatch, got %v", err)
+	}
+	if got := testLedger().AllocateRefund(money.New(1000, "EUR"), models.PaymentMethodCard); len(got) != 0 {
+		t.Errorf("a refund in another currency should not be allocated, got %+v", got)
+	}
+}
+
+func TestLedger_Totals(t *testing.T) {
+	l := testLedger()
+
+	if l.Paid() != usd(17500) {
+		t.Errorf("got paid %s, want $175.00", l.Paid())
+	}
+	if l.Refunded() != usd(2000) {
+		t.Errorf("got refunded %s, want $20.00", l.Refunded())
+	}
+	if l.Balance() != usd(9500) {
+		t.Errorf("got balance %s, want $95.00", l.Balance())
+	}
+	if !l.DepositOutstanding().IsZero() {
+		t.Errorf("deposit is paid, got %s outstanding", l.DepositOutstanding())
+	}
+	if l.Overdue(day(2, 15)) || !l.Overdue(day(2, 16)) {
+		t.Error("the balance is overdue the day after its due date")
+	}
+	if l.CancellationRefund(usd(5000)) != usd(10500) || !l.CancellationRefund(usd(50000)).IsZero() {
+		t.Errorf("got cancellation refund %s, want $105.00", l.CancellationRefund(usd(5000)))
+	}
+}
+
+func TestLedger_AllocateRefund(t *testing.T) {
+	l := testLedger()
+
+	// the latest card payment only has 30.00 left after its refund
+	got := l.AllocateRefund(usd(6000), models.PaymentMethodCard)
+	want := []struct {
+		charge int
+		amount money.Money
+	}{{4, usd(3000)}, {1, usd(3000)}}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d allocations, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Charge.ID != w.charge || got[i].Amount != w.amount {
+			t.Errorf("allocation %d: got %s from charge %d, want %s from charge %d", i, got[i].Amount, got[i].Charge.ID, w.amount, w.charge)
+		}
+	}
+
+	covered := money.New(0, "USD")
+	for _, a := range l.AllocateRefund(usd(20000), models.PaymentMethodCard) {
+		covered = covered.Add(a.Amount)
+	}
+	if covered != usd(10500) {
+		t.Errorf("card payments hold $105.00, allocated %s", covered)
+	}
+}
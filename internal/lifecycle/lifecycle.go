@@ -0,0 +1,99 @@
+// Package lifecycle holds the states a reservation goes through and the
+// moves allowed between them. Every change of status is checked here.
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+)
+
+// ErrTransition is returned for a move the lifecycle does not allow.
+var ErrTransition = errors.New("lifecycle: status change not allowed")
+
+// States are the statuses of a reservation, in the order they usually
+// happen.
+var States = []string{
+	models.ReservationPendingPayment,
+	models.ReservationConfirmed,
+	models.ReservationCheckedIn,
+	models.ReservationCheckedOut,
+	models.ReservationCancelled,
+	models.ReservationNoShow,
+}
+
+var transitions = map[string][]string{
+	models.ReservationPendingPayment: {models.ReservationConfirmed, models.ReservationCancelled},
+	models.ReservationConfirmed:      {models.ReservationCheckedIn, models.ReservationCancelled, models.ReservationNoShow},
+	models.ReservationCheckedIn:      {models.ReservationCheckedOut},
+}
+
+// Valid reports whether status is a known state.
+func Valid(status string) bool {
+	for _, s := range States {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Next lists the states a reservation in from can move to.
+func Next(from string) []string {
+	return transitions[from]
+}
+
+// From lists the states a reservation can reach to from.
+func From(to string) []string {
+	var from []string
+	for _, s := range States {
+		if Can(s, to) {
+			from = append(from, s)
+		}
+	}
+	return from
+}
+
+// Can reports whether a reservation in from may move to to.
+func Can(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns ErrTransition, with the states involved, when the move is
+// not allowed.
+func Check(from, to string) error {
+	if !Can(from, to) {
+		return fmt.Errorf("%w: %s to %s", ErrTransition, from, to)
+	}
+	return nil
+}
+
+// Releases reports whether a reservation in status gives its dates back.
+func Releases(status string) bool {
+	return status == models.ReservationCancelled || status == models.ReservationNoShow
+}
+
+// Label names a status for people.
+func Label(status string) string {
+	switch status {
+	case models.ReservationPendingPayment:
+		return "Pending payment"
+	case models.ReservationConfirmed:
+		return "Confirmed"
+	case models.ReservationCheckedIn:
+		return "Checked in"
+	case models.ReservationCheckedOut:
+		return "Checked out"
+	case models.ReservationCancelled:
+		return "Cancelled"
+	case models.ReservationNoShow:
+		return "No show"
+	}
+	return status
+}
@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+)
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{models.ReservationPendingPayment, models.ReservationConfirmed, true},
+		{models.ReservationPendingPayment, models.ReservationCheckedIn, false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, true},
+		{models.ReservationConfirmed, models.ReservationNoShow, true},
+		{models.ReservationCheckedIn, models.ReservationCheckedOut, true},
+		{models.ReservationCheckedIn, models.ReservationCancelled, false},
+		{models.ReservationCheckedOut, models.ReservationCheckedIn, false},
+		{models.ReservationCancelled, models.ReservationConfirmed, false},
+		{models.ReservationNoShow, models.ReservationCheckedIn, false},
+		{"", models.ReservationConfirmed, false},
+	}
+
+	for _, tt := range tests {
+		if got := Can(tt.from, tt.to); got != tt.want {
+			t.Errorf("Can(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFrom(t *testing.T) {
+	got := From(models.ReservationCancelled)
+	if len(got) != 2 || got[0] != models.ReservationPendingPayment || got[1] != models.ReservationConfirmed {
+		t.Errorf("got %v, want pending payment and confirmed", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check(models.ReservationConfirmed, models.ReservationCheckedIn); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := Check(models.ReservationCheckedOut, models.ReservationConfirmed); !errors.Is(err, ErrTransition) {
+		t.Errorf("got %v, want ErrTransition", err)
+	}
+}
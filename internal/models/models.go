@@ -2,20 +2,25 @@ package models
 
 import (
 	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
 )
 
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Phone       string
-	Password    string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	AccessLevel int
+	ID            int
+	FirstName     string
+	LastName      string
+	Email         string
+	Phone         string
+	Password      string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	AccessLevel   int
+	EmailVerified bool
 }
 
+// Reservation.UserId is zero for reservations made without logging in.
 type Reservation struct {
 	ID        int
 	UserId    int
@@ -28,11 +33,71 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Phone     string
-	Processed bool
-	Room      Room
-	User      User
+	Status    string
+	// Quote is the price breakdown the guest agreed to when booking
+	Quote pricing.Quote
+	// Deposit is charged at booking, the rest of the total is due on
+	// BalanceDue
+	Deposit    money.Money
+	BalanceDue time.Time
+	// PaymentDueAt is when a reservation waiting for its payment is
+	// cancelled and its room given back
+	PaymentDueAt time.Time
+	// CancelledBy is the user who cancelled the reservation and
+	// CancellationPenalty what the guest was charged for it
+	CancelledAt         time.Time
+	CancelledBy         int
+	CancellationReason  string
+	CancellationPenalty money.Money
+	// CheckedInAt is when the guest actually arrived, GuestIdDocument the
+	// identity document the front desk saw then
+	CheckedInAt     time.Time
+	CheckedOutAt    time.Time
+	GuestIdDocument string
+	FrontDeskNotes  string
+	// Adults and Children is the party staying, children are under 12
+	Adults   int
+	Children int
+	// RoomUnitId is the unit of the room the guests stay in, given out when
+	// the reservation is made
+	RoomUnitId int
+	Room       Room
+	Unit       RoomUnit
+	User       User
 }
 
+// Guests returns the party of the reservation.
+func (r Reservation) Guests() Guests {
+	return Guests{Adults: r.Adults, Children: r.Children}
+}
+
+// Guests is the size of a party looking for a room.
+type Guests struct {
+	Adults   int
+	Children int
+}
+
+// MaxGuests bounds each count of a party, larger groups book several rooms.
+const MaxGuests = 20
+
+// Departure is a reservation due to leave. NextArrival is the start of the
+// next booking or block of its room, zero when there is none, a guest still
+// in the room then, even on their own departure day, collides with it.
+type Departure struct {
+	Reservation Reservation
+	NextArrival time.Time
+	Late        bool
+	Collides    bool
+}
+
+// Ids of the seeded restrictions.
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	// RestrictionExternal blocks are imported from an external ical feed
+	RestrictionExternal = 3
+)
+
 type Restriction struct {
 	ID        int
 	Name      string
@@ -43,18 +108,88 @@ type Restriction struct {
 type Room struct {
 	ID          int
 	Name        string
-	Price       float32
+	Price       money.Money
 	Description string
 	Slug        string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// CancellationPolicyId is zero for rooms without a cancellation policy
+	CancellationPolicyId int
+	// SortOrder is the position of the room in listings, lowest first
+	SortOrder int
+	// MaxAdults and MaxChildren is how many guests the room sleeps, in Beds
+	// beds. Children may take the places of adults, MaxChildren are the
+	// places only children fit in.
+	MaxAdults   int
+	MaxChildren int
+	Beds        int
+	// UnitCount is how many units the room is sold from, FreeUnits how many
+	// of them are free for the stay searched
+	UnitCount int
+	FreeUnits int
+	// Amenities is only loaded where the room's features are shown
+	Amenities []Amenity
+	// ArchivedAt is zero for rooms guests can still book
+	ArchivedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// RoomImage is a photo of a room, stored in a web sized copy and a
+// thumbnail.
+type RoomImage struct {
+	ID       int
+	RoomId   int
+	WebKey   string
+	ThumbKey string
+	Alt      string
+	// Width and Height are the size of the web copy
+	Width     int
+	Height    int
+	SortOrder int
+	IsCover   bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// URL and ThumbURL are filled from the storage for templates
+	URL      string
+	ThumbURL string
+}
+
+// RoomUnit is one of the identical physical rooms a room is sold from, such
+// as room 101 of the standard doubles.
+type RoomUnit struct {
+	ID        int
+	RoomId    int
+	Name      string
+	SortOrder int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Amenity is a feature of a room from the catalogue, such as a sea view.
+// Guests filter searches on its slug.
+type Amenity struct {
+	ID        int
+	Name      string
+	Slug      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Sleeps reports whether the room has space for the party.
+func (r Room) Sleeps(guests Guests) bool {
+	return guests.Adults <= r.MaxAdults && guests.Adults+guests.Children <= r.MaxAdults+r.MaxChildren
 }
 
 type RoomRestriction struct {
-	ID            int
-	RoomId        int
+	ID     int
+	RoomId int
+	// RoomUnitId is zero for restrictions closing every unit of the room
+	RoomUnitId    int
 	RestrictionId int
 	ReservationId int
+	FeedId        int
+	ExternalUID   string
+	Reason        string
+	Note          string
 	StartDate     time.Time
 	EndDate       time.Time
 	CreatedAt     time.Time
@@ -63,6 +198,161 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// BlockReason is why an owner blocked a room.
+type BlockReason struct {
+	Value string
+	Label string
+}
+
+var BlockReasons = []BlockReason{
+	{"maintenance", "Maintenance"},
+	{"owner_stay", "Owner stay"},
+	{"deep_clean", "Deep clean"},
+	{"other", "Other"},
+}
+
+// BlockReasonLabel returns the label of a block reason value.
+func BlockReasonLabel(value string) string {
+	for _, r := range BlockReasons {
+		if r.Value == value {
+			return r.Label
+		}
+	}
+	return "Blocked"
+}
+
+// CalendarSpan is a run of consecutive nights of a room on the admin
+// calendar that share the same occupant. Free nights are spans of one.
+type CalendarSpan struct {
+	// Kind is one of "free", "reservation", "block" or "external"
+	Kind  string
+	ID    int
+	Date  string
+	Days  int
+	Label string
+}
+
+// UnitCalendar is the row of a unit on the admin calendar.
+type UnitCalendar struct {
+	Unit  RoomUnit
+	Spans []CalendarSpan
+}
+
+// Reservation statuses, a reservation booked online waits for its payment
+// before it is confirmed. The moves allowed between them are in the
+// lifecycle package.
+const (
+	ReservationPendingPayment = "pending_payment"
+	ReservationConfirmed      = "confirmed"
+	ReservationCheckedIn      = "checked_in"
+	ReservationCheckedOut     = "checked_out"
+	ReservationCancelled      = "cancelled"
+	ReservationNoShow         = "no_show"
+)
+
+const (
+	PaymentKindCharge = "charge"
+	PaymentKindRefund = "refund"
+)
+
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+)
+
+// Payment methods, card payments go through the payment provider while the
+// others are recorded by staff.
+const (
+	PaymentMethodCard         = "card"
+	PaymentMethodCash         = "cash"
+	PaymentMethodBankTransfer = "bank_transfer"
+)
+
+// PaymentProviderManual is the provider of payments recorded by staff.
+const PaymentProviderManual = "manual"
+
+// Payment is money taken from or given back to the guest of a reservation.
+// ProviderRef is the intent id of charges and the refund id of refunds made
+// through the provider, refunds point at their charge with ParentId.
+type Payment struct {
+	ID            int
+	ReservationId int
+	ParentId      int
+	Kind          string
+	Method        string
+	Provider      string
+	ProviderRef   string
+	Amount        money.Money
+	Status        string
+	Note          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// RoomICalFeed is an external calendar whose events block the room.
+type RoomICalFeed struct {
+	ID           int
+	RoomId       int
+	Name         string
+	URL          string
+	LastSyncedAt time.Time
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
+type ReservationChangeRequest struct {
+	ID            int
+	ReservationId int
+	UserId        int
+	StartDate     time.Time
+	EndDate       time.Time
+	Note          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ReservationEvent records a change of status of a reservation. FromStatus
+// is empty for the event of its creation and UserId zero for changes made by
+// the system, such as a captured payment.
+type ReservationEvent struct {
+	ID            int
+	ReservationId int
+	FromStatus    string
+	ToStatus      string
+	UserId        int
+	Note          string
+	CreatedAt     time.Time
+	User          User
+}
+
+type PasswordReset struct {
+	ID        int
+	UserId    int
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// APIToken is a personal access token, only the hash of the secret is stored.
+// Zero LastUsedAt, ExpiresAt and RevokedAt mean never.
+type APIToken struct {
+	ID         int
+	UserId     int
+	Name       string
+	TokenHash  string
+	Scopes     string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  time.Time
+	CreatedBy  int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
 type MailData struct {
 	To       string
 	From     string
@@ -0,0 +1,29 @@
+package models
+
+import "testing"
+
+func TestRoom_Sleeps(t *testing.T) {
+	double := Room{MaxAdults: 2}
+	family := Room{MaxAdults: 2, MaxChildren: 2}
+
+	tests := []struct {
+		name   string
+		room   Room
+		guests Guests
+		want   bool
+	}{
+		{"couple in a double", double, Guests{Adults: 2}, true},
+		{"parent and child in a double", double, Guests{Adults: 1, Children: 1}, true},
+		{"family in a double", double, Guests{Adults: 2, Children: 1}, false},
+		{"family room", family, Guests{Adults: 2, Children: 2}, true},
+		{"children in adult places", family, Guests{Adults: 1, Children: 3}, true},
+		{"too many adults", family, Guests{Adults: 3}, false},
+		{"too many guests", family, Guests{Adults: 2, Children: 3}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.room.Sleeps(tt.guests); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
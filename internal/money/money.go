@@ -0,0 +1,208 @@
+// Package money represents amounts exactly, as an integer number of minor
+// units (cents) of an ISO 4217 currency, so prices never pick up floating
+// point rounding errors.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when the property does not configure one.
+const DefaultCurrency = "USD"
+
+var (
+	ErrInvalidAmount   = errors.New("money: invalid amount")
+	ErrInvalidCurrency = errors.New("money: invalid currency code")
+	// ErrCurrencyMismatch is returned by the callers of Add and Sub that
+	// check amounts read from storage, which may be in different currencies
+	ErrCurrencyMismatch = errors.New("money: amounts are in different currencies")
+)
+
+// zeroDecimal lists the currencies without minor units, every other
+// currency is assumed to have two decimals.
+var zeroDecimal = map[string]bool{
+	"CLP": true, "ISK": true, "JPY": true, "KRW": true, "PYG": true, "UGX": true, "VND": true, "XAF": true, "XOF": true,
+}
+
+var threeDecimal = map[string]bool{
+	"BHD": true, "IQD": true, "JOD": true, "KWD": true, "LYD": true, "OMR": true, "TND": true,
+}
+
+// symbols are written before the amount, other currencies are followed by
+// their code.
+var symbols = map[string]string{
+	"USD": "$", "EUR": "€", "GBP": "£", "JPY": "¥", "VND": "₫", "AUD": "A$", "CAD": "C$",
+}
+
+type Money struct {
+	// Amount is in minor units, 12050 is 120.50 USD
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ValidCurrency reports whether code looks like an ISO 4217 code.
+func ValidCurrency(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Digits is the number of minor unit digits of the currency.
+func Digits(currency string) int {
+	switch {
+	case zeroDecimal[currency]:
+		return 0
+	case threeDecimal[currency]:
+		return 3
+	}
+	return 2
+}
+
+// Parse reads a decimal amount such as "120", "-4.5" or "120.50". More
+// decimals than the currency has are only accepted when they are zeros, as
+// in the "100.00" postgres returns for a decimal column of a yen price.
+func Parse(s, currency string) (Money, error) {
+	if !ValidCurrency(currency) {
+		return Money{}, ErrInvalidCurrency
+	}
+
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || !digitsOnly(whole) || !digitsOnly(frac) {
+		return Money{}, ErrInvalidAmount
+	}
+
+	digits := Digits(currency)
+	if len(frac) > digits {
+		if strings.Trim(frac[digits:], "0") != "" {
+			return Money{}, ErrInvalidAmount
+		}
+		frac = frac[:digits]
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, ErrInvalidAmount
+	}
+	if negative {
+		amount = -amount
+	}
+	return New(amount, currency), nil
+}
+
+func digitsOnly(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add panics when the currencies differ, amounts of different currencies
+// are never meant to be combined.
+func (m Money) Add(o Money) Money {
+	m.mustMatch(o)
+	return New(m.Amount+o.Amount, m.Currency)
+}
+
+func (m Money) Sub(o Money) Money {
+	m.mustMatch(o)
+	return New(m.Amount-o.Amount, m.Currency)
+}
+
+// Percent is percent of the amount, rounded half away from zero to the
+// minor unit.
+func (m Money) Percent(percent int) Money {
+	v := m.Amount * int64(percent)
+	if v < 0 {
+		return New((v-50)/100, m.Currency)
+	}
+	return New((v+50)/100, m.Currency)
+}
+
+func (m Money) mustMatch(o Money) {
+	if m.Currency != o.Currency {
+		panic(fmt.Sprintf("money: cannot combine %s and %s", m.Currency, o.Currency))
+	}
+}
+
+// Decimal writes the amount without currency or grouping, "1234.50", as
+// expected by form inputs and decimal columns.
+func (m Money) Decimal() string {
+	return m.format(false)
+}
+
+// String writes the amount for people, "$1,234.50" or "1,234.50 CHF".
+func (m Money) String() string {
+	amount := m.format(true)
+	if symbol, ok := symbols[m.Currency]; ok {
+		if strings.HasPrefix(amount, "-") {
+			return "-" + symbol + amount[1:]
+		}
+		return symbol + amount
+	}
+	return amount + " " + m.Currency
+}
+
+func (m Money) format(group bool) string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+
+	digits := Digits(m.Currency)
+	s := strconv.FormatInt(amount, 10)
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	whole, frac := s[:len(s)-digits], s[len(s)-digits:]
+
+	if group {
+		whole = groupThousands(whole)
+	}
+	if digits == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + frac
+}
+
+func groupThousands(s string) string {
+	if len(s) <= 3 {
+		return s
+	}
+	var b strings.Builder
+	head := len(s) % 3
+	if head > 0 {
+		b.WriteString(s[:head])
+	}
+	for i := head; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
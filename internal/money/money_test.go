@@ -0,0 +1,88 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		amount   int64
+		err      error
+	}{
+		{"120", "USD", 12000, nil},
+		{"120.5", "USD", 12050, nil},
+		{" 0.05 ", "USD", 5, nil},
+		{"-4.50", "EUR", -450, nil},
+		{"100.00", "JPY", 100, nil},
+		{"1.234", "KWD", 1234, nil},
+		{"120.555", "USD", 0, ErrInvalidAmount},
+		{"100.50", "JPY", 0, ErrInvalidAmount},
+		{".50", "USD", 0, ErrInvalidAmount},
+		{"1e3", "USD", 0, ErrInvalidAmount},
+		{"+1", "USD", 0, ErrInvalidAmount},
+		{"1", "usd", 0, ErrInvalidCurrency},
+	}
+
+	for _, tt := range tests {
+		m, err := Parse(tt.in, tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Parse(%q, %s): got error %v, want %v", tt.in, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && (m.Amount != tt.amount || m.Currency != tt.currency) {
+			t.Errorf("Parse(%q, %s) = %+v, want %d", tt.in, tt.currency, m, tt.amount)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		m       Money
+		str     string
+		decimal string
+	}{
+		{New(0, "USD"), "$0.00", "0.00"},
+		{New(5, "USD"), "$0.05", "0.05"},
+		{New(123456789, "USD"), "$1,234,567.89", "1234567.89"},
+		{New(-12050, "EUR"), "-€120.50", "-120.50"},
+		{New(1500000, "VND"), "₫1,500,000", "1500000"},
+		{New(12050, "CHF"), "120.50 CHF", "120.50"},
+		{New(1234, "KWD"), "1.234 KWD", "1.234"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.str {
+			t.Errorf("%+v: String() = %q, want %q", tt.m, got, tt.str)
+		}
+		if got := tt.m.Decimal(); got != tt.decimal {
+			t.Errorf("%+v: Decimal() = %q, want %q", tt.m, got, tt.decimal)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	a, b := New(10000, "USD"), New(2550, "USD")
+
+	if got := a.Add(b); got != New(12550, "USD") {
+		t.Errorf("Add: got %+v", got)
+	}
+	if got := a.Sub(b); got != New(7450, "USD") {
+		t.Errorf("Sub: got %+v", got)
+	}
+	if got := New(1005, "USD").Percent(10); got != New(101, "USD") {
+		t.Errorf("Percent rounds half up: got %+v", got)
+	}
+	if got := New(-1005, "USD").Percent(10); got != New(-101, "USD") {
+		t.Errorf("Percent rounds half away from zero: got %+v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("adding different currencies did not panic")
+		}
+	}()
+	a.Add(New(1, "EUR"))
+}
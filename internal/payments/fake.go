@@ -0,0 +1,133 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+// Fake is an in-process provider for tests and local development. Every
+// capture succeeds unless the intent was declined with Decline, and
+// webhooks are signed with an HMAC of the body.
+type Fake struct {
+	secret []byte
+
+	mu       sync.Mutex
+	seq      int
+	intents  map[string]*Intent
+	refunded map[string]money.Money
+	declined map[string]bool
+}
+
+func NewFake(secret []byte) *Fake {
+	return &Fake{
+		secret:   secret,
+		intents:  make(map[string]*Intent),
+		refunded: make(map[string]money.Money),
+		declined: make(map[string]bool),
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) CreateIntent(ctx context.Context, amount money.Money, reference string) (Intent, error) {
+	if err := ctx.Err(); err != nil {
+		return Intent{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	intent := &Intent{
+		ID:           fmt.Sprintf("pi_fake_%d", f.seq),
+		Amount:       amount,
+		Status:       IntentRequiresCapture,
+		ClientSecret: fmt.Sprintf("pi_fake_%d_secret", f.seq),
+	}
+	f.intents[intent.ID] = intent
+	return *intent, nil
+}
+
+// Decline makes the next capture of the intent fail.
+func (f *Fake) Decline(intentID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.declined[intentID] = true
+}
+
+func (f *Fake) Capture(ctx context.Context, intentID string) (Intent, error) {
+	if err := ctx.Err(); err != nil {
+		return Intent{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[intentID]
+	if !ok {
+		return Intent{}, ErrUnknownIntent
+	}
+	if f.declined[intentID] {
+		delete(f.declined, intentID)
+		intent.Status = IntentFailed
+		return *intent, ErrDeclined
+	}
+
+	intent.Status = IntentCaptured
+	return *intent, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, intentID string, amount money.Money) (Refund, error) {
+	if err := ctx.Err(); err != nil {
+		return Refund{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, ok := f.intents[intentID]
+	if !ok || intent.Status != IntentCaptured {
+		return Refund{}, ErrUnknownIntent
+	}
+
+	refunded, ok := f.refunded[intentID]
+	if !ok {
+		refunded = money.New(0, intent.Amount.Currency)
+	}
+	if amount.Amount <= 0 || refunded.Add(amount).Amount > intent.Amount.Amount {
+		return Refund{}, ErrRefundTooLarge
+	}
+	f.refunded[intentID] = refunded.Add(amount)
+
+	f.seq++
+	return Refund{ID: fmt.Sprintf("re_fake_%d", f.seq), IntentID: intentID, Amount: amount}, nil
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	if !hmac.Equal([]byte(f.Sign(payload)), []byte(signature)) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+// Sign is the signature VerifyWebhook expects for payload, for sending
+// webhooks to a development server.
+func (f *Fake) Sign(payload []byte) string {
+	h := hmac.New(sha256.New, f.secret)
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
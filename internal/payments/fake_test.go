@@ -0,0 +1,83 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+func TestFake_CaptureAndRefund(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake([]byte("secret"))
+
+	intent, err := f.CreateIntent(ctx, money.New(25000, "USD"), "reservation-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intent.Status != IntentRequiresCapture {
+		t.Errorf("new intent has status %s", intent.Status)
+	}
+
+	if _, err := f.Refund(ctx, intent.ID, money.New(100, "USD")); !errors.Is(err, ErrUnknownIntent) {
+		t.Errorf("refunded an intent that was not captured: %v", err)
+	}
+
+	captured, err := f.Capture(ctx, intent.ID)
+	if err != nil || captured.Status != IntentCaptured {
+		t.Fatalf("capture failed: %v, %s", err, captured.Status)
+	}
+
+	if _, err := f.Refund(ctx, intent.ID, money.New(20000, "USD")); err != nil {
+		t.Errorf("partial refund failed: %v", err)
+	}
+	if _, err := f.Refund(ctx, intent.ID, money.New(5001, "USD")); !errors.Is(err, ErrRefundTooLarge) {
+		t.Errorf("refunded more than captured: %v", err)
+	}
+}
+
+func TestFake_Decline(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake([]byte("secret"))
+
+	intent, _ := f.CreateIntent(ctx, money.New(25000, "USD"), "reservation-1")
+	f.Decline(intent.ID)
+
+	if _, err := f.Capture(ctx, intent.ID); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected ErrDeclined, got %v", err)
+	}
+	// declines only apply once, the guest can try again
+	if _, err := f.Capture(ctx, intent.ID); err != nil {
+		t.Errorf("second capture failed: %v", err)
+	}
+	if _, err := f.Capture(ctx, "pi_missing"); !errors.Is(err, ErrUnknownIntent) {
+		t.Errorf("expected ErrUnknownIntent, got %v", err)
+	}
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	f := NewFake([]byte("secret"))
+	payload, _ := json.Marshal(Event{Type: EventPaymentCaptured, IntentID: "pi_fake_1"})
+
+	event, err := f.VerifyWebhook(payload, f.Sign(payload))
+	if err != nil || event.IntentID != "pi_fake_1" {
+		t.Errorf("valid webhook rejected: %v", err)
+	}
+
+	if _, err := f.VerifyWebhook(payload, NewFake([]byte("other")).Sign(payload)); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New("fake", []byte("secret"))
+	if err != nil || p.Name() != "fake" {
+		t.Errorf("got %v, %v, want the fake", p, err)
+	}
+
+	if _, err := New("stripe", []byte("secret")); !errors.Is(err, ErrUnknownProvider) {
+		t.Errorf("got %v, want ErrUnknownProvider", err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package payments talks to the payment provider charging guests. Handlers
+// only see the Provider interface, so the provider can be swapped without
+// touching the booking flow.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+var (
+	// ErrDeclined is returned when the provider refuses to capture a payment.
+	ErrDeclined         = errors.New("payments: payment declined")
+	ErrUnknownIntent    = errors.New("payments: unknown payment intent")
+	ErrRefundTooLarge   = errors.New("payments: refund exceeds the captured amount")
+	ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+	ErrUnknownProvider  = errors.New("payments: unknown provider")
+)
+
+// Intent statuses
+const (
+	IntentRequiresCapture = "requires_capture"
+	IntentCaptured        = "captured"
+	IntentFailed          = "failed"
+)
+
+// Webhook event types
+const (
+	EventPaymentCaptured = "payment.captured"
+	EventPaymentFailed   = "payment.failed"
+	EventRefundSucceeded = "refund.succeeded"
+)
+
+// Intent is an amount the provider has been asked to collect.
+type Intent struct {
+	ID     string
+	Amount money.Money
+	Status string
+	// ClientSecret lets the guest's browser confirm the intent with the
+	// provider directly, it is never stored
+	ClientSecret string
+}
+
+type Refund struct {
+	ID       string
+	IntentID string
+	Amount   money.Money
+}
+
+// Event is a verified webhook notification. RefundID is only set for
+// refund events.
+type Event struct {
+	Type     string      `json:"type"`
+	IntentID string      `json:"intent_id"`
+	RefundID string      `json:"refund_id,omitempty"`
+	Amount   money.Money `json:"amount"`
+}
+
+type Provider interface {
+	// Name is stored with every payment so they can be traced back to the
+	// provider that handled them.
+	Name() string
+	// CreateIntent starts collecting amount, reference identifies the
+	// reservation on the provider's side.
+	CreateIntent(ctx context.Context, amount money.Money, reference string) (Intent, error)
+	// Capture collects the intent, ErrDeclined means the guest was not
+	// charged.
+	Capture(ctx context.Context, intentID string) (Intent, error)
+	// Refund gives back amount, at most what is left of the captured intent.
+	Refund(ctx context.Context, intentID string, amount money.Money) (Refund, error)
+	// VerifyWebhook checks the signature of a webhook body and decodes it.
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// New returns the provider called name, secret signs its webhooks. Only the
+// in-process fake exists so far.
+func New(name string, secret []byte) (Provider, error) {
+	switch name {
+	case "fake":
+		return NewFake(secret), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownProvider, name)
+}
@@ -0,0 +1,153 @@
+// Package pricing computes what a stay costs from a room's rates. All the
+// amounts of a room are in the currency of its base rate.
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+var ErrNoNights = errors.New("pricing: a stay needs at least one night")
+
+// DefaultWeekendDays are the nights charged at the weekend rate, Friday and
+// Saturday, when a room does not configure its own.
+var DefaultWeekendDays = []time.Weekday{time.Friday, time.Saturday}
+
+// Season overrides the nightly rates from Start to End, both inclusive.
+// A zero WeekendRate means weekend nights cost NightlyRate as well.
+type Season struct {
+	ID          int
+	Name        string
+	Start       time.Time
+	End         time.Time
+	NightlyRate money.Money
+	WeekendRate money.Money
+}
+
+// Discount takes Percent off the nights of stays of at least MinNights.
+type Discount struct {
+	ID        int
+	MinNights int
+	Percent   int
+}
+
+// Rates is everything that goes into the price of a room.
+type Rates struct {
+	// Base is the nightly rate outside of any season
+	Base money.Money
+	// WeekendRate replaces Base on WeekendDays, zero disables it
+	WeekendRate money.Money
+	WeekendDays []time.Weekday
+	// Stays shorter than ShortStayNights pay ShortStaySurcharge percent on
+	// top of their nights
+	ShortStayNights    int
+	ShortStaySurcharge int
+	Seasons            []Season
+	Discounts          []Discount
+}
+
+// Night is the price of a single night of a stay.
+type Night struct {
+	Date  time.Time   `json:"date"`
+	Label string      `json:"label"`
+	Rate  money.Money `json:"rate"`
+}
+
+// Quote is the priced breakdown of a stay.
+type Quote struct {
+	Nights         []Night     `json:"nights"`
+	Subtotal       money.Money `json:"subtotal"`
+	Surcharge      money.Money `json:"surcharge"`
+	SurchargeLabel string      `json:"surcharge_label,omitempty"`
+	Discount       money.Money `json:"discount"`
+	DiscountLabel  string      `json:"discount_label,omitempty"`
+	Total          money.Money `json:"total"`
+}
+
+// Quote prices the nights from arrival up to, not including, departure.
+func (r Rates) Quote(arrival, departure time.Time) (Quote, error) {
+	zero := money.New(0, r.Base.Currency)
+	q := Quote{Subtotal: zero, Surcharge: zero, Discount: zero}
+
+	for d := dateOf(arrival); d.Before(dateOf(departure)); d = d.AddDate(0, 0, 1) {
+		label, rate := r.nightlyRate(d)
+		q.Nights = append(q.Nights, Night{Date: d, Label: label, Rate: rate})
+		q.Subtotal = q.Subtotal.Add(rate)
+	}
+
+	if len(q.Nights) == 0 {
+		return Quote{}, ErrNoNights
+	}
+
+	nights := len(q.Nights)
+	if nights < r.ShortStayNights && r.ShortStaySurcharge > 0 {
+		q.Surcharge = q.Subtotal.Percent(r.ShortStaySurcharge)
+		q.SurchargeLabel = "Short stay surcharge"
+	}
+
+	if d, ok := r.bestDiscount(nights); ok {
+		q.Discount = q.Subtotal.Percent(d.Percent)
+		q.DiscountLabel = "Length of stay discount"
+	}
+
+	q.Total = q.Subtotal.Add(q.Surcharge).Sub(q.Discount)
+	return q, nil
+}
+
+// nightlyRate picks the season covering the night, the latest starting one
+// when seasons overlap, and falls back to the base and weekend rates.
+func (r Rates) nightlyRate(night time.Time) (string, money.Money) {
+	weekend := r.isWeekend(night)
+
+	var season *Season
+	for i := range r.Seasons {
+		s := &r.Seasons[i]
+		if night.Before(dateOf(s.Start)) || night.After(dateOf(s.End)) {
+			continue
+		}
+		if season == nil || s.Start.After(season.Start) {
+			season = s
+		}
+	}
+
+	switch {
+	case season != nil && weekend && !season.WeekendRate.IsZero():
+		return season.Name + " weekend", season.WeekendRate
+	case season != nil:
+		return season.Name, season.NightlyRate
+	case weekend && !r.WeekendRate.IsZero():
+		return "Weekend", r.WeekendRate
+	}
+	return "Standard", r.Base
+}
+
+func (r Rates) isWeekend(night time.Time) bool {
+	days := r.WeekendDays
+	if days == nil {
+		days = DefaultWeekendDays
+	}
+	for _, d := range days {
+		if night.Weekday() == d {
+			return true
+		}
+	}
+	return false
+}
+
+// bestDiscount is the largest discount the stay qualifies for.
+func (r Rates) bestDiscount(nights int) (Discount, bool) {
+	var best Discount
+	for _, d := range r.Discounts {
+		if nights >= d.MinNights && d.Percent > best.Percent {
+			best = d
+		}
+	}
+	return best, best.Percent > 0
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,118 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+)
+
+func day(m time.Month, d int) time.Time {
+	return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func usd(cents int64) money.Money {
+	return money.New(cents, "USD")
+}
+
+func TestQuote_BaseAndWeekend(t *testing.T) {
+	rates := Rates{Base: usd(10000), WeekendRate: usd(15000)}
+
+	// 2050-01-06 is a thursday: thursday, friday, saturday and sunday nights
+	q, err := rates.Quote(day(1, 6), day(1, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{10000, 15000, 15000, 10000}
+	if len(q.Nights) != len(want) {
+		t.Fatalf("got %d nights, want %d", len(q.Nights), len(want))
+	}
+	for i, rate := range want {
+		if q.Nights[i].Rate != usd(rate) {
+			t.Errorf("night %d: got %s, want %d", i, q.Nights[i].Rate, rate)
+		}
+	}
+	if q.Total != usd(50000) || q.Subtotal != usd(50000) {
+		t.Errorf("got total %s, want 500.00", q.Total)
+	}
+}
+
+func TestQuote_Seasons(t *testing.T) {
+	rates := Rates{
+		Base: usd(10000),
+		Seasons: []Season{
+			{Name: "Summer", Start: day(7, 1), End: day(8, 31), NightlyRate: usd(20000), WeekendRate: usd(25000)},
+			{Name: "Festival", Start: day(7, 14), End: day(7, 16), NightlyRate: usd(30000)},
+		},
+	}
+
+	q, err := rates.Quote(day(6, 30), day(7, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := map[time.Time]string{}
+	for _, n := range q.Nights {
+		labels[n.Date] = n.Label
+	}
+
+	tests := []struct {
+		night time.Time
+		label string
+	}{
+		{day(6, 30), "Standard"},
+		{day(7, 1), "Summer weekend"}, // friday
+		{day(7, 4), "Summer"},
+		{day(7, 14), "Festival"},
+		{day(7, 15), "Festival"},
+	}
+	for _, tt := range tests {
+		if labels[tt.night] != tt.label {
+			t.Errorf("%s: got %q, want %q", tt.night.Format("2006-01-02"), labels[tt.night], tt.label)
+		}
+	}
+}
+
+func TestQuote_SurchargeAndDiscount(t *testing.T) {
+	rates := Rates{
+		Base:               usd(10000),
+		WeekendDays:        []time.Weekday{},
+		ShortStayNights:    3,
+		ShortStaySurcharge: 15,
+		Discounts: []Discount{
+			{MinNights: 7, Percent: 10},
+			{MinNights: 14, Percent: 20},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		nights    int
+		surcharge int64
+		discount  int64
+		total     int64
+	}{
+		{"one night", 1, 1500, 0, 11500},
+		{"three nights", 3, 0, 0, 30000},
+		{"a week", 7, 0, 7000, 63000},
+		{"two weeks", 14, 0, 28000, 112000},
+	}
+
+	for _, tt := range tests {
+		q, err := rates.Quote(day(3, 1), day(3, 1).AddDate(0, 0, tt.nights))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Surcharge != usd(tt.surcharge) || q.Discount != usd(tt.discount) || q.Total != usd(tt.total) {
+			t.Errorf("%s: got surcharge %s, discount %s, total %s", tt.name, q.Surcharge, q.Discount, q.Total)
+		}
+	}
+}
+
+func TestQuote_NoNights(t *testing.T) {
+	if _, err := (Rates{Base: usd(100)}).Quote(day(1, 2), day(1, 2)); !errors.Is(err, ErrNoNights) {
+		t.Errorf("expected ErrNoNights, got %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package rbac
+
+import "strings"
+
+// Role is stored in users.access_level.
+type Role int
+
+const (
+	RoleGuest Role = iota + 1
+	RoleFrontDesk
+	RoleManager
+	RoleOwner
+)
+
+type Permission string
+
+const (
+	PermReadRooms          Permission = "rooms.read"
+	PermOwnReservations    Permission = "reservations.own"
+	PermViewAdmin          Permission = "admin.view"
+	PermViewReservations   Permission = "reservations.view"
+	PermEditReservations   Permission = "reservations.edit"
+	PermDeleteReservations Permission = "reservations.delete"
+	PermManageCalendar     Permission = "calendar.manage"
+	PermManagePricing      Permission = "pricing.manage"
+	PermManageRooms        Permission = "rooms.manage"
+	PermFrontDesk          Permission = "front_desk.manage"
+	PermRecordPayments     Permission = "payments.record"
+	PermRefundPayments     Permission = "payments.refund"
+	PermManageUsers        Permission = "users.manage"
+	PermManageAPITokens    Permission = "api_tokens.manage"
+)
+
+var roleNames = map[Role]string{
+	RoleGuest:     "guest",
+	RoleFrontDesk: "front-desk",
+	RoleManager:   "manager",
+	RoleOwner:     "owner",
+}
+
+// matrix lists what each role may do, higher roles repeat the permissions of
+// lower ones so the whole policy can be read in one place.
+var matrix = map[Role][]Permission{
+	RoleGuest: {
+		PermReadRooms,
+		PermOwnReservations,
+	},
+	RoleFrontDesk: {
+		PermReadRooms,
+		PermOwnReservations,
+		PermViewAdmin,
+		PermViewReservations,
+		PermEditReservations,
+		PermFrontDesk,
+		PermRecordPayments,
+	},
+	RoleManager: {
+		PermReadRooms,
+		PermOwnReservations,
+		PermViewAdmin,
+		PermViewReservations,
+		PermEditReservations,
+		PermFrontDesk,
+		PermRecordPayments,
+		PermRefundPayments,
+		PermDeleteReservations,
+		PermManageCalendar,
+		PermManagePricing,
+		PermManageRooms,
+	},
+	RoleOwner: {
+		PermReadRooms,
+		PermOwnReservations,
+		PermViewAdmin,
+		PermViewReservations,
+		PermEditReservations,
+		PermFrontDesk,
+		PermRecordPayments,
+		PermRefundPayments,
+		PermDeleteReservations,
+		PermManageCalendar,
+		PermManagePricing,
+		PermManageRooms,
+		PermManageUsers,
+		PermManageAPITokens,
+	},
+}
+
+// RoleFromAccessLevel maps users.access_level to a role, unknown levels are
+// treated as guests.
+func RoleFromAccessLevel(level int) Role {
+	r := Role(level)
+	if _, ok := matrix[r]; !ok {
+		return RoleGuest
+	}
+	return r
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return roleNames[RoleGuest]
+}
+
+func (r Role) Can(p Permission) bool {
+	for _, granted := range matrix[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Permissions returns the permissions granted to the role.
+func (r Role) Permissions() []Permission {
+	return append([]Permission{}, matrix[r]...)
+}
+
+// Can reports whether a user with the given access level holds p.
+func Can(accessLevel int, p Permission) bool {
+	return RoleFromAccessLevel(accessLevel).Can(p)
+}
+
+// ParsePermissions keeps the known permissions of a comma separated list.
+func ParsePermissions(list string) []Permission {
+	known := make(map[Permission]bool)
+	for _, p := range matrix[RoleOwner] {
+		known[p] = true
+	}
+
+	perms := []Permission{}
+	for _, p := range strings.Split(list, ",") {
+		p := Permission(strings.TrimSpace(p))
+		if known[p] {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
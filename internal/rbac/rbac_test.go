@@ -0,0 +1,62 @@
+package rbac
+
+import "testing"
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		name        string
+		accessLevel int
+		perm        Permission
+		expected    bool
+	}{
+		{"guest cannot view admin", 1, PermViewAdmin, false},
+		{"guest manages own reservations", 1, PermOwnReservations, true},
+		{"front desk views reservations", 2, PermViewReservations, true},
+		{"front desk cannot delete reservations", 2, PermDeleteReservations, false},
+		{"front desk records payments", 2, PermRecordPayments, true},
+		{"front desk checks guests in", 2, PermFrontDesk, true},
+		{"guest cannot check in", 1, PermFrontDesk, false},
+		{"front desk cannot refund", 2, PermRefundPayments, false},
+		{"manager manages calendar", 3, PermManageCalendar, true},
+		{"manager cannot manage users", 3, PermManageUsers, false},
+		{"manager manages rooms", 3, PermManageRooms, true},
+		{"front desk cannot manage rooms", 2, PermManageRooms, false},
+		{"owner manages users", 4, PermManageUsers, true},
+		{"unknown level is a guest", 99, PermViewAdmin, false},
+		{"zero level is a guest", 0, PermViewReservations, false},
+	}
+
+	for _, tt := range tests {
+		if got := Can(tt.accessLevel, tt.perm); got != tt.expected {
+			t.Errorf("%s: expected %t, got %t", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestRoleFromAccessLevel(t *testing.T) {
+	if RoleFromAccessLevel(3) != RoleManager {
+		t.Error("access level 3 should be a manager")
+	}
+
+	if RoleFromAccessLevel(-1).String() != "guest" {
+		t.Error("invalid access level should be a guest")
+	}
+}
+
+func TestHigherRolesIncludeLowerPermissions(t *testing.T) {
+	roles := []Role{RoleGuest, RoleFrontDesk, RoleManager, RoleOwner}
+	for i := 1; i < len(roles); i++ {
+		for _, p := range roles[i-1].Permissions() {
+			if !roles[i].Can(p) {
+				t.Errorf("%s should have %s like %s", roles[i], p, roles[i-1])
+			}
+		}
+	}
+}
+
+func TestParsePermissions(t *testing.T) {
+	perms := ParsePermissions("rooms.read, reservations.view,unknown,")
+	if len(perms) != 2 || perms[0] != PermReadRooms || perms[1] != PermViewReservations {
+		t.Errorf("unexpected permissions %v", perms)
+	}
+}
@@ -3,14 +3,22 @@ package render
 import (
 	"html/template"
 	"time"
+
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/rbac"
 )
 
 // Function is a map of functions that can be used in the template
 var function = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"can":         Can,
+	"formatMoney": FormatMoney,
+	"statusLabel": StatusLabel,
 }
 
 func HumanDate(t time.Time) string {
@@ -33,3 +41,19 @@ func Iterate(cnt int) []int {
 func Add(a, b int) int {
 	return a + b
 }
+
+// Can reports whether the user holds the named permission, so templates can
+// hide actions the user is not allowed to perform
+func Can(u models.User, perm string) bool {
+	return rbac.Can(u.AccessLevel, rbac.Permission(perm))
+}
+
+// FormatMoney writes an amount with its currency, such as "$1,234.50"
+func FormatMoney(m money.Money) string {
+	return m.String()
+}
+
+// StatusLabel names a reservation status, such as "Checked in"
+func StatusLabel(status string) string {
+	return lifecycle.Label(status)
+}
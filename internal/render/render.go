@@ -10,6 +10,7 @@ import (
 
 	"github.com/justinas/nosurf"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/config"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/helpers"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
 )
 
@@ -35,6 +36,9 @@ func ApplyDefaultData(td *models.TemplateData, r *http.Request) *models.Template
 	} else {
 		td.IsAuthenticated = 0
 	}
+	if user, ok := helpers.CurrentUser(r); ok {
+		td.CurrentUser = user
+	}
 	return td
 }
 
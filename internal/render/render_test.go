@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
 )
 
 func TestAddDefaultData(t *testing.T) {
@@ -75,3 +76,9 @@ func getSession() (*http.Request, error) {
 	request = request.WithContext(ctx)
 	return request, nil
 }
+
+func TestFormatMoney(t *testing.T) {
+	if got := FormatMoney(money.New(123450, "USD")); got != "$1,234.50" {
+		t.Errorf("FormatMoney = %q, want $1,234.50", got)
+	}
+}
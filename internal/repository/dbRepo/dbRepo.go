@@ -1,9 +1,15 @@
 package dbRepo
 
 import (
+	"context"
 	"database/sql"
+	"sync"
+	"time"
 
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/config"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
 )
 
 type pgRepository struct {
@@ -14,6 +20,19 @@ type pgRepository struct {
 type testDbRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// mu guards the reservations made through CreateReservation, which the
+	// guest reservation lists give back to their user, and their payments,
+	// the cancellation policies added and those set on rooms, the amenities
+	// added to the catalogue and the stay rules added to rooms
+	mu           sync.Mutex
+	made         []models.Reservation
+	payments     []models.Payment
+	policies     []cancellation.Policy
+	roomPolicies map[int]int
+	amenities    []models.Amenity
+	stayRules    map[int]stayrules.Rules
+	lastRuleId   int
 }
 
 func InitPGRepository(app *config.AppConfig, db *sql.DB) *pgRepository {
@@ -25,7 +44,23 @@ func InitPGRepository(app *config.AppConfig, db *sql.DB) *pgRepository {
 
 func InitTestingRepository(app *config.AppConfig, db *sql.DB) *testDbRepo {
 	return &testDbRepo{
-		App: app,
-		DB:  db,
+		App:          app,
+		DB:           db,
+		roomPolicies: map[int]int{1: 1},
+		stayRules:    make(map[int]stayrules.Rules),
+		lastRuleId:   2,
+	}
+}
+
+// defaultDBTimeout is used when AppConfig.DBTimeout is not set
+const defaultDBTimeout = 3 * time.Second
+
+// withTimeout bounds a query by the configured timeout, ctx still cancels it
+// earlier when the client goes away.
+func (m *pgRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.DBTimeout
+	if timeout <= 0 {
+		timeout = defaultDBTimeout
 	}
+	return context.WithTimeout(ctx, timeout)
 }
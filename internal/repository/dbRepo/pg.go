@@ -2,25 +2,50 @@ package dbRepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const (
-	ReservationTable     = "reservations"
-	RoomRestrictionTable = "room_restrictions"
-	RoomTable            = "rooms"
-	UserTable            = "users"
+	ReservationTable        = "reservations"
+	RoomRestrictionTable    = "room_restrictions"
+	RoomTable               = "rooms"
+	UserTable               = "users"
+	PasswordResetTable      = "password_resets"
+	ChangeRequestTable      = "reservation_change_requests"
+	APITokenTable           = "api_tokens"
+	ICalFeedTable           = "room_ical_feeds"
+	RoomRateSettingsTable   = "room_rate_settings"
+	SeasonalRateTable       = "seasonal_rates"
+	StayDiscountTable       = "length_of_stay_discounts"
+	PaymentTable            = "payments"
+	CancellationPolicyTable = "cancellation_policies"
+	ReservationEventTable   = "reservation_events"
+	RoomImageTable          = "room_images"
+	RoomUnitTable           = "room_units"
+	AmenityTable            = "amenities"
+	RoomAmenityTable        = "room_amenities"
+	StayRuleTable           = "stay_rules"
 )
 
 // User services
-func (m *pgRepository) AllUsers() ([]models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`select id, first_name, last_name, email, password, access_level, created_at, updated_at from %s`, UserTable)
@@ -47,13 +72,13 @@ func (m *pgRepository) AllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (m *pgRepository) GetUserById(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetUserById(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 	var user models.User
-	query := fmt.Sprintf(`select id, email, phone, first_name, last_name, password, access_level from %s where id=$1`, UserTable)
+	query := fmt.Sprintf(`select id, email, phone, first_name, last_name, password, access_level, email_verified from %s where id=$1`, UserTable)
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.Phone, &user.FirstName, &user.LastName, &user.AccessLevel)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&user.ID, &user.Email, &user.Phone, &user.FirstName, &user.LastName, &user.Password, &user.AccessLevel, &user.EmailVerified)
 
 	if err != nil {
 		log.Println("GetUserById", err)
@@ -63,8 +88,62 @@ func (m *pgRepository) GetUserById(id int) (models.User, error) {
 	return user, nil
 }
 
-func (m *pgRepository) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	var user models.User
+	query := fmt.Sprintf(`select id, email, phone, first_name, last_name, password, access_level, email_verified from %s where email=$1`, UserTable)
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.ID, &user.Email, &user.Phone, &user.FirstName, &user.LastName, &user.Password, &user.AccessLevel, &user.EmailVerified)
+
+	if err != nil {
+		log.Println("GetUserByEmail", err)
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (m *pgRepository) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("InsertUser", err)
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`insert into %s 
+		(first_name, last_name, email, phone, password, access_level) 
+		values ($1, $2, $3, $4, $5, $6) returning id`, UserTable)
+	var newId int
+	err = m.DB.QueryRowContext(ctx, query, u.FirstName, u.LastName, u.Email, u.Phone, string(hashedPassword), 1).Scan(&newId)
+	if err != nil {
+		log.Println("InsertUser", err)
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+func (m *pgRepository) VerifyUserEmail(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set email_verified=true, updated_at=$1 where id=$2`, UserTable)
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+
+	if err != nil {
+		log.Println("VerifyUserEmail", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`update %s set email=$1, phone=$2, first_name=$3, last_name=$4, password=$5, access_level=$6, updated_at=$7 where id=$8`, UserTable)
@@ -78,13 +157,14 @@ func (m *pgRepository) UpdateUser(u models.User) error {
 	return nil
 }
 
-func (m *pgRepository) Authenticate(email, testPassword string) (int, string, error) {
+func (m *pgRepository) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	var id int
 	var hashedPassword string
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := fmt.Sprintf("select id, password from %s where email=$1", UserTable)
+	// accounts from before registration lowercased emails may have capitals
+	query := fmt.Sprintf("select id, password from %s where lower(email)=lower($1)", UserTable)
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(&id, &hashedPassword)
 
 	if err != nil {
@@ -102,20 +182,107 @@ func (m *pgRepository) Authenticate(email, testPassword string) (int, string, er
 	return id, hashedPassword, nil
 }
 
+func (m *pgRepository) InsertPasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`insert into %s (user_id, token_hash, expires_at) values ($1, $2, $3)`, PasswordResetTable)
+	_, err := m.DB.ExecContext(ctx, query, userId, tokenHash, expiresAt)
+
+	if err != nil {
+		log.Println("InsertPasswordReset", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetPasswordResetByTokenHash only returns resets that have not been used yet,
+// callers still have to check the expiry.
+func (m *pgRepository) GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, user_id, token_hash, expires_at, created_at
+		from %s
+		where token_hash = $1 and used_at is null
+	`, PasswordResetTable)
+	var reset models.PasswordReset
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(&reset.ID, &reset.UserId, &reset.TokenHash, &reset.ExpiresAt, &reset.CreatedAt)
+
+	if err != nil {
+		log.Println("GetPasswordResetByTokenHash", err)
+		return reset, err
+	}
+
+	return reset, nil
+}
+
+// ResetUserPassword stores the new password and marks every outstanding reset
+// of the user as used, so a token can never be replayed.
+func (m *pgRepository) ResetUserPassword(ctx context.Context, resetId, userId int, password string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("ResetUserPassword", err)
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ResetUserPassword", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`update %s set used_at=$1 where id=$2 and user_id=$3 and used_at is null`, PasswordResetTable)
+	result, err := tx.ExecContext(ctx, query, time.Now(), resetId, userId)
+	if err != nil {
+		log.Println("ResetUserPassword", err)
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	query = fmt.Sprintf(`update %s set used_at=$1 where user_id=$2 and used_at is null`, PasswordResetTable)
+	_, err = tx.ExecContext(ctx, query, time.Now(), userId)
+	if err != nil {
+		log.Println("ResetUserPassword", err)
+		return err
+	}
+
+	query = fmt.Sprintf(`update %s set password=$1, updated_at=$2 where id=$3`, UserTable)
+	_, err = tx.ExecContext(ctx, query, string(hashedPassword), time.Now(), userId)
+	if err != nil {
+		log.Println("ResetUserPassword", err)
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // Reservation actions
-func (m *pgRepository) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// AllReservations lists the reservations in status, all of them when status
+// is empty.
+func (m *pgRepository) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`
 		select 
-			rs.id, rs.user_id, rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
-			rs.start_date, rs.end_date, rs.processed, rs.created_at, rs.updated_at, r.id, r.name, r.price
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.start_date, rs.end_date, rs.status, rs.created_at, rs.updated_at, r.id, r.name, r.price
 		from %s rs
 		left join %s r on rs.room_id = r.id
+		where $1 = '' or rs.status = $1
 	`, ReservationTable, RoomTable)
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, status)
 
 	if err != nil {
 		log.Println("AllReservations", err)
@@ -128,7 +295,7 @@ func (m *pgRepository) AllReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var res models.Reservation
-		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Processed, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, &res.Room.Price)
+		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Status, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price))
 		if err != nil {
 			log.Println("AllReservations", err)
 			return []models.Reservation{}, err
@@ -140,20 +307,20 @@ func (m *pgRepository) AllReservations() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-func (m *pgRepository) AllNewReservations() ([]models.Reservation, error) {
-	cxt, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`
 		select 
-			rs.id, rs.user_id, rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
 			rs.start_date, rs.end_date, rs.created_at, rs.updated_at, r.id, r.name, r.price
 		from %s rs
 		left join %s r on rs.room_id = r.id
-		where rs.processed = false and rs.start_date > $1 and rs.end_date > $2
+		where rs.status in ($3, $4) and rs.start_date > $1 and rs.end_date > $2
 	`, ReservationTable, RoomTable)
 
-	rows, err := m.DB.QueryContext(cxt, query, time.Now(), time.Now())
+	rows, err := m.DB.QueryContext(ctx, query, time.Now(), time.Now(), models.ReservationPendingPayment, models.ReservationConfirmed)
 
 	if err != nil || rows.Err() != nil {
 		log.Println("AllReservations", err)
@@ -165,7 +332,7 @@ func (m *pgRepository) AllNewReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var res models.Reservation
-		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, &res.Room.Price)
+		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price))
 		if err != nil {
 			log.Println("AllReservations", err)
 			return []models.Reservation{}, err
@@ -178,290 +345,3138 @@ func (m *pgRepository) AllNewReservations() ([]models.Reservation, error) {
 
 }
 
-func (m *pgRepository) GetReservationById(id int) (models.Reservation, error) {
-	cxt, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`
 		select 
-			rs.id, rs.user_id, rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
-			rs.start_date, rs.end_date, rs.processed, rs.created_at, rs.updated_at, r.id, r.name, r.price
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.start_date, rs.end_date, rs.status, rs.total_price, rs.currency, rs.price_breakdown, rs.deposit_amount, rs.balance_due_date,
+			rs.cancelled_at, coalesce(rs.cancelled_by, 0), rs.cancellation_reason, rs.cancellation_penalty,
+			rs.checked_in_at, rs.checked_out_at, rs.guest_id_document, rs.front_desk_notes, rs.adults, rs.children, rs.created_at, rs.updated_at, r.id, r.name, r.price,
+			coalesce(rs.room_unit_id, 0), coalesce(u.name, ''), rs.payment_due_at
 		from %s rs
 		left join %s r on rs.room_id = r.id
+		left join %s u on rs.room_unit_id = u.id
 		where rs.id = $1
-	`, ReservationTable, RoomTable)
+	`, ReservationTable, RoomTable, RoomUnitTable)
 	var res models.Reservation
-	err := m.DB.QueryRowContext(cxt, query, id).Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Processed, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, &res.Room.Price)
+	var total int64
+	var currency string
+	var breakdown []byte
+	var deposit, penalty int64
+	var balanceDue, cancelledAt, checkedInAt, checkedOutAt, paymentDue sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Status, &total, &currency, &breakdown, &deposit, &balanceDue, &cancelledAt, &res.CancelledBy, &res.CancellationReason, &penalty, &checkedInAt, &checkedOutAt, &res.GuestIdDocument, &res.FrontDeskNotes, &res.Adults, &res.Children, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price), &res.RoomUnitId, &res.Unit.Name, &paymentDue)
 
 	if err != nil {
 		log.Println("GetReservationById", err)
 		return res, err
 	}
+	res.Unit.ID = res.RoomUnitId
+	res.Unit.RoomId = res.RoomId
+	res.Quote = decodeQuote(total, currency, breakdown)
+	res.Deposit = money.New(deposit, currency)
+	res.BalanceDue = balanceDue.Time
+	res.CancelledAt = cancelledAt.Time
+	res.CancellationPenalty = money.New(penalty, currency)
+	res.CheckedInAt = checkedInAt.Time
+	res.CheckedOutAt = checkedOutAt.Time
+	res.PaymentDueAt = paymentDue.Time
 
 	return res, nil
 }
 
-func (m *pgRepository) UpdateReservation(u models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetReservationsByUserId(ctx context.Context, userId int) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := fmt.Sprintf(`update %s set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5 where id=$6`, ReservationTable)
-	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.Phone, time.Now(), u.ID)
+	query := fmt.Sprintf(`
+		select 
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.start_date, rs.end_date, rs.status, rs.created_at, rs.updated_at, r.id, r.name, r.price, r.slug
+		from %s rs
+		left join %s r on rs.room_id = r.id
+		where rs.user_id = $1
+		order by rs.start_date desc
+	`, ReservationTable, RoomTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, userId)
 
 	if err != nil {
-		log.Println("UpdateReservation", err)
-		return err
+		log.Println("GetReservationsByUserId", err)
+		return []models.Reservation{}, err
 	}
 
-	return nil
-}
-
-func (m *pgRepository) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	reservations := []models.Reservation{}
+	defer rows.Close()
 
-	query := fmt.Sprintf(`delete from %s where id=$1`, ReservationTable)
-	_, err := m.DB.ExecContext(ctx, query, id)
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Status, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price), &res.Room.Slug)
+		if err != nil {
+			log.Println("GetReservationsByUserId", err)
+			return []models.Reservation{}, err
+		}
 
-	if err != nil {
-		log.Println("DeleteReservation", err)
-		return err
+		reservations = append(reservations, res)
 	}
 
-	return nil
+	return reservations, nil
 }
 
-func (m *pgRepository) ProcessReservation(id int, processed bool) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetReservationsByUserIdPage(ctx context.Context, userId, limit, offset int) ([]models.Reservation, int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := fmt.Sprintf(`update %s set processed=$1 where id=$2`, ReservationTable)
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	query := fmt.Sprintf(`
+		select 
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.start_date, rs.end_date, rs.status, rs.created_at, rs.updated_at, r.id, r.name, r.price, r.slug,
+			count(*) over()
+		from %s rs
+		left join %s r on rs.room_id = r.id
+		where rs.user_id = $1
+		order by rs.start_date desc
+		limit $2 offset $3
+	`, ReservationTable, RoomTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, userId, limit, offset)
 
 	if err != nil {
-		log.Println("ProcessReservation", err)
-		return err
+		log.Println("GetReservationsByUserIdPage", err)
+		return []models.Reservation{}, 0, err
 	}
 
-	return nil
-}
+	var total int
+	reservations := []models.Reservation{}
+	defer rows.Close()
 
-func (m *pgRepository) InsertReservation(res *models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	sql := fmt.Sprintf(`insert into %s 
-		(user_id, room_id, email, first_name, last_name, phone, start_date, end_date) 
-		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`, ReservationTable)
-	var newId int
-	err := m.DB.QueryRowContext(ctx, sql, res.UserId, res.RoomId, res.Email, res.FirstName, res.LastName, res.Phone, res.StartDate, res.EndDate).Scan(&newId)
-	if err != nil {
-		log.Println("InsertReservation", err)
-		log.Println(err)
-		return 0, err
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Status, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price), &res.Room.Slug, &total)
+		if err != nil {
+			log.Println("GetReservationsByUserIdPage", err)
+			return []models.Reservation{}, 0, err
+		}
+
+		reservations = append(reservations, res)
 	}
 
-	log.Println("New reservation id: ", newId)
+	if len(reservations) == 0 && offset > 0 {
+		query = fmt.Sprintf(`select count(id) from %s where user_id = $1`, ReservationTable)
+		err = m.DB.QueryRowContext(ctx, query, userId).Scan(&total)
+		if err != nil {
+			log.Println("GetReservationsByUserIdPage", err)
+			return reservations, 0, err
+		}
+	}
 
-	return newId, nil
+	return reservations, total, nil
 }
 
-func (m *pgRepository) InsertRoomRestriction(res *models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetUserReservationById returns sql.ErrNoRows when the reservation belongs to
+// somebody else, so guests cannot tell other users' reservations exist.
+func (m *pgRepository) GetUserReservationById(ctx context.Context, id, userId int) (models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	sql := fmt.Sprintf(`insert into %s 
-		(room_id, restriction_id, reservation_id, start_date, end_date) 
-		values ($1, $2, $3, $4, $5)`, RoomRestrictionTable)
-	_, err := m.DB.ExecContext(ctx, sql, res.RoomId, res.RestrictionId, res.ReservationId, res.StartDate, res.EndDate)
+
+	query := fmt.Sprintf(`
+		select 
+			rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, 
+			rs.start_date, rs.end_date, rs.status, rs.total_price, rs.currency, rs.price_breakdown, rs.deposit_amount, rs.balance_due_date,
+			rs.cancelled_at, coalesce(rs.cancelled_by, 0), rs.cancellation_reason, rs.cancellation_penalty, rs.created_at, rs.updated_at, r.id, r.name, r.price, r.slug
+		from %s rs
+		left join %s r on rs.room_id = r.id
+		where rs.id = $1 and rs.user_id = $2
+	`, ReservationTable, RoomTable)
+	var res models.Reservation
+	var total int64
+	var currency string
+	var breakdown []byte
+	var deposit, penalty int64
+	var balanceDue, cancelledAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id, userId).Scan(&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.Status, &total, &currency, &breakdown, &deposit, &balanceDue, &cancelledAt, &res.CancelledBy, &res.CancellationReason, &penalty, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price), &res.Room.Slug)
+
 	if err != nil {
-		log.Println("InsertRoomRestriction", err)
-		return err
+		log.Println("GetUserReservationById", err)
+		return res, err
 	}
-	return nil
+	res.Quote = decodeQuote(total, currency, breakdown)
+	res.Deposit = money.New(deposit, currency)
+	res.BalanceDue = balanceDue.Time
+	res.CancelledAt = cancelledAt.Time
+	res.CancellationPenalty = money.New(penalty, currency)
+
+	return res, nil
 }
 
-func (m *pgRepository) CheckIfRoomAvailableByDate(roomId int, start, end time.Time) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) InsertReservationChangeRequest(ctx context.Context, req models.ReservationChangeRequest) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := fmt.Sprintf(`
-		select count(id) 
-		from %s
-			where room_id = $1 and $2 < end_date and $3 > start_date
-	`, RoomRestrictionTable)
-	var numRows int
+	query := fmt.Sprintf(`insert into %s 
+		(reservation_id, user_id, start_date, end_date, note) 
+		values ($1, $2, $3, $4, $5)`, ChangeRequestTable)
+	_, err := m.DB.ExecContext(ctx, query, req.ReservationId, req.UserId, req.StartDate, req.EndDate, req.Note)
 
-	err := m.DB.QueryRowContext(ctx, query, roomId, start, end).Scan(&numRows)
-	log.Println("numRows: ", numRows)
 	if err != nil {
-		log.Println("CheckIfRoomAvailableByDate", err)
-		log.Println(err)
-		return false, err
-	}
-
-	if numRows == 0 {
-		return true, nil
+		log.Println("InsertReservationChangeRequest", err)
+		return err
 	}
 
-	return false, nil
+	return nil
 }
 
-func (m *pgRepository) SearchAvailabilityInRange(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) GetReservationChangeRequests(ctx context.Context, reservationId int) ([]models.ReservationChangeRequest, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	query := fmt.Sprintf(`
-		select id, name from %s 
-			where id not in (select room_id from %s where $1 < end_date and $2 > start_date)
-	`, RoomTable, RoomRestrictionTable)
-
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+		select id, reservation_id, user_id, start_date, end_date, note, created_at, updated_at
+		from %s
+		where reservation_id = $1
+		order by created_at desc
+	`, ChangeRequestTable)
 
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
 	if err != nil {
-		log.Println("SearchAvailabilityInRange", err)
-		log.Println(err)
+		log.Println("GetReservationChangeRequests", err)
 		return nil, err
 	}
 
-	var rooms []models.Room
+	requests := []models.ReservationChangeRequest{}
 	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(&room.ID, &room.Name)
+		var req models.ReservationChangeRequest
+		err := rows.Scan(&req.ID, &req.ReservationId, &req.UserId, &req.StartDate, &req.EndDate, &req.Note, &req.CreatedAt, &req.UpdatedAt)
 		if err != nil {
-			log.Println("SearchAvailabilityInRange", err)
-			log.Println(err)
+			log.Println("GetReservationChangeRequests", err)
 			return nil, err
 		}
-		rooms = append(rooms, room)
+		requests = append(requests, req)
 	}
 
-	return rooms, nil
+	return requests, nil
 }
 
-func (m *pgRepository) GetRoomById(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *pgRepository) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := fmt.Sprintf(`select id, name, description, slug, price, created_at, updated_at from %s where id = $1`, RoomTable)
-	var room models.Room
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&room.ID, &room.Name, &room.Description, &room.Slug, &room.Price, &room.CreatedAt, &room.UpdatedAt)
+	query := fmt.Sprintf(`update %s set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5 where id=$6`, ReservationTable)
+	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.Phone, time.Now(), u.ID)
 
 	if err != nil {
-		log.Println("GetRoomById", err)
-		return room, err
+		log.Println("UpdateReservation", err)
+		return err
 	}
 
-	return room, nil
+	return nil
 }
 
-func (m *pgRepository) GetRoomBySlug(slug string) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// MoveReservation moves the reservation to the room and dates of res, with
+// the price, deposit and balance due worked out for them. The nights are
+// checked again against every restriction of the room but the reservation's
+// own, and its room restriction is moved along in the same transaction. The
+// guests keep their unit when it is free for the new nights. Reservations
+// still pending payment cannot be moved.
+func (m *pgRepository) MoveReservation(ctx context.Context, res models.Reservation, userId int, note string) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	query := fmt.Sprintf(`select id, name, description, slug, price, created_at, updated_at from %s where slug = $1`, RoomTable)
-	var room models.Room
-	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&room.ID, &room.Name, &room.Description, &room.Slug, &room.Price, &room.CreatedAt, &room.UpdatedAt)
 
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println("GetRoomBySlug", err)
-		log.Println(err)
-		return room, err
+		log.Println("MoveReservation", err)
+		return err
 	}
+	defer tx.Rollback()
 
-	return room, nil
-}
-
-func (m *pgRepository) GetRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	query := fmt.Sprintf(`select id, name, description, slug, price, created_at, updated_at from %s`, RoomTable)
-
-	rooms := []models.Room{}
-	rows, err := m.DB.QueryContext(ctx, query)
-
+	status, err := lockReservationStatus(ctx, tx, res.ID)
 	if err != nil {
-		log.Println("GetRooms", err)
-		return []models.Room{}, err
+		log.Println("MoveReservation", err)
+		return err
 	}
-
-	defer rows.Close()
-	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.Slug, &room.Price, &room.CreatedAt, &room.UpdatedAt)
-		if err != nil {
-			return []models.Room{}, err
-		}
-		rooms = append(rooms, room)
+	// reservations that reached the end of their lifecycle keep their stay,
+	// and the payment intent of a pending one was made for the old deposit
+	if len(lifecycle.Next(status)) == 0 || status == models.ReservationPendingPayment {
+		return fmt.Errorf("%w: %s reservations cannot be moved", lifecycle.ErrTransition, status)
 	}
 
-	return rooms, nil
-}
-
-func (m *pgRepository) GetRoomRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	query := fmt.Sprintf(`
-		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-		from %s
-		where $1 < end_date and $2 >= start_date and room_id = $3
-	`, RoomRestrictionTable)
-
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomId)
+	var restrictionId int
+	query := fmt.Sprintf(`select id from %s where reservation_id = $1`, RoomRestrictionTable)
+	err = tx.QueryRowContext(ctx, query, res.ID).Scan(&restrictionId)
+	if err != nil {
+		log.Println("MoveReservation", err)
+		return err
+	}
 
+	res.RoomUnitId, err = allocateUnit(ctx, tx, res.RoomId, res.StartDate, res.EndDate, restrictionId, res.RoomUnitId)
 	if err != nil {
-		log.Println("GetRoomRestrictionsForRoomByDate", err)
-		return nil, err
+		log.Println("MoveReservation", err)
+		return err
 	}
 
-	var restrictions []models.RoomRestriction
-	defer rows.Close()
-	for rows.Next() {
-		var r models.RoomRestriction
-		err := rows.Scan(&r.ID, &r.ReservationId, &r.RestrictionId, &r.RoomId, &r.StartDate, &r.EndDate)
+	err = checkRoomFits(ctx, tx, res.RoomId, res.Guests())
+	if err != nil {
+		log.Println("MoveReservation", err)
+		return err
+	}
 
+	var breakdown []byte
+	if len(res.Quote.Nights) > 0 {
+		breakdown, err = json.Marshal(res.Quote)
 		if err != nil {
-			log.Println("GetRoomRestrictionsForRoomByDate", err)
-			return nil, err
+			log.Println("MoveReservation", err)
+			return err
 		}
-
-		restrictions = append(restrictions, r)
+	}
+	var balanceDue sql.NullTime
+	if !res.BalanceDue.IsZero() {
+		balanceDue = sql.NullTime{Time: res.BalanceDue, Valid: true}
 	}
 
-	return restrictions, nil
-}
+	now := time.Now()
+	query = fmt.Sprintf(`
+		update %s
+		set room_id = $1, start_date = $2, end_date = $3, total_price = $4, currency = $5, price_breakdown = $6,
+			deposit_amount = $7, balance_due_date = $8, room_unit_id = $9, updated_at = $10
+		where id = $11
+	`, ReservationTable)
+	_, err = tx.ExecContext(ctx, query,
+		res.RoomId, res.StartDate, res.EndDate, res.Quote.Total.Amount, res.Quote.Total.Currency, breakdown,
+		res.Deposit.Amount, balanceDue, res.RoomUnitId, now, res.ID,
+	)
+	if err != nil {
+		log.Println("MoveReservation", err)
+		return err
+	}
 
-func (m *pgRepository) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	query := fmt.Sprintf(`
-		insert into %s (room_id, restriction_id, start_date, end_date) values ($1, $2, $3, $4)
-	`, RoomRestrictionTable)
+	query = fmt.Sprintf(`update %s set room_id = $1, room_unit_id = $2, start_date = $3, end_date = $4, updated_at = $5 where id = $6`, RoomRestrictionTable)
+	_, err = tx.ExecContext(ctx, query, res.RoomId, res.RoomUnitId, res.StartDate, res.EndDate, now, restrictionId)
+	if err != nil {
+		log.Println("MoveReservation", err)
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, id, 2, startDate, startDate.AddDate(0, 0, 1))
+	err = insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationId: res.ID,
+		FromStatus:    status,
+		ToStatus:      status,
+		UserId:        userId,
+		Note:          note,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		log.Println("MoveReservation", err)
+		return err
+	}
 
+	err = tx.Commit()
 	if err != nil {
-		log.Println("InsertBlockForRoom", err)
+		log.Println("MoveReservation", err)
 		return err
 	}
 
 	return nil
 }
 
-func (m *pgRepository) RemoveBlockById(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// CancelReservation marks the reservation cancelled and gives its dates
+// back. The row is kept so its history and payments stay available.
+func (m *pgRepository) CancelReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
-	query := fmt.Sprintf(`
-		delete from %s where id = $1
-	`, RoomRestrictionTable)
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("CancelReservation", err)
+		return err
+	}
+	defer tx.Rollback()
 
+	from, err := lockReservationStatus(ctx, tx, res.ID)
 	if err != nil {
-		log.Println("RemoveBlockForRoom", err)
+		log.Println("CancelReservation", err)
+		return err
+	}
+	if err := lifecycle.Check(from, models.ReservationCancelled); err != nil {
 		return err
 	}
 
-	return nil
+	query := fmt.Sprintf(`
+		update %s
+		set status = $1, cancelled_at = $2, cancelled_by = nullif($3, 0), cancellation_reason = $4, cancellation_penalty = $5, updated_at = $2
+		where id = $6
+	`, ReservationTable)
+	_, err = tx.ExecContext(ctx, query,
+		models.ReservationCancelled, res.CancelledAt, res.CancelledBy, res.CancellationReason, res.CancellationPenalty.Amount, res.ID,
+	)
+	if err != nil {
+		log.Println("CancelReservation", err)
+		return err
+	}
+
+	err = releaseReservationDates(ctx, tx, res.ID)
+	if err != nil {
+		log.Println("CancelReservation", err)
+		return err
+	}
+
+	err = insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationId: res.ID,
+		FromStatus:    from,
+		ToStatus:      models.ReservationCancelled,
+		UserId:        res.CancelledBy,
+		Note:          res.CancellationReason,
+		CreatedAt:     res.CancelledAt,
+	})
+	if err != nil {
+		log.Println("CancelReservation", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("CancelReservation", err)
+		return err
+	}
+
+	return nil
+}
+
+// TransitionReservation moves the reservation to status when its lifecycle
+// allows it and records who did it. Reservations that end up cancelled or
+// no-show give their dates back.
+func (m *pgRepository) TransitionReservation(ctx context.Context, id int, to string, userId int, note string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("TransitionReservation", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transition(ctx, tx, id, to, userId, note, time.Now())
+	if err != nil {
+		log.Println("TransitionReservation", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("TransitionReservation", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) GetOverdueReservations(ctx context.Context, now time.Time) ([]int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id from %s
+		where status = $1 and payment_due_at <= $2
+		order by payment_due_at
+	`, ReservationTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationPendingPayment, now)
+	if err != nil {
+		log.Println("GetOverdueReservations", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Println("GetOverdueReservations", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (m *pgRepository) ExpireReservation(ctx context.Context, id int, now time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ExpireReservation", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// the payment may have been captured since the reservation was listed,
+	// the lock makes the capture wait or the check below see it
+	query := fmt.Sprintf(`
+		select id from %s
+		where id = $1 and status = $2 and payment_due_at <= $3
+		for update
+	`, ReservationTable)
+	err = tx.QueryRowContext(ctx, query, id, models.ReservationPendingPayment, now).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return lifecycle.ErrTransition
+	}
+	if err != nil {
+		log.Println("ExpireReservation", err)
+		return err
+	}
+
+	err = transition(ctx, tx, id, models.ReservationCancelled, 0, "Payment not received in time", now)
+	if err != nil {
+		log.Println("ExpireReservation", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("ExpireReservation", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) GetReservationEvents(ctx context.Context, reservationId int) ([]models.ReservationEvent, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select e.id, e.reservation_id, e.from_status, e.to_status, coalesce(e.user_id, 0), e.note, e.created_at,
+			coalesce(u.first_name, ''), coalesce(u.last_name, '')
+		from %s e
+		left join %s u on e.user_id = u.id
+		where e.reservation_id = $1
+		order by e.created_at, e.id
+	`, ReservationEventTable, UserTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		log.Println("GetReservationEvents", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.ReservationEvent
+	for rows.Next() {
+		var e models.ReservationEvent
+		err := rows.Scan(&e.ID, &e.ReservationId, &e.FromStatus, &e.ToStatus, &e.UserId, &e.Note, &e.CreatedAt, &e.User.FirstName, &e.User.LastName)
+		if err != nil {
+			log.Println("GetReservationEvents", err)
+			return nil, err
+		}
+		e.User.ID = e.UserId
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// lockReservationStatus reads the status of a reservation and holds its row
+// until tx ends, so two changes of status cannot both pass the lifecycle.
+func lockReservationStatus(ctx context.Context, tx *sql.Tx, id int) (string, error) {
+	var status string
+	query := fmt.Sprintf(`select status from %s where id = $1 for update`, ReservationTable)
+	err := tx.QueryRowContext(ctx, query, id).Scan(&status)
+	return status, err
+}
+
+// transition moves the reservation to status within tx, see
+// TransitionReservation.
+func transition(ctx context.Context, tx *sql.Tx, id int, to string, userId int, note string, at time.Time) error {
+	from, err := lockReservationStatus(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := lifecycle.Check(from, to); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`update %s set status = $1, updated_at = $2 where id = $3`, ReservationTable)
+	_, err = tx.ExecContext(ctx, query, to, at, id)
+	if err != nil {
+		return err
+	}
+
+	if lifecycle.Releases(to) {
+		err = releaseReservationDates(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationId: id,
+		FromStatus:    from,
+		ToStatus:      to,
+		UserId:        userId,
+		Note:          note,
+		CreatedAt:     at,
+	})
+}
+
+func releaseReservationDates(ctx context.Context, tx *sql.Tx, id int) error {
+	query := fmt.Sprintf(`delete from %s where reservation_id = $1`, RoomRestrictionTable)
+	_, err := tx.ExecContext(ctx, query, id)
+	return err
+}
+
+func insertReservationEvent(ctx context.Context, tx *sql.Tx, e models.ReservationEvent) error {
+	query := fmt.Sprintf(`
+		insert into %s (reservation_id, from_status, to_status, user_id, note, created_at)
+		values ($1, $2, $3, nullif($4, 0), $5, $6)
+	`, ReservationEventTable)
+	_, err := tx.ExecContext(ctx, query, e.ReservationId, e.FromStatus, e.ToStatus, e.UserId, e.Note, e.CreatedAt)
+	return err
+}
+
+// roomLockNamespace is the first key of the advisory locks taken on rooms,
+// the second key is the room id.
+const roomLockNamespace = 1
+
+// lockRoom serialises writers of a room's restrictions until tx ends.
+func lockRoom(ctx context.Context, tx *sql.Tx, roomId int) error {
+	_, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1, $2)`, roomLockNamespace, roomId)
+	return err
+}
+
+func (m *pgRepository) CreateReservation(ctx context.Context, res *models.Reservation) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Availability has to be checked again once we hold the lock, the guest
+	// may have searched minutes ago.
+	res.RoomUnitId, err = allocateUnit(ctx, tx, res.RoomId, res.StartDate, res.EndDate, 0, 0)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		return 0, err
+	}
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	err = checkRoomFits(ctx, tx, res.RoomId, res.Guests())
+	if errors.Is(err, repository.ErrRoomTooSmall) {
+		return 0, err
+	}
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	// so are the stay rules, which may have changed since as well
+	rules, err := queryStayRules(ctx, tx, res.RoomId)
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+	err = rules[res.RoomId].Check(res.StartDate, res.EndDate, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	currency := res.Quote.Total.Currency
+	if currency == "" {
+		currency = m.currency()
+	}
+	status := res.Status
+	if status == "" {
+		status = models.ReservationConfirmed
+	}
+
+	var breakdown []byte
+	if len(res.Quote.Nights) > 0 {
+		breakdown, err = json.Marshal(res.Quote)
+		if err != nil {
+			log.Println("CreateReservation", err)
+			return 0, err
+		}
+	}
+
+	query := fmt.Sprintf(`insert into %s 
+		(user_id, room_id, email, first_name, last_name, phone, start_date, end_date, total_price, currency, price_breakdown, status, deposit_amount, balance_due_date, adults, children, room_unit_id, payment_due_at) 
+		values (nullif($1, 0), $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) returning id`, ReservationTable)
+	var balanceDue, paymentDue sql.NullTime
+	if !res.BalanceDue.IsZero() {
+		balanceDue = sql.NullTime{Time: res.BalanceDue, Valid: true}
+	}
+	if !res.PaymentDueAt.IsZero() {
+		paymentDue = sql.NullTime{Time: res.PaymentDueAt, Valid: true}
+	}
+	var newId int
+	err = tx.QueryRowContext(ctx, query, res.UserId, res.RoomId, res.Email, res.FirstName, res.LastName, res.Phone, res.StartDate, res.EndDate, res.Quote.Total.Amount, currency, breakdown, status, res.Deposit.Amount, balanceDue, res.Adults, res.Children, res.RoomUnitId, paymentDue).Scan(&newId)
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	query = fmt.Sprintf(`insert into %s 
+		(room_id, room_unit_id, restriction_id, reservation_id, start_date, end_date) 
+		values ($1, $2, $3, $4, $5, $6)`, RoomRestrictionTable)
+	_, err = tx.ExecContext(ctx, query, res.RoomId, res.RoomUnitId, models.RestrictionReservation, newId, res.StartDate, res.EndDate)
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	err = insertReservationEvent(ctx, tx, models.ReservationEvent{
+		ReservationId: newId,
+		ToStatus:      status,
+		UserId:        res.UserId,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("CreateReservation", err)
+		return 0, err
+	}
+
+	log.Println("New reservation id: ", newId)
+
+	return newId, nil
+}
+
+// Front desk
+// GetArrivals lists the confirmed reservations starting on day, and those
+// already checked in, so pending, no-show and cancelled ones are left out.
+func (m *pgRepository) GetArrivals(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select %s
+		from %s rs
+		left join %s r on rs.room_id = r.id
+		left join %s u on rs.room_unit_id = u.id
+		where rs.start_date = $1 and rs.status in ($2, $3)
+		order by r.name, u.sort_order, rs.last_name
+	`, frontDeskColumns, ReservationTable, RoomTable, RoomUnitTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, day, models.ReservationConfirmed, models.ReservationCheckedIn)
+	if err != nil {
+		log.Println("GetArrivals", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reservations []models.Reservation
+	for rows.Next() {
+		res, err := m.scanFrontDeskReservation(rows)
+		if err != nil {
+			log.Println("GetArrivals", err)
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	return reservations, rows.Err()
+}
+
+// GetDepartures lists the guests leaving on day, along with those still
+// checked in after their departure date, and when their unit is next taken.
+func (m *pgRepository) GetDepartures(ctx context.Context, day time.Time) ([]models.Departure, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select %s,
+			(select min(rr.start_date) from %s rr
+			where rr.room_id = rs.room_id and rr.start_date >= rs.end_date and rr.reservation_id is distinct from rs.id
+				and (rr.room_unit_id is null or rs.room_unit_id is null or rr.room_unit_id = rs.room_unit_id))
+		from %s rs
+		left join %s r on rs.room_id = r.id
+		left join %s u on rs.room_unit_id = u.id
+		where (rs.status = $2 and rs.end_date <= $1)
+			or (rs.status = $3 and (rs.end_date = $1 or rs.checked_out_at::date = $1))
+		order by r.name, u.sort_order, rs.last_name
+	`, frontDeskColumns, RoomRestrictionTable, ReservationTable, RoomTable, RoomUnitTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, day, models.ReservationCheckedIn, models.ReservationCheckedOut)
+	if err != nil {
+		log.Println("GetDepartures", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var departures []models.Departure
+	for rows.Next() {
+		var d models.Departure
+		var nextArrival sql.NullTime
+		d.Reservation, err = m.scanFrontDeskReservation(rows, &nextArrival)
+		if err != nil {
+			log.Println("GetDepartures", err)
+			return nil, err
+		}
+		d.NextArrival = nextArrival.Time
+		departures = append(departures, d)
+	}
+
+	return departures, rows.Err()
+}
+
+// CheckInReservation moves the reservation to checked in with the time the
+// guest arrived and the identity document they showed.
+func (m *pgRepository) CheckInReservation(ctx context.Context, id, userId int, arrivedAt time.Time, idDocument, notes string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("CheckInReservation", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transition(ctx, tx, id, models.ReservationCheckedIn, userId, notes, time.Now())
+	if err != nil {
+		log.Println("CheckInReservation", err)
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		update %s
+		set checked_in_at = $1, guest_id_document = $2,
+			front_desk_notes = case when $3 = '' then front_desk_notes when front_desk_notes = '' then $3 else front_desk_notes || E'\n' || $3 end
+		where id = $4
+	`, ReservationTable)
+	_, err = tx.ExecContext(ctx, query, arrivedAt, idDocument, notes, id)
+	if err != nil {
+		log.Println("CheckInReservation", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("CheckInReservation", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) CheckOutReservation(ctx context.Context, id, userId int, leftAt time.Time, notes string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("CheckOutReservation", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transition(ctx, tx, id, models.ReservationCheckedOut, userId, notes, time.Now())
+	if err != nil {
+		log.Println("CheckOutReservation", err)
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		update %s
+		set checked_out_at = $1,
+			front_desk_notes = case when $2 = '' then front_desk_notes when front_desk_notes = '' then $2 else front_desk_notes || E'\n' || $2 end
+		where id = $3
+	`, ReservationTable)
+	_, err = tx.ExecContext(ctx, query, leftAt, notes, id)
+	if err != nil {
+		log.Println("CheckOutReservation", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("CheckOutReservation", err)
+		return err
+	}
+
+	return nil
+}
+
+// frontDeskColumns are the reservation columns read by
+// scanFrontDeskReservation.
+const frontDeskColumns = `
+	rs.id, coalesce(rs.user_id, 0), rs.room_id, rs.email, rs.first_name, rs.last_name, rs.phone, rs.start_date, rs.end_date,
+	rs.status, rs.total_price, rs.currency, rs.checked_in_at, rs.checked_out_at, rs.guest_id_document, rs.front_desk_notes,
+	rs.adults, rs.children, r.id, r.name, r.price, coalesce(rs.room_unit_id, 0), coalesce(u.name, '')`
+
+func (m *pgRepository) scanFrontDeskReservation(row rowScanner, extra ...interface{}) (models.Reservation, error) {
+	var res models.Reservation
+	var total int64
+	var currency string
+	var checkedInAt, checkedOutAt sql.NullTime
+	dest := []interface{}{&res.ID, &res.UserId, &res.RoomId, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate,
+		&res.Status, &total, &currency, &checkedInAt, &checkedOutAt, &res.GuestIdDocument, &res.FrontDeskNotes,
+		&res.Adults, &res.Children, &res.Room.ID, &res.Room.Name, m.price(&res.Room.Price), &res.RoomUnitId, &res.Unit.Name}
+	err := row.Scan(append(dest, extra...)...)
+	res.Unit.ID = res.RoomUnitId
+	res.Unit.RoomId = res.RoomId
+	res.Quote.Total = money.New(total, currency)
+	res.CheckedInAt = checkedInAt.Time
+	res.CheckedOutAt = checkedOutAt.Time
+	return res, err
+}
+
+// CheckIfRoomAvailableByDate reports whether at least one unit of the room
+// is free between start and end.
+func (m *pgRepository) CheckIfRoomAvailableByDate(ctx context.Context, roomId int, start, end time.Time) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select count(u.id) 
+		from %s u
+		join %s r on r.id = u.room_id
+			where u.room_id = $1 and r.archived_at is null and %s
+	`, RoomUnitTable, RoomTable, unitFree(2))
+	var freeUnits int
+
+	err := m.DB.QueryRowContext(ctx, query, roomId, start, end, 0).Scan(&freeUnits)
+	if err != nil {
+		log.Println("CheckIfRoomAvailableByDate", err)
+		return false, err
+	}
+
+	return freeUnits > 0, nil
+}
+
+// SearchAvailabilityInRange lists the rooms with a unit free between start
+// and end that sleep the party and have all of the amenities, with how many
+// of their units are free.
+func (m *pgRepository) SearchAvailabilityInRange(ctx context.Context, start, end time.Time, guests models.Guests, amenityIds []int) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select r.id, r.name, r.max_adults, r.max_children, r.beds, count(u.id)
+		from %s r
+		join %s u on u.room_id = r.id
+		where r.archived_at is null and r.max_adults >= $4 and r.max_adults + r.max_children >= $4 + $5 and %s
+			and (select count(*) from %s ra where ra.room_id = r.id and ra.amenity_id = any($6::int[]))
+				= coalesce(cardinality($6::int[]), 0)
+		group by r.id
+		order by r.sort_order, r.id
+	`, RoomTable, RoomUnitTable, unitFree(1), RoomAmenityTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, 0, guests.Adults, guests.Children, amenityIds)
+
+	if err != nil {
+		log.Println("SearchAvailabilityInRange", err)
+		log.Println(err)
+		return nil, err
+	}
+
+	var rooms []models.Room
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(&room.ID, &room.Name, &room.MaxAdults, &room.MaxChildren, &room.Beds, &room.FreeUnits)
+		if err != nil {
+			log.Println("SearchAvailabilityInRange", err)
+			log.Println(err)
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("SearchAvailabilityInRange", err)
+		return nil, err
+	}
+
+	rules, err := queryStayRules(ctx, m.DB, 0)
+	if err != nil {
+		log.Println("SearchAvailabilityInRange", err)
+		return nil, err
+	}
+
+	allowed := rooms[:0]
+	now := time.Now()
+	for _, room := range rooms {
+		if rules[room.ID].Check(start, end, now) == nil {
+			allowed = append(allowed, room)
+		}
+	}
+
+	return allowed, nil
+}
+
+func (m *pgRepository) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`select id, name, description, slug, price, sort_order, max_adults, max_children, beds, archived_at, created_at, updated_at from %s where id = $1`, RoomTable)
+	var room models.Room
+	var archivedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&room.ID, &room.Name, &room.Description, &room.Slug, m.price(&room.Price), &room.SortOrder, &room.MaxAdults, &room.MaxChildren, &room.Beds, &archivedAt, &room.CreatedAt, &room.UpdatedAt)
+	room.ArchivedAt = archivedAt.Time
+
+	if err != nil {
+		log.Println("GetRoomById", err)
+		return room, err
+	}
+
+	return room, nil
+}
+
+func (m *pgRepository) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	query := fmt.Sprintf(`select id, name, description, slug, price, sort_order, max_adults, max_children, beds, created_at, updated_at from %s where slug = $1 and archived_at is null`, RoomTable)
+	var room models.Room
+	err := m.DB.QueryRowContext(ctx, query, slug).Scan(&room.ID, &room.Name, &room.Description, &room.Slug, m.price(&room.Price), &room.SortOrder, &room.MaxAdults, &room.MaxChildren, &room.Beds, &room.CreatedAt, &room.UpdatedAt)
+
+	if err != nil {
+		log.Println("GetRoomBySlug", err)
+		log.Println(err)
+		return room, err
+	}
+
+	return room, nil
+}
+
+func (m *pgRepository) GetRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, name, description, slug, price, coalesce(cancellation_policy_id, 0), sort_order, max_adults, max_children, beds, created_at, updated_at
+		from %s
+		where archived_at is null
+		order by sort_order, id
+	`, RoomTable)
+
+	rooms := []models.Room{}
+	rows, err := m.DB.QueryContext(ctx, query)
+
+	if err != nil {
+		log.Println("GetRooms", err)
+		return []models.Room{}, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.Slug, m.price(&room.Price), &room.CancellationPolicyId, &room.SortOrder, &room.MaxAdults, &room.MaxChildren, &room.Beds, &room.CreatedAt, &room.UpdatedAt)
+		if err != nil {
+			return []models.Room{}, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
+
+func (m *pgRepository) GetRoomsPage(ctx context.Context, limit, offset int) ([]models.Room, int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, name, description, slug, price, sort_order, max_adults, max_children, beds, created_at, updated_at, count(*) over()
+		from %s
+		where archived_at is null
+		order by sort_order, id
+		limit $1 offset $2
+	`, RoomTable)
+
+	rooms := []models.Room{}
+	rows, err := m.DB.QueryContext(ctx, query, limit, offset)
+
+	if err != nil {
+		log.Println("GetRoomsPage", err)
+		return rooms, 0, err
+	}
+
+	var total int
+	defer rows.Close()
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.Slug, m.price(&room.Price), &room.SortOrder, &room.MaxAdults, &room.MaxChildren, &room.Beds, &room.CreatedAt, &room.UpdatedAt, &total)
+		if err != nil {
+			log.Println("GetRoomsPage", err)
+			return []models.Room{}, 0, err
+		}
+		rooms = append(rooms, room)
+	}
+
+	if len(rooms) == 0 && offset > 0 {
+		// count(*) over() has nothing to report past the last page
+		err = m.DB.QueryRowContext(ctx, fmt.Sprintf(`select count(id) from %s where archived_at is null`, RoomTable)).Scan(&total)
+		if err != nil {
+			log.Println("GetRoomsPage", err)
+			return rooms, 0, err
+		}
+	}
+
+	return rooms, total, nil
+}
+
+// Room management
+// AllRooms lists every room, archived ones included, in their sort order.
+func (m *pgRepository) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select r.id, r.name, r.description, r.slug, r.price, coalesce(r.cancellation_policy_id, 0), r.sort_order, r.max_adults, r.max_children, r.beds, r.archived_at, r.created_at, r.updated_at,
+			(select count(*) from %s u where u.room_id = r.id)
+		from %s r
+		order by r.sort_order, r.id
+	`, RoomUnitTable, RoomTable)
+
+	rooms := []models.Room{}
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("AllRooms", err)
+		return rooms, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var room models.Room
+		var archivedAt sql.NullTime
+		err := rows.Scan(&room.ID, &room.Name, &room.Description, &room.Slug, m.price(&room.Price), &room.CancellationPolicyId, &room.SortOrder, &room.MaxAdults, &room.MaxChildren, &room.Beds, &archivedAt, &room.CreatedAt, &room.UpdatedAt, &room.UnitCount)
+		if err != nil {
+			log.Println("AllRooms", err)
+			return []models.Room{}, err
+		}
+		room.ArchivedAt = archivedAt.Time
+		rooms = append(rooms, room)
+	}
+
+	return rooms, nil
+}
+
+// InsertRoom adds the room at the end of the sort order, with a first unit
+// named "1".
+func (m *pgRepository) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("InsertRoom", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = checkSlugFree(ctx, tx, room.Slug, 0)
+	if err != nil {
+		log.Println("InsertRoom", err)
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+		insert into %s (name, slug, description, price, max_adults, max_children, beds, sort_order)
+		values ($1, $2, $3, $4, $5, $6, $7, (select coalesce(max(sort_order), 0) + 1 from %s))
+		returning id
+	`, RoomTable, RoomTable)
+
+	var id int
+	err = tx.QueryRowContext(ctx, query, room.Name, room.Slug, room.Description, room.Price.Decimal(), room.MaxAdults, room.MaxChildren, room.Beds).Scan(&id)
+	if err != nil {
+		log.Println("InsertRoom", err)
+		return 0, err
+	}
+
+	query = fmt.Sprintf(`insert into %s (room_id, name, sort_order) values ($1, '1', 1)`, RoomUnitTable)
+	_, err = tx.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println("InsertRoom", err)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("InsertRoom", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) UpdateRoom(ctx context.Context, room models.Room) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("UpdateRoom", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkSlugFree(ctx, tx, room.Slug, room.ID)
+	if err != nil {
+		log.Println("UpdateRoom", err)
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		update %s
+		set name = $1, slug = $2, description = $3, price = $4, max_adults = $5, max_children = $6, beds = $7, updated_at = $8
+		where id = $9
+	`, RoomTable)
+	_, err = tx.ExecContext(ctx, query, room.Name, room.Slug, room.Description, room.Price.Decimal(), room.MaxAdults, room.MaxChildren, room.Beds, time.Now(), room.ID)
+	if err != nil {
+		log.Println("UpdateRoom", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("UpdateRoom", err)
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomArchived archives or restores a room. Its reservations are left as
+// they are.
+func (m *pgRepository) SetRoomArchived(ctx context.Context, id int, archived bool) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var archivedAt sql.NullTime
+	if archived {
+		archivedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	query := fmt.Sprintf(`update %s set archived_at = $1, updated_at = $2 where id = $3`, RoomTable)
+	_, err := m.DB.ExecContext(ctx, query, archivedAt, time.Now(), id)
+	if err != nil {
+		log.Println("SetRoomArchived", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReorderRooms sorts the rooms in the order of ids, rooms left out keep
+// their position.
+func (m *pgRepository) ReorderRooms(ctx context.Context, ids []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ReorderRooms", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`update %s set sort_order = $1 where id = $2`, RoomTable)
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, query, i+1, id)
+		if err != nil {
+			log.Println("ReorderRooms", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("ReorderRooms", err)
+		return err
+	}
+
+	return nil
+}
+
+// checkSlugFree returns ErrSlugTaken if a room other than exceptId, archived
+// or not, uses slug.
+func checkSlugFree(ctx context.Context, tx *sql.Tx, slug string, exceptId int) error {
+	query := fmt.Sprintf(`select count(id) from %s where slug = $1 and id <> $2`, RoomTable)
+
+	var numRows int
+	if err := tx.QueryRowContext(ctx, query, slug, exceptId).Scan(&numRows); err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrSlugTaken
+	}
+	return nil
+}
+
+// Room images
+// GetRoomImages lists the photos of a room in their sort order.
+func (m *pgRepository) GetRoomImages(ctx context.Context, roomId int) ([]models.RoomImage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, room_id, web_key, thumb_key, alt, width, height, sort_order, is_cover, created_at, updated_at
+		from %s
+		where room_id = $1
+		order by sort_order, id
+	`, RoomImageTable)
+
+	images := []models.RoomImage{}
+	rows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("GetRoomImages", err)
+		return images, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var img models.RoomImage
+		err := rows.Scan(&img.ID, &img.RoomId, &img.WebKey, &img.ThumbKey, &img.Alt, &img.Width, &img.Height, &img.SortOrder, &img.IsCover, &img.CreatedAt, &img.UpdatedAt)
+		if err != nil {
+			log.Println("GetRoomImages", err)
+			return []models.RoomImage{}, err
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+func (m *pgRepository) GetRoomImageById(ctx context.Context, id int) (models.RoomImage, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, room_id, web_key, thumb_key, alt, width, height, sort_order, is_cover, created_at, updated_at
+		from %s
+		where id = $1
+	`, RoomImageTable)
+
+	var img models.RoomImage
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&img.ID, &img.RoomId, &img.WebKey, &img.ThumbKey, &img.Alt, &img.Width, &img.Height, &img.SortOrder, &img.IsCover, &img.CreatedAt, &img.UpdatedAt)
+	if err != nil {
+		log.Println("GetRoomImageById", err)
+		return img, err
+	}
+
+	return img, nil
+}
+
+// InsertRoomImage adds the photo at the end of its room's gallery, the first
+// photo of a room becomes its cover.
+func (m *pgRepository) InsertRoomImage(ctx context.Context, img models.RoomImage) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, web_key, thumb_key, alt, width, height, sort_order, is_cover)
+		values ($1, $2, $3, $4, $5, $6,
+			(select coalesce(max(sort_order), 0) + 1 from %s where room_id = $1),
+			not exists (select 1 from %s where room_id = $1))
+		returning id
+	`, RoomImageTable, RoomImageTable, RoomImageTable)
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, img.RoomId, img.WebKey, img.ThumbKey, img.Alt, img.Width, img.Height).Scan(&id)
+	if err != nil {
+		log.Println("InsertRoomImage", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteRoomImage removes the photo, when it was the cover the first photo
+// left in the gallery takes its place.
+func (m *pgRepository) DeleteRoomImage(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("DeleteRoomImage", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomId int
+	var wasCover bool
+	query := fmt.Sprintf(`delete from %s where id = $1 returning room_id, is_cover`, RoomImageTable)
+	err = tx.QueryRowContext(ctx, query, id).Scan(&roomId, &wasCover)
+	if err != nil {
+		log.Println("DeleteRoomImage", err)
+		return err
+	}
+
+	if wasCover {
+		query = fmt.Sprintf(`
+			update %s set is_cover = true, updated_at = $1
+			where id = (select id from %s where room_id = $2 order by sort_order, id limit 1)
+		`, RoomImageTable, RoomImageTable)
+		_, err = tx.ExecContext(ctx, query, time.Now(), roomId)
+		if err != nil {
+			log.Println("DeleteRoomImage", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("DeleteRoomImage", err)
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomCoverImage makes the photo the cover of the room, in place of the
+// current one.
+func (m *pgRepository) SetRoomCoverImage(ctx context.Context, roomId, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("SetRoomCoverImage", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`update %s set is_cover = false, updated_at = $1 where room_id = $2 and is_cover`, RoomImageTable)
+	_, err = tx.ExecContext(ctx, query, time.Now(), roomId)
+	if err != nil {
+		log.Println("SetRoomCoverImage", err)
+		return err
+	}
+
+	query = fmt.Sprintf(`update %s set is_cover = true, updated_at = $1 where room_id = $2 and id = $3`, RoomImageTable)
+	result, err := tx.ExecContext(ctx, query, time.Now(), roomId, id)
+	if err != nil {
+		log.Println("SetRoomCoverImage", err)
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		log.Println("SetRoomCoverImage", err)
+		return err
+	}
+	if n == 0 {
+		// the photo belongs to another room
+		return sql.ErrNoRows
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("SetRoomCoverImage", err)
+		return err
+	}
+
+	return nil
+}
+
+// ReorderRoomImages sorts the photos of a room in the order of ids, photos
+// of other rooms are left alone.
+func (m *pgRepository) ReorderRoomImages(ctx context.Context, roomId int, ids []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("ReorderRoomImages", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`update %s set sort_order = $1 where id = $2 and room_id = $3`, RoomImageTable)
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, query, i+1, id, roomId)
+		if err != nil {
+			log.Println("ReorderRoomImages", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("ReorderRoomImages", err)
+		return err
+	}
+
+	return nil
+}
+
+// Room units
+// GetRoomUnits lists the units of a room in their sort order.
+func (m *pgRepository) GetRoomUnits(ctx context.Context, roomId int) ([]models.RoomUnit, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, room_id, name, sort_order, created_at, updated_at
+		from %s
+		where room_id = $1
+		order by sort_order, id
+	`, RoomUnitTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("GetRoomUnits", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var units []models.RoomUnit
+	for rows.Next() {
+		var u models.RoomUnit
+		err := rows.Scan(&u.ID, &u.RoomId, &u.Name, &u.SortOrder, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			log.Println("GetRoomUnits", err)
+			return nil, err
+		}
+		units = append(units, u)
+	}
+
+	return units, rows.Err()
+}
+
+// InsertRoomUnit adds the unit after the other units of its room.
+func (m *pgRepository) InsertRoomUnit(ctx context.Context, unit models.RoomUnit) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("InsertRoomUnit", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, unit.RoomId)
+	if err != nil {
+		log.Println("InsertRoomUnit", err)
+		return 0, err
+	}
+
+	var numRows int
+	query := fmt.Sprintf(`select count(id) from %s where room_id = $1 and name = $2`, RoomUnitTable)
+	err = tx.QueryRowContext(ctx, query, unit.RoomId, unit.Name).Scan(&numRows)
+	if err != nil {
+		log.Println("InsertRoomUnit", err)
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrUnitNameTaken
+	}
+
+	query = fmt.Sprintf(`
+		insert into %s (room_id, name, sort_order)
+		values ($1, $2, (select coalesce(max(sort_order), 0) + 1 from %s where room_id = $1))
+		returning id
+	`, RoomUnitTable, RoomUnitTable)
+
+	var id int
+	err = tx.QueryRowContext(ctx, query, unit.RoomId, unit.Name).Scan(&id)
+	if err != nil {
+		log.Println("InsertRoomUnit", err)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("InsertRoomUnit", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteRoomUnit removes a unit nothing refers to, a room keeps at least
+// one unit.
+func (m *pgRepository) DeleteRoomUnit(ctx context.Context, roomId, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("DeleteRoomUnit", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoom(ctx, tx, roomId)
+	if err != nil {
+		log.Println("DeleteRoomUnit", err)
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		select
+			(select count(id) from %s where room_id = $1),
+			(select count(id) from %s where room_unit_id = $2),
+			(select count(id) from %s where room_unit_id = $2)
+	`, RoomUnitTable, RoomRestrictionTable, ReservationTable)
+
+	var units, restrictions, reservations int
+	err = tx.QueryRowContext(ctx, query, roomId, id).Scan(&units, &restrictions, &reservations)
+	if err != nil {
+		log.Println("DeleteRoomUnit", err)
+		return err
+	}
+	if units <= 1 || restrictions > 0 || reservations > 0 {
+		return repository.ErrUnitInUse
+	}
+
+	query = fmt.Sprintf(`delete from %s where id = $1 and room_id = $2`, RoomUnitTable)
+	result, err := tx.ExecContext(ctx, query, id, roomId)
+	if err != nil {
+		log.Println("DeleteRoomUnit", err)
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("DeleteRoomUnit", err)
+		return err
+	}
+
+	return nil
+}
+
+// Amenities
+// AllAmenities lists the amenity catalogue by name.
+func (m *pgRepository) AllAmenities(ctx context.Context) ([]models.Amenity, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`select id, name, slug, created_at, updated_at from %s order by name`, AmenityTable)
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("AllAmenities", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amenities []models.Amenity
+	for rows.Next() {
+		var a models.Amenity
+		if err := rows.Scan(&a.ID, &a.Name, &a.Slug, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			log.Println("AllAmenities", err)
+			return nil, err
+		}
+		amenities = append(amenities, a)
+	}
+
+	return amenities, rows.Err()
+}
+
+func (m *pgRepository) InsertAmenity(ctx context.Context, a models.Amenity) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var numRows int
+	query := fmt.Sprintf(`select count(id) from %s where lower(name) = lower($1) or slug = $2`, AmenityTable)
+	err := m.DB.QueryRowContext(ctx, query, a.Name, a.Slug).Scan(&numRows)
+	if err != nil {
+		log.Println("InsertAmenity", err)
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrAmenityTaken
+	}
+
+	query = fmt.Sprintf(`insert into %s (name, slug, created_at, updated_at) values ($1, $2, $3, $4) returning id`, AmenityTable)
+
+	var id int
+	err = m.DB.QueryRowContext(ctx, query, a.Name, a.Slug, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		log.Println("InsertAmenity", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) DeleteAmenity(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1`, AmenityTable)
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println("DeleteAmenity", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetRoomAmenities lists the amenities of a room by name.
+func (m *pgRepository) GetRoomAmenities(ctx context.Context, roomId int) ([]models.Amenity, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select a.id, a.name, a.slug, a.created_at, a.updated_at
+		from %s a
+		join %s ra on ra.amenity_id = a.id
+		where ra.room_id = $1
+		order by a.name
+	`, AmenityTable, RoomAmenityTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("GetRoomAmenities", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amenities []models.Amenity
+	for rows.Next() {
+		var a models.Amenity
+		if err := rows.Scan(&a.ID, &a.Name, &a.Slug, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			log.Println("GetRoomAmenities", err)
+			return nil, err
+		}
+		amenities = append(amenities, a)
+	}
+
+	return amenities, rows.Err()
+}
+
+func (m *pgRepository) SetRoomAmenities(ctx context.Context, roomId int, ids []int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("SetRoomAmenities", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`delete from %s where room_id = $1`, RoomAmenityTable)
+	_, err = tx.ExecContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("SetRoomAmenities", err)
+		return err
+	}
+
+	query = fmt.Sprintf(`insert into %s (room_id, amenity_id) values ($1, $2) on conflict do nothing`, RoomAmenityTable)
+	for _, id := range ids {
+		_, err = tx.ExecContext(ctx, query, roomId, id)
+		if err != nil {
+			log.Println("SetRoomAmenities", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("SetRoomAmenities", err)
+		return err
+	}
+
+	return nil
+}
+
+// Stay rules
+func (m *pgRepository) GetRoomStayRules(ctx context.Context, roomId int) (stayrules.Rules, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rules, err := queryStayRules(ctx, m.DB, roomId)
+	if err != nil {
+		log.Println("GetRoomStayRules", err)
+		return nil, err
+	}
+
+	return rules[roomId], nil
+}
+
+func (m *pgRepository) AllStayRules(ctx context.Context) (map[int]stayrules.Rules, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rules, err := queryStayRules(ctx, m.DB, 0)
+	if err != nil {
+		log.Println("AllStayRules", err)
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (m *pgRepository) InsertStayRule(ctx context.Context, roomId int, rule stayrules.Rule) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival, closed_to_departure,
+			min_lead_days, max_lead_days, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning id
+	`, StayRuleTable)
+
+	var start, end sql.NullTime
+	if !rule.Start.IsZero() {
+		start = sql.NullTime{Time: rule.Start, Valid: true}
+	}
+	if !rule.End.IsZero() {
+		end = sql.NullTime{Time: rule.End, Valid: true}
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query,
+		roomId, start, end, rule.MinNights, rule.MaxNights, joinWeekdays(rule.ClosedToArrival), joinWeekdays(rule.ClosedToDeparture),
+		rule.MinLeadDays, rule.MaxLeadDays, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		log.Println("InsertStayRule", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) DeleteStayRule(ctx context.Context, roomId, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1 and room_id = $2`, StayRuleTable)
+	_, err := m.DB.ExecContext(ctx, query, id, roomId)
+	if err != nil {
+		log.Println("DeleteStayRule", err)
+		return err
+	}
+
+	return nil
+}
+
+// querier is the part of sql.DB and sql.Tx reads go through.
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryStayRules reads the stay rules of roomId, or of every room when it is
+// zero, by room id.
+func queryStayRules(ctx context.Context, q querier, roomId int) (map[int]stayrules.Rules, error) {
+	query := fmt.Sprintf(`
+		select id, room_id, start_date, end_date, min_nights, max_nights, closed_to_arrival, closed_to_departure,
+			min_lead_days, max_lead_days
+		from %s
+		where $1 = 0 or room_id = $1
+		order by room_id, start_date nulls first, id
+	`, StayRuleTable)
+
+	rows, err := q.QueryContext(ctx, query, roomId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[int]stayrules.Rules)
+	for rows.Next() {
+		var rule stayrules.Rule
+		var id int
+		var start, end sql.NullTime
+		var closedToArrival, closedToDeparture string
+		err := rows.Scan(&rule.ID, &id, &start, &end, &rule.MinNights, &rule.MaxNights, &closedToArrival, &closedToDeparture,
+			&rule.MinLeadDays, &rule.MaxLeadDays)
+		if err != nil {
+			return nil, err
+		}
+		rule.Start, rule.End = start.Time, end.Time
+		rule.ClosedToArrival = parseWeekdays(closedToArrival)
+		rule.ClosedToDeparture = parseWeekdays(closedToDeparture)
+		rules[id] = append(rules[id], rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (m *pgRepository) GetRoomRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, coalesce(reservation_id, 0), restriction_id, room_id, coalesce(room_unit_id, 0), coalesce(feed_id, 0), reason, note, start_date, end_date, created_at, updated_at
+		from %s
+		where $1 < end_date and $2 >= start_date and room_id = $3
+		order by start_date
+	`, RoomRestrictionTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomId)
+
+	if err != nil {
+		log.Println("GetRoomRestrictionsForRoomByDate", err)
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.ReservationId, &r.RestrictionId, &r.RoomId, &r.RoomUnitId, &r.FeedId, &r.Reason, &r.Note, &r.StartDate, &r.EndDate, &r.CreatedAt, &r.UpdatedAt)
+
+		if err != nil {
+			log.Println("GetRoomRestrictionsForRoomByDate", err)
+			return nil, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	return restrictions, nil
+}
+
+func (m *pgRepository) GetBlockById(ctx context.Context, id int) (models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select rr.id, rr.room_id, coalesce(rr.room_unit_id, 0), rr.restriction_id, rr.reason, rr.note, rr.start_date, rr.end_date, rr.created_at, rr.updated_at, r.id, r.name
+		from %s rr
+		left join %s r on rr.room_id = r.id
+		where rr.id = $1 and rr.restriction_id = $2
+	`, RoomRestrictionTable, RoomTable)
+
+	var block models.RoomRestriction
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&block.ID, &block.RoomId, &block.RoomUnitId, &block.RestrictionId, &block.Reason, &block.Note, &block.StartDate, &block.EndDate,
+		&block.CreatedAt, &block.UpdatedAt, &block.Room.ID, &block.Room.Name,
+	)
+	if err != nil {
+		log.Println("GetBlockById", err)
+		return block, err
+	}
+
+	return block, nil
+}
+
+func (m *pgRepository) InsertBlock(ctx context.Context, block models.RoomRestriction) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("InsertBlock", err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = checkRoomFree(ctx, tx, block.RoomId, block.RoomUnitId, block.StartDate, block.EndDate, 0)
+	if err != nil {
+		log.Println("InsertBlock", err)
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, room_unit_id, restriction_id, reason, note, start_date, end_date, created_at, updated_at)
+		values ($1, nullif($2, 0), $3, $4, $5, $6, $7, $8, $9) returning id
+	`, RoomRestrictionTable)
+
+	var id int
+	err = tx.QueryRowContext(ctx, query,
+		block.RoomId, block.RoomUnitId, models.RestrictionOwnerBlock, block.Reason, block.Note, block.StartDate, block.EndDate, time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		log.Println("InsertBlock", err)
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("InsertBlock", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) UpdateBlock(ctx context.Context, block models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("UpdateBlock", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldRoomId int
+	query := fmt.Sprintf(`select room_id from %s where id = $1 and restriction_id = $2 and reservation_id is null`, RoomRestrictionTable)
+	err = tx.QueryRowContext(ctx, query, block.ID, models.RestrictionOwnerBlock).Scan(&oldRoomId)
+	if err != nil {
+		log.Println("UpdateBlock", err)
+		return err
+	}
+
+	// a block moving rooms holds both, always locked lowest id first so two
+	// moves between the same rooms cannot wait on each other
+	for _, roomId := range []int{min(oldRoomId, block.RoomId), max(oldRoomId, block.RoomId)} {
+		if err = lockRoom(ctx, tx, roomId); err != nil {
+			log.Println("UpdateBlock", err)
+			return err
+		}
+	}
+
+	err = checkRoomFree(ctx, tx, block.RoomId, block.RoomUnitId, block.StartDate, block.EndDate, block.ID)
+	if err != nil {
+		log.Println("UpdateBlock", err)
+		return err
+	}
+
+	query = fmt.Sprintf(`
+		update %s set room_id = $1, room_unit_id = nullif($2, 0), reason = $3, note = $4, start_date = $5, end_date = $6, updated_at = $7
+		where id = $8 and restriction_id = $9
+	`, RoomRestrictionTable)
+
+	_, err = tx.ExecContext(ctx, query,
+		block.RoomId, block.RoomUnitId, block.Reason, block.Note, block.StartDate, block.EndDate, time.Now(), block.ID, models.RestrictionOwnerBlock,
+	)
+	if err != nil {
+		log.Println("UpdateBlock", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("UpdateBlock", err)
+		return err
+	}
+
+	return nil
+}
+
+// checkRoomOpen locks the room for the rest of tx and returns
+// ErrRoomUnavailable if the room is archived.
+func checkRoomOpen(ctx context.Context, tx *sql.Tx, roomId int) error {
+	if err := lockRoom(ctx, tx, roomId); err != nil {
+		return err
+	}
+
+	var archived bool
+	query := fmt.Sprintf(`select archived_at is not null from %s where id = $1`, RoomTable)
+	if err := tx.QueryRowContext(ctx, query, roomId).Scan(&archived); err != nil {
+		return err
+	}
+	if archived {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// checkRoomFree locks the room for the rest of tx and returns
+// ErrRoomUnavailable if the room is archived or a restriction other than
+// exceptId overlaps the nights from start until end, on the unit or, for a
+// unitId of zero, on any unit of the room.
+func checkRoomFree(ctx context.Context, tx *sql.Tx, roomId, unitId int, start, end time.Time, exceptId int) error {
+	if err := checkRoomOpen(ctx, tx, roomId); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		select count(id)
+		from %s
+		where room_id = $1 and $2 < end_date and $3 > start_date and id <> $4
+			and ($5 = 0 or room_unit_id = $5 or room_unit_id is null)
+	`, RoomRestrictionTable)
+
+	var numRows int
+	if err := tx.QueryRowContext(ctx, query, roomId, start, end, exceptId, unitId).Scan(&numRows); err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// allocateUnit locks the room for the rest of tx and returns a unit free
+// from start until end, ignoring restriction exceptId. The prefer unit is
+// chosen when it is free, otherwise the first free unit in sort order. It
+// returns ErrRoomUnavailable if the room is archived or fully taken.
+func allocateUnit(ctx context.Context, tx *sql.Tx, roomId int, start, end time.Time, exceptId, prefer int) (int, error) {
+	if err := checkRoomOpen(ctx, tx, roomId); err != nil {
+		return 0, err
+	}
+
+	unitId, err := freeUnit(ctx, tx, roomId, start, end, exceptId, prefer)
+	if err != nil {
+		return 0, err
+	}
+	if unitId == 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+	return unitId, nil
+}
+
+// unitFree is the condition for unit u to have no restriction overlapping
+// the nights between the start and end parameters other than the excepted
+// restriction, which are numbered from param on.
+func unitFree(param int) string {
+	return fmt.Sprintf(`not exists (
+		select 1 from %s rr
+		where rr.room_id = u.room_id and (rr.room_unit_id = u.id or rr.room_unit_id is null)
+			and $%d < rr.end_date and $%d > rr.start_date and rr.id <> $%d)`,
+		RoomRestrictionTable, param, param+1, param+2)
+}
+
+// freeUnit returns the unit allocateUnit would, or zero when every unit is
+// taken. The caller holds the room lock.
+func freeUnit(ctx context.Context, tx *sql.Tx, roomId int, start, end time.Time, exceptId, prefer int) (int, error) {
+	query := fmt.Sprintf(`
+		select u.id
+		from %s u
+		where u.room_id = $1 and %s
+		order by u.id = $5 desc, u.sort_order, u.id
+		limit 1
+	`, RoomUnitTable, unitFree(2))
+
+	var unitId int
+	err := tx.QueryRowContext(ctx, query, roomId, start, end, exceptId, prefer).Scan(&unitId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return unitId, err
+}
+
+// checkRoomFits returns ErrRoomTooSmall if the room does not sleep the party.
+func checkRoomFits(ctx context.Context, tx *sql.Tx, roomId int, guests models.Guests) error {
+	var room models.Room
+	query := fmt.Sprintf(`select max_adults, max_children from %s where id = $1`, RoomTable)
+	if err := tx.QueryRowContext(ctx, query, roomId).Scan(&room.MaxAdults, &room.MaxChildren); err != nil {
+		return err
+	}
+	if !room.Sleeps(guests) {
+		return repository.ErrRoomTooSmall
+	}
+	return nil
+}
+
+// RemoveBlockById removes an owner block. Ids of reservation restrictions or
+// imported blocks return sql.ErrNoRows and are left in place.
+func (m *pgRepository) RemoveBlockById(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	query := fmt.Sprintf(`
+		delete from %s where id = $1 and restriction_id = $2 and reservation_id is null
+	`, RoomRestrictionTable)
+
+	result, err := m.DB.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		log.Println("RemoveBlockById", err)
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// API token services
+func (m *pgRepository) InsertAPIToken(ctx context.Context, token models.APIToken) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (user_id, name, token_hash, scopes, expires_at, created_by, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id
+	`, APITokenTable)
+
+	var expiresAt sql.NullTime
+	if !token.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: token.ExpiresAt, Valid: true}
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query,
+		token.UserId, token.Name, token.TokenHash, token.Scopes, expiresAt, token.CreatedBy, time.Now(), time.Now(),
+	).Scan(&id)
+
+	if err != nil {
+		log.Println("InsertAPIToken", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAPITokenByHash only returns tokens that have not been revoked, expiry is
+// left to the caller.
+func (m *pgRepository) GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, user_id, name, token_hash, scopes, last_used_at, expires_at, created_at, updated_at
+		from %s
+		where token_hash = $1 and revoked_at is null
+	`, APITokenTable)
+
+	var token models.APIToken
+	var lastUsedAt, expiresAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID, &token.UserId, &token.Name, &token.TokenHash, &token.Scopes,
+		&lastUsedAt, &expiresAt, &token.CreatedAt, &token.UpdatedAt,
+	)
+
+	if err != nil {
+		log.Println("GetAPITokenByHash", err)
+		return token, err
+	}
+	token.LastUsedAt = lastUsedAt.Time
+	token.ExpiresAt = expiresAt.Time
+
+	return token, nil
+}
+
+func (m *pgRepository) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select
+			t.id, t.user_id, t.name, t.scopes, t.last_used_at, t.expires_at, t.revoked_at, t.created_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level
+		from %s t
+		left join %s u on t.user_id = u.id
+		order by t.created_at desc
+	`, APITokenTable, UserTable)
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("AllAPITokens", err)
+		return []models.APIToken{}, err
+	}
+	defer rows.Close()
+
+	apiTokens := []models.APIToken{}
+	for rows.Next() {
+		var token models.APIToken
+		var lastUsedAt, expiresAt, revokedAt sql.NullTime
+		err := rows.Scan(
+			&token.ID, &token.UserId, &token.Name, &token.Scopes, &lastUsedAt, &expiresAt, &revokedAt, &token.CreatedAt,
+			&token.User.ID, &token.User.FirstName, &token.User.LastName, &token.User.Email, &token.User.AccessLevel,
+		)
+		if err != nil {
+			log.Println("AllAPITokens", err)
+			return []models.APIToken{}, err
+		}
+		token.LastUsedAt = lastUsedAt.Time
+		token.ExpiresAt = expiresAt.Time
+		token.RevokedAt = revokedAt.Time
+
+		apiTokens = append(apiTokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("AllAPITokens", err)
+		return []models.APIToken{}, err
+	}
+
+	return apiTokens, nil
+}
+
+func (m *pgRepository) RevokeAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`, APITokenTable)
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+
+	if err != nil {
+		log.Println("RevokeAPIToken", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) TouchAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set last_used_at = $1 where id = $2`, APITokenTable)
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+
+	if err != nil {
+		log.Println("TouchAPIToken", err)
+		return err
+	}
+
+	return nil
+}
+
+// External calendar services
+func (m *pgRepository) AllRoomICalFeeds(ctx context.Context) ([]models.RoomICalFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select f.id, f.room_id, f.name, f.url, f.last_synced_at, f.last_error, f.created_at, f.updated_at, r.id, r.name, r.slug
+		from %s f
+		left join %s r on f.room_id = r.id
+		order by r.name, f.name
+	`, ICalFeedTable, RoomTable)
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("AllRoomICalFeeds", err)
+		return []models.RoomICalFeed{}, err
+	}
+	defer rows.Close()
+
+	feeds := []models.RoomICalFeed{}
+	for rows.Next() {
+		var feed models.RoomICalFeed
+		var syncedAt sql.NullTime
+		err := rows.Scan(
+			&feed.ID, &feed.RoomId, &feed.Name, &feed.URL, &syncedAt, &feed.LastError, &feed.CreatedAt, &feed.UpdatedAt,
+			&feed.Room.ID, &feed.Room.Name, &feed.Room.Slug,
+		)
+		if err != nil {
+			log.Println("AllRoomICalFeeds", err)
+			return []models.RoomICalFeed{}, err
+		}
+		feed.LastSyncedAt = syncedAt.Time
+		feeds = append(feeds, feed)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("AllRoomICalFeeds", err)
+		return []models.RoomICalFeed{}, err
+	}
+
+	return feeds, nil
+}
+
+func (m *pgRepository) GetRoomICalFeedById(ctx context.Context, id int) (models.RoomICalFeed, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		from %s
+		where id = $1
+	`, ICalFeedTable)
+
+	var feed models.RoomICalFeed
+	var syncedAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&feed.ID, &feed.RoomId, &feed.Name, &feed.URL, &syncedAt, &feed.LastError, &feed.CreatedAt, &feed.UpdatedAt,
+	)
+	if err != nil {
+		log.Println("GetRoomICalFeedById", err)
+		return feed, err
+	}
+	feed.LastSyncedAt = syncedAt.Time
+
+	return feed, nil
+}
+
+func (m *pgRepository) InsertRoomICalFeed(ctx context.Context, feed models.RoomICalFeed) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, name, url, created_at, updated_at)
+		values ($1, $2, $3, $4, $5) returning id
+	`, ICalFeedTable)
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, feed.RoomId, feed.Name, feed.URL, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		log.Println("InsertRoomICalFeed", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteRoomICalFeed also removes the blocks imported from the feed.
+func (m *pgRepository) DeleteRoomICalFeed(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1`, ICalFeedTable)
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println("DeleteRoomICalFeed", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) UpdateRoomICalFeedStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4`, ICalFeedTable)
+	_, err := m.DB.ExecContext(ctx, query, syncedAt, lastError, time.Now(), id)
+	if err != nil {
+		log.Println("UpdateRoomICalFeedStatus", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) GetExternalBlocksByFeed(ctx context.Context, feedId int) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, room_id, restriction_id, feed_id, external_uid, start_date, end_date
+		from %s
+		where feed_id = $1
+	`, RoomRestrictionTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, feedId)
+	if err != nil {
+		log.Println("GetExternalBlocksByFeed", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []models.RoomRestriction
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.RoomId, &r.RestrictionId, &r.FeedId, &r.ExternalUID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			log.Println("GetExternalBlocksByFeed", err)
+			return nil, err
+		}
+		blocks = append(blocks, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("GetExternalBlocksByFeed", err)
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+func (m *pgRepository) InsertExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("InsertExternalBlock", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	// The booking sold one unit elsewhere, it takes a free one here. When none
+	// is free the block closes the whole room so the overbooking shows up, and
+	// ErrRoomUnavailable is returned once the block is saved.
+	err = lockRoom(ctx, tx, block.RoomId)
+	if err != nil {
+		log.Println("InsertExternalBlock", err)
+		return err
+	}
+	unitId, err := freeUnit(ctx, tx, block.RoomId, block.StartDate, block.EndDate, 0, 0)
+	if err != nil {
+		log.Println("InsertExternalBlock", err)
+		return err
+	}
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, room_unit_id, restriction_id, feed_id, external_uid, start_date, end_date, created_at, updated_at)
+		values ($1, nullif($2, 0), $3, $4, $5, $6, $7, $8, $9)
+	`, RoomRestrictionTable)
+
+	_, err = tx.ExecContext(ctx, query,
+		block.RoomId, unitId, models.RestrictionExternal, block.FeedId, block.ExternalUID, block.StartDate, block.EndDate, time.Now(), time.Now(),
+	)
+	if err != nil {
+		log.Println("InsertExternalBlock", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("InsertExternalBlock", err)
+		return err
+	}
+
+	if unitId == 0 {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// RemoveExternalBlock removes a block imported from a calendar feed.
+func (m *pgRepository) RemoveExternalBlock(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1 and feed_id is not null`, RoomRestrictionTable)
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println("RemoveExternalBlock", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateExternalBlock moves an imported block to new dates, keeping its unit
+// when that is still free. Like InsertExternalBlock it closes the whole room
+// when no unit is free, and then returns ErrRoomUnavailable once the change is
+// saved.
+func (m *pgRepository) UpdateExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomId, currentUnitId int
+	query := fmt.Sprintf(`select room_id, coalesce(room_unit_id, 0) from %s where id = $1 and feed_id is not null`, RoomRestrictionTable)
+	err = tx.QueryRowContext(ctx, query, block.ID).Scan(&roomId, &currentUnitId)
+	if err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+
+	err = lockRoom(ctx, tx, roomId)
+	if err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+	unitId, err := freeUnit(ctx, tx, roomId, block.StartDate, block.EndDate, block.ID, currentUnitId)
+	if err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+
+	query = fmt.Sprintf(`
+		update %s set room_unit_id = nullif($1, 0), start_date = $2, end_date = $3, updated_at = $4
+		where id = $5
+	`, RoomRestrictionTable)
+
+	_, err = tx.ExecContext(ctx, query, unitId, block.StartDate, block.EndDate, time.Now(), block.ID)
+	if err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("UpdateExternalBlock", err)
+		return err
+	}
+
+	if unitId == 0 {
+		return repository.ErrRoomUnavailable
+	}
+	return nil
+}
+
+// Pricing services
+func (m *pgRepository) GetRoomRates(ctx context.Context, roomId int) (pricing.Rates, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	// the base rate is the price of the room, the other amounts are stored in
+	// minor units of the property currency
+	query := fmt.Sprintf(`
+		select r.price, coalesce(s.weekend_rate, 0), s.weekend_days,
+			coalesce(s.short_stay_nights, 0), coalesce(s.short_stay_surcharge, 0)
+		from %s r
+		left join %s s on s.room_id = r.id
+		where r.id = $1
+	`, RoomTable, RoomRateSettingsTable)
+
+	var rates pricing.Rates
+	var weekendRate int64
+	var weekendDays sql.NullString
+	err := m.DB.QueryRowContext(ctx, query, roomId).Scan(
+		m.price(&rates.Base), &weekendRate, &weekendDays, &rates.ShortStayNights, &rates.ShortStaySurcharge,
+	)
+	if err != nil {
+		log.Println("GetRoomRates", err)
+		return rates, err
+	}
+	rates.WeekendRate = money.New(weekendRate, m.currency())
+	if weekendDays.Valid {
+		rates.WeekendDays = parseWeekdays(weekendDays.String)
+	}
+
+	query = fmt.Sprintf(`
+		select id, name, start_date, end_date, nightly_rate, weekend_rate
+		from %s
+		where room_id = $1
+		order by start_date, end_date
+	`, SeasonalRateTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("GetRoomRates", err)
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s pricing.Season
+		var nightlyRate, weekendRate int64
+		if err := rows.Scan(&s.ID, &s.Name, &s.Start, &s.End, &nightlyRate, &weekendRate); err != nil {
+			log.Println("GetRoomRates", err)
+			return rates, err
+		}
+		s.NightlyRate = money.New(nightlyRate, m.currency())
+		s.WeekendRate = money.New(weekendRate, m.currency())
+		rates.Seasons = append(rates.Seasons, s)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("GetRoomRates", err)
+		return rates, err
+	}
+
+	query = fmt.Sprintf(`
+		select id, min_nights, percent
+		from %s
+		where room_id = $1
+		order by min_nights
+	`, StayDiscountTable)
+
+	discountRows, err := m.DB.QueryContext(ctx, query, roomId)
+	if err != nil {
+		log.Println("GetRoomRates", err)
+		return rates, err
+	}
+	defer discountRows.Close()
+
+	for discountRows.Next() {
+		var d pricing.Discount
+		if err := discountRows.Scan(&d.ID, &d.MinNights, &d.Percent); err != nil {
+			log.Println("GetRoomRates", err)
+			return rates, err
+		}
+		rates.Discounts = append(rates.Discounts, d)
+	}
+	if err = discountRows.Err(); err != nil {
+		log.Println("GetRoomRates", err)
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// UpdateRoomRateSettings saves the weekend and short stay settings of the
+// room, the base rate, seasons and discounts are stored separately.
+func (m *pgRepository) UpdateRoomRateSettings(ctx context.Context, roomId int, rates pricing.Rates) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, weekend_rate, weekend_days, short_stay_nights, short_stay_surcharge, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6)
+		on conflict (room_id) do update set
+			weekend_rate = excluded.weekend_rate,
+			weekend_days = excluded.weekend_days,
+			short_stay_nights = excluded.short_stay_nights,
+			short_stay_surcharge = excluded.short_stay_surcharge,
+			updated_at = excluded.updated_at
+	`, RoomRateSettingsTable)
+
+	_, err := m.DB.ExecContext(ctx, query,
+		roomId, rates.WeekendRate.Amount, formatWeekdays(rates.WeekendDays), rates.ShortStayNights, rates.ShortStaySurcharge, time.Now(),
+	)
+	if err != nil {
+		log.Println("UpdateRoomRateSettings", err)
+		return err
+	}
+
+	return nil
+}
+
+func (m *pgRepository) InsertSeasonalRate(ctx context.Context, roomId int, season pricing.Season) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $7) returning id
+	`, SeasonalRateTable)
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query,
+		roomId, season.Name, season.Start, season.End, season.NightlyRate.Amount, season.WeekendRate.Amount, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		log.Println("InsertSeasonalRate", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) DeleteSeasonalRate(ctx context.Context, roomId, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1 and room_id = $2`, SeasonalRateTable)
+	_, err := m.DB.ExecContext(ctx, query, id, roomId)
+	if err != nil {
+		log.Println("DeleteSeasonalRate", err)
+		return err
+	}
+
+	return nil
+}
+
+// SaveStayDiscount replaces the discount of the room for the same number of
+// nights, if any.
+func (m *pgRepository) SaveStayDiscount(ctx context.Context, roomId int, discount pricing.Discount) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (room_id, min_nights, percent, created_at, updated_at)
+		values ($1, $2, $3, $4, $4)
+		on conflict (room_id, min_nights) do update set percent = excluded.percent, updated_at = excluded.updated_at
+		returning id
+	`, StayDiscountTable)
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, roomId, discount.MinNights, discount.Percent, time.Now()).Scan(&id)
+	if err != nil {
+		log.Println("SaveStayDiscount", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) DeleteStayDiscount(ctx context.Context, roomId, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1 and room_id = $2`, StayDiscountTable)
+	_, err := m.DB.ExecContext(ctx, query, id, roomId)
+	if err != nil {
+		log.Println("DeleteStayDiscount", err)
+		return err
+	}
+
+	return nil
+}
+
+// parseWeekdays reads the comma separated weekday numbers of weekend_days and
+// of the stay rules, an empty list is no day at all.
+func parseWeekdays(list string) []time.Weekday {
+	days := []time.Weekday{}
+	for _, s := range strings.Split(list, ",") {
+		d, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || d < 0 || d > 6 {
+			continue
+		}
+		days = append(days, time.Weekday(d))
+	}
+	return days
+}
+
+func formatWeekdays(days []time.Weekday) string {
+	if days == nil {
+		days = pricing.DefaultWeekendDays
+	}
+	return joinWeekdays(days)
+}
+
+func joinWeekdays(days []time.Weekday) string {
+	list := make([]string, len(days))
+	for i, d := range days {
+		list[i] = strconv.Itoa(int(d))
+	}
+	return strings.Join(list, ",")
+}
+
+// decodeQuote restores the price breakdown saved with a reservation,
+// reservations made before pricing existed only have a zero total.
+func decodeQuote(total int64, currency string, breakdown []byte) pricing.Quote {
+	var q pricing.Quote
+	if len(breakdown) > 0 {
+		if err := json.Unmarshal(breakdown, &q); err != nil {
+			log.Println("decodeQuote", err)
+		}
+	}
+	q.Total = money.New(total, currency)
+	return q
+}
+
+// currency is the currency of every amount stored without one.
+func (m *pgRepository) currency() string {
+	if m.App.Currency == "" {
+		return money.DefaultCurrency
+	}
+	return m.App.Currency
+}
+
+// price scans a decimal column exactly into dst, in the property currency.
+func (m *pgRepository) price(dst *money.Money) sql.Scanner {
+	return decimalScanner{dst: dst, currency: m.currency()}
+}
+
+type decimalScanner struct {
+	dst      *money.Money
+	currency string
+}
+
+func (d decimalScanner) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*d.dst = money.New(0, d.currency)
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	default:
+		return fmt.Errorf("cannot scan %T into money", src)
+	}
+
+	amount, err := money.Parse(s, d.currency)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into money: %w", s, err)
+	}
+	*d.dst = amount
+	return nil
+}
+
+// Payment services
+func (m *pgRepository) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (reservation_id, parent_id, kind, method, provider, provider_ref, amount, currency, status, note, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, nullif($6, ''), $7, $8, $9, $10, $11, $11) returning id
+	`, PaymentTable)
+
+	var parentId sql.NullInt64
+	if p.ParentId > 0 {
+		parentId = sql.NullInt64{Int64: int64(p.ParentId), Valid: true}
+	}
+
+	method := p.Method
+	if method == "" {
+		method = models.PaymentMethodCard
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query,
+		p.ReservationId, parentId, p.Kind, method, p.Provider, p.ProviderRef, p.Amount.Amount, p.Amount.Currency, p.Status, p.Note, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		log.Println("InsertPayment", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *pgRepository) GetPaymentByRef(ctx context.Context, provider, ref string) (models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, reservation_id, coalesce(parent_id, 0), kind, method, provider, coalesce(provider_ref, ''), amount, currency, status, note, created_at, updated_at
+		from %s
+		where provider = $1 and provider_ref = $2
+	`, PaymentTable)
+
+	p, err := scanPayment(m.DB.QueryRowContext(ctx, query, provider, ref))
+	if err != nil {
+		log.Println("GetPaymentByRef", err)
+		return p, err
+	}
+
+	return p, nil
+}
+
+func (m *pgRepository) StoredCurrencies(ctx context.Context) ([]string, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select currency from %s
+		union
+		select currency from %s
+		order by currency
+	`, ReservationTable, PaymentTable)
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("StoredCurrencies", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var currencies []string
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			log.Println("StoredCurrencies", err)
+			return nil, err
+		}
+		currencies = append(currencies, currency)
+	}
+
+	return currencies, rows.Err()
+}
+
+func (m *pgRepository) GetPaymentsByReservation(ctx context.Context, reservationId int) ([]models.Payment, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select id, reservation_id, coalesce(parent_id, 0), kind, method, provider, coalesce(provider_ref, ''), amount, currency, status, note, created_at, updated_at
+		from %s
+		where reservation_id = $1
+		order by created_at, id
+	`, PaymentTable)
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationId)
+	if err != nil {
+		log.Println("GetPaymentsByReservation", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			log.Println("GetPaymentsByReservation", err)
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("GetPaymentsByReservation", err)
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+func (m *pgRepository) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set status = $1, updated_at = $2 where id = $3`, PaymentTable)
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		log.Println("UpdatePaymentStatus", err)
+		return err
+	}
+
+	return nil
+}
+
+// CapturePayment marks the charge as paid and confirms its reservation if it
+// was waiting for it. Capturing twice, as when the guest's redirect and the
+// provider's webhook both arrive, changes nothing the second time.
+func (m *pgRepository) CapturePayment(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("CapturePayment", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf(`
+		update %s set status = $1, updated_at = $2
+		where id = $3 and kind = $4
+		returning reservation_id
+	`, PaymentTable)
+
+	var reservationId int
+	err = tx.QueryRowContext(ctx, query, models.PaymentSucceeded, time.Now(), id, models.PaymentKindCharge).Scan(&reservationId)
+	if err != nil {
+		log.Println("CapturePayment", err)
+		return err
+	}
+
+	from, err := lockReservationStatus(ctx, tx, reservationId)
+	if err != nil {
+		log.Println("CapturePayment", err)
+		return err
+	}
+
+	// only a reservation waiting for its payment is confirmed by it, later
+	// payments go to the balance
+	if from == models.ReservationPendingPayment {
+		now := time.Now()
+		query = fmt.Sprintf(`update %s set status = $1, updated_at = $2 where id = $3`, ReservationTable)
+		_, err = tx.ExecContext(ctx, query, models.ReservationConfirmed, now, reservationId)
+		if err != nil {
+			log.Println("CapturePayment", err)
+			return err
+		}
+
+		err = insertReservationEvent(ctx, tx, models.ReservationEvent{
+			ReservationId: reservationId,
+			FromStatus:    from,
+			ToStatus:      models.ReservationConfirmed,
+			Note:          "Payment captured",
+			CreatedAt:     now,
+		})
+		if err != nil {
+			log.Println("CapturePayment", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println("CapturePayment", err)
+		return err
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPayment(row rowScanner) (models.Payment, error) {
+	var p models.Payment
+	var amount int64
+	var currency string
+	err := row.Scan(&p.ID, &p.ReservationId, &p.ParentId, &p.Kind, &p.Method, &p.Provider, &p.ProviderRef, &amount, &currency, &p.Status, &p.Note, &p.CreatedAt, &p.UpdatedAt)
+	p.Amount = money.New(amount, currency)
+	return p, err
+}
+
+// Cancellation policies
+func (m *pgRepository) AllCancellationPolicies(ctx context.Context) ([]cancellation.Policy, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`select id, name, free_until_days, penalty_percent from %s order by name`, CancellationPolicyTable)
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Println("AllCancellationPolicies", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []cancellation.Policy{}
+	for rows.Next() {
+		var p cancellation.Policy
+		err := rows.Scan(&p.ID, &p.Name, &p.FreeUntilDays, &p.PenaltyPercent)
+		if err != nil {
+			log.Println("AllCancellationPolicies", err)
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println("AllCancellationPolicies", err)
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+func (m *pgRepository) InsertCancellationPolicy(ctx context.Context, p cancellation.Policy) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		insert into %s (name, free_until_days, penalty_percent, created_at, updated_at)
+		values ($1, $2, $3, $4, $4) returning id
+	`, CancellationPolicyTable)
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, query, p.Name, p.FreeUntilDays, p.PenaltyPercent, time.Now()).Scan(&id)
+	if err != nil {
+		log.Println("InsertCancellationPolicy", err)
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// DeleteCancellationPolicy leaves the rooms that used it without a policy.
+func (m *pgRepository) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`delete from %s where id = $1`, CancellationPolicyTable)
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println("DeleteCancellationPolicy", err)
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomCancellationPolicy attaches a policy to a room, a zero policyId
+// removes it.
+func (m *pgRepository) SetRoomCancellationPolicy(ctx context.Context, roomId, policyId int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`update %s set cancellation_policy_id = nullif($1, 0), updated_at = $2 where id = $3`, RoomTable)
+	result, err := m.DB.ExecContext(ctx, query, policyId, time.Now(), roomId)
+	if err != nil {
+		log.Println("SetRoomCancellationPolicy", err)
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetRoomCancellationPolicy returns the zero policy, free cancellation, for
+// rooms without one.
+func (m *pgRepository) GetRoomCancellationPolicy(ctx context.Context, roomId int) (cancellation.Policy, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		select p.id, p.name, p.free_until_days, p.penalty_percent
+		from %s r
+		join %s p on p.id = r.cancellation_policy_id
+		where r.id = $1
+	`, RoomTable, CancellationPolicyTable)
+
+	var p cancellation.Policy
+	err := m.DB.QueryRowContext(ctx, query, roomId).Scan(&p.ID, &p.Name, &p.FreeUntilDays, &p.PenaltyPercent)
+	if errors.Is(err, sql.ErrNoRows) {
+		return cancellation.Policy{}, nil
+	}
+	if err != nil {
+		log.Println("GetRoomCancellationPolicy", err)
+		return p, err
+	}
+
+	return p, nil
 }
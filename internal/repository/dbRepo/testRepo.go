@@ -1,101 +1,1337 @@
 package dbRepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/lifecycle"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/money"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/repository"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/tokens"
 )
 
-func (m *testDbRepo) AllUsers() ([]models.User, error) {
+func (m *testDbRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return []models.User{}, nil
 }
 
-func (m *testDbRepo) InsertReservation(res *models.Reservation) (int, error) {
-	if res.RoomId == 2 {
+func (m *testDbRepo) CreateReservation(ctx context.Context, res *models.Reservation) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	switch res.RoomId {
+	case 2, 1000:
 		return 0, errors.New("some error")
+	case 3:
+		return 0, repository.ErrRoomUnavailable
 	}
-	return 0, nil
+	if room, err := m.GetRoomById(ctx, res.RoomId); err == nil && !room.Sleeps(res.Guests()) {
+		return 0, repository.ErrRoomTooSmall
+	}
+	rules, _ := m.GetRoomStayRules(ctx, res.RoomId)
+	if err := rules.Check(res.StartDate, res.EndDate, time.Now()); err != nil {
+		return 0, err
+	}
+	res.RoomUnitId = res.RoomId
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	made := *res
+	made.ID = firstMadeId + len(m.made)
+	m.made = append(m.made, made)
+	return made.ID, nil
 }
 
-func (m *testDbRepo) InsertRoomRestriction(res *models.RoomRestriction) error {
-	if res.RoomId == 1000 {
-		return errors.New("some error")
+// firstMadeId is the id of the first reservation made through
+// CreateReservation, below it are the fixed reservations of the test repo.
+const firstMadeId = 100
+
+// madeById returns the reservation made through CreateReservation with the
+// id, m.mu must be held.
+func (m *testDbRepo) madeById(id int) (*models.Reservation, bool) {
+	i := id - firstMadeId
+	if i < 0 || i >= len(m.made) {
+		return nil, false
 	}
-	return nil
+	return &m.made[i], true
 }
 
-func (m *testDbRepo) CheckIfRoomAvailableByDate(roomId int, start, end time.Time) (bool, error) {
+// madeBy lists the reservations made through CreateReservation by the user.
+func (m *testDbRepo) madeBy(userId int) []models.Reservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservations := []models.Reservation{}
+	for _, res := range m.made {
+		if userId != 0 && res.UserId == userId {
+			reservations = append(reservations, res)
+		}
+	}
+	return reservations
+}
+
+// CheckIfRoomAvailableByDate fails for room 2, the other rooms are taken on
+// the dates of the reservations made through CreateReservation until they
+// are cancelled.
+func (m *testDbRepo) CheckIfRoomAvailableByDate(ctx context.Context, roomId int, start, end time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	if roomId == 2 {
 		return false, errors.New("some error")
 	}
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, res := range m.made {
+		if res.RoomId == roomId && res.Status != models.ReservationCancelled && res.StartDate.Before(end) && start.Before(res.EndDate) {
+			return false, nil
+		}
+	}
 	return true, nil
 }
 
-func (m *testDbRepo) SearchAvailabilityInRange(start, end time.Time) ([]models.Room, error) {
-	return []models.Room{}, nil
+// SearchAvailabilityInRange offers both test rooms, only room 2 has the sea
+// view (amenity 2). Both have stay rules over Christmas 2060.
+func (m *testDbRepo) SearchAvailabilityInRange(ctx context.Context, start, end time.Time, guests models.Guests, amenityIds []int) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rooms := []models.Room{}
+	for _, room := range []models.Room{
+		{ID: 1, Name: "General's Quarters", MaxAdults: 2, MaxChildren: 1, Beds: 1, FreeUnits: 1},
+		{ID: 2, Name: "Major's Suite", MaxAdults: 4, MaxChildren: 2, Beds: 2, FreeUnits: 2},
+	} {
+		amenities, _ := m.GetRoomAmenities(ctx, room.ID)
+		rules, _ := m.GetRoomStayRules(ctx, room.ID)
+		if room.Sleeps(guests) && hasAmenities(amenities, amenityIds) && rules.Check(start, end, time.Now()) == nil {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms, nil
+}
+
+func hasAmenities(amenities []models.Amenity, ids []int) bool {
+	for _, id := range ids {
+		found := false
+		for _, a := range amenities {
+			found = found || a.ID == id
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
-func (m *testDbRepo) GetRoomById(id int) (models.Room, error) {
+func (m *testDbRepo) GetRoomById(ctx context.Context, id int) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+
 	if id >= 2 {
 		return models.Room{}, errors.New("some error")
 	}
-	return models.Room{}, nil
+	return models.Room{ID: id, MaxAdults: 2, MaxChildren: 1, Beds: 1}, nil
 }
 
-func (m *testDbRepo) GetRoomBySlug(slug string) (models.Room, error) {
+func (m *testDbRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Room{}, err
+	}
+
+	if slug == "generals-quarters" {
+		return models.Room{ID: 1, Name: "General's Quarters", Slug: slug}, nil
+	}
 	return models.Room{}, nil
 }
 
-func (m *testDbRepo) GetRooms() ([]models.Room, error) {
+func (m *testDbRepo) GetRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return []models.Room{}, nil
 }
 
-func (m *testDbRepo) GetUserById(id int) (models.User, error) {
-	return models.User{}, nil
+func (m *testDbRepo) GetRoomsPage(ctx context.Context, limit, offset int) ([]models.Room, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	rooms := []models.Room{
+		{ID: 1, Name: "General's Quarters", Slug: "generals-quarters", Price: money.New(10000, "USD")},
+		{ID: 2, Name: "Major's Suite", Slug: "majors-suite", Price: money.New(20000, "USD")},
+	}
+	if offset >= len(rooms) {
+		return []models.Room{}, len(rooms), nil
+	}
+	end := offset + limit
+	if end > len(rooms) {
+		end = len(rooms)
+	}
+	return rooms[offset:end], len(rooms), nil
+}
+
+func (m *testDbRepo) GetUserById(ctx context.Context, id int) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	if id == 2 {
+		return models.User{ID: 2, Email: "unverified@example.com"}, nil
+	}
+	return models.User{ID: id, EmailVerified: true}, nil
+}
+
+func (m *testDbRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return models.User{}, err
+	}
+
+	switch email {
+	case "existing@example.com":
+		return models.User{ID: 1, Email: email, EmailVerified: true}, nil
+	case "unverified@example.com":
+		return models.User{ID: 2, Email: email}, nil
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDbRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if u.Email == "fail@example.com" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+func (m *testDbRepo) VerifyUserEmail(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) UpdateUser(ctx context.Context, u models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, "", err
+	}
+
+	if email == "unverified@example.com" {
+		return 2, "", nil
+	}
+	return 1, "", nil
+}
+
+func (m *testDbRepo) InsertPasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (models.PasswordReset, error) {
+	if err := ctx.Err(); err != nil {
+		return models.PasswordReset{}, err
+	}
+
+	switch tokenHash {
+	case tokens.Hash("valid-token"):
+		return models.PasswordReset{ID: 1, UserId: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}, nil
+	case tokens.Hash("expired-token"):
+		return models.PasswordReset{ID: 2, UserId: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(-time.Hour)}, nil
+	}
+	return models.PasswordReset{}, sql.ErrNoRows
+}
+
+func (m *testDbRepo) ResetUserPassword(ctx context.Context, resetId, userId int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) InsertAPIToken(ctx context.Context, token models.APIToken) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if token.Name == "fail" {
+		return 0, errors.New("cannot insert api token")
+	}
+	return 1, nil
+}
+
+func (m *testDbRepo) GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return models.APIToken{}, err
+	}
+
+	switch tokenHash {
+	case tokens.Hash("bk_valid"):
+		return models.APIToken{ID: 1, UserId: 1, Name: "channel manager", TokenHash: tokenHash, Scopes: "rooms.read"}, nil
+	case tokens.Hash("bk_expired"):
+		return models.APIToken{ID: 2, UserId: 1, Name: "old script", TokenHash: tokenHash, Scopes: "rooms.read", ExpiresAt: time.Now().Add(-time.Hour)}, nil
+	}
+	return models.APIToken{}, sql.ErrNoRows
 }
 
-func (m *testDbRepo) UpdateUser(u models.User) error {
+func (m *testDbRepo) AllAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.APIToken{}, nil
+}
+
+func (m *testDbRepo) RevokeAPIToken(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *testDbRepo) Authenticate(email, testPassword string) (int, string, error) {
-	return 0, "", nil
+func (m *testDbRepo) TouchAPIToken(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
 }
 
-func (m *testDbRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDbRepo) AllReservations(ctx context.Context, status string) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return []models.Reservation{}, nil
 }
 
-func (m *testDbRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDbRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return []models.Reservation{}, nil
 }
 
-func (m *testDbRepo) GetReservationById(id int) (models.Reservation, error) {
+// GetReservationById knows #1, confirmed a month ahead with a deposit paid,
+// #2 checked in and leaving today and #3 confirmed and arriving today.
+func (m *testDbRepo) GetReservationById(ctx context.Context, id int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+
+	m.mu.Lock()
+	made, ok := m.madeById(id)
+	if ok {
+		res := *made
+		m.mu.Unlock()
+		return res, nil
+	}
+	m.mu.Unlock()
+
+	y, mo, d := time.Now().Date()
+	today := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	switch id {
+	case 1:
+		return models.Reservation{
+			ID:         1,
+			UserId:     1,
+			RoomId:     1,
+			Status:     models.ReservationConfirmed,
+			StartDate:  time.Now().AddDate(0, 1, 0),
+			EndDate:    time.Now().AddDate(0, 1, 2),
+			Quote:      pricing.Quote{Total: money.New(25000, "USD")},
+			Deposit:    money.New(7500, "USD"),
+			BalanceDue: time.Now().AddDate(0, 0, 16),
+		}, nil
+	case 2:
+		return models.Reservation{ID: 2, UserId: 1, RoomId: 1, Status: models.ReservationCheckedIn, StartDate: today.AddDate(0, 0, -2), EndDate: today}, nil
+	case 3:
+		return models.Reservation{ID: 3, UserId: 1, RoomId: 2, Status: models.ReservationConfirmed, StartDate: today, EndDate: today.AddDate(0, 0, 2)}, nil
+	}
 	return models.Reservation{}, nil
 }
 
-func (m *testDbRepo) UpdateReservation(u models.Reservation) error {
+func (m *testDbRepo) GetReservationsByUserId(ctx context.Context, userId int) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return m.madeBy(userId), nil
+}
+
+func (m *testDbRepo) GetReservationsByUserIdPage(ctx context.Context, userId, limit, offset int) ([]models.Reservation, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	reservations := m.madeBy(userId)
+	total := len(reservations)
+	if offset >= total {
+		return []models.Reservation{}, total, nil
+	}
+	return reservations[offset:min(offset+limit, total)], total, nil
+}
+
+// GetUserReservationById knows four reservations of user 1: #1 far in the
+// future, #2 starting tomorrow, past the cancellation notice, #3 in five days,
+// inside the penalty window of room 1, and #4 already cancelled.
+func (m *testDbRepo) GetUserReservationById(ctx context.Context, id, userId int) (models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Reservation{}, err
+	}
+
+	if userId != 1 {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	switch id {
+	case 1:
+		return models.Reservation{ID: 1, UserId: 1, RoomId: 1, Status: models.ReservationConfirmed, StartDate: time.Now().AddDate(0, 1, 0), EndDate: time.Now().AddDate(0, 1, 2)}, nil
+	case 2:
+		return models.Reservation{ID: 2, UserId: 1, RoomId: 1, Status: models.ReservationConfirmed, StartDate: time.Now().AddDate(0, 0, 1), EndDate: time.Now().AddDate(0, 0, 3)}, nil
+	case 3:
+		return models.Reservation{ID: 3, UserId: 1, RoomId: 1, Status: models.ReservationConfirmed, Quote: pricing.Quote{Total: money.New(25000, "USD")}, StartDate: time.Now().AddDate(0, 0, 5), EndDate: time.Now().AddDate(0, 0, 7)}, nil
+	case 4:
+		return models.Reservation{ID: 4, UserId: 1, RoomId: 1, Status: models.ReservationCancelled, CancelledAt: time.Now(), StartDate: time.Now().AddDate(0, 1, 0), EndDate: time.Now().AddDate(0, 1, 2)}, nil
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+func (m *testDbRepo) InsertReservationChangeRequest(ctx context.Context, req models.ReservationChangeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) GetReservationChangeRequests(ctx context.Context, reservationId int) ([]models.ReservationChangeRequest, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.ReservationChangeRequest{}, nil
+}
+
+func (m *testDbRepo) UpdateReservation(ctx context.Context, u models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MoveReservation finds room 3 taken whatever the dates.
+func (m *testDbRepo) MoveReservation(ctx context.Context, res models.Reservation, userId int, note string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if res.RoomId == 3 {
+		return repository.ErrRoomUnavailable
+	}
+	if room, err := m.GetRoomById(ctx, res.RoomId); err == nil && !room.Sleeps(res.Guests()) {
+		return repository.ErrRoomTooSmall
+	}
+	current, _ := m.GetReservationById(ctx, res.ID)
+	if len(lifecycle.Next(current.Status)) == 0 || current.Status == models.ReservationPendingPayment {
+		return lifecycle.ErrTransition
+	}
+	return nil
+}
+
+// CancelReservation records the cancellation of the reservations made
+// through CreateReservation, which gives their dates back.
+func (m *testDbRepo) CancelReservation(ctx context.Context, res models.Reservation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if made, ok := m.madeById(res.ID); ok {
+		if err := lifecycle.Check(made.Status, models.ReservationCancelled); err != nil {
+			return err
+		}
+		made.Status = models.ReservationCancelled
+		made.CancelledAt = res.CancelledAt
+		made.CancelledBy = res.CancelledBy
+		made.CancellationReason = res.CancellationReason
+		made.CancellationPenalty = res.CancellationPenalty
+	}
+	return nil
+}
+
+// TransitionReservation moves reservation 1, which is confirmed, and the
+// reservations made through CreateReservation through the lifecycle, the
+// other reservations do not exist.
+func (m *testDbRepo) TransitionReservation(ctx context.Context, id int, to string, userId int, note string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if made, ok := m.madeById(id); ok {
+		if err := lifecycle.Check(made.Status, to); err != nil {
+			return err
+		}
+		made.Status = to
+		return nil
+	}
+
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return lifecycle.Check(models.ReservationConfirmed, to)
+}
+
+// GetOverdueReservations lists the reservations made through
+// CreateReservation still waiting for a payment due by now.
+func (m *testDbRepo) GetOverdueReservations(ctx context.Context, now time.Time) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []int
+	for _, res := range m.made {
+		if res.Status == models.ReservationPendingPayment && !res.PaymentDueAt.IsZero() && !res.PaymentDueAt.After(now) {
+			ids = append(ids, res.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *testDbRepo) ExpireReservation(ctx context.Context, id int, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	made, ok := m.madeById(id)
+	if !ok || made.Status != models.ReservationPendingPayment || made.PaymentDueAt.IsZero() || made.PaymentDueAt.After(now) {
+		return lifecycle.ErrTransition
+	}
+	made.Status = models.ReservationCancelled
+	return nil
+}
+
+func (m *testDbRepo) GetReservationEvents(ctx context.Context, reservationId int) ([]models.ReservationEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if reservationId != 1 {
+		return nil, nil
+	}
+	return []models.ReservationEvent{
+		{ID: 1, ReservationId: 1, ToStatus: models.ReservationPendingPayment, UserId: 1, CreatedAt: time.Now().AddDate(0, 0, -2), User: models.User{ID: 1, FirstName: "John", LastName: "Doe"}},
+		{ID: 2, ReservationId: 1, FromStatus: models.ReservationPendingPayment, ToStatus: models.ReservationConfirmed, Note: "Payment captured", CreatedAt: time.Now().AddDate(0, 0, -2)},
+	}, nil
+}
+
+// AllRooms lists the two test rooms and an archived one.
+func (m *testDbRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.Room{
+		{ID: 1, Name: "General's Quarters", Slug: "generals-quarters", Price: money.New(10000, "USD"), CancellationPolicyId: 1, SortOrder: 1, UnitCount: 1},
+		{ID: 2, Name: "Major's Suite", Slug: "majors-suite", Price: money.New(20000, "USD"), SortOrder: 2, UnitCount: 2},
+		{ID: 4, Name: "Colonel's Cabin", Slug: "colonels-cabin", Price: money.New(15000, "USD"), SortOrder: 3, UnitCount: 1, ArchivedAt: time.Now().AddDate(0, -1, 0)},
+	}, nil
+}
+
+func (m *testDbRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if room.Slug == "generals-quarters" || room.Slug == "majors-suite" {
+		return 0, repository.ErrSlugTaken
+	}
+	return 3, nil
+}
+
+func (m *testDbRepo) UpdateRoom(ctx context.Context, room models.Room) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if room.Slug == "majors-suite" && room.ID != 2 {
+		return repository.ErrSlugTaken
+	}
+	return nil
+}
+
+func (m *testDbRepo) SetRoomArchived(ctx context.Context, id int, archived bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) ReorderRooms(ctx context.Context, ids []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) GetRoomImages(ctx context.Context, roomId int) ([]models.RoomImage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if roomId != 1 {
+		return []models.RoomImage{}, nil
+	}
+	return []models.RoomImage{
+		{ID: 1, RoomId: 1, WebKey: "rooms/1/a-web.jpg", ThumbKey: "rooms/1/a-thumb.jpg", Width: 1600, Height: 1200, SortOrder: 1, IsCover: true},
+		{ID: 2, RoomId: 1, WebKey: "rooms/1/b-web.jpg", ThumbKey: "rooms/1/b-thumb.jpg", Width: 1600, Height: 900, SortOrder: 2},
+		{ID: 3, RoomId: 1, WebKey: "rooms/1/c-web.jpg", ThumbKey: "rooms/1/c-thumb.jpg", Width: 900, Height: 1200, SortOrder: 3},
+	}, nil
+}
+
+func (m *testDbRepo) GetRoomImageById(ctx context.Context, id int) (models.RoomImage, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RoomImage{}, err
+	}
+
+	images, _ := m.GetRoomImages(ctx, 1)
+	for _, img := range images {
+		if img.ID == id {
+			return img, nil
+		}
+	}
+	return models.RoomImage{}, sql.ErrNoRows
+}
+
+func (m *testDbRepo) InsertRoomImage(ctx context.Context, img models.RoomImage) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 4, nil
+}
+
+func (m *testDbRepo) DeleteRoomImage(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *testDbRepo) DeleteReservation(id int) error {
+func (m *testDbRepo) SetRoomCoverImage(ctx context.Context, roomId, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *testDbRepo) ProcessReservation(id int, processed bool) error {
+func (m *testDbRepo) ReorderRoomImages(ctx context.Context, roomId int, ids []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *testDbRepo) GetRoomRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetRoomUnits gives room 1 a single unit and room 2 two of them.
+func (m *testDbRepo) GetRoomUnits(ctx context.Context, roomId int) ([]models.RoomUnit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	switch roomId {
+	case 1:
+		return []models.RoomUnit{{ID: 1, RoomId: 1, Name: "1", SortOrder: 1}}, nil
+	case 2:
+		return []models.RoomUnit{
+			{ID: 2, RoomId: 2, Name: "201", SortOrder: 1},
+			{ID: 3, RoomId: 2, Name: "202", SortOrder: 2},
+		}, nil
+	}
+	return []models.RoomUnit{}, nil
+}
+
+func (m *testDbRepo) InsertRoomUnit(ctx context.Context, unit models.RoomUnit) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	units, _ := m.GetRoomUnits(ctx, unit.RoomId)
+	for _, u := range units {
+		if u.Name == unit.Name {
+			return 0, repository.ErrUnitNameTaken
+		}
+	}
+	return 4, nil
+}
+
+// DeleteRoomUnit refuses unit 1, the only unit of room 1.
+func (m *testDbRepo) DeleteRoomUnit(ctx context.Context, roomId, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if id == 1 {
+		return repository.ErrUnitInUse
+	}
+	return nil
+}
+
+// AllAmenities has wifi, sea view and accessible.
+// AllAmenities has wifi, the sea view and accessibility, and the amenities
+// added through InsertAmenity, by name.
+func (m *testDbRepo) AllAmenities(ctx context.Context) ([]models.Amenity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	amenities := append([]models.Amenity{
+		{ID: 3, Name: "Accessible", Slug: "accessible"},
+		{ID: 2, Name: "Sea view", Slug: "sea-view"},
+		{ID: 1, Name: "Wi-Fi", Slug: "wifi"},
+	}, m.amenities...)
+	sort.Slice(amenities, func(i, j int) bool {
+		return amenities[i].Name < amenities[j].Name
+	})
+	return amenities, nil
+}
+
+func (m *testDbRepo) InsertAmenity(ctx context.Context, a models.Amenity) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	amenities, _ := m.AllAmenities(ctx)
+	for _, existing := range amenities {
+		if strings.EqualFold(existing.Name, a.Name) || existing.Slug == a.Slug {
+			return 0, repository.ErrAmenityTaken
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.ID = 4
+	if n := len(m.amenities); n > 0 {
+		a.ID = m.amenities[n-1].ID + 1
+	}
+	m.amenities = append(m.amenities, a)
+	return a.ID, nil
+}
+
+// DeleteAmenity removes the amenities added through InsertAmenity, the
+// others stay.
+func (m *testDbRepo) DeleteAmenity(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, a := range m.amenities {
+		if a.ID == id {
+			m.amenities = append(m.amenities[:i], m.amenities[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// GetRoomAmenities gives both rooms wifi and room 2 the sea view as well.
+func (m *testDbRepo) GetRoomAmenities(ctx context.Context, roomId int) ([]models.Amenity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	switch roomId {
+	case 1:
+		return []models.Amenity{{ID: 1, Name: "Wi-Fi", Slug: "wifi"}}, nil
+	case 2:
+		return []models.Amenity{{ID: 2, Name: "Sea view", Slug: "sea-view"}, {ID: 1, Name: "Wi-Fi", Slug: "wifi"}}, nil
+	}
+	return []models.Amenity{}, nil
+}
+
+func (m *testDbRepo) SetRoomAmenities(ctx context.Context, roomId int, ids []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRoomStayRules closes Christmas 2060 to stays shorter than 3 nights and
+// sunday arrivals in room 1, and to stays shorter than 5 nights in room 2.
+func (m *testDbRepo) GetRoomStayRules(ctx context.Context, roomId int) (stayrules.Rules, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rules, _ := m.AllStayRules(ctx)
+	return rules[roomId], nil
+}
+
+// AllStayRules has the Christmas 2060 rules 1 and 2 and the rules added
+// through InsertStayRule.
+func (m *testDbRepo) AllStayRules(ctx context.Context) (map[int]stayrules.Rules, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	start := time.Date(2060, 12, 20, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2060, 12, 31, 0, 0, 0, 0, time.UTC)
+	rules := map[int]stayrules.Rules{
+		1: {{ID: 1, Start: start, End: end, MinNights: 3, ClosedToArrival: []time.Weekday{time.Sunday}}},
+		2: {{ID: 2, Start: start, End: end, MinNights: 5}},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for roomId, added := range m.stayRules {
+		rules[roomId] = append(rules[roomId], added...)
+	}
+	return rules, nil
+}
+
+func (m *testDbRepo) InsertStayRule(ctx context.Context, roomId int, rule stayrules.Rule) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRuleId++
+	rule.ID = m.lastRuleId
+	m.stayRules[roomId] = append(m.stayRules[roomId], rule)
+	return rule.ID, nil
+}
+
+// DeleteStayRule removes the rules added through InsertStayRule, rules 1 and
+// 2 stay.
+func (m *testDbRepo) DeleteStayRule(ctx context.Context, roomId, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules := m.stayRules[roomId]
+	for i, rule := range rules {
+		if rule.ID == id {
+			m.stayRules[roomId] = append(rules[:i], rules[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *testDbRepo) GetRoomRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if roomId == 1 {
+		arrival := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+		return []models.RoomRestriction{
+			{ID: 7, RoomId: 1, RoomUnitId: 1, RestrictionId: 1, ReservationId: 3, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 3)},
+			{ID: 8, RoomId: 1, RestrictionId: 2, Reason: "maintenance", StartDate: arrival.AddDate(0, 0, 5), EndDate: arrival.AddDate(0, 0, 6)},
+			{ID: 10, RoomId: 1, RestrictionId: models.RestrictionExternal, FeedId: 1, ExternalUID: "booked@channel", StartDate: arrival.AddDate(0, 0, 20), EndDate: arrival.AddDate(0, 0, 22)},
+		}, nil
+	}
+	return []models.RoomRestriction{}, nil
+}
+
+func (m *testDbRepo) GetBlockById(ctx context.Context, id int) (models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RoomRestriction{}, err
+	}
+
+	if id != 8 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+	start := time.Date(2050, 1, 15, 0, 0, 0, 0, time.UTC)
+	return models.RoomRestriction{ID: 8, RoomId: 1, RestrictionId: models.RestrictionOwnerBlock, Reason: "maintenance", StartDate: start, EndDate: start.AddDate(0, 0, 1)}, nil
+}
+
+func (m *testDbRepo) InsertBlock(ctx context.Context, block models.RoomRestriction) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// the test reservation of room 1 takes the nights from 2050-01-10 to 2050-01-13
+	taken := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	if block.RoomId == 1 && block.StartDate.Before(taken.AddDate(0, 0, 3)) && block.EndDate.After(taken) {
+		return 0, repository.ErrRoomUnavailable
+	}
+	return 9, nil
+}
+
+func (m *testDbRepo) UpdateBlock(ctx context.Context, block models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) RemoveBlockById(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) RemoveExternalBlock(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) AllRoomICalFeeds(ctx context.Context) ([]models.RoomICalFeed, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.RoomICalFeed{}, nil
+}
+
+func (m *testDbRepo) GetRoomICalFeedById(ctx context.Context, id int) (models.RoomICalFeed, error) {
+	if err := ctx.Err(); err != nil {
+		return models.RoomICalFeed{}, err
+	}
+
+	if id != 1 {
+		return models.RoomICalFeed{}, sql.ErrNoRows
+	}
+	return models.RoomICalFeed{ID: 1, RoomId: 1, Name: "Airbnb", URL: "http://127.0.0.1:1/calendar.ics"}, nil
+}
+
+func (m *testDbRepo) InsertRoomICalFeed(ctx context.Context, feed models.RoomICalFeed) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (m *testDbRepo) DeleteRoomICalFeed(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) UpdateRoomICalFeedStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) GetExternalBlocksByFeed(ctx context.Context, feedId int) ([]models.RoomRestriction, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return []models.RoomRestriction{}, nil
 }
 
-func (m *testDbRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDbRepo) InsertExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (m *testDbRepo) RemoveBlockById(id int) error {
+func (m *testDbRepo) UpdateExternalBlock(ctx context.Context, block models.RoomRestriction) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// GetRoomRates prices room 1 at 100.00 a night and 150.00 on weekends with a
+// tenth off stays of a week, room 2 fails.
+func (m *testDbRepo) GetRoomRates(ctx context.Context, roomId int) (pricing.Rates, error) {
+	if err := ctx.Err(); err != nil {
+		return pricing.Rates{}, err
+	}
+
+	if roomId == 2 {
+		return pricing.Rates{}, errors.New("some error")
+	}
+	return pricing.Rates{
+		Base:        money.New(10000, "USD"),
+		WeekendRate: money.New(15000, "USD"),
+		Discounts:   []pricing.Discount{{ID: 1, MinNights: 7, Percent: 10}},
+	}, nil
+}
+
+func (m *testDbRepo) UpdateRoomRateSettings(ctx context.Context, roomId int, rates pricing.Rates) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) InsertSeasonalRate(ctx context.Context, roomId int, season pricing.Season) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (m *testDbRepo) DeleteSeasonalRate(ctx context.Context, roomId, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *testDbRepo) SaveStayDiscount(ctx context.Context, roomId int, discount pricing.Discount) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return 1, nil
+}
+
+func (m *testDbRepo) DeleteStayDiscount(ctx context.Context, roomId, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertPayment keeps the payments of the reservations made through
+// CreateReservation, the other payments are not kept.
+func (m *testDbRepo) InsertPayment(ctx context.Context, p models.Payment) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.madeById(p.ReservationId); ok {
+		p.ID = firstMadeId + len(m.payments)
+		m.payments = append(m.payments, p)
+		return p.ID, nil
+	}
+	return 1, nil
+}
+
+// GetPaymentByRef knows the payments kept by InsertPayment and a pending
+// charge of reservation 1 for every other intent of the fake provider.
+func (m *testDbRepo) GetPaymentByRef(ctx context.Context, provider, ref string) (models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return models.Payment{}, err
+	}
+
+	m.mu.Lock()
+	for _, p := range m.payments {
+		if p.Provider == provider && p.ProviderRef == ref {
+			m.mu.Unlock()
+			return p, nil
+		}
+	}
+	m.mu.Unlock()
+
+	if !strings.HasPrefix(ref, "pi_fake_") {
+		return models.Payment{}, sql.ErrNoRows
+	}
+	return models.Payment{
+		ID:            1,
+		ReservationId: 1,
+		Kind:          models.PaymentKindCharge,
+		Provider:      provider,
+		ProviderRef:   ref,
+		Amount:        money.New(25000, "USD"),
+		Status:        models.PaymentPending,
+	}, nil
+}
+
+// GetPaymentsByReservation has reservation 1 paying its deposit in cash and
+// the reservations made through CreateReservation their kept payments.
+func (m *testDbRepo) GetPaymentsByReservation(ctx context.Context, reservationId int) ([]models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	if _, ok := m.madeById(reservationId); ok {
+		defer m.mu.Unlock()
+		payments := []models.Payment{}
+		for _, p := range m.payments {
+			if p.ReservationId == reservationId {
+				payments = append(payments, p)
+			}
+		}
+		return payments, nil
+	}
+	m.mu.Unlock()
+
+	if reservationId != 1 {
+		return []models.Payment{}, nil
+	}
+	return []models.Payment{
+		{ID: 2, ReservationId: 1, Kind: models.PaymentKindCharge, Method: models.PaymentMethodCash, Provider: models.PaymentProviderManual, Amount: money.New(7500, "USD"), Status: models.PaymentSucceeded},
+	}, nil
+}
+
+func (m *testDbRepo) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CapturePayment confirms the reservation of a charge kept by InsertPayment
+// when it is still waiting for its payment.
+func (m *testDbRepo) CapturePayment(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := id - firstMadeId
+	if i < 0 || i >= len(m.payments) {
+		return nil
+	}
+	m.payments[i].Status = models.PaymentSucceeded
+	if made, ok := m.madeById(m.payments[i].ReservationId); ok && made.Status == models.ReservationPendingPayment {
+		made.Status = models.ReservationConfirmed
+	}
+	return nil
+}
+
+// AllCancellationPolicies has the moderate policy 1 and the policies added
+// through InsertCancellationPolicy.
+func (m *testDbRepo) AllCancellationPolicies(ctx context.Context) ([]cancellation.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]cancellation.Policy{moderatePolicy}, m.policies...), nil
+}
+
+// moderatePolicy is policy 1, set on room 1.
+var moderatePolicy = cancellation.Policy{ID: 1, Name: "Moderate", FreeUntilDays: 7, PenaltyPercent: 50}
+
+func (m *testDbRepo) InsertCancellationPolicy(ctx context.Context, p cancellation.Policy) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.ID = 2 + len(m.policies)
+	m.policies = append(m.policies, p)
+	return p.ID, nil
+}
+
+func (m *testDbRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetRoomCancellationPolicy knows rooms 1 and 2.
+func (m *testDbRepo) SetRoomCancellationPolicy(ctx context.Context, roomId, policyId int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if roomId > 2 {
+		return sql.ErrNoRows
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if policyId == 0 {
+		delete(m.roomPolicies, roomId)
+	} else {
+		m.roomPolicies[roomId] = policyId
+	}
+	return nil
+}
+
+// GetRoomCancellationPolicy gives room 1 the moderate policy unless set
+// otherwise, the other rooms have none.
+func (m *testDbRepo) GetRoomCancellationPolicy(ctx context.Context, roomId int) (cancellation.Policy, error) {
+	if err := ctx.Err(); err != nil {
+		return cancellation.Policy{}, err
+	}
+
+	policies, _ := m.AllCancellationPolicies(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range policies {
+		if p.ID == m.roomPolicies[roomId] {
+			return p, nil
+		}
+	}
+	return cancellation.Policy{}, nil
+}
+
+// GetArrivals has reservation 1 arriving on any day.
+func (m *testDbRepo) GetArrivals(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.Reservation{
+		{ID: 1, RoomId: 1, FirstName: "John", LastName: "Doe", Status: models.ReservationConfirmed, StartDate: day, EndDate: day.AddDate(0, 0, 2), Room: models.Room{ID: 1, Name: "General's Quarters"}},
+	}, nil
+}
+
+// GetDepartures has reservation 2 overstaying into the next booking of its
+// room and reservation 3 leaving on time.
+func (m *testDbRepo) GetDepartures(ctx context.Context, day time.Time) ([]models.Departure, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []models.Departure{
+		{
+			Reservation: models.Reservation{ID: 2, RoomId: 1, FirstName: "Jane", LastName: "Doe", Status: models.ReservationCheckedIn, StartDate: day.AddDate(0, 0, -3), EndDate: day.AddDate(0, 0, -1), Room: models.Room{ID: 1, Name: "General's Quarters"}},
+			NextArrival: day,
+		},
+		{
+			Reservation: models.Reservation{ID: 3, RoomId: 2, FirstName: "Jim", LastName: "Beam", Status: models.ReservationCheckedIn, StartDate: day.AddDate(0, 0, -2), EndDate: day, Room: models.Room{ID: 2, Name: "Major's Suite"}},
+		},
+	}, nil
+}
+
+// CheckInReservation records the arrival on the reservations made through
+// CreateReservation, the fixed reservations are only checked.
+func (m *testDbRepo) CheckInReservation(ctx context.Context, id, userId int, arrivedAt time.Time, idDocument, notes string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if made, ok := m.madeById(id); ok {
+		defer m.mu.Unlock()
+		if err := lifecycle.Check(made.Status, models.ReservationCheckedIn); err != nil {
+			return err
+		}
+		made.Status = models.ReservationCheckedIn
+		made.CheckedInAt = arrivedAt
+		made.GuestIdDocument = idDocument
+		made.FrontDeskNotes = addNote(made.FrontDeskNotes, notes)
+		return nil
+	}
+
+	m.mu.Unlock()
+
+	res, _ := m.GetReservationById(ctx, id)
+	return lifecycle.Check(res.Status, models.ReservationCheckedIn)
+}
+
+// CheckOutReservation records the departure on the reservations made
+// through CreateReservation, the fixed reservations are only checked.
+func (m *testDbRepo) CheckOutReservation(ctx context.Context, id, userId int, leftAt time.Time, notes string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if made, ok := m.madeById(id); ok {
+		defer m.mu.Unlock()
+		if err := lifecycle.Check(made.Status, models.ReservationCheckedOut); err != nil {
+			return err
+		}
+		made.Status = models.ReservationCheckedOut
+		made.CheckedOutAt = leftAt
+		made.FrontDeskNotes = addNote(made.FrontDeskNotes, notes)
+		return nil
+	}
+
+	m.mu.Unlock()
+
+	res, _ := m.GetReservationById(ctx, id)
+	return lifecycle.Check(res.Status, models.ReservationCheckedOut)
+}
+
+// addNote appends a line to the front desk notes as the database does.
+func addNote(notes, note string) string {
+	switch {
+	case note == "":
+		return notes
+	case notes == "":
+		return note
+	}
+	return notes + "\n" + note
+}
+
+func (m *testDbRepo) StoredCurrencies(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return []string{"USD"}, nil
+}
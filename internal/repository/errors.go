@@ -0,0 +1,24 @@
+package repository
+
+import "errors"
+
+// ErrRoomUnavailable is returned when a room is already restricted for some of
+// the requested dates.
+var ErrRoomUnavailable = errors.New("room is not available for the selected dates")
+
+// ErrSlugTaken is returned when another room already uses the slug.
+var ErrSlugTaken = errors.New("slug is already used by another room")
+
+// ErrRoomTooSmall is returned when the party does not fit in the room.
+var ErrRoomTooSmall = errors.New("room does not sleep that many guests")
+
+// ErrUnitNameTaken is returned when another unit of the room has the name.
+var ErrUnitNameTaken = errors.New("name is already used by another unit of the room")
+
+// ErrUnitInUse is returned when deleting a unit that reservations or blocks
+// refer to, or the last unit of a room.
+var ErrUnitInUse = errors.New("unit is in use")
+
+// ErrAmenityTaken is returned when another amenity already uses the name or
+// slug.
+var ErrAmenityTaken = errors.New("amenity already exists")
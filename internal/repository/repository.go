@@ -1,37 +1,184 @@
 package repository
 
 import (
+	"context"
 	"time"
 
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/cancellation"
 	"github.com/thanhphuocnguyen/go-bookings-app/internal/models"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/pricing"
+	"github.com/thanhphuocnguyen/go-bookings-app/internal/stayrules"
 )
 
 type DatabaseRepo interface {
 	//Reservations
-	GetReservationById(id int) (models.Reservation, error)
-	InsertReservation(res *models.Reservation) (int, error)
-	InsertRoomRestriction(res *models.RoomRestriction) error
-	CheckIfRoomAvailableByDate(roomId int, start, end time.Time) (bool, error)
-	SearchAvailabilityInRange(start, end time.Time) ([]models.Room, error)
-	GetRoomRestrictionsForRoomByDate(roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetReservationById(ctx context.Context, id int) (models.Reservation, error)
+	// CreateReservation inserts the reservation and its room restriction in one
+	// transaction on a free unit of the room, setting res.RoomUnitId. It returns
+	// ErrRoomUnavailable if every unit was taken meanwhile and ErrRoomTooSmall
+	// if the party does not fit in the room. A stay breaking the room's stay
+	// rules is refused with a *stayrules.Violation.
+	CreateReservation(ctx context.Context, res *models.Reservation) (int, error)
+	// CheckIfRoomAvailableByDate reports whether any unit of the room is free,
+	// never for archived rooms
+	CheckIfRoomAvailableByDate(ctx context.Context, roomId int, start, end time.Time) (bool, error)
+	// SearchAvailabilityInRange returns the rooms with free units that sleep
+	// the party, have every one of the amenities and whose stay rules allow
+	// the stay, with their FreeUnits counted
+	SearchAvailabilityInRange(ctx context.Context, start, end time.Time, guests models.Guests, amenityIds []int) ([]models.Room, error)
+	GetRoomRestrictionsForRoomByDate(ctx context.Context, roomId int, start, end time.Time) ([]models.RoomRestriction, error)
+
+	//Guest reservations, always scoped by the owning user
+	GetReservationsByUserId(ctx context.Context, userId int) ([]models.Reservation, error)
+	GetReservationsByUserIdPage(ctx context.Context, userId, limit, offset int) ([]models.Reservation, int, error)
+	GetUserReservationById(ctx context.Context, id, userId int) (models.Reservation, error)
+	InsertReservationChangeRequest(ctx context.Context, req models.ReservationChangeRequest) error
+	GetReservationChangeRequests(ctx context.Context, reservationId int) ([]models.ReservationChangeRequest, error)
 
 	//Rooms
-	GetRoomById(id int) (models.Room, error)
-	GetRoomBySlug(slug string) (models.Room, error)
-	GetRooms() ([]models.Room, error)
+	GetRoomById(ctx context.Context, id int) (models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	GetRooms(ctx context.Context) ([]models.Room, error)
+	// GetRoomsPage returns a page of rooms and the total number of rooms
+	GetRoomsPage(ctx context.Context, limit, offset int) ([]models.Room, int, error)
+
+	//Room management, archived rooms are left out of GetRooms, GetRoomsPage,
+	//GetRoomBySlug and SearchAvailabilityInRange but kept for their reservations,
+	//so admin pages showing reservations list rooms with AllRooms
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	// InsertRoom and UpdateRoom return ErrSlugTaken when another room uses the slug
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+	UpdateRoom(ctx context.Context, room models.Room) error
+	SetRoomArchived(ctx context.Context, id int, archived bool) error
+	ReorderRooms(ctx context.Context, ids []int) error
+
+	//Room images, a room with photos always has exactly one cover
+	GetRoomImages(ctx context.Context, roomId int) ([]models.RoomImage, error)
+	GetRoomImageById(ctx context.Context, id int) (models.RoomImage, error)
+	InsertRoomImage(ctx context.Context, img models.RoomImage) (int, error)
+	DeleteRoomImage(ctx context.Context, id int) error
+	SetRoomCoverImage(ctx context.Context, roomId, id int) error
+	ReorderRoomImages(ctx context.Context, roomId int, ids []int) error
+
+	//Room units, the identical rooms guests of a room type are given
+	GetRoomUnits(ctx context.Context, roomId int) ([]models.RoomUnit, error)
+	// InsertRoomUnit returns ErrUnitNameTaken when the room has a unit of
+	// that name
+	InsertRoomUnit(ctx context.Context, unit models.RoomUnit) (int, error)
+	// DeleteRoomUnit returns ErrUnitInUse for units with reservations or
+	// blocks and for the last unit of the room
+	DeleteRoomUnit(ctx context.Context, roomId, id int) error
+
+	//Amenities, deleting one takes it off every room
+	AllAmenities(ctx context.Context) ([]models.Amenity, error)
+	// InsertAmenity returns ErrAmenityTaken when the name or slug is used
+	InsertAmenity(ctx context.Context, a models.Amenity) (int, error)
+	DeleteAmenity(ctx context.Context, id int) error
+	GetRoomAmenities(ctx context.Context, roomId int) ([]models.Amenity, error)
+	// SetRoomAmenities replaces the amenities of the room with ids
+	SetRoomAmenities(ctx context.Context, roomId int, ids []int) error
+
+	//Stay rules, the bookings guests can make, staff moving reservations are
+	//not bound by them
+	GetRoomStayRules(ctx context.Context, roomId int) (stayrules.Rules, error)
+	// AllStayRules returns the rules of every room by room id
+	AllStayRules(ctx context.Context) (map[int]stayrules.Rules, error)
+	InsertStayRule(ctx context.Context, roomId int, rule stayrules.Rule) (int, error)
+	DeleteStayRule(ctx context.Context, roomId, id int) error
 
 	//Users
-	AllUsers() ([]models.User, error)
-	GetUserById(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	GetUserById(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	VerifyUserEmail(ctx context.Context, id int) error
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	InsertPasswordReset(ctx context.Context, userId int, tokenHash string, expiresAt time.Time) error
+	GetPasswordResetByTokenHash(ctx context.Context, tokenHash string) (models.PasswordReset, error)
+	ResetUserPassword(ctx context.Context, resetId, userId int, password string) error
+
+	//API tokens, looked up by the sha256 hash of the secret only
+	InsertAPIToken(ctx context.Context, token models.APIToken) (int, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (models.APIToken, error)
+	AllAPITokens(ctx context.Context) ([]models.APIToken, error)
+	RevokeAPIToken(ctx context.Context, id int) error
+	TouchAPIToken(ctx context.Context, id int) error
 
 	//Admin
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	ProcessReservation(id int, processed bool) error
-	DeleteReservation(id int) error
-	UpdateReservation(u models.Reservation) error
-	InsertBlockForRoom(id int, startDate time.Time) error
-	RemoveBlockById(id int) error
+	AllReservations(ctx context.Context, status string) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	// TransitionReservation and CancelReservation return
+	// lifecycle.ErrTransition when the reservation cannot move to the new
+	// status, every change is recorded in the reservation's events
+	TransitionReservation(ctx context.Context, id int, to string, userId int, note string) error
+	// GetOverdueReservations lists the reservations still waiting for a
+	// payment due at or before now. ExpireReservation cancels one of them and
+	// frees its dates, returning lifecycle.ErrTransition once it was paid or
+	// is not overdue any more.
+	GetOverdueReservations(ctx context.Context, now time.Time) ([]int, error)
+	ExpireReservation(ctx context.Context, id int, now time.Time) error
+	GetReservationEvents(ctx context.Context, reservationId int) ([]models.ReservationEvent, error)
+
+	//Front desk, checking in and out goes through the lifecycle as well
+	GetArrivals(ctx context.Context, day time.Time) ([]models.Reservation, error)
+	GetDepartures(ctx context.Context, day time.Time) ([]models.Departure, error)
+	CheckInReservation(ctx context.Context, id, userId int, arrivedAt time.Time, idDocument, notes string) error
+	CheckOutReservation(ctx context.Context, id, userId int, leftAt time.Time, notes string) error
+
+	// CancelReservation records the cancellation fields of res and frees its
+	// dates
+	CancelReservation(ctx context.Context, res models.Reservation) error
+	UpdateReservation(ctx context.Context, u models.Reservation) error
+	// MoveReservation changes the room, dates and price of a reservation,
+	// keeping its unit when free, returning ErrRoomUnavailable when every
+	// unit is taken for the new nights,
+	// ErrRoomTooSmall when the party does not fit in the new room and
+	// lifecycle.ErrTransition once the stay is over or cancelled
+	MoveReservation(ctx context.Context, res models.Reservation, userId int, note string) error
+	// InsertBlock and UpdateBlock return ErrRoomUnavailable when the nights
+	// overlap a reservation or another block of the unit, or of any unit for
+	// blocks closing the whole room.
+	GetBlockById(ctx context.Context, id int) (models.RoomRestriction, error)
+	InsertBlock(ctx context.Context, block models.RoomRestriction) (int, error)
+	UpdateBlock(ctx context.Context, block models.RoomRestriction) error
+	// RemoveBlockById only removes owner blocks, other ids are not found
+	RemoveBlockById(ctx context.Context, id int) error
+
+	//External calendars
+	AllRoomICalFeeds(ctx context.Context) ([]models.RoomICalFeed, error)
+	GetRoomICalFeedById(ctx context.Context, id int) (models.RoomICalFeed, error)
+	InsertRoomICalFeed(ctx context.Context, feed models.RoomICalFeed) (int, error)
+	DeleteRoomICalFeed(ctx context.Context, id int) error
+	UpdateRoomICalFeedStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error
+	GetExternalBlocksByFeed(ctx context.Context, feedId int) ([]models.RoomRestriction, error)
+	InsertExternalBlock(ctx context.Context, block models.RoomRestriction) error
+	UpdateExternalBlock(ctx context.Context, block models.RoomRestriction) error
+	RemoveExternalBlock(ctx context.Context, id int) error
+
+	//Pricing, amounts are in cents
+	GetRoomRates(ctx context.Context, roomId int) (pricing.Rates, error)
+	UpdateRoomRateSettings(ctx context.Context, roomId int, rates pricing.Rates) error
+	InsertSeasonalRate(ctx context.Context, roomId int, season pricing.Season) (int, error)
+	DeleteSeasonalRate(ctx context.Context, roomId, id int) error
+	SaveStayDiscount(ctx context.Context, roomId int, discount pricing.Discount) (int, error)
+	DeleteStayDiscount(ctx context.Context, roomId, id int) error
+
+	//Cancellation policies
+	AllCancellationPolicies(ctx context.Context) ([]cancellation.Policy, error)
+	InsertCancellationPolicy(ctx context.Context, p cancellation.Policy) (int, error)
+	DeleteCancellationPolicy(ctx context.Context, id int) error
+	SetRoomCancellationPolicy(ctx context.Context, roomId, policyId int) error
+	GetRoomCancellationPolicy(ctx context.Context, roomId int) (cancellation.Policy, error)
+
+	//Payments, charges and refunds made through the payment provider
+	InsertPayment(ctx context.Context, p models.Payment) (int, error)
+	GetPaymentByRef(ctx context.Context, provider, ref string) (models.Payment, error)
+	GetPaymentsByReservation(ctx context.Context, reservationId int) ([]models.Payment, error)
+	UpdatePaymentStatus(ctx context.Context, id int, status string) error
+	// CapturePayment marks a charge as paid and confirms its reservation
+	CapturePayment(ctx context.Context, id int) error
+	// StoredCurrencies lists the currencies reservations and payments were
+	// saved in
+	StoredCurrencies(ctx context.Context) ([]string, error)
 }
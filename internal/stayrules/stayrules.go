@@ -0,0 +1,151 @@
+// Package stayrules decides which stays a room can be booked for: how many
+// nights, on which weekdays guests may arrive and leave, and how long before
+// arrival.
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rule restricts the stays arriving from Start to End, both inclusive, a zero
+// Start or End leaves the range open on that side. Zero limits are not
+// enforced. ClosedToDeparture applies to stays leaving between Start and
+// End.
+type Rule struct {
+	ID                int
+	Start             time.Time
+	End               time.Time
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   []time.Weekday
+	ClosedToDeparture []time.Weekday
+	// MinLeadDays is how many days before arrival stays must be booked,
+	// MaxLeadDays how far ahead bookings open
+	MinLeadDays int
+	MaxLeadDays int
+}
+
+// Rules are every rule of a room, a stay has to satisfy all of them.
+type Rules []Rule
+
+// Codes of the rules a stay can break.
+const (
+	CodeMinNights         = "min_nights"
+	CodeMaxNights         = "max_nights"
+	CodeClosedToArrival   = "closed_to_arrival"
+	CodeClosedToDeparture = "closed_to_departure"
+	CodeLeadTime          = "lead_time"
+	CodeHorizon           = "booking_horizon"
+)
+
+// Violation is the rule a stay breaks, Message explains it to guests.
+type Violation struct {
+	Code    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return "stayrules: " + v.Message
+}
+
+// Check returns a *Violation for the first rule the stay from arrival to
+// departure, booked on today, breaks and nil when it breaks none.
+func (rs Rules) Check(arrival, departure, today time.Time) error {
+	arrival, departure = dateOf(arrival), dateOf(departure)
+	nights := days(arrival, departure)
+	lead := days(dateOf(today), arrival)
+
+	for _, r := range rs {
+		if r.covers(departure) && hasDay(r.ClosedToDeparture, departure.Weekday()) {
+			return &Violation{CodeClosedToDeparture, fmt.Sprintf("Departures are not possible on %ss", departure.Weekday())}
+		}
+		if !r.covers(arrival) {
+			continue
+		}
+
+		switch {
+		case hasDay(r.ClosedToArrival, arrival.Weekday()):
+			return &Violation{CodeClosedToArrival, fmt.Sprintf("Arrivals are not possible on %ss", arrival.Weekday())}
+		case nights < r.MinNights:
+			return &Violation{CodeMinNights, fmt.Sprintf("Stays arriving on %s must be at least %s", arrival.Format("2 January 2006"), plural(r.MinNights, "night"))}
+		case r.MaxNights > 0 && nights > r.MaxNights:
+			return &Violation{CodeMaxNights, fmt.Sprintf("Stays arriving on %s can be at most %s", arrival.Format("2 January 2006"), plural(r.MaxNights, "night"))}
+		case lead < r.MinLeadDays:
+			return &Violation{CodeLeadTime, fmt.Sprintf("Stays must be booked at least %s before arrival", plural(r.MinLeadDays, "day"))}
+		case r.MaxLeadDays > 0 && lead > r.MaxLeadDays:
+			return &Violation{CodeHorizon, fmt.Sprintf("Stays can be booked at most %s ahead", plural(r.MaxLeadDays, "day"))}
+		}
+	}
+	return nil
+}
+
+// String describes the rule for the admin pages.
+func (r Rule) String() string {
+	var parts []string
+	if r.MinNights > 0 {
+		parts = append(parts, "at least "+plural(r.MinNights, "night"))
+	}
+	if r.MaxNights > 0 {
+		parts = append(parts, "at most "+plural(r.MaxNights, "night"))
+	}
+	if len(r.ClosedToArrival) > 0 {
+		parts = append(parts, "no arrivals on "+dayList(r.ClosedToArrival))
+	}
+	if len(r.ClosedToDeparture) > 0 {
+		parts = append(parts, "no departures on "+dayList(r.ClosedToDeparture))
+	}
+	if r.MinLeadDays > 0 {
+		parts = append(parts, "booked "+plural(r.MinLeadDays, "day")+" ahead")
+	}
+	if r.MaxLeadDays > 0 {
+		parts = append(parts, "booked at most "+plural(r.MaxLeadDays, "day")+" ahead")
+	}
+	if len(parts) == 0 {
+		return "No restrictions"
+	}
+	s := strings.Join(parts, ", ")
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func (r Rule) covers(day time.Time) bool {
+	if !r.Start.IsZero() && day.Before(dateOf(r.Start)) {
+		return false
+	}
+	return r.End.IsZero() || !day.After(dateOf(r.End))
+}
+
+func hasDay(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func dayList(days []time.Weekday) string {
+	names := make([]string, len(days))
+	for i, d := range days {
+		names[i] = d.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// days counts the calendar days from a to b.
+func days(a, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,74 @@
+package stayrules
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func day(m time.Month, d int) time.Time {
+	return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRules_Check(t *testing.T) {
+	// 2050-07-02 is a saturday
+	summer := Rule{Start: day(7, 1), End: day(8, 31), MinNights: 3, MaxNights: 14, ClosedToArrival: []time.Weekday{time.Sunday}}
+	always := Rule{ClosedToDeparture: []time.Weekday{time.Monday}, MinLeadDays: 2, MaxLeadDays: 365}
+	rules := Rules{summer, always}
+	today := time.Date(2050, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		arrival   time.Time
+		departure time.Time
+		today     time.Time
+		code      string
+	}{
+		{"allowed", day(7, 2), day(7, 5), today, ""},
+		{"too short", day(7, 2), day(7, 4), today, CodeMinNights},
+		{"short before the season", day(6, 29), day(7, 1), today, ""},
+		{"too long", day(7, 2), day(7, 20), today, CodeMaxNights},
+		{"closed to arrival", day(7, 3), day(7, 6), today, CodeClosedToArrival},
+		{"closed to departure", day(7, 1), day(7, 4), today, CodeClosedToDeparture},
+		{"booked too late", day(6, 2), day(6, 4), today, CodeLeadTime},
+		{"last day booked in time", day(6, 3), day(6, 5), today, ""},
+		{"booked too early", day(7, 2), day(7, 5), time.Date(2049, 6, 1, 0, 0, 0, 0, time.UTC), CodeHorizon},
+	}
+
+	for _, tt := range tests {
+		err := rules.Check(tt.arrival, tt.departure, tt.today)
+		if tt.code == "" {
+			if err != nil {
+				t.Errorf("%s: got %v, want no violation", tt.name, err)
+			}
+			continue
+		}
+
+		var v *Violation
+		if !errors.As(err, &v) {
+			t.Errorf("%s: got %v, want a violation", tt.name, err)
+			continue
+		}
+		if v.Code != tt.code {
+			t.Errorf("%s: got %s, want %s", tt.name, v.Code, tt.code)
+		}
+	}
+}
+
+func TestRule_String(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{}, "No restrictions"},
+		{Rule{MinNights: 1}, "At least 1 night"},
+		{Rule{MinNights: 2, ClosedToArrival: []time.Weekday{time.Saturday, time.Sunday}}, "At least 2 nights, no arrivals on Saturday, Sunday"},
+		{Rule{MaxLeadDays: 90}, "Booked at most 90 days ahead"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package storage keeps uploaded files such as room photos. Handlers only
+// see the Storage interface, so the files can move to a bucket without
+// touching them.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey is returned for keys that would escape the storage, such as
+// "../secret".
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+type Storage interface {
+	// Save writes the content of r under key, replacing any file there.
+	Save(ctx context.Context, key string, r io.Reader) error
+	// Delete removes the file under key, a missing file is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL is the public address the file under key is served from.
+	URL(key string) string
+}
+
+// Local stores files in a directory of the server, the application serves
+// them itself under baseURL.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Save writes to a temporary file first so a file is never served half
+// written.
+func (l *Local) Save(ctx context.Context, key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+func (l *Local) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(path.Clean(key))), nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir, "/uploads/")
+	ctx := context.Background()
+
+	if err := l.Save(ctx, "rooms/1/photo.jpg", strings.NewReader("jpeg")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "photo.jpg"))
+	if err != nil || string(got) != "jpeg" {
+		t.Fatalf("got %q, %v, want the saved content", got, err)
+	}
+
+	if url := l.URL("rooms/1/photo.jpg"); url != "/uploads/rooms/1/photo.jpg" {
+		t.Errorf("got url %q", url)
+	}
+
+	if err := l.Delete(ctx, "rooms/1/photo.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rooms", "1", "photo.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the file should be deleted")
+	}
+	if err := l.Delete(ctx, "rooms/1/photo.jpg"); err != nil {
+		t.Errorf("deleting a missing file should not fail, got %v", err)
+	}
+}
+
+func TestLocal_InvalidKey(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/uploads")
+
+	for _, key := range []string{"", "../secret", "rooms/../../secret", "/etc/passwd", `rooms\1`} {
+		if err := l.Save(context.Background(), key, strings.NewReader("x")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Save(%q): got %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tokens
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Sign returns a url-safe token binding subject to purpose until ttl elapses.
+// The token is not encrypted, so subject must not be a secret.
+func Sign(secret []byte, purpose, subject string, ttl time.Duration) string {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject + "|" + expires))
+	return payload + "." + mac(secret, purpose, payload)
+}
+
+// Verify checks the signature and expiry of a token produced by Sign and
+// returns its subject.
+func Verify(secret []byte, purpose, token string) (string, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(sig), []byte(mac(secret, purpose, payload))) != 1 {
+		return "", ErrInvalidToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	idx := strings.LastIndex(string(raw), "|")
+	if idx < 0 {
+		return "", ErrInvalidToken
+	}
+
+	expires, err := strconv.ParseInt(string(raw[idx+1:]), 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if time.Now().Unix() > expires {
+		return "", ErrExpiredToken
+	}
+
+	return string(raw[:idx]), nil
+}
+
+func mac(secret []byte, purpose, payload string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Random returns a url-safe random token with n bytes of entropy.
+func Random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash returns the hex encoded SHA-256 of a token, suitable for storing
+// random tokens in the database.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// MAC returns a deterministic, non expiring token for subject, for links that
+// must stay stable such as calendar subscriptions. Rotating the secret
+// invalidates every MAC.
+func MAC(secret []byte, purpose, subject string) string {
+	return mac(secret, purpose, subject)
+}
+
+// ValidMAC reports in constant time whether token is the MAC of subject.
+func ValidMAC(secret []byte, purpose, subject, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(mac(secret, purpose, subject))) == 1
+}
@@ -0,0 +1,80 @@
+package tokens
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var secret = []byte("testing-secret")
+
+func TestSignAndVerify(t *testing.T) {
+	token := Sign(secret, "verify-email", "42|testing@example.com", time.Hour)
+
+	subject, err := Verify(secret, "verify-email", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if subject != "42|testing@example.com" {
+		t.Errorf("expected subject 42|testing@example.com, got %s", subject)
+	}
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	token := Sign(secret, "verify-email", "42", time.Hour)
+
+	if _, err := Verify(secret, "reset-password", token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("token verified for a different purpose")
+	}
+
+	if _, err := Verify([]byte("other-secret"), "verify-email", token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("token verified with a different secret")
+	}
+
+	if _, err := Verify(secret, "verify-email", token+"x"); !errors.Is(err, ErrInvalidToken) {
+		t.Error("tampered token verified")
+	}
+
+	if _, err := Verify(secret, "verify-email", "garbage"); !errors.Is(err, ErrInvalidToken) {
+		t.Error("garbage token verified")
+	}
+}
+
+func TestVerify_Expired(t *testing.T) {
+	token := Sign(secret, "verify-email", "42", -time.Minute)
+
+	if _, err := Verify(secret, "verify-email", token); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("expected ErrExpiredToken, got %v", err)
+	}
+}
+
+func TestRandomAndHash(t *testing.T) {
+	a, err := Random(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Random(32)
+
+	if a == b {
+		t.Error("Random returned the same token twice")
+	}
+
+	if Hash(a) != Hash(a) || Hash(a) == Hash(b) {
+		t.Error("Hash is not deterministic per token")
+	}
+}
+
+func TestMAC(t *testing.T) {
+	token := MAC(secret, "room-calendar", "generals-quarters")
+
+	if token != MAC(secret, "room-calendar", "generals-quarters") {
+		t.Error("MAC is not deterministic")
+	}
+	if !ValidMAC(secret, "room-calendar", "generals-quarters", token) {
+		t.Error("valid MAC rejected")
+	}
+	if ValidMAC(secret, "room-calendar", "majors-suite", token) {
+		t.Error("MAC accepted for a different subject")
+	}
+}
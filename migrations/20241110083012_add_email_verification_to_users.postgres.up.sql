@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN phone varchar DEFAULT '' NOT NULL,
+    ADD COLUMN email_verified BOOLEAN DEFAULT FALSE NOT NULL;
+
+-- accounts from before verification existed keep logging in
+UPDATE users SET email_verified = true;
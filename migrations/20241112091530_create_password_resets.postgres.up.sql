@@ -0,0 +1,12 @@
+CREATE TABLE
+    "password_resets" (
+        "id" SERIAL PRIMARY KEY,
+        "user_id" integer NOT NULL,
+        "token_hash" varchar UNIQUE NOT NULL,
+        "expires_at" timestamp NOT NULL,
+        "used_at" timestamp,
+        "created_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE
+    );
+
+CREATE INDEX "idx_password_resets_user_id" ON "password_resets" ("user_id");
@@ -0,0 +1,15 @@
+CREATE TABLE
+    "reservation_change_requests" (
+        "id" SERIAL PRIMARY KEY,
+        "reservation_id" integer NOT NULL,
+        "user_id" integer NOT NULL,
+        "start_date" date NOT NULL,
+        "end_date" date NOT NULL,
+        "note" text DEFAULT '' NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+        FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE
+    );
+
+CREATE INDEX "idx_reservation_change_requests_reservation_id" ON "reservation_change_requests" ("reservation_id");
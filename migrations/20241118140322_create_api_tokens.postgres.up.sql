@@ -0,0 +1,18 @@
+CREATE TABLE
+    "api_tokens" (
+        "id" SERIAL PRIMARY KEY,
+        "user_id" integer NOT NULL,
+        "name" varchar NOT NULL,
+        "token_hash" varchar UNIQUE NOT NULL,
+        "scopes" varchar DEFAULT '' NOT NULL,
+        "last_used_at" timestamp,
+        "expires_at" timestamp,
+        "revoked_at" timestamp,
+        "created_by" integer,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE,
+        FOREIGN KEY ("created_by") REFERENCES "users" ("id") ON DELETE SET NULL
+    );
+
+CREATE INDEX "idx_api_tokens_user_id" ON "api_tokens" ("user_id");
@@ -0,0 +1,9 @@
+DELETE FROM restrictions WHERE id = 3;
+
+DROP INDEX "idx_room_restrictions_feed_uid";
+
+ALTER TABLE "room_restrictions"
+DROP COLUMN "external_uid",
+DROP COLUMN "feed_id";
+
+DROP TABLE "room_ical_feeds";
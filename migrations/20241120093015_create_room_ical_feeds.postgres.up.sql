@@ -0,0 +1,36 @@
+CREATE TABLE
+    "room_ical_feeds" (
+        "id" SERIAL PRIMARY KEY,
+        "room_id" integer NOT NULL,
+        "name" varchar NOT NULL,
+        "url" varchar NOT NULL,
+        "last_synced_at" timestamp,
+        "last_error" varchar DEFAULT '' NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+    );
+
+ALTER TABLE "room_restrictions"
+ADD COLUMN "feed_id" integer REFERENCES "room_ical_feeds" ("id") ON DELETE CASCADE,
+ADD COLUMN "external_uid" varchar;
+
+CREATE UNIQUE INDEX "idx_room_restrictions_feed_uid" ON "room_restrictions" ("feed_id", "external_uid");
+
+-- the app refers to this restriction as models.RestrictionExternal, the insert
+-- fails rather than give it another id when 3 is already used
+INSERT INTO
+    restrictions (id, name)
+VALUES
+    (3, 'External');
+
+SELECT
+    setval(
+        pg_get_serial_sequence('restrictions', 'id'),
+        (
+            SELECT
+                max("id")
+            FROM
+                restrictions
+        )
+    );
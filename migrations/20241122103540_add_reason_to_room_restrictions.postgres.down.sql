@@ -0,0 +1,3 @@
+ALTER TABLE "room_restrictions"
+DROP COLUMN "note",
+DROP COLUMN "reason";
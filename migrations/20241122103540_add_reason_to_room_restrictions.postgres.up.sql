@@ -0,0 +1,5 @@
+ALTER TABLE "room_restrictions"
+ADD COLUMN "reason" varchar DEFAULT '' NOT NULL,
+ADD COLUMN "note" text DEFAULT '' NOT NULL;
+
+UPDATE "room_restrictions" SET "reason" = 'other' WHERE "restriction_id" = 2;
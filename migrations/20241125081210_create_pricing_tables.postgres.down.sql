@@ -0,0 +1,9 @@
+ALTER TABLE "reservations"
+DROP COLUMN "price_breakdown",
+DROP COLUMN "total_price";
+
+DROP TABLE "length_of_stay_discounts";
+
+DROP TABLE "seasonal_rates";
+
+DROP TABLE "room_rate_settings";
@@ -0,0 +1,45 @@
+-- amounts are integer minor units (cents)
+CREATE TABLE
+    "room_rate_settings" (
+        "room_id" integer PRIMARY KEY,
+        "weekend_rate" bigint DEFAULT 0 NOT NULL,
+        "weekend_days" varchar DEFAULT '5,6' NOT NULL,
+        "short_stay_nights" integer DEFAULT 0 NOT NULL,
+        "short_stay_surcharge" integer DEFAULT 0 NOT NULL CHECK ("short_stay_surcharge" BETWEEN 0 AND 100),
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+    );
+
+CREATE TABLE
+    "seasonal_rates" (
+        "id" SERIAL PRIMARY KEY,
+        "room_id" integer NOT NULL,
+        "name" varchar NOT NULL,
+        "start_date" date NOT NULL,
+        "end_date" date NOT NULL,
+        "nightly_rate" bigint NOT NULL,
+        "weekend_rate" bigint DEFAULT 0 NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        CHECK ("end_date" >= "start_date"),
+        FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+    );
+
+CREATE INDEX "idx_seasonal_rates_room_dates" ON "seasonal_rates" ("room_id", "start_date", "end_date");
+
+CREATE TABLE
+    "length_of_stay_discounts" (
+        "id" SERIAL PRIMARY KEY,
+        "room_id" integer NOT NULL,
+        "min_nights" integer NOT NULL CHECK ("min_nights" > 1),
+        "percent" integer NOT NULL CHECK ("percent" BETWEEN 1 AND 100),
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        UNIQUE ("room_id", "min_nights"),
+        FOREIGN KEY ("room_id") REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+    );
+
+ALTER TABLE "reservations"
+ADD COLUMN "total_price" bigint DEFAULT 0 NOT NULL,
+ADD COLUMN "price_breakdown" jsonb;
@@ -0,0 +1,3 @@
+-- total_price is in minor units of this currency
+ALTER TABLE "reservations"
+ADD COLUMN "currency" varchar(3) DEFAULT 'USD' NOT NULL;
@@ -0,0 +1,4 @@
+DROP TABLE "payments";
+
+ALTER TABLE "reservations"
+DROP COLUMN "status";
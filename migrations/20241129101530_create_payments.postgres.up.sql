@@ -0,0 +1,23 @@
+ALTER TABLE "reservations"
+ADD COLUMN "status" varchar DEFAULT 'confirmed' NOT NULL;
+
+CREATE TABLE
+    "payments" (
+        "id" SERIAL PRIMARY KEY,
+        "reservation_id" integer NOT NULL,
+        -- refunds point at the charge they give money back from
+        "parent_id" integer,
+        "kind" varchar NOT NULL,
+        "provider" varchar NOT NULL,
+        "provider_ref" varchar NOT NULL,
+        "amount" bigint NOT NULL,
+        "currency" varchar(3) NOT NULL,
+        "status" varchar NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ()),
+        UNIQUE ("provider", "provider_ref"),
+        FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+        FOREIGN KEY ("parent_id") REFERENCES "payments" ("id") ON DELETE CASCADE ON UPDATE CASCADE
+    );
+
+CREATE INDEX "idx_payments_reservation_id" ON "payments" ("reservation_id");
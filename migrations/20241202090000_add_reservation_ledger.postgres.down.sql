@@ -0,0 +1,10 @@
+UPDATE "payments" SET "provider_ref" = 'manual-' || "id" WHERE "provider_ref" IS NULL;
+
+ALTER TABLE "payments"
+ALTER COLUMN "provider_ref" SET NOT NULL,
+DROP COLUMN "note",
+DROP COLUMN "method";
+
+ALTER TABLE "reservations"
+DROP COLUMN "balance_due_date",
+DROP COLUMN "deposit_amount";
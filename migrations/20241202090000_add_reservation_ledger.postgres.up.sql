@@ -0,0 +1,9 @@
+ALTER TABLE "reservations"
+ADD COLUMN "deposit_amount" bigint DEFAULT 0 NOT NULL,
+ADD COLUMN "balance_due_date" date;
+
+ALTER TABLE "payments"
+ADD COLUMN "method" varchar DEFAULT 'card' NOT NULL,
+ADD COLUMN "note" text DEFAULT '' NOT NULL,
+-- payments recorded by staff have no provider reference
+ALTER COLUMN "provider_ref" DROP NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE "reservations"
+DROP COLUMN "cancellation_penalty",
+DROP COLUMN "cancellation_reason",
+DROP COLUMN "cancelled_by",
+DROP COLUMN "cancelled_at";
+
+ALTER TABLE "rooms"
+DROP COLUMN "cancellation_policy_id";
+
+DROP TABLE IF EXISTS "cancellation_policies";
@@ -0,0 +1,20 @@
+CREATE TABLE
+    "cancellation_policies" (
+        "id" SERIAL PRIMARY KEY,
+        "name" varchar NOT NULL,
+        "free_until_days" integer DEFAULT 0 NOT NULL,
+        "penalty_percent" integer DEFAULT 0 NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        "updated_at" timestamp DEFAULT (now ())
+    );
+
+ALTER TABLE "rooms"
+ADD COLUMN "cancellation_policy_id" integer REFERENCES "cancellation_policies" ("id") ON DELETE SET NULL;
+
+-- cancelled reservations are kept for their history, only their dates are
+-- given back
+ALTER TABLE "reservations"
+ADD COLUMN "cancelled_at" timestamp,
+ADD COLUMN "cancelled_by" integer REFERENCES "users" ("id") ON DELETE SET NULL,
+ADD COLUMN "cancellation_reason" text DEFAULT '' NOT NULL,
+ADD COLUMN "cancellation_penalty" bigint DEFAULT 0 NOT NULL;
@@ -0,0 +1,12 @@
+ALTER TABLE "reservations"
+ADD COLUMN "processed" boolean DEFAULT false NOT NULL;
+
+UPDATE "reservations"
+SET
+    "processed" = true
+WHERE
+    "status" IN ('checked_in', 'checked_out', 'no_show');
+
+DROP INDEX IF EXISTS "idx_reservations_status";
+
+DROP TABLE IF EXISTS "reservation_events";
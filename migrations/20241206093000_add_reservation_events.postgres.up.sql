@@ -0,0 +1,32 @@
+CREATE TABLE
+    "reservation_events" (
+        "id" SERIAL PRIMARY KEY,
+        "reservation_id" integer NOT NULL,
+        -- empty for the event of the reservation's creation
+        "from_status" varchar DEFAULT '' NOT NULL,
+        "to_status" varchar NOT NULL,
+        -- null for changes made by the system
+        "user_id" integer,
+        "note" text DEFAULT '' NOT NULL,
+        "created_at" timestamp DEFAULT (now ()),
+        FOREIGN KEY ("reservation_id") REFERENCES "reservations" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+        FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE SET NULL
+    );
+
+CREATE INDEX "idx_reservation_events_reservation_id" ON "reservation_events" ("reservation_id");
+
+CREATE INDEX "idx_reservations_status" ON "reservations" ("status");
+
+-- existing reservations start their history in their current status
+INSERT INTO
+    "reservation_events" ("reservation_id", "to_status", "note", "created_at")
+SELECT
+    "id",
+    "status",
+    'Status before the history was kept',
+    "created_at"
+FROM
+    "reservations";
+
+ALTER TABLE "reservations"
+DROP COLUMN "processed";
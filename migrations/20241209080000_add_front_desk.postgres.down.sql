@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS "idx_reservations_end_date";
+
+DROP INDEX IF EXISTS "idx_reservations_start_date";
+
+ALTER TABLE "reservations"
+DROP COLUMN "front_desk_notes",
+DROP COLUMN "guest_id_document",
+DROP COLUMN "checked_out_at",
+DROP COLUMN "checked_in_at";
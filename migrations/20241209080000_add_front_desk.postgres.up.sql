@@ -0,0 +1,10 @@
+-- filled in by the front desk when the guest arrives and leaves
+ALTER TABLE "reservations"
+ADD COLUMN "checked_in_at" timestamp,
+ADD COLUMN "checked_out_at" timestamp,
+ADD COLUMN "guest_id_document" varchar DEFAULT '' NOT NULL,
+ADD COLUMN "front_desk_notes" text DEFAULT '' NOT NULL;
+
+CREATE INDEX "idx_reservations_start_date" ON "reservations" ("start_date");
+
+CREATE INDEX "idx_reservations_end_date" ON "reservations" ("end_date");
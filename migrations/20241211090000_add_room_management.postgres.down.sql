@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS "idx_rooms_sort_order";
+
+ALTER TABLE "rooms"
+DROP COLUMN "sort_order",
+DROP COLUMN "archived_at";
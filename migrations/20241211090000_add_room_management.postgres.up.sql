@@ -0,0 +1,10 @@
+-- archived rooms are hidden from guests but keep their reservations
+ALTER TABLE "rooms"
+ADD COLUMN "archived_at" timestamp,
+ADD COLUMN "sort_order" integer DEFAULT 0 NOT NULL;
+
+UPDATE "rooms"
+SET
+    "sort_order" = "id";
+
+CREATE INDEX "idx_rooms_sort_order" ON "rooms" ("sort_order");
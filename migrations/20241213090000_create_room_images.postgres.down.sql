@@ -0,0 +1 @@
+DROP TABLE IF EXISTS "room_images";
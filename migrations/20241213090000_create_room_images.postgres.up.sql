@@ -0,0 +1,21 @@
+-- photos of a room, the files themselves live in the configured storage
+CREATE TABLE "room_images" (
+    "id" serial PRIMARY KEY,
+    "room_id" integer NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "web_key" varchar(255) NOT NULL,
+    "thumb_key" varchar(255) NOT NULL,
+    "alt" varchar(255) DEFAULT '' NOT NULL,
+    "width" integer NOT NULL,
+    "height" integer NOT NULL,
+    "sort_order" integer DEFAULT 0 NOT NULL,
+    "is_cover" boolean DEFAULT false NOT NULL,
+    "created_at" timestamp DEFAULT now() NOT NULL,
+    "updated_at" timestamp DEFAULT now() NOT NULL
+);
+
+CREATE INDEX "idx_room_images_room_id" ON "room_images" ("room_id", "sort_order");
+
+-- a room has at most one cover
+CREATE UNIQUE INDEX "idx_room_images_cover" ON "room_images" ("room_id")
+WHERE
+    "is_cover";
@@ -0,0 +1,8 @@
+ALTER TABLE "reservations"
+DROP COLUMN "children",
+DROP COLUMN "adults";
+
+ALTER TABLE "rooms"
+DROP COLUMN "beds",
+DROP COLUMN "max_children",
+DROP COLUMN "max_adults";
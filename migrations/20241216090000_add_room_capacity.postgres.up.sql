@@ -0,0 +1,11 @@
+-- how many guests a room sleeps, existing rooms keep the double they were
+-- sold as
+ALTER TABLE "rooms"
+ADD COLUMN "max_adults" integer DEFAULT 2 NOT NULL CHECK ("max_adults" > 0),
+ADD COLUMN "max_children" integer DEFAULT 0 NOT NULL CHECK ("max_children" >= 0),
+ADD COLUMN "beds" integer DEFAULT 1 NOT NULL CHECK ("beds" > 0);
+
+-- the party of each reservation, for pricing and housekeeping
+ALTER TABLE "reservations"
+ADD COLUMN "adults" integer DEFAULT 1 NOT NULL CHECK ("adults" > 0),
+ADD COLUMN "children" integer DEFAULT 0 NOT NULL CHECK ("children" >= 0);
@@ -0,0 +1,9 @@
+ALTER TABLE "reservations"
+DROP COLUMN "room_unit_id";
+
+DROP INDEX IF EXISTS "idx_room_restrictions_room_unit_id";
+
+ALTER TABLE "room_restrictions"
+DROP COLUMN "room_unit_id";
+
+DROP TABLE IF EXISTS "room_units";
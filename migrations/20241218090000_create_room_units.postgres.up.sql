@@ -0,0 +1,45 @@
+-- the physical rooms a room is sold from, each reservation takes one of them
+CREATE TABLE "room_units" (
+    "id" serial PRIMARY KEY,
+    "room_id" integer NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "name" varchar(255) NOT NULL,
+    "sort_order" integer DEFAULT 0 NOT NULL,
+    "created_at" timestamp DEFAULT now() NOT NULL,
+    "updated_at" timestamp DEFAULT now() NOT NULL,
+    UNIQUE ("room_id", "name")
+);
+
+-- every room so far was a single unit
+INSERT INTO
+    "room_units" ("room_id", "name", "sort_order")
+SELECT
+    "id",
+    '1',
+    1
+FROM
+    "rooms";
+
+-- restrictions without a unit close every unit of their room
+ALTER TABLE "room_restrictions"
+ADD COLUMN "room_unit_id" integer REFERENCES "room_units" ("id") ON DELETE RESTRICT;
+
+UPDATE "room_restrictions" rr
+SET
+    "room_unit_id" = u."id"
+FROM
+    "room_units" u
+WHERE
+    u."room_id" = rr."room_id";
+
+CREATE INDEX "idx_room_restrictions_room_unit_id" ON "room_restrictions" ("room_unit_id");
+
+ALTER TABLE "reservations"
+ADD COLUMN "room_unit_id" integer REFERENCES "room_units" ("id") ON DELETE RESTRICT;
+
+UPDATE "reservations" rs
+SET
+    "room_unit_id" = u."id"
+FROM
+    "room_units" u
+WHERE
+    u."room_id" = rs."room_id";
@@ -0,0 +1,26 @@
+-- the catalogue of features a room can have, guests filter searches on them
+CREATE TABLE "amenities" (
+    "id" serial PRIMARY KEY,
+    "name" varchar(255) NOT NULL,
+    "slug" varchar(255) NOT NULL UNIQUE,
+    "created_at" timestamp DEFAULT now() NOT NULL,
+    "updated_at" timestamp DEFAULT now() NOT NULL
+);
+
+CREATE TABLE "room_amenities" (
+    "room_id" integer NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE,
+    "amenity_id" integer NOT NULL REFERENCES "amenities" ("id") ON DELETE CASCADE,
+    PRIMARY KEY ("room_id", "amenity_id")
+);
+
+CREATE INDEX "idx_room_amenities_amenity_id" ON "room_amenities" ("amenity_id");
+
+INSERT INTO
+    "amenities" ("name", "slug")
+VALUES
+    ('Wi-Fi', 'wifi'),
+    ('Sea view', 'sea-view'),
+    ('Accessible', 'accessible'),
+    ('Pets allowed', 'pets-allowed'),
+    ('Air conditioning', 'air-conditioning'),
+    ('Parking', 'parking');
@@ -0,0 +1,20 @@
+-- booking rules of a room for the stays arriving between start_date and
+-- end_date, null leaves the range open, zero limits are not enforced
+CREATE TABLE "stay_rules" (
+    "id" serial PRIMARY KEY,
+    "room_id" integer NOT NULL REFERENCES "rooms" ("id") ON DELETE CASCADE ON UPDATE CASCADE,
+    "start_date" date,
+    "end_date" date,
+    "min_nights" integer DEFAULT 0 NOT NULL CHECK ("min_nights" >= 0),
+    "max_nights" integer DEFAULT 0 NOT NULL CHECK ("max_nights" >= 0),
+    "closed_to_arrival" varchar DEFAULT '' NOT NULL,
+    "closed_to_departure" varchar DEFAULT '' NOT NULL,
+    "min_lead_days" integer DEFAULT 0 NOT NULL CHECK ("min_lead_days" >= 0),
+    "max_lead_days" integer DEFAULT 0 NOT NULL CHECK ("max_lead_days" >= 0),
+    "created_at" timestamp DEFAULT now() NOT NULL,
+    "updated_at" timestamp DEFAULT now() NOT NULL,
+    CHECK ("end_date" >= "start_date"),
+    CHECK ("max_nights" = 0 OR "max_nights" >= "min_nights")
+);
+
+CREATE INDEX "idx_stay_rules_room_id" ON "stay_rules" ("room_id");
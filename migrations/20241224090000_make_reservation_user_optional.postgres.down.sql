@@ -0,0 +1,8 @@
+UPDATE "reservations"
+SET
+    "user_id" = 1
+WHERE
+    "user_id" IS NULL;
+
+ALTER TABLE "reservations"
+ALTER COLUMN "user_id" SET NOT NULL;
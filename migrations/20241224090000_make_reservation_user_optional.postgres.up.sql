@@ -0,0 +1,18 @@
+-- reservations made without logging in have no user, they used to be saved
+-- for user 1 and are given back to nobody unless the email is user 1's
+ALTER TABLE "reservations"
+ALTER COLUMN "user_id" DROP NOT NULL;
+
+UPDATE "reservations"
+SET
+    "user_id" = NULL
+WHERE
+    "user_id" = 1
+    AND lower("email") <> (
+        SELECT
+            lower("email")
+        FROM
+            "users"
+        WHERE
+            "id" = 1
+    );
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "reservations_payment_due_at_idx";
+
+ALTER TABLE "reservations"
+DROP COLUMN "payment_due_at";
@@ -0,0 +1,14 @@
+-- reservations waiting for their payment hold the room until payment_due_at,
+-- the ones already waiting get half an hour from now
+ALTER TABLE "reservations"
+ADD COLUMN "payment_due_at" timestamp;
+
+UPDATE "reservations"
+SET
+    "payment_due_at" = now() + interval '30 minutes'
+WHERE
+    "status" = 'pending_payment';
+
+CREATE INDEX "reservations_payment_due_at_idx" ON "reservations" ("payment_due_at")
+WHERE
+    "status" = 'pending_payment';